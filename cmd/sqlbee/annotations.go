@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+// The standard prefix of sqlbee annotations, e.g. sqlbee.connctd.io/inject. The legacy form
+// sqlbee.connctd.io.inject is still supported but deprecated
+const annotationPrefix = "sqlbee.connctd.io/"
+
+// sqlbeeAnnotations returns the sqlbee annotations of an object keyed by their legacy form. If an
+// annotation is present in both forms the standard one takes precedence
+func sqlbeeAnnotations(obj runtime.Object) map[string]string {
+	annotations := map[string]string{}
+	for name, val := range sting.AnnotationsWithPrefix(obj, annotationBase) {
+		annotations[annotationBase+name] = val
+	}
+	for name, val := range sting.AnnotationsWithPrefix(obj, annotationPrefix) {
+		annotations[annotationBase+name] = val
+	}
+	return annotations
+}
+
+// legacyAnnotations returns the sorted keys of the sqlbee annotations of an object which use the
+// deprecated legacy form
+func legacyAnnotations(obj runtime.Object) []string {
+	keys := []string{}
+	for name := range sting.AnnotationsWithPrefix(obj, annotationBase) {
+		keys = append(keys, annotationBase+name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// annotationValue retrieves the value of the sqlbee annotation specified by its legacy key in either
+// form. In case the annotation is not found a default value can be specified as the last parameter
+func annotationValue(obj runtime.Object, key string, def ...string) string {
+	if val, exists := sqlbeeAnnotations(obj)[key]; exists {
+		return val
+	}
+	if len(def) > 0 {
+		return def[0]
+	}
+	return ""
+}
+
+// annotationHasValue checks whether the sqlbee annotation specified by its legacy key is present in
+// either form and has the specified value
+func annotationHasValue(obj runtime.Object, key, val string) bool {
+	v, exists := sqlbeeAnnotations(obj)[key]
+	return exists && v == val
+}
+
+// annotationsWithPrefix retrieves all sqlbee annotations in either form whose legacy key starts with
+// prefix. The keys of the returned map are stripped of the prefix
+func annotationsWithPrefix(obj runtime.Object, prefix string) map[string]string {
+	annotations := map[string]string{}
+	for key, val := range sqlbeeAnnotations(obj) {
+		if strings.HasPrefix(key, prefix) {
+			annotations[strings.TrimPrefix(key, prefix)] = val
+		}
+	}
+	return annotations
+}
+
+// annotationField returns the field path of the sqlbee annotation specified by its legacy key in its
+// standard form, e.g. metadata.annotations[sqlbee.connctd.io/instance]
+func annotationField(key string) string {
+	return "metadata.annotations[" + annotationPrefix + strings.TrimPrefix(key, annotationBase) + "]"
+}
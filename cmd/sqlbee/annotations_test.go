@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnotationForms(t *testing.T) {
+	pod := &corev1.Pod{}
+	pod.Annotations = map[string]string{
+		"sqlbee.connctd.io/inject":             "true",
+		"sqlbee.connctd.io.instance":           "proj:eu:legacy",
+		"sqlbee.connctd.io.secret":             "legacy",
+		"sqlbee.connctd.io/secret":             "standard",
+		"sqlbee.connctd.io/instance.reporting": "proj:eu:reporting",
+		"other.io/secret":                      "other",
+	}
+
+	assert.True(t, annotationHasValue(pod, annotationInject, "true"))
+	assert.Equal(t, "proj:eu:legacy", annotationValue(pod, annotationInstance))
+	assert.Equal(t, "standard", annotationValue(pod, annotationSecret))
+	assert.Equal(t, "default", annotationValue(pod, annotationCaMap, "default"))
+	assert.Equal(t, map[string]string{"reporting": "proj:eu:reporting"}, annotationsWithPrefix(pod, annotationInstance+"."))
+	assert.Equal(t, []string{"sqlbee.connctd.io.instance", "sqlbee.connctd.io.secret"}, legacyAnnotations(pod))
+}
+
+func TestAnnotationField(t *testing.T) {
+	assert.Equal(t, "metadata.annotations[sqlbee.connctd.io/instance]", annotationField(annotationInstance))
+}
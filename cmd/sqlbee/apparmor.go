@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+const (
+	// Prefix of the pod annotations setting the AppArmor profile of a container, followed by its name
+	appArmorAnnotationPrefix = "container.apparmor.security.beta.kubernetes.io/"
+
+	appArmorRuntimeDefault = "runtime/default"
+	appArmorUnconfined     = "unconfined"
+	appArmorLocalhost      = "localhost/"
+)
+
+// validateAppArmorProfile checks whether the AppArmor profile is supported
+func validateAppArmorProfile(profile string) error {
+	switch {
+	case profile == "", profile == appArmorRuntimeDefault, profile == appArmorUnconfined:
+		return nil
+	case strings.HasPrefix(profile, appArmorLocalhost) && len(profile) > len(appArmorLocalhost):
+		return nil
+	}
+	return fmt.Errorf("Invalid AppArmor profile %q, expected %s, %s or %s<profile>", profile, appArmorRuntimeDefault, appArmorUnconfined, appArmorLocalhost)
+}
+
+// configureAppArmor annotates the pod template with the AppArmor profile of every injected proxy.
+// Profiles annotated by the workload itself are kept. Pods using one of the runtime classes which
+// don't support AppArmor, like sandboxed runtimes, are left alone
+func configureAppArmor(obj runtime.Object, podSpec *corev1.PodSpec, proxyName string, opts Options) error {
+	profile := annotationValue(obj, annotationAppArmor, opts.AppArmorProfile)
+	if err := validateAppArmorProfile(profile); err != nil {
+		return sting.Invalid(fmt.Sprintf("Invalid value of annotation %s: %s", annotationAppArmor, err))
+	}
+	if profile == "" {
+		return nil
+	}
+	if podSpec.RuntimeClassName != nil {
+		for _, runtimeClass := range opts.AppArmorSkipRuntimeClasses {
+			if *podSpec.RuntimeClassName == runtimeClass {
+				return nil
+			}
+		}
+	}
+
+	annotations := templateAnnotations(obj)
+	if annotations == nil {
+		return nil
+	}
+	if *annotations == nil {
+		*annotations = map[string]string{}
+	}
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range containers {
+			if !isProxyContainer(container, proxyName) {
+				continue
+			}
+			if _, exists := (*annotations)[appArmorAnnotationPrefix+container.Name]; !exists {
+				(*annotations)[appArmorAnnotationPrefix+container.Name] = profile
+			}
+		}
+	}
+	return nil
+}
+
+// removeAppArmor removes the AppArmor profiles of containers which don't exist anymore from the pod
+// template, as the API server rejects profiles of unknown containers
+func removeAppArmor(obj runtime.Object, podSpec *corev1.PodSpec) {
+	annotations := templateAnnotations(obj)
+	if annotations == nil {
+		return
+	}
+	containers := map[string]bool{}
+	for _, list := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range list {
+			containers[container.Name] = true
+		}
+	}
+	for key := range *annotations {
+		if strings.HasPrefix(key, appArmorAnnotationPrefix) && !containers[strings.TrimPrefix(key, appArmorAnnotationPrefix)] {
+			delete(*annotations, key)
+		}
+	}
+}
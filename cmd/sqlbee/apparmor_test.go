@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureAppArmor(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationInstance + ".reporting":                      "proj:eu:reporting",
+		appArmorAnnotationPrefix + "cloud-sql-proxy-reporting": "localhost/proxy",
+	})
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	opts := Options{DefaultInstance: "proj:eu:db", AppArmorProfile: appArmorRuntimeDefault}
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, opts))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	require.NoError(t, configureNamedInstances(context.Background(), pod, proxyContainer.Name, 3306, &pod.Spec, opts))
+	require.NoError(t, configureAppArmor(pod, &pod.Spec, proxyContainer.Name, opts))
+
+	assert.Equal(t, appArmorRuntimeDefault, pod.Annotations[appArmorAnnotationPrefix+"cloud-sql-proxy"])
+	assert.Equal(t, "localhost/proxy", pod.Annotations[appArmorAnnotationPrefix+"cloud-sql-proxy-reporting"])
+	assert.NotContains(t, pod.Annotations, appArmorAnnotationPrefix+"wordpress")
+
+	// The profiles of removed proxies are removed as well
+	require.True(t, removeProxies(&pod.Spec, proxyContainer.Name, defaultSocketVolume))
+	removeAppArmor(pod, &pod.Spec)
+	assert.NotContains(t, pod.Annotations, appArmorAnnotationPrefix+"cloud-sql-proxy")
+	assert.NotContains(t, pod.Annotations, appArmorAnnotationPrefix+"cloud-sql-proxy-reporting")
+}
+
+func TestAppArmorRuntimeClasses(t *testing.T) {
+	gvisor := "gvisor"
+	pod := testPodWithAnnotations(t, map[string]string{annotationAppArmor: "unconfined"})
+	pod.Spec.RuntimeClassName = &gvisor
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "cloud-sql-proxy", Command: []string{"/cloud_sql_proxy"}})
+
+	require.NoError(t, configureAppArmor(pod, &pod.Spec, "cloud-sql-proxy", Options{AppArmorSkipRuntimeClasses: []string{"kata", "gvisor"}}))
+	assert.NotContains(t, pod.Annotations, appArmorAnnotationPrefix+"cloud-sql-proxy")
+
+	require.NoError(t, configureAppArmor(pod, &pod.Spec, "cloud-sql-proxy", Options{}))
+	assert.Equal(t, appArmorUnconfined, pod.Annotations[appArmorAnnotationPrefix+"cloud-sql-proxy"])
+}
+
+func TestValidateAppArmorProfile(t *testing.T) {
+	for profile, valid := range map[string]bool{
+		"":                true,
+		"runtime/default": true,
+		"unconfined":      true,
+		"localhost/proxy": true,
+		"localhost/":      false,
+		"docker-default":  false,
+	} {
+		assert.Equal(t, valid, validateAppArmorProfile(profile) == nil, profile)
+	}
+
+	pod := testPodWithAnnotations(t, map[string]string{annotationAppArmor: "confined"})
+	assert.Error(t, configureAppArmor(pod, &pod.Spec, "cloud-sql-proxy", Options{}))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+)
+
+const (
+	// How often the bootstrapped certificate is checked and renewed before it expires
+	certificateBootstrapInterval = 12 * time.Hour
+	// How long to wait for cert-manager to issue the certificate
+	certManagerTimeout = 2 * time.Minute
+)
+
+// Issuers of the bootstrapped certificate. By default SQLBee signs it with its own CA, it can be
+// requested from a signer via the CertificateSigningRequest API like csr:<signer> or from an issuer
+// of cert-manager like cert-manager:ClusterIssuer/<name> instead
+const (
+	issuerSelfSigned  = "self-signed"
+	issuerCSR         = "csr:"
+	issuerCertManager = "cert-manager:"
+)
+
+// validateCertificateIssuer checks whether the issuer of the bootstrapped certificate is supported
+func validateCertificateIssuer(issuer string) error {
+	switch {
+	case issuer == "", issuer == issuerSelfSigned:
+		return nil
+	case strings.HasPrefix(issuer, issuerCSR) && len(issuer) > len(issuerCSR):
+		return nil
+	case strings.HasPrefix(issuer, issuerCertManager):
+		kind, name := certManagerIssuer(issuer)
+		if (kind == "Issuer" || kind == "ClusterIssuer") && name != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid certificate issuer %q, expected %s, %s<signer> or %s<Issuer|ClusterIssuer>/<name>", issuer, issuerSelfSigned, issuerCSR, issuerCertManager)
+}
+
+// certManagerIssuer returns the kind and the name of the issuer of cert-manager
+func certManagerIssuer(issuer string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(issuer, issuerCertManager), "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// certificateIssuer returns the issuer of the bootstrapped certificate. Certificates requested via the
+// CertificateSigningRequest API are verified with the CA certificate within the file
+func certificateIssuer(issuer string, client kube.CSRClient, caFile string, approve bool) (kube.CertificateIssuer, error) {
+	if !strings.HasPrefix(issuer, issuerCSR) {
+		return kube.SelfSignedIssuer{}, nil
+	}
+	caCert, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return kube.NewCSRIssuer(client, strings.TrimPrefix(issuer, issuerCSR), caCert, approve), nil
+}
+
+// requestCertManagerCertificate creates the certificate of cert-manager stored in the secret, lets
+// the CA injector of cert-manager set the CA bundle of the webhook configuration and waits until the
+// certificate has been issued. cert-manager renews the certificate before it expires
+func requestCertManagerCertificate(ctx context.Context, client *kube.Client, ref *corev1.SecretReference, webhook string, dnsNames []string, issuer string) error {
+	kind, name := certManagerIssuer(issuer)
+	if err := client.ApplyCertificate(ctx, kube.NewServingCertificate(ref.Namespace, ref.Name, dnsNames, kind, name)); err != nil {
+		return err
+	}
+	if err := client.AnnotateWebhook(ctx, webhook, map[string]string{kube.AnnotationInjectCAFrom: ref.Namespace + "/" + ref.Name}); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, certManagerTimeout)
+	defer cancel()
+	return kube.WaitForSecret(ctx, client, ref.Namespace, ref.Name, 2*time.Second)
+}
+
+// certSecretReference parses a reference to the secret containing the serving certificate, either
+// <namespace>/<name> or only the name of a secret within the namespace of SQLBee
+func certSecretReference(value, namespace string) (*corev1.SecretReference, error) {
+	parts := strings.Split(value, "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return &corev1.SecretReference{Namespace: namespace, Name: parts[0]}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return &corev1.SecretReference{Namespace: parts[0], Name: parts[1]}, nil
+	}
+	return nil, fmt.Errorf("Invalid secret %q, expected <name> or <namespace>/<name>", value)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+)
+
+func TestCertSecretReference(t *testing.T) {
+	for _, data := range []struct {
+		value    string
+		expected *corev1.SecretReference
+		err      string
+	}{
+		{"sqlbee-certs", &corev1.SecretReference{Namespace: "sqlbee", Name: "sqlbee-certs"}, ""},
+		{"cert-manager/sqlbee-tls", &corev1.SecretReference{Namespace: "cert-manager", Name: "sqlbee-tls"}, ""},
+		{"cert-manager/", nil, `Invalid secret "cert-manager/", expected <name> or <namespace>/<name>`},
+		{"a/b/c", nil, `Invalid secret "a/b/c", expected <name> or <namespace>/<name>`},
+	} {
+		ref, err := certSecretReference(data.value, "sqlbee")
+		if data.err != "" {
+			assert.EqualError(t, err, data.err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, data.expected, ref)
+	}
+}
+
+func TestValidateCertificateIssuer(t *testing.T) {
+	for _, issuer := range []string{"", "self-signed", "csr:example.com/webhook-serving", "cert-manager:Issuer/sqlbee-ca", "cert-manager:ClusterIssuer/cluster-ca"} {
+		assert.NoError(t, validateCertificateIssuer(issuer), issuer)
+	}
+	for _, issuer := range []string{"csr:", "cert-manager:Issuer", "cert-manager:Certificate/sqlbee", "vault"} {
+		assert.Error(t, validateCertificateIssuer(issuer), issuer)
+	}
+	kind, name := certManagerIssuer("cert-manager:ClusterIssuer/cluster-ca")
+	assert.Equal(t, "ClusterIssuer", kind)
+	assert.Equal(t, "cluster-ca", name)
+}
+
+func TestCertificateIssuer(t *testing.T) {
+	issuer, err := certificateIssuer(issuerSelfSigned, nil, "", false)
+	require.NoError(t, err)
+	assert.Equal(t, kube.SelfSignedIssuer{}, issuer)
+
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, err = certificateIssuer("csr:example.com/webhook-serving", nil, filepath.Join(dir, "ca.crt"), false)
+	assert.Error(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ca.crt"), []byte("ca"), 0600))
+	issuer, err = certificateIssuer("csr:example.com/webhook-serving", nil, filepath.Join(dir, "ca.crt"), false)
+	require.NoError(t, err)
+	assert.IsType(t, &kube.CSRIssuer{}, issuer)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Version 2 of the proxy renamed its binary and most of its flags, spells the flags with two dashes
+// and takes the instances as arguments
+var sqlProxyCmdV2 = []string{
+	"/cloud-sql-proxy",
+}
+
+// proxyFlags spells the command line of the proxy in the version of its image
+type proxyFlags struct {
+	v2 bool
+}
+
+// newProxyFlags returns the spelling of the command line of the proxy within image
+func newProxyFlags(image string) proxyFlags {
+	return proxyFlags{v2: isProxyV2(image)}
+}
+
+// command returns the binary of the proxy
+func (f proxyFlags) command() []string {
+	if f.v2 {
+		return append([]string{}, sqlProxyCmdV2...)
+	}
+	return append([]string{}, sqlProxyCmd...)
+}
+
+// socketDir returns the flag of the directory the proxy creates its sockets in
+func (f proxyFlags) socketDir(dir string) string {
+	if f.v2 {
+		return "--unix-socket=" + dir
+	}
+	return "-dir=" + dir
+}
+
+// credentialsFile returns the flag of the file containing the credentials of the proxy
+func (f proxyFlags) credentialsFile(file string) string {
+	if f.v2 {
+		return "--credentials-file=" + file
+	}
+	return "-credential_file=" + file
+}
+
+// instance returns the argument listening for the instance on the TCP port of localhost. The port
+// of an instance of version 2 takes precedence over the socket directory
+func (f proxyFlags) instance(instance string, port int) string {
+	if f.v2 {
+		return fmt.Sprintf("%s?port=%d", instance, port)
+	}
+	return fmt.Sprintf("-instances=%s=tcp:127.0.0.1:%d", instance, port)
+}
+
+// fuse returns the flags mounting the instances as FUSE filesystem within dir
+func (f proxyFlags) fuse(dir string) []string {
+	if f.v2 {
+		return []string{"--fuse=" + dir}
+	}
+	return []string{f.socketDir(dir), "-fuse"}
+}
+
+// logLevel returns the flags logging at the level of the proxy
+func (f proxyFlags) logLevel(level string) []string {
+	switch {
+	case level == logLevelError && f.v2:
+		return []string{"--quiet"}
+	case level == logLevelError:
+		return []string{"-verbose=false"}
+	case level == logLevelDebug && f.v2:
+		return []string{"--debug-logs"}
+	case level == logLevelDebug:
+		return []string{"-verbose=true", "-log_debug_stdout"}
+	case f.v2:
+		return nil
+	}
+	return []string{"-verbose=true"}
+}
+
+// iamAuthn returns the flag enabling automatic IAM database authentication
+func (f proxyFlags) iamAuthn() string {
+	if f.v2 {
+		return "--auto-iam-authn"
+	}
+	return "-enable_iam_login"
+}
+
+// healthCheck returns the flags serving the health checks of the proxy on port. Version 2 serves them
+// via its HTTP server, which only listens on localhost by default
+func (f proxyFlags) healthCheck(port int) []string {
+	if f.v2 {
+		return []string{"--health-check", "--http-address=0.0.0.0", "--http-port=" + strconv.Itoa(port)}
+	}
+	return []string{"-use_http_health_check", "-health_check_port=" + strconv.Itoa(port)}
+}
+
+// isProxyBinary checks whether the binary is the one of either version of the proxy
+func isProxyBinary(binary string) bool {
+	return binary == sqlProxyCmd[0] || binary == sqlProxyCmdV2[0]
+}
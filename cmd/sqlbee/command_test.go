@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyCommand(t *testing.T) {
+	opts := Options{DefaultInstance: "proj:eu:db", DefaultSecretName: "cloud-sql-proxy-credentials"}
+	for _, data := range []struct {
+		annotations map[string]string
+		expected    []string
+	}{
+		{
+			annotations: map[string]string{},
+			expected: []string{
+				"/cloud_sql_proxy", "-dir=/cloudsql", "-credential_file=/credentials/credentials.json",
+				"-instances=proj:eu:db=tcp:127.0.0.1:3306",
+			},
+		},
+		{
+			annotations: map[string]string{annotationImage: testImageV2},
+			expected: []string{
+				"/cloud-sql-proxy", "--unix-socket=/cloudsql", "--credentials-file=/credentials/credentials.json",
+				"proj:eu:db?port=3306",
+			},
+		},
+		{
+			annotations: map[string]string{
+				annotationLogLevel: "debug",
+				annotationDBType:   "postgres",
+				annotationIAMAuthn: "true",
+			},
+			expected: []string{
+				"/cloud_sql_proxy", "-dir=/cloudsql", "-credential_file=/credentials/credentials.json",
+				"-verbose=true", "-log_debug_stdout", "-enable_iam_login", "-instances=proj:eu:db=tcp:127.0.0.1:5432",
+			},
+		},
+		{
+			annotations: map[string]string{
+				annotationImage:     testImageV2,
+				annotationLogLevel:  "debug",
+				annotationDBType:    "postgres",
+				annotationIAMAuthn:  "true",
+				annotationQuit:      "true",
+				annotationTelemetry: "false",
+			},
+			expected: []string{
+				"/cloud-sql-proxy", "--unix-socket=/cloudsql", "--credentials-file=/credentials/credentials.json",
+				"--quitquitquit", "--debug-logs", "--disable-metrics", "--disable-traces", "--auto-iam-authn",
+				"proj:eu:db?port=5432",
+			},
+		},
+		{
+			annotations: map[string]string{annotationFuse: "true"},
+			expected: []string{
+				"/cloud_sql_proxy", "-credential_file=/credentials/credentials.json", "-dir=/cloudsql", "-fuse",
+			},
+		},
+		{
+			annotations: map[string]string{annotationImage: testImageV2, annotationFuse: "true", annotationLogLevel: "error"},
+			expected: []string{
+				"/cloud-sql-proxy", "--credentials-file=/credentials/credentials.json", "--quiet", "--fuse=/cloudsql",
+			},
+		},
+	} {
+		pod := testPodWithAnnotations(t, data.annotations)
+		proxyContainer, _, err := configureProxy(pod, opts)
+		require.NoError(t, err)
+		assert.Equal(t, data.expected, proxyContainer.Command, "%v", data.annotations)
+	}
+
+	// Version 2 of the proxy can't discover the instances of projects
+	pod := testPodWithAnnotations(t, map[string]string{annotationImage: testImageV2, annotationProjects: "proj"})
+	_, _, err := configureProxy(pod, opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires version 1 of the cloud-sql-proxy")
+}
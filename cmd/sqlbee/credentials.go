@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Sources of the secret based credentials. By default the secret is mounted as volume, clusters whose
+// policies forbid secret volumes can pass the credentials via an environment variable instead
+const (
+	credentialsSourceVolume = "volume"
+	credentialsSourceEnv    = "env"
+
+	// The environment variable of the proxy pointing to the credentials if passed via env
+	credentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+)
+
+// validateCredentialsSource checks whether the source of the credentials is supported
+func validateCredentialsSource(source string) error {
+	switch source {
+	case "", credentialsSourceVolume, credentialsSourceEnv, credentialsSourceCSI, credentialsSourceVault:
+		return nil
+	}
+	return fmt.Errorf("Invalid credentials source %q, expected %s, %s, %s or %s", source, credentialsSourceVolume, credentialsSourceEnv, credentialsSourceCSI, credentialsSourceVault)
+}
+
+// configureCredentialsEnv mounts the credentials within the secret via a projected volume instead of a
+// secret volume and points the application default credentials of the proxy to them via the environment.
+// Passing the credentials themselves would expose them within the command line of the proxy
+func configureCredentialsEnv(container *corev1.Container, volumes *[]corev1.Volume, secretName, secretKey string, opts Options) {
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      opts.CredentialsVolume,
+		MountPath: opts.CredentialsPath,
+		ReadOnly:  true,
+	})
+	*volumes = append(*volumes, corev1.Volume{
+		Name: opts.CredentialsVolume,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+						Items:                []corev1.KeyToPath{{Key: secretKey, Path: secretKey}},
+					},
+				}},
+			},
+		},
+	})
+	setEnv(container, corev1.EnvVar{Name: credentialsEnv, Value: path.Join(opts.CredentialsPath, secretKey)})
+}
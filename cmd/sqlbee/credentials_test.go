@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsEnv(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationSecret:     "creds",
+		annotationSecretKey:  "service-account.json",
+		annotationCredSource: "env",
+	})
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{}))
+
+	// The credentials are neither part of the command line nor mounted via a secret volume
+	for _, arg := range proxyContainer.Command {
+		assert.NotContains(t, arg, "credential")
+	}
+	assert.Contains(t, proxyContainer.Env, corev1.EnvVar{Name: "GOOGLE_APPLICATION_CREDENTIALS", Value: "/credentials/service-account.json"})
+	assert.Contains(t, proxyContainer.VolumeMounts, corev1.VolumeMount{Name: defaultCredentialsVolume, MountPath: "/credentials", ReadOnly: true})
+	assert.Contains(t, volumes, corev1.Volume{
+		Name: defaultCredentialsVolume,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "creds"},
+						Items:                []corev1.KeyToPath{{Key: "service-account.json", Path: "service-account.json"}},
+					},
+				}},
+			},
+		},
+	})
+	for _, volume := range volumes {
+		assert.Nil(t, volume.Secret)
+	}
+	assert.False(t, usesMetadataCredentials(*proxyContainer))
+
+	// The annotation takes precedence over the configured source
+	pod, proxyContainer = mutatePod(t, Options{CredentialsSource: credentialsSourceEnv}, map[string]string{annotationSecret: "creds", annotationCredSource: "volume"})
+	assert.Contains(t, proxyContainer.Command, "-credential_file=/credentials/credentials.json")
+	assert.Empty(t, proxyContainer.Env)
+
+	pod = testPodWithAnnotations(t, map[string]string{annotationSecret: "creds", annotationCredSource: "file"})
+	assert.Error(t, configureContainerAndVolumes(context.Background(), pod, sqlProxyContainer.DeepCopy(), &volumes, Options{}))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// Credentials mounted by the Secrets Store CSI driver, so the keys aren't stored within secrets
+	credentialsSourceCSI = "csi"
+
+	secretsStoreDriver = "secrets-store.csi.k8s.io"
+)
+
+// configureCredentialsCSI mounts the credentials provided by the secret provider class via the Secrets
+// Store CSI driver and returns the path of the credentials file. The class needs to provide the credentials
+// as object named after the secret key. Inline CSI volumes aren't known to our API types, so the source of
+// the returned volume is set on the serialized object
+func configureCredentialsCSI(container *corev1.Container, volumes *[]corev1.Volume, providerClass, secretKey string, opts Options) (string, error) {
+	if providerClass == "" {
+		return "", fmt.Errorf("Credentials mounted via the Secrets Store CSI driver require a secret provider class via annotation %s", annotationCSIClass)
+	}
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      opts.CredentialsVolume,
+		MountPath: opts.CredentialsPath,
+		ReadOnly:  true,
+	})
+	*volumes = append(*volumes, corev1.Volume{Name: opts.CredentialsVolume})
+	return path.Join(opts.CredentialsPath, secretKey), nil
+}
+
+// csiVolumeSource returns the serialized inline CSI volume source of the Secrets Store CSI driver
+func csiVolumeSource(providerClass string) map[string]interface{} {
+	return map[string]interface{}{
+		"csi": map[string]interface{}{
+			"driver":           secretsStoreDriver,
+			"readOnly":         true,
+			"volumeAttributes": map[string]interface{}{"secretProviderClass": providerClass},
+		},
+	}
+}
+
+// volumeFields returns the serialized volume sources of the credentials volumes mounted via the Secrets
+// Store CSI driver by their name, including the ones of the proxies of named instances
+func volumeFields(obj runtime.Object, opts Options) map[string]map[string]interface{} {
+	opts = withVolumeDefaults(opts)
+	fields := map[string]map[string]interface{}{}
+	add := func(obj runtime.Object, volume string) {
+		if annotationValue(obj, annotationCredSource, opts.CredentialsSource) == credentialsSourceCSI {
+			fields[volume] = csiVolumeSource(annotationValue(obj, annotationCSIClass, opts.SecretProviderClass))
+		}
+	}
+	add(obj, opts.CredentialsVolume)
+	for _, name := range namedInstances(obj) {
+		add(namedInstanceObject(obj, name), opts.CredentialsVolume+"-"+name)
+	}
+	return fields
+}
+
+// setVolumeFields sets the serialized fields on the volumes of the mutated object with the same name.
+// Sources unknown to our API types are lost when the object is decoded, so they are always set
+func setVolumeFields(mutated []byte, path []string, fields map[string]map[string]interface{}) ([]byte, error) {
+	if path == nil || len(fields) == 0 {
+		return mutated, nil
+	}
+	mutatedObj, err := decodeJSONObject(mutated)
+	if err != nil {
+		return nil, err
+	}
+	spec := nestedObject(mutatedObj, path)
+	if spec == nil {
+		return mutated, nil
+	}
+	for _, volume := range objectList(spec["volumes"]) {
+		name, _ := volume["name"].(string)
+		if volumeFields, exists := fields[name]; exists {
+			mergeFields(volume, volumeFields)
+		}
+	}
+	return json.Marshal(mutatedObj)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattbaird/jsonpatch"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsCSI(t *testing.T) {
+	raw := strings.Replace(podJson, `"sqlbee.connctd.io.inject": "true"`, `"sqlbee.connctd.io.inject": "true",
+         "sqlbee.connctd.io.credentialsSource": "csi",
+         "sqlbee.connctd.io.secretKey": "sa.json",
+         "sqlbee.connctd.io.instance.reporting": "proj:eu:reporting",
+         "sqlbee.connctd.io.secretProviderClass.reporting": "reporting-credentials"`, 1)
+	require.NotEqual(t, podJson, raw)
+
+	ar := Mutate(Options{DefaultInstance: "proj:eu:db", SecretProviderClass: "gcp-credentials"})(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Resource: podResource,
+			Object: runtime.RawExtension{
+				Raw: []byte(raw),
+			},
+		},
+	})
+	require.NotNil(t, ar)
+	require.True(t, ar.Allowed)
+
+	var ops []jsonpatch.JsonPatchOperation
+	require.NoError(t, json.Unmarshal(ar.Patch, &ops))
+	classes := map[string]interface{}{}
+	for _, op := range ops {
+		volume, isVolume := op.Value.(map[string]interface{})
+		if !isVolume || !strings.HasPrefix(op.Path, "/spec/volumes/") {
+			continue
+		}
+		csi, _ := volume["csi"].(map[string]interface{})
+		if csi == nil {
+			continue
+		}
+		assert.Equal(t, secretsStoreDriver, csi["driver"])
+		assert.Equal(t, true, csi["readOnly"])
+		classes[volume["name"].(string)] = csi["volumeAttributes"].(map[string]interface{})["secretProviderClass"]
+	}
+	assert.Equal(t, map[string]interface{}{
+		defaultCredentialsVolume:                "gcp-credentials",
+		defaultCredentialsVolume + "-reporting": "reporting-credentials",
+	}, classes)
+	assert.Contains(t, string(ar.Patch), `"-credential_file=/credentials/sa.json"`)
+
+	// The secret provider class is required
+	ar = Mutate(Options{DefaultInstance: "proj:eu:db", CredentialsSource: credentialsSourceCSI})(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Resource: podResource,
+			Object: runtime.RawExtension{
+				Raw: []byte(podJson),
+			},
+		},
+	})
+	require.NotNil(t, ar)
+	assert.False(t, ar.Allowed)
+}
@@ -0,0 +1,46 @@
+package main
+
+// configuredDefaults are the defaults of the injection returned by the health endpoint of SQLBee, so
+// fleet tooling can verify how it is configured
+type configuredDefaults struct {
+	Instance               string   `json:"instance,omitempty"`
+	Projects               string   `json:"projects,omitempty"`
+	Secret                 string   `json:"secret,omitempty"`
+	DBType                 string   `json:"dbType,omitempty"`
+	CredentialsSource      string   `json:"credentialsSource,omitempty"`
+	Image                  string   `json:"image"`
+	Mode                   string   `json:"mode,omitempty"`
+	Placement              string   `json:"placement,omitempty"`
+	RequireAnnotation      bool     `json:"requireAnnotation"`
+	Selector               string   `json:"selector,omitempty"`
+	Rollout                *Rollout `json:"rollout,omitempty"`
+	VerifyReferences       string   `json:"verifyReferences,omitempty"`
+	VerifyWorkloadIdentity string   `json:"verifyWorkloadIdentity,omitempty"`
+}
+
+// newConfiguredDefaults returns the defaults of the options, the image is the one injected unless
+// annotated
+func newConfiguredDefaults(opts Options) configuredDefaults {
+	image := defaultImage
+	if opts.DefaultImage != "" {
+		image = opts.DefaultImage
+	}
+	defaults := configuredDefaults{
+		Instance:               opts.DefaultInstance,
+		Projects:               opts.DefaultProjects,
+		Secret:                 opts.DefaultSecretName,
+		DBType:                 opts.DBType,
+		CredentialsSource:      opts.CredentialsSource,
+		Image:                  mirrorImage(image, opts.ImageMirror),
+		Mode:                   opts.Mode,
+		Placement:              opts.Placement,
+		RequireAnnotation:      opts.RequireAnnotation,
+		Rollout:                opts.Rollout,
+		VerifyReferences:       opts.VerifyReferences,
+		VerifyWorkloadIdentity: opts.VerifyWorkloadIdentity,
+	}
+	if opts.Selector != nil {
+		defaults.Selector = opts.Selector.String()
+	}
+	return defaults
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestNewConfiguredDefaults(t *testing.T) {
+	assert.Equal(t, configuredDefaults{
+		Instance:          "proj:eu:db",
+		Secret:            "creds",
+		Image:             defaultImage,
+		RequireAnnotation: true,
+	}, newConfiguredDefaults(Options{DefaultInstance: "proj:eu:db", DefaultSecretName: "creds", RequireAnnotation: true}))
+
+	defaults := newConfiguredDefaults(Options{
+		DefaultImage: "gcr.io/cloudsql-docker/gce-proxy:1.33.2",
+		ImageMirror:  "registry.internal/cloudsql",
+		Selector:     labels.SelectorFromSet(labels.Set{"db": "cloudsql"}),
+		Rollout:      &Rollout{Percent: 10},
+	})
+	assert.Equal(t, "registry.internal/cloudsql/gce-proxy:1.33.2", defaults.Image)
+	assert.Equal(t, "db=cloudsql", defaults.Selector)
+	assert.Equal(t, &Rollout{Percent: 10}, defaults.Rollout)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+const (
+	// Reasons of the events about the injection
+	reasonInjected = "SQLProxyInjected"
+	reasonDenied   = "SQLBeeInjectionDenied"
+
+	// Component reporting the events
+	eventComponent = "sqlbee"
+	// How long creating an event may take, independent of the admission request
+	eventTimeout = 10 * time.Second
+	// How many events may wait to be created, further ones are dropped
+	eventQueueSize = 100
+)
+
+// droppedEvents counts the events dropped as the queue was full, by their reason
+var droppedEvents = sting.NewCounter("sqlbee_dropped_events_total",
+	"Events about injections and denials dropped as too many were waiting to be created", "reason")
+
+// admittedObjectMeta returns the metadata of the object being admitted
+func admittedObjectMeta(ar *v1beta1.AdmissionReview) (metav1.ObjectMeta, error) {
+	object := struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}{}
+	err := json.Unmarshal(ar.Request.Object.Raw, &object)
+	return object.Metadata, err
+}
+
+// involvedObject returns the object the events about the admission of an object are reported on.
+// Objects being created have no UID yet and pods often no name, so the events of objects with a
+// controller are reported on the controller, e.g. the ReplicaSet of a pod. Returns false if the
+// object can't be referenced
+func involvedObject(ar *v1beta1.AdmissionReview, meta metav1.ObjectMeta) (corev1.ObjectReference, bool) {
+	for _, owner := range meta.OwnerReferences {
+		if owner.Controller != nil && *owner.Controller {
+			return corev1.ObjectReference{
+				APIVersion: owner.APIVersion,
+				Kind:       owner.Kind,
+				Namespace:  ar.Request.Namespace,
+				Name:       owner.Name,
+				UID:        owner.UID,
+			}, true
+		}
+	}
+	name := ar.Request.Name
+	if name == "" {
+		name = meta.Name
+	}
+	if name == "" || ar.Request.Namespace == "" {
+		return corev1.ObjectReference{}, false
+	}
+	return corev1.ObjectReference{
+		APIVersion: metav1.GroupVersion{Group: ar.Request.Kind.Group, Version: ar.Request.Kind.Version}.String(),
+		Kind:       ar.Request.Kind.Kind,
+		Namespace:  ar.Request.Namespace,
+		Name:       name,
+		UID:        meta.UID,
+	}, true
+}
+
+// admissionEvent returns the event about the admission decision, or nil if the object has been
+// allowed without injection
+func admissionEvent(ar *v1beta1.AdmissionReview, response *v1beta1.AdmissionResponse) *corev1.Event {
+	meta, err := admittedObjectMeta(ar)
+	if err != nil {
+		return nil
+	}
+	involved, ok := involvedObject(ar, meta)
+	if !ok {
+		return nil
+	}
+	object := ar.Request.Name
+	if object == "" {
+		object = meta.Name
+	}
+	if object == "" {
+		object = meta.GenerateName
+	}
+	if !response.Allowed {
+		message := "Refused to inject the cloud-sql-proxy"
+		if response.Result != nil {
+			message = response.Result.Message
+		}
+		return kube.NewEvent(involved, corev1.EventTypeWarning, reasonDenied,
+			fmt.Sprintf("%s %s: %s", ar.Request.Kind.Kind, object, message), eventComponent)
+	}
+	if len(response.Patch) == 0 || string(response.Patch) == "[]" {
+		return nil
+	}
+	return kube.NewEvent(involved, corev1.EventTypeNormal, reasonInjected,
+		fmt.Sprintf("Injected the cloud-sql-proxy into %s %s", ar.Request.Kind.Kind, object), eventComponent)
+}
+
+// queuedEvent is an event waiting to be created and the admission request it is about
+type queuedEvent struct {
+	requestUID types.UID
+	event      *corev1.Event
+}
+
+// eventQueue creates events one after another in the background. Events are dropped while the queue
+// is full, so a slow API server doesn't pile up events and goroutines
+type eventQueue struct {
+	events kube.EventCreator
+	queue  chan queuedEvent
+}
+
+// newEventQueue creates a new eventQueue holding up to size events, they are created once it runs
+func newEventQueue(events kube.EventCreator, size int) *eventQueue {
+	return &eventQueue{events: events, queue: make(chan queuedEvent, size)}
+}
+
+// enqueue adds the event to the queue. Returns false if it has been dropped as the queue is full
+func (q *eventQueue) enqueue(requestUID types.UID, event *corev1.Event) bool {
+	select {
+	case q.queue <- queuedEvent{requestUID: requestUID, event: event}:
+		return true
+	default:
+		droppedEvents.Inc(event.Reason)
+		return false
+	}
+}
+
+// run creates the queued events until the context is done
+func (q *eventQueue) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case queued := <-q.queue:
+			createCtx, cancel := context.WithTimeout(ctx, eventTimeout)
+			if err := q.events.CreateEvent(createCtx, queued.event); err != nil {
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"requestUID": queued.requestUID,
+					"namespace":  queued.event.Namespace,
+					"object":     queued.event.InvolvedObject.Kind + "/" + queued.event.InvolvedObject.Name,
+					"reason":     queued.event.Reason,
+				}).Warn("Failed to create the event about the injection")
+			}
+			cancel()
+		}
+	}
+}
+
+// recordEvents creates events about the injections and denials of the mutation, so users see them
+// via kubectl describe. The events are created in the background by the queue, dry runs don't cause
+// any
+func recordEvents(mutate sting.MutateContextFunc, events *eventQueue) sting.MutateContextFunc {
+	return func(ctx context.Context, ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+		response := mutate(ctx, ar)
+		if response == nil || ar.Request == nil || (ar.Request.DryRun != nil && *ar.Request.DryRun) {
+			return response
+		}
+		event := admissionEvent(ar, response)
+		if event == nil {
+			return response
+		}
+		if !events.enqueue(ar.Request.UID, event) {
+			logrus.WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"namespace":  event.Namespace,
+				"object":     event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name,
+				"reason":     event.Reason,
+			}).Debug("Dropped the event about the injection as too many are waiting to be created")
+		}
+		return response
+	}
+}
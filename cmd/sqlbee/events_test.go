@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// channelEvents passes the created events to the channel
+type channelEvents chan *corev1.Event
+
+func (c channelEvents) CreateEvent(ctx context.Context, event *corev1.Event) error {
+	c <- event
+	return nil
+}
+
+func TestRecordEvents(t *testing.T) {
+	controller := true
+	pod := testPodWithAnnotations(t, nil)
+	pod.Name = ""
+	pod.GenerateName = "wordpress-7d9f8-"
+	pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "wordpress-7d9f8", UID: "rs-uid", Controller: &controller}}
+	owned, err := json.Marshal(pod)
+	require.NoError(t, err)
+
+	dryRun := true
+	for _, data := range []struct {
+		instance string
+		raw      []byte
+		name     string
+		dryRun   *bool
+		event    *corev1.Event
+	}{
+		{
+			instance: "proj:eu:db",
+			raw:      owned,
+			event: &corev1.Event{
+				InvolvedObject: corev1.ObjectReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Namespace: "team-a", Name: "wordpress-7d9f8", UID: "rs-uid"},
+				Type:           corev1.EventTypeNormal,
+				Reason:         reasonInjected,
+				Message:        "Injected the cloud-sql-proxy into Pod wordpress-7d9f8-",
+			},
+		},
+		{
+			instance: "proj:eu",
+			raw:      []byte(podJson),
+			name:     "wordpress",
+			event: &corev1.Event{
+				InvolvedObject: corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "team-a", Name: "wordpress"},
+				Type:           corev1.EventTypeWarning,
+				Reason:         reasonDenied,
+			},
+		},
+		{instance: "proj:eu:db", raw: owned, dryRun: &dryRun},
+	} {
+		events := make(channelEvents, 1)
+		queue := newEventQueue(events, eventQueueSize)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go queue.run(ctx)
+		mutate := recordEvents(MutateContext(Options{DefaultInstance: data.instance}), queue)
+		ar := mutate(context.Background(), &v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Namespace: "team-a",
+				Name:      data.name,
+				Resource:  podResource,
+				DryRun:    data.dryRun,
+				Object:    runtime.RawExtension{Raw: data.raw},
+			},
+		})
+		require.NotNil(t, ar)
+
+		if data.event == nil {
+			select {
+			case event := <-events:
+				t.Fatalf("Unexpected event %+v", event)
+			case <-time.After(50 * time.Millisecond):
+			}
+			continue
+		}
+		var event *corev1.Event
+		select {
+		case event = <-events:
+		case <-time.After(time.Second):
+			t.Fatal("No event created")
+		}
+		assert.Equal(t, data.event.InvolvedObject, event.InvolvedObject)
+		assert.Equal(t, "team-a", event.Namespace)
+		assert.Equal(t, data.event.Type, event.Type)
+		assert.Equal(t, data.event.Reason, event.Reason)
+		assert.Equal(t, eventComponent, event.Source.Component)
+		if data.event.Message != "" {
+			assert.Equal(t, data.event.Message, event.Message)
+		} else {
+			assert.Contains(t, event.Message, "Pod wordpress: ")
+		}
+	}
+}
+
+func TestEventQueueDropsEvents(t *testing.T) {
+	events := make(channelEvents, 2)
+	queue := newEventQueue(events, 1)
+	dropped := droppedEvents.Value(reasonInjected)
+	assert.True(t, queue.enqueue("first", &corev1.Event{Reason: reasonInjected}))
+	// The queue is full until it runs
+	assert.False(t, queue.enqueue("second", &corev1.Event{Reason: reasonInjected}))
+	assert.Equal(t, dropped+1, droppedEvents.Value(reasonInjected))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.run(ctx)
+	select {
+	case event := <-events:
+		assert.Equal(t, reasonInjected, event.Reason)
+	case <-time.After(time.Second):
+		t.Fatal("No event created")
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("Unexpected event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// The proxy serves its health checks on this port by default. The proxies of named instances use
+	// the following ports
+	defaultHealthCheckPort = 8090
+
+	healthStartupPath   = "/startup"
+	healthLivenessPath  = "/liveness"
+	healthReadinessPath = "/readiness"
+)
+
+// isHealthChecks determines whether the health checks of the proxy are enabled for the given object
+func isHealthChecks(obj runtime.Object, opts Options) bool {
+	return annotationBool(obj, annotationHealth, opts.HealthChecks)
+}
+
+// healthProbe returns a probe requesting the health check endpoint at path
+func healthProbe(path string, port int, period, timeout, failures int32) *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromInt(port),
+			},
+		},
+		PeriodSeconds:    period,
+		TimeoutSeconds:   timeout,
+		FailureThreshold: failures,
+	}
+}
+
+// configureHealthChecks enables the health check endpoints of the proxy and adds probes using them,
+// so a wedged proxy is restarted and the pod only becomes ready once the proxy is. Returns the
+// command of the proxy extended by the required flags
+func configureHealthChecks(obj runtime.Object, container *corev1.Container, cmd []string, flags proxyFlags) ([]string, error) {
+	port := defaultHealthCheckPort
+	if value := annotationValue(obj, annotationHealthPort); value != "" {
+		var err error
+		if port, err = parsePort(value); err != nil {
+			return nil, fmt.Errorf("Invalid value of annotation %s: %s", annotationHealthPort, err)
+		}
+	}
+	// The thresholds follow the recommendations for the proxy
+	container.LivenessProbe = healthProbe(healthLivenessPath, port, 60, 30, 5)
+	container.ReadinessProbe = healthProbe(healthReadinessPath, port, 10, 5, 1)
+	return append(cmd, flags.healthCheck(port)...), nil
+}
+
+// setStartupProbe adds a startup probe to the serialized proxy container if its health checks are
+// enabled. Startup probes aren't known to our API types, they are derived from the liveness probe
+func setStartupProbe(container map[string]interface{}) {
+	if _, exists := container["startupProbe"]; exists {
+		return
+	}
+	liveness, _ := container["livenessProbe"].(map[string]interface{})
+	httpGet, _ := liveness["httpGet"].(map[string]interface{})
+	if httpGet == nil || httpGet["path"] != healthLivenessPath {
+		return
+	}
+	container["startupProbe"] = map[string]interface{}{
+		"httpGet": map[string]interface{}{
+			"path": healthStartupPath,
+			"port": httpGet["port"],
+		},
+		"periodSeconds":    1,
+		"timeoutSeconds":   5,
+		"failureThreshold": 20,
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecks(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		port        int
+		flags       []string
+	}{
+		{
+			annotations: map[string]string{annotationHealth: "true"},
+			port:        defaultHealthCheckPort,
+			flags:       []string{"-use_http_health_check", "-health_check_port=8090"},
+		},
+		{
+			annotations: map[string]string{},
+			opts:        Options{HealthChecks: true},
+			port:        defaultHealthCheckPort,
+			flags:       []string{"-use_http_health_check", "-health_check_port=8090"},
+		},
+		{
+			annotations: map[string]string{annotationHealthPort: "9000"},
+			opts:        Options{HealthChecks: true},
+			port:        9000,
+			flags:       []string{"-use_http_health_check", "-health_check_port=9000"},
+		},
+		{
+			annotations: map[string]string{annotationHealth: "true", annotationImage: testImageV2},
+			port:        defaultHealthCheckPort,
+			flags:       []string{"--health-check", "--http-address=0.0.0.0", "--http-port=8090"},
+		},
+		{annotations: map[string]string{annotationHealth: "false"}, opts: Options{HealthChecks: true}},
+	} {
+		_, proxyContainer := mutatePod(t, data.opts, data.annotations)
+
+		if data.port == 0 {
+			assert.NotContains(t, proxyContainer.Command, "-use_http_health_check")
+			assert.NotContains(t, proxyContainer.Command, "--health-check")
+			assert.Nil(t, proxyContainer.LivenessProbe)
+			assert.Nil(t, proxyContainer.ReadinessProbe)
+			continue
+		}
+		assert.Subset(t, proxyContainer.Command, data.flags)
+		require.NotNil(t, proxyContainer.LivenessProbe)
+		assert.Equal(t, healthLivenessPath, proxyContainer.LivenessProbe.HTTPGet.Path)
+		assert.Equal(t, data.port, proxyContainer.LivenessProbe.HTTPGet.Port.IntValue())
+		require.NotNil(t, proxyContainer.ReadinessProbe)
+		assert.Equal(t, healthReadinessPath, proxyContainer.ReadinessProbe.HTTPGet.Path)
+	}
+
+	pod := testPodWithAnnotations(t, map[string]string{annotationHealth: "true", annotationHealthPort: "none"})
+	_, _, err := configureProxy(pod, Options{})
+	assert.Error(t, err)
+}
+
+func TestNamedInstanceHealthChecks(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationHealth:                    "true",
+		annotationInstance:                  "proj:eu:default",
+		annotationInstance + ".billing":     "proj:eu:billing",
+		annotationInstance + ".reporting":   "proj:eu:reporting",
+		annotationHealthPort + ".reporting": "9100",
+	})
+	require.NoError(t, configureNamedInstances(context.Background(), pod, "cloud-sql-proxy", 3306, &pod.Spec, Options{}))
+	assert.Contains(t, containerByName(&pod.Spec, "cloud-sql-proxy-billing").Command, "-health_check_port=8091")
+	assert.Contains(t, containerByName(&pod.Spec, "cloud-sql-proxy-reporting").Command, "-health_check_port=9100")
+}
+
+func TestSetStartupProbe(t *testing.T) {
+	container := map[string]interface{}{
+		"livenessProbe": map[string]interface{}{
+			"httpGet": map[string]interface{}{"path": healthLivenessPath, "port": 8091},
+		},
+	}
+	setStartupProbe(container)
+	startup, _ := container["startupProbe"].(map[string]interface{})
+	require.NotNil(t, startup)
+	assert.Equal(t, map[string]interface{}{"path": healthStartupPath, "port": 8091}, startup["httpGet"])
+
+	container = map[string]interface{}{
+		"livenessProbe": map[string]interface{}{
+			"httpGet": map[string]interface{}{"path": "/custom", "port": 8080},
+		},
+	}
+	setStartupProbe(container)
+	assert.NotContains(t, container, "startupProbe")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// splitImage splits an image reference into the repository and the tag or digest including its
+// separator, e.g. gcr.io/cloudsql-docker/gce-proxy and :1.33.1
+func splitImage(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i:]
+	}
+	if i := strings.LastIndex(image, ":"); i >= 0 && !strings.Contains(image[i:], "/") {
+		return image[:i], image[i:]
+	}
+	return image, ""
+}
+
+// mirrorImage rewrites an image to be pulled from the mirror, keeping the name of the repository and
+// the tag or digest. Images already referring to the mirror are returned unchanged
+func mirrorImage(image, mirror string) string {
+	mirror = strings.TrimSuffix(mirror, "/")
+	if mirror == "" || strings.HasPrefix(image, mirror+"/") {
+		return image
+	}
+	repository, reference := splitImage(image)
+	return mirror + "/" + path.Base(repository) + reference
+}
+
+// isProxyV2 determines whether an image contains version 2 of the proxy, either by the name of its
+// repository or by the major version of its tag
+func isProxyV2(image string) bool {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	repository, tag := splitImage(image)
+	if path.Base(repository) == path.Base(imageNameV2) {
+		return true
+	}
+	version := strings.TrimPrefix(strings.TrimPrefix(tag, ":"), "v")
+	if i := strings.IndexAny(version, ".-"); i >= 0 {
+		version = version[:i]
+	}
+	major, err := strconv.Atoi(version)
+	return err == nil && major >= 2
+}
+
+// majorMinorVersion returns the major and minor version of the tag of an image, e.g. 1 and 33 of
+// gce-proxy:1.33.1-alpine
+func majorMinorVersion(image string) (int, int, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(imageVersion(image), "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	return major, minor, err == nil
+}
+
+// isUnpinnedImage checks whether an image refers to no specific version, as it has neither a digest nor
+// a tag other than latest
+func isUnpinnedImage(image string) bool {
+	_, reference := splitImage(image)
+	return reference == "" || reference == ":latest"
+}
+
+// isDeprecatedImage checks whether an image contains version 1 of the proxy older than the built-in
+// default image
+func isDeprecatedImage(image string) bool {
+	if isProxyV2(image) {
+		return false
+	}
+	major, minor, ok := majorMinorVersion(image)
+	_, defaultMinor, _ := majorMinorVersion(defaultImage)
+	return ok && major == 1 && minor < defaultMinor
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitImage(t *testing.T) {
+	for image, expected := range map[string][2]string{
+		"gcr.io/cloudsql-docker/gce-proxy:1.33.1":     {"gcr.io/cloudsql-docker/gce-proxy", ":1.33.1"},
+		"gcr.io/cloudsql-docker/gce-proxy@sha256:abc": {"gcr.io/cloudsql-docker/gce-proxy", "@sha256:abc"},
+		"localhost:5000/gce-proxy":                    {"localhost:5000/gce-proxy", ""},
+		"localhost:5000/gce-proxy:1.33.1":             {"localhost:5000/gce-proxy", ":1.33.1"},
+		"gce-proxy":                                   {"gce-proxy", ""},
+	} {
+		repository, reference := splitImage(image)
+		assert.Equal(t, expected[0], repository, image)
+		assert.Equal(t, expected[1], reference, image)
+	}
+}
+
+func TestMirrorImage(t *testing.T) {
+	mirror := "registry.internal/cloudsql"
+	for image, expected := range map[string]string{
+		"gcr.io/cloudsql-docker/gce-proxy:1.33.1":     "registry.internal/cloudsql/gce-proxy:1.33.1",
+		"gcr.io/cloudsql-docker/gce-proxy@sha256:abc": "registry.internal/cloudsql/gce-proxy@sha256:abc",
+		"gce-proxy": "registry.internal/cloudsql/gce-proxy",
+		"registry.internal/cloudsql/gce-proxy:1.30.0": "registry.internal/cloudsql/gce-proxy:1.30.0",
+	} {
+		assert.Equal(t, expected, mirrorImage(image, mirror), image)
+	}
+	assert.Equal(t, defaultImage, mirrorImage(defaultImage, ""))
+	assert.Equal(t, "registry.internal/cloudsql/gce-proxy:1.33.1", mirrorImage(defaultImage, mirror+"/"))
+}
+
+func TestConfigureImageMirror(t *testing.T) {
+	opts := Options{ImageMirror: "registry.internal/cloudsql"}
+	for _, data := range []struct {
+		annotations map[string]string
+		image       string
+	}{
+		{annotations: nil, image: "registry.internal/cloudsql/gce-proxy:" + imageTag},
+		{annotations: map[string]string{annotationImage: "eu.gcr.io/custom/proxy:1.2.3"}, image: "registry.internal/cloudsql/proxy:1.2.3"},
+	} {
+		_, proxyContainer := mutatePod(t, opts, data.annotations)
+		assert.Equal(t, data.image, proxyContainer.Image)
+	}
+}
+
+type staticResolver map[string]string
+
+func (s staticResolver) ResolveDigest(ctx context.Context, image string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if pinned, exists := s[image]; exists {
+		return pinned, nil
+	}
+	return "", errors.New("unknown image")
+}
+
+func TestConfigureResolvedDigest(t *testing.T) {
+	pinned := defaultImage + "@sha256:9e5ab5a7a7ba1c1d3e7e3f7c1d0b2c9a2b3a7f1f6e5e4d3c2b1a09f8e7d6c5b4"
+	opts := Options{Digests: staticResolver{defaultImage: pinned}}
+
+	_, proxyContainer := mutatePod(t, opts, nil)
+	assert.Equal(t, pinned, proxyContainer.Image)
+	assert.Equal(t, imageTag, imageVersion(proxyContainer.Image))
+
+	_, _, err := configureProxy(testPodWithAnnotations(t, map[string]string{annotationImage: "eu.gcr.io/custom/proxy:1.2.3"}), opts)
+	assert.Error(t, err)
+
+	// The lookup is canceled together with the admission request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	err = configureContainerAndVolumes(ctx, testPodWithAnnotations(t, nil), sqlProxyContainer.DeepCopy(), &volumes, opts)
+	assert.Error(t, err)
+}
+
+func TestIsProxyV2(t *testing.T) {
+	for image, expected := range map[string]bool{
+		defaultImage: false,
+		"gcr.io/cloudsql-docker/gce-proxy:1.33.1@sha256:abc": false,
+		"gce-proxy":            false,
+		imageNameV2 + ":2.8.0": true,
+		imageNameV2:            true,
+		"registry.internal/cloudsql/cloud-sql-proxy:2.8.0-alpine": true,
+		"registry.internal/cloudsql/gce-proxy:2.0.0@sha256:abc":   true,
+		"localhost:5000/proxy:v2.1.0":                             true,
+	} {
+		assert.Equal(t, expected, isProxyV2(image), image)
+	}
+}
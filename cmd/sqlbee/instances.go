@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+// The admin servers of named instances listen on ports following the one of the default proxy, like
+// their health checks
+const defaultAdminPort = 9091
+
+// namedInstances returns the sorted names of the instances which are annotated via indexed
+// annotations like sqlbee.connctd.io/instance.reporting
+func namedInstances(obj runtime.Object) []string {
+	names := []string{}
+	for name, instance := range annotationsWithPrefix(obj, annotationInstance+".") {
+		if name != "" && instance != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namedInstanceObject returns an object carrying the annotations which apply to the proxy of a named
+// instance. Indexed annotations like sqlbee.connctd.io/secret.reporting take precedence over the
+// annotations of the object, the remaining ones are shared with the default proxy. Ports can't be shared
+func namedInstanceObject(obj runtime.Object, name string) *corev1.Pod {
+	suffix := "." + name
+	annotations := map[string]string{}
+	for key, value := range annotationsWithPrefix(obj, annotationBase) {
+		if annotationBase+key == annotationPort || annotationBase+key == annotationHealthPort {
+			continue
+		}
+		if !strings.HasSuffix(key, suffix) {
+			if _, exists := annotations[annotationBase+key]; !exists {
+				annotations[annotationBase+key] = value
+			}
+			continue
+		}
+		annotations[annotationBase+strings.TrimSuffix(key, suffix)] = value
+	}
+	// Named instances always connect to a single instance via TCP
+	delete(annotations, annotationFuse)
+	delete(annotations, annotationProjects)
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      objectLabels(obj),
+			Annotations: annotations,
+		},
+	}
+}
+
+// quitURLEnvName returns the name of the environment variable containing the quitquitquit URL of
+// the proxy of a named instance
+func quitURLEnvName(name string) string {
+	return quitURLEnv + "_" + strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
+
+// configureNamedInstances adds an independent proxy container with separate port and volumes for every
+// named instance to the podSpec. The containers are named after the default proxy and the instance name
+func configureNamedInstances(ctx context.Context, obj runtime.Object, proxyName string, defaultPort int, podSpec *corev1.PodSpec, opts Options) error {
+	names := namedInstances(obj)
+	if len(names) == 0 {
+		return nil
+	}
+
+	// Named instances can't rely on defaults intended for the default proxy
+	opts = withVolumeDefaults(opts)
+	opts.DefaultInstance = ""
+	opts.DefaultProjects = ""
+	opts.Fuse = false
+
+	mode, err := injectionMode(obj, opts)
+	if err != nil {
+		return sting.Invalid(err.Error())
+	}
+
+	usedPorts := map[int]bool{defaultPort: true}
+	proxyNames := map[string]bool{proxyName: true}
+	quitEnv := []corev1.EnvVar{}
+	for i, name := range names {
+		namedObj := namedInstanceObject(obj, name)
+		port, err := proxyPort(namedObj, opts)
+		if err != nil {
+			return sting.Invalid(fmt.Sprintf("Failed to configure the proxy of instance %s: %s", name, err))
+		}
+		if annotationValue(namedObj, annotationPort) == "" {
+			// Unless annotated the next free port starting with the default port of the engine is used
+			for usedPorts[port] {
+				port++
+			}
+		}
+		if usedPorts[port] {
+			return sting.Invalid(fmt.Sprintf("Port %d of instance %s is already in use", port, name))
+		}
+		usedPorts[port] = true
+		namedObj.Annotations[annotationPort] = strconv.Itoa(port)
+		if annotationValue(namedObj, annotationHealthPort) == "" {
+			namedObj.Annotations[annotationHealthPort] = strconv.Itoa(defaultHealthCheckPort + i + 1)
+		}
+
+		container := sqlProxyContainer.DeepCopy()
+		volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+		if err := configureContainerAndVolumes(ctx, namedObj, container, &volumes, opts); err != nil {
+			return fmt.Errorf("Failed to configure the proxy of instance %s: %w", name, err)
+		}
+		container.Name = proxyName + "-" + name
+		proxyNames[container.Name] = true
+
+		// The volumes of the proxies are separated, except the shared socket directory
+		for j := range volumes {
+			if volumes[j].Name != opts.SocketVolume {
+				volumes[j].Name += "-" + name
+			}
+		}
+		for j := range container.VolumeMounts {
+			if container.VolumeMounts[j].Name != opts.SocketVolume {
+				container.VolumeMounts[j].Name += "-" + name
+			}
+		}
+
+		if isQuitQuitQuit(namedObj, opts) {
+			adminPort := defaultAdminPort + i + 1
+			container.Command = append(container.Command, fmt.Sprintf("--admin-port=%d", adminPort))
+			quitEnv = append(quitEnv, corev1.EnvVar{
+				Name:  quitURLEnvName(name),
+				Value: fmt.Sprintf("http://127.0.0.1:%d/quitquitquit", adminPort),
+			})
+		}
+		if mode == modeInitSidecar {
+			mutatePodSpecNative(volumes, container, podSpec)
+		} else {
+			mutatePodSpec(volumes, container, podSpec)
+			placement, _ := proxyPlacement(namedObj, opts)
+			podSpec.Containers = placeContainer(podSpec.Containers, container.Name, placement)
+		}
+	}
+
+	for i := range podSpec.Containers {
+		if proxyNames[podSpec.Containers[i].Name] {
+			continue
+		}
+		for _, env := range quitEnv {
+			addEnv(&podSpec.Containers[i], env)
+		}
+	}
+	return nil
+}
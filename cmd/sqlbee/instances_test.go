@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func containerByName(podSpec *corev1.PodSpec, name string) *corev1.Container {
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == name {
+			return &podSpec.Containers[i]
+		}
+	}
+	return nil
+}
+
+func volumeNames(podSpec *corev1.PodSpec) []string {
+	names := []string{}
+	for _, volume := range podSpec.Volumes {
+		names = append(names, volume.Name)
+	}
+	return names
+}
+
+func TestNamedInstances(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationInstance:                 "proj:eu:default",
+		annotationSecret:                   "default-credentials",
+		annotationQuit:                     "true",
+		annotationImage:                    testImageV2,
+		annotationInstance + ".reporting":  "proj:eu:reporting",
+		annotationSecret + ".reporting":    "reporting-credentials",
+		annotationCPURequest + ".billing":  "50m",
+		annotationInstance + ".billing":    "proj:eu:billing",
+		annotationPort + ".billing":        "3307",
+		annotationInstance + ".empty-name": "",
+	})
+	assert.Equal(t, []string{"billing", "reporting"}, namedInstances(pod))
+
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{}))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	configureAppContainers(pod, proxyContainer, &pod.Spec, Options{})
+	require.NoError(t, configureNamedInstances(context.Background(), pod, proxyContainer.Name, 3306, &pod.Spec, Options{}))
+
+	require.Len(t, pod.Spec.Containers, 4)
+	names := volumeNames(&pod.Spec)
+	for _, name := range []string{"cloudsql", "sql-service-token-account", "sql-service-token-account-billing", "sql-service-token-account-reporting"} {
+		assert.Contains(t, names, name)
+	}
+	assert.Len(t, names, len(testPodWithAnnotations(t, nil).Spec.Volumes)+4)
+
+	billing := containerByName(&pod.Spec, "cloud-sql-proxy-billing")
+	require.NotNil(t, billing)
+	assert.Contains(t, billing.Command, "proj:eu:billing?port=3307")
+	assert.Contains(t, billing.Command, "--admin-port=9092")
+	assert.Equal(t, "50m", billing.Resources.Requests.Cpu().String())
+	assert.Equal(t, "sql-service-token-account-billing", billing.VolumeMounts[1].Name)
+
+	reporting := containerByName(&pod.Spec, "cloud-sql-proxy-reporting")
+	require.NotNil(t, reporting)
+	assert.Contains(t, reporting.Command, "proj:eu:reporting?port=3308")
+	assert.Contains(t, reporting.Command, "--admin-port=9093")
+	assert.Equal(t, "10m", reporting.Resources.Requests.Cpu().String())
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == "sql-service-token-account-reporting" {
+			assert.Equal(t, "reporting-credentials", volume.Secret.SecretName)
+		}
+	}
+
+	app := pod.Spec.Containers[0]
+	assert.Contains(t, app.Env, corev1.EnvVar{Name: quitURLEnv, Value: quitURL})
+	assert.Contains(t, app.Env, corev1.EnvVar{Name: "SQLBEE_QUIT_URL_BILLING", Value: "http://127.0.0.1:9092/quitquitquit"})
+	assert.Contains(t, app.Env, corev1.EnvVar{Name: "SQLBEE_QUIT_URL_REPORTING", Value: "http://127.0.0.1:9093/quitquitquit"})
+	assert.Empty(t, billing.Env)
+}
+
+func TestMutateNamedInstancesOnly(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationInstance + ".reporting": "proj:eu:reporting",
+	})
+	ar := reviewPod(t, Options{}, "", pod)
+	require.True(t, ar.Allowed)
+	assert.Contains(t, string(ar.Patch), "cloud-sql-proxy-reporting")
+	assert.NotContains(t, string(ar.Patch), `"name":"cloud-sql-proxy"`)
+}
+
+func TestNamedInstancesPortInUse(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationInstance + ".reporting": "proj:eu:reporting",
+		annotationPort + ".reporting":     "3306",
+	})
+	assert.Error(t, configureNamedInstances(context.Background(), pod, "cloud-sql-proxy", 3306, &pod.Spec, Options{}))
+}
+
+func TestQuitURLEnvName(t *testing.T) {
+	assert.Equal(t, "SQLBEE_QUIT_URL_REPORTING_DB", quitURLEnvName("reporting-db"))
+}
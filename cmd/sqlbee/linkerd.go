@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// Outbound connections to these ports bypass the Linkerd proxy
+	linkerdSkipOutboundPorts = "config.linkerd.io/skip-outbound-ports"
+	// Cloud SQL instances accept the TLS connections of the proxy on this port. Linkerd can't
+	// detect the protocol, as the server speaks first
+	cloudSQLPort = 3307
+)
+
+// isLinkerd checks whether the outbound traffic of the proxy needs to bypass Linkerd
+func isLinkerd(obj runtime.Object, opts Options) bool {
+	return annotationBool(obj, annotationLinkerd, opts.Linkerd)
+}
+
+// portsContain checks whether a comma separated list of ports and port ranges like 3306-3310
+// contains the port
+func portsContain(ports string, port int) bool {
+	for _, p := range strings.Split(ports, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(p), "-", 2)
+		low, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+		high := low
+		if len(bounds) == 2 {
+			if high, err = strconv.Atoi(bounds[1]); err != nil {
+				continue
+			}
+		}
+		if low <= port && port <= high {
+			return true
+		}
+	}
+	return false
+}
+
+// configureLinkerd annotates the pod template so the connections of the proxy to the Cloud SQL
+// instances bypass the Linkerd proxy. Ports skipped by the workload itself are kept
+func configureLinkerd(obj runtime.Object) {
+	annotations := templateAnnotations(obj)
+	if annotations == nil {
+		return
+	}
+	if *annotations == nil {
+		*annotations = map[string]string{}
+	}
+	ports := (*annotations)[linkerdSkipOutboundPorts]
+	if portsContain(ports, cloudSQLPort) {
+		return
+	}
+	if strings.TrimSpace(ports) != "" {
+		ports += ","
+	}
+	(*annotations)[linkerdSkipOutboundPorts] = ports + strconv.Itoa(cloudSQLPort)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortsContain(t *testing.T) {
+	assert.True(t, portsContain("3307", 3307))
+	assert.True(t, portsContain("25, 3307", 3307))
+	assert.True(t, portsContain("3300-3310", 3307))
+	assert.False(t, portsContain("", 3307))
+	assert.False(t, portsContain("3306,3308-3310", 3307))
+	assert.False(t, portsContain("invalid-ports", 3307))
+}
+
+func TestConfigureLinkerd(t *testing.T) {
+	pod := testPodWithAnnotations(t, nil)
+	pod.Annotations = nil
+	configureLinkerd(pod)
+	assert.Equal(t, "3307", pod.Annotations[linkerdSkipOutboundPorts])
+	configureLinkerd(pod)
+	assert.Equal(t, "3307", pod.Annotations[linkerdSkipOutboundPorts])
+
+	pod.Annotations[linkerdSkipOutboundPorts] = "25"
+	configureLinkerd(pod)
+	assert.Equal(t, "25,3307", pod.Annotations[linkerdSkipOutboundPorts])
+}
+
+func TestMutateLinkerd(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		skipped     bool
+	}{
+		{annotations: map[string]string{annotationInstance: "proj:eu:db"}, skipped: false},
+		{annotations: map[string]string{annotationInstance: "proj:eu:db"}, opts: Options{Linkerd: true}, skipped: true},
+		{annotations: map[string]string{annotationInstance: "proj:eu:db", annotationLinkerd: "true"}, skipped: true},
+		{annotations: map[string]string{annotationInstance: "proj:eu:db", annotationLinkerd: "false"}, opts: Options{Linkerd: true}, skipped: false},
+	} {
+		raw, err := json.Marshal(testPodWithAnnotations(t, data.annotations))
+		require.NoError(t, err)
+		ar := Mutate(data.opts)(&v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Resource: podResource,
+				Object:   runtime.RawExtension{Raw: raw},
+			},
+		})
+		require.True(t, ar.Allowed)
+		assert.Equal(t, data.skipped, strings.Contains(string(ar.Patch), "skip-outbound-ports"), data.annotations)
+	}
+}
@@ -1,20 +1,127 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
 
+	"github.com/connctd/sqlbee/pkg/kube"
+	"github.com/connctd/sqlbee/pkg/registry"
+	"github.com/connctd/sqlbee/pkg/secretmanager"
 	"github.com/connctd/sqlbee/pkg/sting"
+	"github.com/connctd/sqlbee/pkg/sting/logrusadapter"
 )
 
 var (
 	certPath          = flag.String("cert", "", "Path to server certificate")
 	keyPath           = flag.String("key", "", "Path to server private key")
+	certSecret        = flag.String("certSecret", "", "Secret containing the server certificate and private key as tls.crt and tls.key, like <namespace>/<name> or the name of a secret in the namespace of SQLBee. It is watched for updates instead of loading cert and key")
+	bootstrapCert     = flag.Bool("bootstrapCertificate", false, "If set, SQLBee generates its own CA and server certificate, stores them in the certSecret and sets the CA bundle of the webhookConfiguration")
+	webhookConfig     = flag.String("webhookConfiguration", "", "Name of the mutating webhook configuration whose CA bundle is set when bootstrapping the certificate")
+	certIssuer        = flag.String("certificateIssuer", issuerSelfSigned, "Issuer of the bootstrapped certificate: self-signed, csr:<signer> to request it via the CertificateSigningRequest API or cert-manager:<Issuer|ClusterIssuer>/<name>")
+	certIssuerCA      = flag.String("certificateCA", filepath.Join(kube.ServiceAccountDir, "ca.crt"), "Path to the CA certificate of the signer of certificates requested via the CertificateSigningRequest API, defaults to the CA of the cluster")
+	approveCSR        = flag.Bool("approveCertificate", false, "If set, SQLBee approves its own CertificateSigningRequests, which requires the permission to approve requests of the signer")
+	serviceName       = flag.String("serviceName", "sqlbee-svc", "Name of the service of SQLBee, the bootstrapped certificate is issued for")
 	instanceName      = flag.String("instance", "", "Default cloud sql instance to connect to")
+	nsInstances       = flag.String("namespaceInstances", "", "Default instances per namespace like team-a=proj:eu:db-a,team-b=proj:eu:db-b")
+	nsInstancesFile   = flag.String("namespaceInstancesFile", "", "Path to a file containing default instances per namespace, one namespace=instance per line")
+	image             = flag.String("image", "", "Default image of the proxy, defaults to the supported proxy version")
+	projectNames      = flag.String("projects", "", "Default GCP project(s) in which all cloud sql instances are discovered, used if no instance is annotated")
+	dbType            = flag.String("db-type", "mysql", "Database engine of the instances, one of mysql, postgres or sqlserver. Determines the default port of the proxy")
 	secretName        = flag.String("secret", "", "Optional secret to use for credentials. Needs to contain a valid 'credentials.json' key")
+	secretKey         = flag.String("secretKey", "credentials.json", "Key of the credentials within the secret")
+	credSource        = flag.String("credentialsSource", credentialsSourceVolume, "Whether the credentials within the secret are mounted as volume, as projected volume passed via an environment variable (env), mounted via the Secrets Store CSI driver (csi) or rendered by the Vault Agent injector (vault)")
+	vaultRole         = flag.String("vaultRole", "", "Vault role used by the Vault Agent injector to render the credentials, if the credentials source is vault")
+	vaultSecretPath   = flag.String("vaultSecretPath", "", "Path of the Vault secret containing the credentials, if the credentials source is vault")
+	providerClass     = flag.String("secretProviderClass", "", "Secret provider class of the Secrets Store CSI driver providing the credentials, if the credentials source is csi")
+	tokenAudience     = flag.String("tokenAudience", "", "Audience of the projected service account token, enables workload identity federation instead of secret based credentials")
+	federatedConfig   = flag.String("federatedConfig", "", "Name of a config map containing the credential configuration for workload identity federation")
 	caConfigMapName   = flag.String("ca-map", "", "Optional name of a config map containing root certs")
 	requireAnnotation = flag.Bool("annotationRequired", false, "If set, the inject annotation is required to inject the object")
+	fuse              = flag.Bool("fuse", false, "If set, the proxy runs in FUSE mode and creates instance sockets on demand")
+	iamAuthn          = flag.Bool("iamAuthn", false, "If set, the proxy authenticates the database users via IAM. Not supported for SQL Server, version 1 of the proxy only supports PostgreSQL")
+	quitQuitQuit      = flag.Bool("quitquitquit", false, "If set, the proxy can be shut down via its quitquitquit endpoint, e.g. when a Job has finished. Requires version 2 of the proxy")
+	quiet             = flag.Bool("quiet", false, "If set, the proxy only logs errors instead of every connection")
+	disableTelemetry  = flag.Bool("disableTelemetry", false, "If set, the proxy neither exports metrics nor traces")
+	containerName     = flag.String("containerName", "cloud-sql-proxy", "Name of the injected container, existing containers with this name are replaced")
+	socketVolume      = flag.String("socketVolume", defaultSocketVolume, "Name of the volume containing the sockets of the proxy")
+	socketPath        = flag.String("socketPath", defaultSocketPath, "Mount path of the volume containing the sockets of the proxy")
+	socketMedium      = flag.String("socketMedium", "", "Storage medium of the socket volume, Memory to back it by a tmpfs")
+	socketSizeLimit   = flag.String("socketSizeLimit", "", "Size limit of the socket volume, unlimited by default")
+	credVolume        = flag.String("credentialsVolume", defaultCredentialsVolume, "Name of the volume containing the credentials")
+	credPath          = flag.String("credentialsPath", defaultCredentialsPath, "Mount path of the volume containing the credentials")
+	caVolume          = flag.String("caVolume", defaultCAVolume, "Name of the volume containing the root certificates")
+	caPath            = flag.String("caPath", defaultCAPath, "Mount path of the volume containing the root certificates")
+	imageMirror       = flag.String("image-mirror", "", "Registry mirror the proxy images are pulled from instead, e.g. registry.internal/cloudsql")
+	httpsProxy        = flag.String("httpsProxy", "", "Egress proxy set as HTTPS_PROXY on the proxy container")
+	noProxy           = flag.String("noProxy", "", "Hosts excluded from the egress proxy, set as NO_PROXY on the proxy container")
+	mode              = flag.String("mode", modeSidecar, "Whether the proxy is injected as additional container (sidecar), as native sidecar (init-sidecar) or depending on the cluster version (auto)")
+	placement         = flag.String("placement", placementLast, "Where the proxy is placed within the containers: first, last or before:<container>")
+	waitForProxy      = flag.Bool("waitForProxy", false, "If set, the application containers are only started once the proxy accepts connections. Requires an image of the proxy containing a shell and nc, like the alpine variants")
+	healthChecks      = flag.Bool("healthChecks", false, "If set, the health check endpoints of the proxy are enabled and probed")
+	disableHardening  = flag.Bool("disableHardening", false, "If set, the proxy runs without the hardened security context satisfying the restricted pod security standard")
+	restricted        = flag.Bool("podSecurityRestricted", false, "If set, the proxy always runs with the hardened security context and injections violating the restricted pod security standard of the namespace are refused")
+	appArmorProfile   = flag.String("appArmorProfile", "", "AppArmor profile of the proxy: runtime/default, unconfined or localhost/<profile>, not set by default")
+	appArmorSkip      = flag.String("appArmorSkipRuntimeClasses", "", "Comma separated list of runtime classes not supporting AppArmor, pods using them receive no profile")
+	proxyUser         = flag.Int64("proxyUser", defaultProxyUser, "User and group the proxy runs as with the hardened security context")
+	preStop           = flag.Duration("preStopDelay", 0, "How long the termination of the proxy is delayed, so it keeps serving while the application shuts down")
+	preStopHook       = flag.String("preStopHook", preStopHookAuto, "Whether the preStop hook uses the sleep action of Kubernetes 1.30 (sleep), executes sleep within the proxy container (exec) or depends on the cluster version (auto)")
+	linkerd           = flag.Bool("linkerd", false, "If set, the connections of the proxy to the instances bypass the Linkerd proxy")
+	cpuRequest        = flag.String("cpuRequest", defaultCPURequest, "Default CPU request of the proxy")
+	memRequest        = flag.String("memRequest", defaultMemRequest, "Default memory request of the proxy")
+	cpuLimit          = flag.String("cpuLimit", "", "Default CPU limit of the proxy, unset by default")
+	memLimit          = flag.String("memLimit", "", "Default memory limit of the proxy, unset by default")
+	imagePullPolicy   = flag.String("imagePullPolicy", "", "Pull policy of the proxy image, defaults to the cluster default")
+	imagePullSecrets  = flag.String("imagePullSecrets", "", "Comma separated list of secrets required to pull the proxy image")
+	useNamespaces     = flag.Bool("namespaceDefaults", false, "If set, the annotations of namespaces are used as defaults for the objects within them")
+	namespaceLabels   = flag.Bool("namespaceLabels", false, "If set, the injection label of namespaces enables or disables the injection for the objects within them")
+	rolloutPercent    = flag.Int("rolloutPercent", 100, "Percentage of the eligible workloads being injected, to roll out a new proxy image or version gradually")
+	rolloutNamespaces = flag.String("rolloutNamespaces", "", "Comma separated list of namespaces, if set only the workloads within them are injected")
+	selector          = flag.String("selector", "", "Label selector, if set only objects matching it are injected, e.g. team=payments")
+	namespaceCacheTTL = flag.Duration("namespaceCacheTTL", time.Minute, "How long namespaces are cached")
+	useSecretManager  = flag.Bool("secretManager", false, "If set, secrets of Secret Manager annotated via secretManagerSecret are materialized as Kubernetes secrets")
+	smSecret          = flag.String("secretManagerSecret", "", "Default secret of Secret Manager containing the credentials, like projects/<project>/secrets/<secret>. Enables secretManager")
+	smAllowed         = flag.String("secretManagerAllowedSecrets", "", "Comma separated list of further secrets of Secret Manager which may be annotated via secretManagerSecret, secrets without a version allow each of their versions")
+	smNamespaces      = flag.String("secretManagerNamespaces", "", "Comma separated list of namespaces which opted in to receive secrets of Secret Manager, required by secretManager")
+	smCacheTTL        = flag.Duration("secretManagerCacheTTL", 10*time.Minute, "How long the payloads of Secret Manager are cached before rotated versions are picked up")
+	replicate         = flag.Bool("replicateSecret", false, "If set, the secret is copied from the namespace of SQLBee into the namespaces of the objects referencing it and kept in sync")
+	replicaNamespaces = flag.String("replicateNamespaces", "", "Comma separated list of namespaces which opted in to receive replicas of the secret, required by replicateSecret")
+	replicationPeriod = flag.Duration("replicationInterval", 10*time.Minute, "How often the replicas of the secret are synced")
+	verifyWorkloadID  = flag.String("verifyWorkloadIdentity", "", "If set to warn or deny, injections of proxies without credentials into pods whose service account lacks the iam.gke.io/gcp-service-account annotation are warned about or refused")
+	verifyRefs        = flag.String("verifyReferences", "", "If set to warn or deny, injections referencing secrets or config maps which don't exist are logged or refused")
+	listenAddrs       = flag.String("listenAddrs", ":443", "Comma separated list of addresses the admission endpoint is served on")
+	mutatePaths       = flag.String("mutatePaths", "/api/v1beta/mutate", "Comma separated list of URL paths the mutating admission endpoint is served on")
+	events            = flag.Bool("events", false, "If set, injections and denials are recorded as events of the objects or their controllers")
+	resolveDigests    = flag.Bool("resolveDigests", false, "If set, the tags of the proxy images are resolved to digests via the registry and the pinned images are injected")
+	digestCacheTTL    = flag.Duration("digestCacheTTL", 10*time.Minute, "How long resolved image digests are cached")
+	digestFailureTTL  = flag.Duration("digestFailureTTL", 30*time.Second, "How long failures to resolve image digests are cached, so an unavailable registry doesn't delay every admission")
+	digestRegistries  = flag.String("digestRegistries", "", "Comma separated list of further registries the digests of annotated images are resolved from, besides the one of the default image or the mirror")
+	maxInFlight       = flag.Int("maxInFlight", 100, "Maximum number of admission requests handled concurrently, unlimited if 0")
+	maxQueued         = flag.Int("maxQueued", 20, "Maximum number of admission requests waiting for a free slot, others are rejected with 429")
+	queueTimeout      = flag.Duration("queueTimeout", 2*time.Second, "How long admission requests wait for a free slot before they are rejected with 503")
+	disableHTTP2      = flag.Bool("disableHTTP2", false, "If set, the admission endpoint only offers HTTP/1.1 instead of HTTP/2")
+	shutdownTimeout   = flag.Duration("shutdownTimeout", 15*time.Second, "How long open connections may drain on shutdown before they are closed")
+	handlerTimeout    = flag.Duration("handlerTimeout", 9*time.Second, "How long the mutation may take before the response of the failure policy is returned, should be below the timeoutSeconds of the webhook")
+	operations        = flag.String("operations", "CREATE", "Comma separated list of the admission operations which are mutated, others and subresources are allowed unchanged")
+	failurePolicy     = flag.String("failurePolicy", "Fail", "Whether objects whose mutation fails internally are denied (Fail) or admitted unchanged (Ignore)")
+	auditLog          = flag.String("auditLog", "", "If set, every admission decision is recorded as JSON to the file, or to stdout if -")
+	auditLogMaxSize   = flag.Int64("auditLogMaxSize", 100, "Maximum size of the audit log file in megabytes before it is rotated, never rotated if 0")
+	auditLogBackups   = flag.Int("auditLogMaxBackups", 5, "Number of rotated audit log files to keep")
+	auditURL          = flag.String("auditURL", "", "If set, every admission decision is posted as JSON to the HTTP(S) endpoint")
+	auditTokenFile    = flag.String("auditTokenFile", "", "Path to a file containing the bearer token sent to the audit endpoint")
+	shadow            = flag.Bool("shadow", false, "If set, the decisions are only recorded and every object is admitted unchanged")
+	recentDecisions   = flag.Int("recentDecisions", 100, "Number of the last admission decisions returned by GET /decisions of the admin server, none are kept if 0")
+	adminTokenFile    = flag.String("adminTokenFile", "", "Path to a file containing the bearer token required to change the log level at runtime via PUT /loglevel and to reload the certificate via POST /reload-certs of the admin server")
 	logLevel          = flag.String("loglevel", "info", "LogLevel")
 )
 
@@ -34,24 +141,373 @@ func main() {
 		"version":           sting.Version,
 		"logLevel":          lvl.String(),
 		"sqlInstance":       *instanceName,
+		"sqlProjects":       *projectNames,
 		"requireAnnotation": *requireAnnotation,
+		"shadow":            *shadow,
 	}).Info("Starting SQLBee")
 
 	// Configure our InjectServer
 	opts := sting.NewOptions()
+	opts.Logger = logrusadapter.New(logrus.StandardLogger())
+	opts.LogLevel = logrusadapter.NewLevel(logrus.StandardLogger())
+	if *adminTokenFile != "" {
+		token, err := ioutil.ReadFile(*adminTokenFile)
+		if err != nil {
+			logrus.WithError(err).WithField("adminTokenFile", *adminTokenFile).Panic("Failed to read the admin token")
+		}
+		opts.AdminToken = strings.TrimSpace(string(token))
+	}
 
 	// Configure our MutateFunc with the received parameters
 	mutateOpts := Options{}
 	mutateOpts.DefaultInstance = *instanceName
+	mutateOpts.DefaultProjects = *projectNames
+	mapping := *nsInstances
+	if *nsInstancesFile != "" {
+		content, err := ioutil.ReadFile(*nsInstancesFile)
+		if err != nil {
+			logrus.WithError(err).WithField("file", *nsInstancesFile).Panic("Failed to read the default instances per namespace")
+		}
+		mapping += "\n" + string(content)
+	}
+	mutateOpts.NamespaceInstances, err = parseNamespaceInstances(mapping)
+	if err != nil {
+		logrus.WithError(err).Panic("Invalid default instances per namespace")
+	}
 	mutateOpts.DefaultCertVolume = *caConfigMapName
+	if _, supported := dbTypePorts[*dbType]; !supported {
+		logrus.WithField("dbType", *dbType).Panic("Unsupported database type")
+	}
+	mutateOpts.DBType = *dbType
 	mutateOpts.DefaultSecretName = *secretName
+	mutateOpts.SecretKey = *secretKey
+	mutateOpts.CredentialsSource = *credSource
+	mutateOpts.SecretProviderClass = *providerClass
+	mutateOpts.VaultRole = *vaultRole
+	mutateOpts.VaultSecretPath = *vaultSecretPath
+	if err := validateCredentialsSource(*credSource); err != nil {
+		logrus.WithError(err).WithField("credentialsSource", *credSource).Panic("Unsupported source of the credentials")
+	}
+	mutateOpts.TokenAudience = *tokenAudience
+	mutateOpts.FederatedConfig = *federatedConfig
 	mutateOpts.RequireAnnotation = *requireAnnotation
+	mutateOpts.Fuse = *fuse
+	mutateOpts.Shadow = *shadow
+	if err := validateDBType(&corev1.Pod{}, mutateOpts, *dbType); err != nil {
+		logrus.WithError(err).WithField("dbType", *dbType).Panic("Unsupported options of the database type")
+	}
+	mutateOpts.QuitQuitQuit = *quitQuitQuit
+	mutateOpts.IAMAuthn = *iamAuthn
+	mutateOpts.Quiet = *quiet
+	mutateOpts.DisableTelemetry = *disableTelemetry
+	mutateOpts.ContainerName = *containerName
+	mutateOpts.SocketVolume = *socketVolume
+	mutateOpts.SocketPath = *socketPath
+	if *socketMedium != "" && corev1.StorageMedium(*socketMedium) != corev1.StorageMediumMemory {
+		logrus.WithField("socketMedium", *socketMedium).Panic("Unsupported medium of the socket volume")
+	}
+	mutateOpts.SocketMedium = *socketMedium
+	if _, err := resource.ParseQuantity(*socketSizeLimit); *socketSizeLimit != "" && err != nil {
+		logrus.WithError(err).WithField("socketSizeLimit", *socketSizeLimit).Panic("Invalid size limit of the socket volume")
+	}
+	mutateOpts.SocketSizeLimit = *socketSizeLimit
+	mutateOpts.CredentialsVolume = *credVolume
+	mutateOpts.CredentialsPath = *credPath
+	mutateOpts.CAVolume = *caVolume
+	mutateOpts.CAPath = *caPath
+	mutateOpts.DefaultCPURequest = *cpuRequest
+	mutateOpts.DefaultMemRequest = *memRequest
+	mutateOpts.DefaultCPULimit = *cpuLimit
+	mutateOpts.DefaultMemLimit = *memLimit
+	for name, quantity := range map[string]string{"cpuRequest": *cpuRequest, "memRequest": *memRequest, "cpuLimit": *cpuLimit, "memLimit": *memLimit} {
+		if _, err := resource.ParseQuantity(quantity); quantity != "" && err != nil {
+			logrus.WithError(err).WithField(name, quantity).Panic("Invalid default resources of the proxy")
+		}
+	}
+	mutateOpts.ImagePullPolicy = *imagePullPolicy
+	mutateOpts.DefaultImage = *image
+	mutateOpts.ImageMirror = *imageMirror
+	mutateOpts.HTTPSProxy = *httpsProxy
+	mutateOpts.NoProxy = *noProxy
+	mutateOpts.Linkerd = *linkerd
+	mutateOpts.HealthChecks = *healthChecks
+	mutateOpts.DisableHardening = *disableHardening
+	mutateOpts.ProxyUser = *proxyUser
+	mutateOpts.PodSecurityRestricted = *restricted
+	if err := validateAppArmorProfile(*appArmorProfile); err != nil {
+		logrus.WithError(err).WithField("appArmorProfile", *appArmorProfile).Panic("Unsupported AppArmor profile of the proxy")
+	}
+	mutateOpts.AppArmorProfile = *appArmorProfile
+	if *appArmorSkip != "" {
+		mutateOpts.AppArmorSkipRuntimeClasses = strings.Split(*appArmorSkip, ",")
+	}
+	mutateOpts.PreStopDelay = *preStop
+	switch *preStopHook {
+	case preStopHookSleep, preStopHookExec:
+		mutateOpts.PreStopHook = *preStopHook
+	case preStopHookAuto:
+		client, err := kube.NewInClusterClient()
+		if err == nil {
+			mutateOpts.PreStopHook, err = detectPreStopHook(context.Background(), client)
+		}
+		if err != nil {
+			// Executing sleep at worst fails the hook, while the sleep action isn't accepted by older clusters
+			logrus.WithError(err).Warn("Failed to detect the preStop hook supported by the cluster, executing sleep within the proxy")
+			mutateOpts.PreStopHook = preStopHookExec
+		}
+		logrus.WithField("preStopHook", mutateOpts.PreStopHook).Info("Detected the preStop hook")
+	default:
+		logrus.WithField("preStopHook", *preStopHook).Panic("Unsupported preStop hook")
+	}
+	if defaults := newConfiguredDefaults(mutateOpts); *preStop > 0 && mutateOpts.PreStopHook == preStopHookExec && !hasShell(defaults.Image) {
+		logrus.WithField("image", defaults.Image).Panic("Delaying the termination of the proxy on clusters without the sleep action requires an image containing sleep like the -alpine variants")
+	}
+	if err := validatePlacement(*placement); err != nil {
+		logrus.WithError(err).WithField("placement", *placement).Panic("Unsupported placement of the proxy")
+	}
+	mutateOpts.Placement = *placement
+	mutateOpts.WaitForProxy = *waitForProxy
+	if defaults := newConfiguredDefaults(mutateOpts); *waitForProxy && !hasShell(defaults.Image) {
+		logrus.WithField("image", defaults.Image).Panic("Waiting for the proxy requires an image containing a shell and nc like the -alpine variants")
+	}
+	switch *mode {
+	case modeSidecar, modeInitSidecar:
+		mutateOpts.Mode = *mode
+	case modeAuto:
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to create Kubernetes client to retrieve the cluster version")
+		}
+		if mutateOpts.Mode, err = detectMode(context.Background(), client); err != nil {
+			logrus.WithError(err).Panic("Failed to detect the injection mode")
+		}
+		logrus.WithField("mode", mutateOpts.Mode).Info("Detected the injection mode")
+	default:
+		logrus.WithField("mode", *mode).Panic("Unsupported injection mode")
+	}
+	if *resolveDigests {
+		// Only the registries of the configured images are requested, as the annotated images are untrusted
+		defaults := newConfiguredDefaults(mutateOpts)
+		registries := []string{}
+		if *digestRegistries != "" {
+			registries = strings.Split(*digestRegistries, ",")
+		}
+		defaultRegistry, err := registry.ImageRegistry(defaults.Image)
+		if err != nil {
+			logrus.WithError(err).WithField("image", defaults.Image).Panic("Invalid default image of the proxy")
+		}
+		client := registry.NewClient(&http.Client{Timeout: 10 * time.Second}, append(registries, defaultRegistry))
+		mutateOpts.Digests = registry.NewDigestCache(client, *digestCacheTTL, *digestFailureTTL)
+	}
+	if *imagePullSecrets != "" {
+		mutateOpts.ImagePullSecrets = strings.Split(*imagePullSecrets, ",")
+	}
+
+	if *selector != "" {
+		mutateOpts.Selector, err = labels.Parse(*selector)
+		if err != nil {
+			logrus.WithError(err).WithField("selector", *selector).Panic("Invalid label selector")
+		}
+	}
+
+	if *rolloutPercent != 100 || *rolloutNamespaces != "" {
+		mutateOpts.Rollout, err = newRollout(*rolloutPercent, *rolloutNamespaces)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"rolloutPercent":    *rolloutPercent,
+				"rolloutNamespaces": *rolloutNamespaces,
+			}).Panic("Invalid rollout")
+		}
+	}
+
+	mutateOpts.NamespaceDefaults = *useNamespaces
+	mutateOpts.NamespaceLabels = *namespaceLabels
+	if mutateOpts.NamespaceDefaults || mutateOpts.NamespaceLabels || mutateOpts.PodSecurityRestricted {
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to create Kubernetes client to retrieve namespaces")
+		}
+		mutateOpts.Namespaces = kube.NewNamespaceCache(client, *namespaceCacheTTL)
+	}
+	smAllowedSecrets := []string{}
+	for _, secret := range append([]string{*smSecret}, strings.Split(*smAllowed, ",")...) {
+		if secret == "" {
+			continue
+		}
+		if _, err := secretmanager.VersionName(secret); err != nil {
+			logrus.WithError(err).WithField("secretManagerSecret", secret).Panic("Invalid secret of Secret Manager")
+		}
+		smAllowedSecrets = append(smAllowedSecrets, secret)
+	}
+	mutateOpts.SecretManagerSecret = *smSecret
+	mutateOpts.SecretManagerAllowed = smAllowedSecrets
+	if *useSecretManager || *smSecret != "" {
+		if *smNamespaces == "" {
+			logrus.Panic("Materializing secrets of Secret Manager requires the namespaces receiving them via secretManagerNamespaces")
+		}
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to create Kubernetes client to materialize secrets of Secret Manager")
+		}
+		accessor := secretmanager.NewClient(&http.Client{Timeout: 10 * time.Second}, secretmanager.DefaultEndpoint, secretmanager.DefaultTokenURL)
+		mutateOpts.SecretManager = secretmanager.NewSecretSync(accessor, client, smAllowedSecrets, strings.Split(*smNamespaces, ","), *smCacheTTL)
+	}
+	if *replicate && *secretName != "" {
+		if *replicaNamespaces == "" {
+			logrus.Panic("Replicating the secret requires the namespaces receiving replicas via replicateNamespaces")
+		}
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to create Kubernetes client to replicate the secret")
+		}
+		namespace, err := kube.InClusterNamespace()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to determine the namespace of SQLBee")
+		}
+		replicator := kube.NewSecretReplicator(client, namespace, *secretName, strings.Split(*replicaNamespaces, ","))
+		// Replicas aren't written in shadow mode
+		if !*shadow {
+			go replicator.Run(context.Background(), *replicationPeriod, func(err error) {
+				logrus.WithError(err).WithField("secret", *secretName).Warn("Failed to sync the replicas of the secret")
+			})
+		}
+		mutateOpts.Replicator = replicator
+	}
+	if err := validateVerifyReferences(*verifyWorkloadID); err != nil {
+		logrus.WithError(err).WithField("verifyWorkloadIdentity", *verifyWorkloadID).Panic("Unsupported verification of the workload identity")
+	}
+	mutateOpts.VerifyWorkloadIdentity = *verifyWorkloadID
+	if mutateOpts.VerifyWorkloadIdentity != "" {
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to create Kubernetes client to verify the workload identity")
+		}
+		mutateOpts.ServiceAccounts = client
+	}
+	if err := validateVerifyReferences(*verifyRefs); err != nil {
+		logrus.WithError(err).WithField("verifyReferences", *verifyRefs).Panic("Unsupported verification of the references")
+	}
+	mutateOpts.VerifyReferences = *verifyRefs
+	if mutateOpts.VerifyReferences != "" {
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to create Kubernetes client to verify references")
+		}
+		mutateOpts.Objects = client
+	}
 
-	opts.Mutate = Mutate(mutateOpts)
-	opts.CertFile = *certPath
-	opts.KeyFile = *keyPath
+	opts.MutateContext = MutateContext(mutateOpts)
+	opts.Defaults = newConfiguredDefaults(mutateOpts)
+	if usesKubernetes(mutateOpts) {
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to create Kubernetes client to check the readiness")
+		}
+		opts.HealthChecks = append(opts.HealthChecks, kubernetesHealthCheck(client))
+	}
+	// Events would report injections which don't happen in shadow mode
+	if *events && !*shadow {
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to create Kubernetes client to record events")
+		}
+		queue := newEventQueue(client, eventQueueSize)
+		go queue.run(context.Background())
+		opts.MutateContext = recordEvents(opts.MutateContext, queue)
+	}
+	opts.MutatePaths = strings.Split(*mutatePaths, ",")
+	opts.ListenAddrs = strings.Split(*listenAddrs, ",")
+	opts.MaxInFlight = *maxInFlight
+	opts.MaxQueued = *maxQueued
+	opts.QueueTimeout = *queueTimeout
+	opts.ShutdownTimeout = *shutdownTimeout
+	opts.HandlerTimeout = *handlerTimeout
+	if opts.Operations, err = sting.ParseOperations(*operations); err != nil {
+		logrus.WithError(err).WithField("operations", *operations).Panic("Unsupported operations")
+	}
+	if opts.FailurePolicy, err = sting.ParseFailurePolicy(*failurePolicy); err != nil {
+		logrus.WithError(err).WithField("failurePolicy", *failurePolicy).Panic("Unsupported failure policy")
+	}
+	if *auditLog == "-" {
+		opts.Auditor = sting.NewJSONAuditor(os.Stdout)
+	} else if *auditLog != "" {
+		file, err := sting.OpenRotatingFile(*auditLog, *auditLogMaxSize*1024*1024, *auditLogBackups)
+		if err != nil {
+			logrus.WithError(err).WithField("auditLog", *auditLog).Panic("Failed to open the audit log")
+		}
+		opts.Auditor = sting.NewJSONAuditor(file)
+	}
+	if *auditURL != "" {
+		auditorOpts := sting.HTTPAuditorOptions{URL: *auditURL, Logger: opts.Logger}
+		if *auditTokenFile != "" {
+			token, err := ioutil.ReadFile(*auditTokenFile)
+			if err != nil {
+				logrus.WithError(err).WithField("auditTokenFile", *auditTokenFile).Panic("Failed to read the audit token")
+			}
+			auditorOpts.Token = strings.TrimSpace(string(token))
+		}
+		auditor, err := sting.NewHTTPAuditor(auditorOpts)
+		if err != nil {
+			logrus.WithError(err).WithField("auditURL", *auditURL).Panic("Failed to configure the audit endpoint")
+		}
+		if opts.Auditor != nil {
+			opts.Auditor = sting.NewMultiAuditor(opts.Auditor, auditor)
+		} else {
+			opts.Auditor = auditor
+		}
+	}
+	opts.RecentDecisions = *recentDecisions
+	opts.Shadow = *shadow
+	if *disableHTTP2 {
+		opts.NextProtos = []string{"http/1.1"}
+	}
+	// The certificate secret replaces the certificate files
+	certificate := sting.WithTLSFiles(*certPath, *keyPath)
+	if *certSecret != "" {
+		client, err := kube.NewInClusterClient()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to create Kubernetes client to watch the certificate secret")
+		}
+		namespace, err := kube.InClusterNamespace()
+		if err != nil {
+			logrus.WithError(err).Panic("Failed to determine the namespace of SQLBee")
+		}
+		secret, err := certSecretReference(*certSecret, namespace)
+		if err != nil {
+			logrus.WithError(err).WithField("certSecret", *certSecret).Panic("Invalid certificate secret")
+		}
+		certificate = sting.WithCertSecret(secret, client)
+		if *bootstrapCert {
+			if *webhookConfig == "" {
+				logrus.Panic("Bootstrapping the certificate requires the name of the webhook configuration")
+			}
+			if err := validateCertificateIssuer(*certIssuer); err != nil {
+				logrus.WithError(err).WithField("certificateIssuer", *certIssuer).Panic("Unsupported issuer of the certificate")
+			}
+			dnsNames := kube.ServiceDNSNames(*serviceName, namespace)
+			if strings.HasPrefix(*certIssuer, issuerCertManager) {
+				if err := requestCertManagerCertificate(context.Background(), client, secret, *webhookConfig, dnsNames, *certIssuer); err != nil {
+					logrus.WithError(err).WithField("certificateIssuer", *certIssuer).Panic("Failed to request the certificate from cert-manager")
+				}
+			} else {
+				issuer, err := certificateIssuer(*certIssuer, client, *certIssuerCA, *approveCSR)
+				if err != nil {
+					logrus.WithError(err).WithField("certificateCA", *certIssuerCA).Panic("Failed to read the CA certificate of the signer")
+				}
+				bootstrapper := kube.NewCertificateBootstrapper(issuer, client, client, secret.Namespace, secret.Name, *webhookConfig, dnsNames)
+				if err := bootstrapper.Bootstrap(context.Background()); err != nil {
+					logrus.WithError(err).WithField("certSecret", *certSecret).Panic("Failed to bootstrap the certificate")
+				}
+				go bootstrapper.Run(context.Background(), certificateBootstrapInterval, func(err error) {
+					logrus.WithError(err).WithField("certSecret", *certSecret).Warn("Failed to renew the bootstrapped certificate")
+				})
+			}
+		}
+	} else if *bootstrapCert {
+		logrus.Panic("Bootstrapping the certificate requires a certificate secret")
+	}
 
-	server, err := sting.New(opts)
+	server, err := sting.New(opts, certificate)
 	if err != nil {
 		logrus.WithError(err).Panic("Failed to create inject server")
 	}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1beta1 "k8s.io/apimachinery/pkg/apis/meta/v1beta1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 
+	"github.com/connctd/sqlbee/pkg/kube"
+	"github.com/connctd/sqlbee/pkg/registry"
+	"github.com/connctd/sqlbee/pkg/secretmanager"
 	"github.com/connctd/sqlbee/pkg/sting"
 )
 
@@ -27,35 +41,126 @@ var (
 	annotationMemRequest = annotationBase + "memRequest"
 	annotationCPULimits  = annotationBase + "cpuLimits"
 	annotationMemLimits  = annotationBase + "memLimits"
+	annotationCPULimit   = annotationBase + "cpuLimit"
+	annotationMemLimit   = annotationBase + "memLimit"
+	annotationFuse       = annotationBase + "fuse"
+	annotationProjects   = annotationBase + "projects"
+	annotationQuit       = annotationBase + "quitquitquit"
+	annotationVerbose    = annotationBase + "verbose"
+	annotationTelemetry  = annotationBase + "telemetry"
+	annotationExtraArgs  = annotationBase + "extraArgs"
+	annotationContainer  = annotationBase + "containerName"
+	annotationPullPolicy = annotationBase + "imagePullPolicy"
+	annotationPullSecret = annotationBase + "imagePullSecrets"
+	annotationSecCtx     = annotationBase + "securityContext"
+	annotationLogLevel   = annotationBase + "logLevel"
+	annotationPort       = annotationBase + "port"
+	annotationOverride   = annotationBase + "override"
+	annotationSecretKey  = annotationBase + "secretKey"
+	annotationDBType     = annotationBase + "dbType"
+	annotationIAMAuthn   = annotationBase + "iamAuthn"
+	annotationAudience   = annotationBase + "tokenAudience"
+	annotationFedConfig  = annotationBase + "federatedConfig"
+	annotationHTTPSProxy = annotationBase + "httpsProxy"
+	annotationNoProxy    = annotationBase + "noProxy"
+	annotationLinkerd    = annotationBase + "linkerd"
+	annotationMode       = annotationBase + "mode"
+	annotationHealth     = annotationBase + "healthChecks"
+	annotationHealthPort = annotationBase + "healthCheckPort"
+	annotationPreStop    = annotationBase + "preStopDelay"
+	annotationPlacement  = annotationBase + "placement"
+	annotationWait       = annotationBase + "waitForProxy"
+	annotationAppArmor   = annotationBase + "appArmorProfile"
+	annotationMedium     = annotationBase + "socketMedium"
+	annotationSocketSize = annotationBase + "socketSizeLimit"
+	annotationCredSource = annotationBase + "credentialsSource"
+	annotationCSIClass   = annotationBase + "secretProviderClass"
+	annotationVaultRole  = annotationBase + "vaultRole"
+	annotationVaultPath  = annotationBase + "vaultSecretPath"
+	annotationSMSecret   = annotationBase + "secretManagerSecret"
+
+	// value of the inject annotation which enforces the injection even in ignored namespaces
+	injectForce = "force"
+
+	// log levels of the proxy which can be annotated
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+	logLevelError = "error"
 
 	// default image to be used if none is specified
 	imageName    = "gcr.io/cloudsql-docker/gce-proxy"
 	imageTag     = "1.33.1"
 	defaultImage = imageName + ":" + imageTag
 
+	// repository of version 2 of the proxy, which spells its flags differently
+	imageNameV2 = "gcr.io/cloud-sql-connectors/cloud-sql-proxy"
+
+	// default key of the credentials within the secret
+	defaultSecretKey = "credentials.json"
+
+	// name of the port the proxy listens on for connections to the instance, used by network policies
+	// and service meshes to discover it
+	proxyPortName = "cloudsql"
+
+	// file names of the projected service account token and the credential configuration used for
+	// workload identity federation
+	federatedTokenFile  = "token"
+	federatedConfigFile = "credential-configuration.json"
+	// requested validity of the projected service account token, renewed by the kubelet
+	federatedTokenExpiration int64 = 3600
+
+	// connection names of Cloud SQL instances, consisting of the optionally domain scoped project ID,
+	// the region and the instance name
+	instanceNamePattern = regexp.MustCompile(`^([a-z0-9-]+(\.[a-z0-9-]+)+:)?[a-z][a-z0-9-]*[a-z0-9]:[a-z][a-z0-9-]*[a-z0-9]:[a-z]([a-z0-9-]{0,96}[a-z0-9])?$`)
+
+	// supported database engines and the default port the proxy listens on for their instances
+	dbTypeMySQL     = "mysql"
+	dbTypePostgres  = "postgres"
+	dbTypeSQLServer = "sqlserver"
+	dbTypePorts     = map[string]int{
+		dbTypeMySQL:     3306,
+		dbTypePostgres:  5432,
+		dbTypeSQLServer: 1433,
+	}
+	defaultDBType = dbTypeMySQL
+
 	// default sidecar resource requests
 	defaultCPURequest = "10m"
 	defaultMemRequest = "16Mi"
-	defaultCPULimit   = ""
-	defaultMemLimit   = ""
 
 	// Command of the sql proxy container. Is extended througout the injection process with additional
 	// parameters depending on the configuration and annotations
 	sqlProxyCmd = []string{
 		"/cloud_sql_proxy",
-		"-dir=/cloudsql",
 	}
 
+	// Mount propagation settings used in FUSE mode. The proxy mounts its FUSE filesystem below
+	// the socket directory which needs to be propagated back to the application containers
+	fuseProxyPropagation = corev1.MountPropagationBidirectional
+	fuseAppPropagation   = corev1.MountPropagationHostToContainer
+
+	// The endpoint of the admin server of the proxy which can be called to shut it down, e.g. when a
+	// Job has finished. Its URL is passed to the application containers via the environment
+	quitURLEnv = "SQLBEE_QUIT_URL"
+	quitURL    = "http://127.0.0.1:9091/quitquitquit"
+
 	// Predefined definition to mount GCP credentials
 	credentialMount = corev1.VolumeMount{
-		MountPath: "/credentials",
-		Name:      "sql-service-token-account",
+		MountPath: defaultCredentialsPath,
+		Name:      defaultCredentialsVolume,
+	}
+
+	// Predefined definition to mount the credentials for workload identity federation
+	federatedMount = corev1.VolumeMount{
+		MountPath: "/federated",
+		Name:      "sql-federated-credentials",
+		ReadOnly:  true,
 	}
 
 	// Predefined definition to mount different root certificates
 	caCertMount = corev1.VolumeMount{
-		MountPath: "/etc/ssl/certs",
-		Name:      "ca-certificates",
+		MountPath: defaultCAPath,
+		Name:      defaultCAVolume,
 	}
 
 	// barebones container specification for the cloud sql proxy sidecar. Is extended throughout the
@@ -65,8 +170,8 @@ var (
 		Command: sqlProxyCmd,
 		VolumeMounts: []corev1.VolumeMount{
 			corev1.VolumeMount{
-				MountPath: "/cloudsql",
-				Name:      "cloudsql",
+				MountPath: defaultSocketPath,
+				Name:      defaultSocketVolume,
 			},
 		},
 		Name: "cloud-sql-proxy",
@@ -74,7 +179,7 @@ var (
 
 	// definition of a volume mount for GCP credentials. Will be modified during the injection process
 	credentialsVolume = corev1.Volume{
-		Name: "sql-service-token-account",
+		Name: defaultCredentialsVolume,
 		VolumeSource: corev1.VolumeSource{
 			Secret: &corev1.SecretVolumeSource{
 				SecretName: "cloud-sql-proxy-credentials",
@@ -84,7 +189,7 @@ var (
 
 	// definition of a volume to mount custom ca certificates
 	caCertVolume = corev1.Volume{
-		Name: "sql-ca-certificates",
+		Name: defaultCAVolume,
 		VolumeSource: corev1.VolumeSource{
 			ConfigMap: &corev1.ConfigMapVolumeSource{
 				LocalObjectReference: corev1.LocalObjectReference{
@@ -97,7 +202,7 @@ var (
 	// the cloud sql proxy will always have this single volume to write temporary data to it
 	sqlProxyVolumes = []corev1.Volume{
 		corev1.Volume{
-			Name: "cloudsql",
+			Name: defaultSocketVolume,
 			VolumeSource: corev1.VolumeSource{
 				EmptyDir: &corev1.EmptyDirVolumeSource{},
 			},
@@ -119,108 +224,911 @@ type Options struct {
 	DefaultInstance string
 	// The secret containing the cloud sql credentials if not specified by annotations
 	DefaultSecretName string
+	// The database engine of the instances, one of mysql, postgres or sqlserver. Determines the default
+	// port the proxy listens on, defaults to mysql
+	DBType string
+	// The key of the credentials within the secret, defaults to credentials.json
+	SecretKey string
+	// Whether the credentials within the secret are mounted as volume or passed via an environment
+	// variable if not specified by annotation, defaults to volume
+	CredentialsSource string
+	// The secret provider class of the Secrets Store CSI driver providing the credentials if not
+	// specified by annotation, required if the credentials source is csi
+	SecretProviderClass string
+	// The Vault role and the path of the secret containing the credentials if not specified by
+	// annotation, required if the credentials source is vault
+	VaultRole       string
+	VaultSecretPath string
+	// The secret of Secret Manager containing the credentials if not specified by annotation, like
+	// projects/<project>/secrets/<secret>. It is materialized as the specified Kubernetes secret
+	SecretManagerSecret string
+	// The secrets of Secret Manager which may be materialized, including the default one. Secrets annotated
+	// otherwise are refused. Secrets without a version allow each of their versions
+	SecretManagerAllowed []string
+	// Used to materialize secrets of Secret Manager, required for SecretManagerSecret
+	SecretManager secretmanager.Materializer
+	// The audience of the projected service account token. If set the proxy authenticates via workload
+	// identity federation instead of secret based credentials
+	TokenAudience string
+	// The config map containing the credential configuration for workload identity federation
+	FederatedConfig string
 	// The config map containing the root certificates, if necessary
 	DefaultCertVolume string
 	// Whether injection should only happen if the inject annotation is present and set to true
 	RequireAnnotation bool
+	// The GCP project(s) in which the cloud sql proxy discovers all instances, used if no instance
+	// is specified via annotation
+	DefaultProjects string
+	// Whether the proxy should run in FUSE mode by default, creating sockets for instances on demand
+	Fuse bool
+	// Whether the proxy should by default enable its quitquitquit endpoint, so workloads like Jobs
+	// can shut it down once they are done
+	QuitQuitQuit bool
+	// Whether the proxy should by default only log errors instead of every connection
+	Quiet bool
+	// Whether the proxy should by default neither export metrics nor traces
+	DisableTelemetry bool
+	// Whether the proxy should by default authenticate the database users via IAM, which isn't supported
+	// for SQL Server instances
+	IAMAuthn bool
+	// The name of the injected container, defaults to cloud-sql-proxy. Existing containers with
+	// this name are replaced
+	ContainerName string
+	// The names of the injected volumes, configurable to avoid collisions with volumes of the workloads.
+	// Default to cloudsql, sql-service-token-account and sql-ca-certificates
+	SocketVolume      string
+	CredentialsVolume string
+	CAVolume          string
+	// The mount paths of the injected volumes, default to /cloudsql, /credentials and /etc/ssl/certs.
+	// Application containers mount the sockets at the same path in FUSE mode
+	SocketPath      string
+	CredentialsPath string
+	CAPath          string
+	// The storage medium and size limit of the socket volume if not specified by annotation. Default
+	// to the storage of the node without limit
+	SocketMedium    string
+	SocketSizeLimit string
+	// The resources of the proxy if not specified by annotation. The requests default to 10m CPU and
+	// 16Mi memory, the limits are unset by default
+	DefaultCPURequest string
+	DefaultMemRequest string
+	DefaultCPULimit   string
+	DefaultMemLimit   string
+	// The pull policy of the proxy image, defaults to the cluster default
+	ImagePullPolicy string
+	// Secrets added to the pods image pull secrets so the proxy image can be pulled from private registries
+	ImagePullSecrets []string
+	// The proxy image to be used if not specified by annotation, defaults to the image of the
+	// supported proxy version
+	DefaultImage string
+	// If set, the proxy images are pulled from this mirror instead, keeping their name and tag or digest
+	ImageMirror string
+	// The egress proxy used by the proxy to reach the Cloud SQL APIs and the hosts excluded from it,
+	// set as HTTPS_PROXY and NO_PROXY on the proxy container only
+	HTTPSProxy string
+	NoProxy    string
+	// Whether the proxy is injected as additional container (sidecar) or as native sidecar (init-sidecar)
+	// if not specified by annotation, defaults to sidecar
+	Mode string
+	// Where the proxy is placed within the containers if not specified by annotation: first, last or
+	// before:<container>, defaults to last
+	Placement string
+	// Whether the application containers are only started once the proxy accepts connections if not
+	// specified by annotation. Requires an image of the proxy containing a shell and nc
+	WaitForProxy bool
+	// Whether the proxy runs without the hardened security context, which satisfies the restricted
+	// pod security standard
+	DisableHardening bool
+	// The user the proxy runs as with the hardened security context, defaults to 65532
+	ProxyUser int64
+	// The AppArmor profile of the proxy if not specified by annotation: runtime/default, unconfined or
+	// localhost/<profile>. Not set by default and for pods using one of the skipped runtime classes
+	AppArmorProfile            string
+	AppArmorSkipRuntimeClasses []string
+	// Whether the proxy always runs with the hardened security context and patches are refused if
+	// the injected fields violate the restricted pod security standard enforced by the namespace
+	PodSecurityRestricted bool
+	// How long the termination of the proxy is delayed by a preStop hook, disabled if zero
+	PreStopDelay time.Duration
+	// Whether the preStop hook uses the sleep action (sleep, default) or executes sleep within the proxy
+	// container (exec) on clusters before Kubernetes 1.30
+	PreStopHook string
+	// Whether the health check endpoints of the proxy are enabled and probed
+	HealthChecks bool
+	// Whether the pods are meshed by Linkerd, so the connections to the instances need to bypass it
+	Linkerd bool
+	// If set, the tags of the proxy images are resolved to digests and the pinned images are injected
+	Digests registry.DigestResolver
+	// Default instances per namespace, replacing DefaultInstance for the objects within them
+	NamespaceInstances map[string]string
+	// Used to retrieve the namespace of an object, required for NamespaceDefaults, NamespaceLabels and
+	// PodSecurityRestricted
+	Namespaces kube.NamespaceGetter
+	// Used to verify that the service accounts of pods whose proxies have no credentials are bound to
+	// GCP service accounts, required for VerifyWorkloadIdentity
+	ServiceAccounts kube.ServiceAccountGetter
+	// Whether injections of proxies without credentials into pods whose service account isn't bound to
+	// a GCP service account are logged and warned about (warn) or refused (deny), disabled if empty
+	VerifyWorkloadIdentity string
+	// If set, the credentials secret is replicated into the namespaces of the objects referencing it
+	Replicator kube.Replicator
+	// If set, objects are admitted unchanged and the decisions are only recorded, so injections have no
+	// side effects like materialized or replicated secrets
+	Shadow bool
+	// Used to verify that the secrets and config maps referenced by the proxies exist, required for
+	// VerifyReferences
+	Objects kube.ObjectChecker
+	// Whether injections referencing missing secrets or config maps are logged (warn) or refused (deny),
+	// disabled if empty
+	VerifyReferences string
+	// Whether the annotations of the namespace of an object are used as defaults for the
+	// annotations of the object
+	NamespaceDefaults bool
+	// Whether the injection label of the namespace of an object enables or disables the injection
+	NamespaceLabels bool
+	// If set, only objects whose labels match the selector are injected. Matching objects don't
+	// require the inject annotation
+	Selector labels.Selector
+	// If set, only the workloads being part of the rollout are injected, unless they enforce it
+	Rollout *Rollout
+}
+
+// objectLabels returns the labels of an object, if it has any
+func objectLabels(obj runtime.Object) labels.Set {
+	return labels.Set(sting.Labels(obj))
+}
+
+// annotationBool retrieves the boolean value of an annotation specified by key. In case the
+// annotation is not present or can't be parsed the default value is returned
+func annotationBool(obj runtime.Object, key string, def bool) bool {
+	val, err := strconv.ParseBool(annotationValue(obj, key, strconv.FormatBool(def)))
+	if err != nil {
+		return def
+	}
+	return val
+}
+
+// annotationList retrieves the comma separated values of an annotation specified by key. In case the
+// annotation is not present or empty the default values are returned
+func annotationList(obj runtime.Object, key string, def []string) []string {
+	val := annotationValue(obj, key)
+	if val == "" {
+		return def
+	}
+	values := []string{}
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// projects determines the GCP projects whose instances are discovered by the proxy. An explicitly
+// annotated instance always takes precedence over project wide discovery
+func projects(obj runtime.Object, opts Options) string {
+	if annotationValue(obj, annotationInstance) != "" {
+		return ""
+	}
+	return annotationValue(obj, annotationProjects, opts.DefaultProjects)
+}
+
+// isFuse determines whether the proxy is injected in FUSE mode for the given object
+func isFuse(obj runtime.Object, opts Options) bool {
+	return annotationBool(obj, annotationFuse, opts.Fuse)
+}
+
+// isQuitQuitQuit determines whether the quitquitquit endpoint of the proxy is enabled for the given object
+func isQuitQuitQuit(obj runtime.Object, opts Options) bool {
+	return annotationBool(obj, annotationQuit, opts.QuitQuitQuit)
 }
 
 // mutates a corev1.PodSpec to contain a cloud sql proxy sidecar and the necessary volume mounts and volumes
 func mutatePodSpec(volumes []corev1.Volume, proxyContainer *corev1.Container, podSpec *corev1.PodSpec) corev1.PodSpec {
+	// An existing cloud sql proxy is updated in place, so re-injecting an unchanged object causes no
+	// changes. It might have been injected as native sidecar before
+	initContainers, existing := removeContainer(podSpec.InitContainers, proxyContainer.Name)
+	podSpec.InitContainers = initContainers
+	merged := mergeProxyContainer(existing, proxyContainer)
+	if !mergeContainer(podSpec.Containers, &merged) {
+		podSpec.Containers = append(podSpec.Containers, merged)
+	}
+
+	replaceVolumes(volumes, podSpec)
+	return *podSpec
+}
 
-	for i, container := range podSpec.Containers {
-		if container.Image == proxyContainer.Image || container.Name == proxyContainer.Name {
-			// If a cloud sql proxy already exists, remove it
-			podSpec.Containers = append(podSpec.Containers[:i], podSpec.Containers[i+1:]...)
-			break
+// adds the volumes to the podSpec, replacing existing volumes with the same name in place
+func replaceVolumes(volumes []corev1.Volume, podSpec *corev1.PodSpec) {
+	existing := map[string]int{}
+	for i, volume := range podSpec.Volumes {
+		existing[volume.Name] = i
+	}
+	for _, volume := range volumes {
+		if i, exists := existing[volume.Name]; exists {
+			podSpec.Volumes[i] = volume
+		} else {
+			podSpec.Volumes = append(podSpec.Volumes, volume)
 		}
 	}
-	podSpec.Containers = append(podSpec.Containers, *proxyContainer)
+}
 
-	for i, volume := range podSpec.Volumes {
-		// Remove possibly existing volumes cloud sql proxy relies on and add them later again
-		if volume.Name == "cloudsql" || volume.Name == "sql-service-token-account" || volume.Name == "sql-ca-certificates" {
-			podSpec.Volumes = append(podSpec.Volumes[:i], podSpec.Volumes[i+1:]...)
+// configures the application containers of a podSpec which has been mutated to contain the proxyContainer
+func configureAppContainers(obj runtime.Object, proxyContainer *corev1.Container, podSpec *corev1.PodSpec, opts Options) {
+	opts = withVolumeDefaults(opts)
+	fuse := isFuse(obj, opts)
+	quit := isQuitQuitQuit(obj, opts)
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		if container.Name == proxyContainer.Name {
+			continue
+		}
+		// In FUSE mode the application containers need to see the sockets created by the proxy
+		if fuse {
+			addFuseMount(container, opts.SocketVolume, opts.SocketPath)
+		}
+		// Tell the application where to request the shutdown of the proxy once it is done
+		if quit {
+			addEnv(container, corev1.EnvVar{Name: quitURLEnv, Value: quitURL})
 		}
 	}
-	podSpec.Volumes = append(podSpec.Volumes, volumes...)
-	return *podSpec
 }
 
-// configures the sidecar container spec and the required volumes for the podSpec based on the provided options
-func configureContainerAndVolumes(obj runtime.Object, sqlProxyContainer *corev1.Container, sqlProxyVolumes *[]corev1.Volume, opts Options) {
-	image := sting.AnnotationValue(obj, annotationImage, defaultImage)
+// adds the image pull secrets required for the proxy image to the podSpec
+func configurePullSecrets(obj runtime.Object, podSpec *corev1.PodSpec, opts Options) {
+	for _, name := range annotationList(obj, annotationPullSecret, opts.ImagePullSecrets) {
+		exists := false
+		for _, secret := range podSpec.ImagePullSecrets {
+			exists = exists || secret.Name == name
+		}
+		if !exists {
+			podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+		}
+	}
+}
 
-	// Retrieve values of resource request from annotations.
-	// Set default values if annotations are empty
-	cpu := sting.AnnotationValue(obj, annotationCPURequest, defaultCPURequest)
-	mem := sting.AnnotationValue(obj, annotationMemRequest, defaultMemRequest)
-	cpuLimit := sting.AnnotationValue(obj, annotationCPULimits, defaultCPULimit)
-	memLimit := sting.AnnotationValue(obj, annotationMemLimits, defaultMemLimit)
+// adds an environment variable to a container unless a variable with the same name is already defined
+func addEnv(container *corev1.Container, env corev1.EnvVar) {
+	for _, e := range container.Env {
+		if e.Name == env.Name {
+			return
+		}
+	}
+	container.Env = append(container.Env, env)
+}
+
+// sets an environment variable of a container, replacing an existing one with the same name
+func setEnv(container *corev1.Container, env corev1.EnvVar) {
+	for i, e := range container.Env {
+		if e.Name == env.Name {
+			container.Env[i] = env
+			return
+		}
+	}
+	container.Env = append(container.Env, env)
+}
 
-	sqlProxyContainer.Resources.Requests = corev1.ResourceList{
-		corev1.ResourceMemory: resource.MustParse(mem),
-		corev1.ResourceCPU:    resource.MustParse(cpu),
+// adds the socket volume to an application container so it can access the sockets created by a proxy
+// running in FUSE mode. Existing mounts of the volume are updated to receive mounts from the proxy
+func addFuseMount(container *corev1.Container, volume, mountPath string) {
+	propagation := fuseAppPropagation
+	for i, mount := range container.VolumeMounts {
+		if mount.Name == volume {
+			container.VolumeMounts[i].MountPropagation = &propagation
+			return
+		}
 	}
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:             volume,
+		MountPath:        mountPath,
+		MountPropagation: &propagation,
+	})
+}
 
-	if cpuLimit != "" || memLimit != "" {
-		sqlProxyContainer.Resources.Limits = corev1.ResourceList{}
-		if cpuLimit != "" {
-			sqlProxyContainer.Resources.Limits[corev1.ResourceCPU] = resource.MustParse(cpuLimit)
+// parses the given resource quantities into a resource list, empty quantities are omitted
+func parseResources(quantities map[corev1.ResourceName]string) (corev1.ResourceList, error) {
+	resources := corev1.ResourceList{}
+	for name, value := range quantities {
+		if value == "" {
+			continue
 		}
-		if memLimit != "" {
-			sqlProxyContainer.Resources.Limits[corev1.ResourceMemory] = resource.MustParse(memLimit)
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s resource quantity %q: %s", name, value, err)
 		}
-	} else {
-		sqlProxyContainer.Resources.Limits = corev1.ResourceList{}
+		resources[name] = quantity
+	}
+	return resources, nil
+}
+
+// returns a projected volume containing a service account token with the audience and the credential
+// configuration of the config map, which needs to refer to the token file below the federated mount
+func federatedVolume(audience, configName string) corev1.Volume {
+	expiration := federatedTokenExpiration
+	return corev1.Volume{
+		Name: federatedMount.Name,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience:          audience,
+							ExpirationSeconds: &expiration,
+							Path:              federatedTokenFile,
+						},
+					},
+					{
+						ConfigMap: &corev1.ConfigMapProjection{
+							LocalObjectReference: corev1.LocalObjectReference{Name: configName},
+							Items:                []corev1.KeyToPath{{Key: federatedConfigFile, Path: federatedConfigFile}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// returns the name of the proxy container of an object
+func proxyContainerName(obj runtime.Object, opts Options) string {
+	defaultName := sqlProxyContainer.Name
+	if opts.ContainerName != "" {
+		defaultName = opts.ContainerName
+	}
+	name := annotationValue(obj, annotationContainer, defaultName)
+
+	// Let Tekton treat the proxy as sidecar, so it is stopped once the steps of the TaskRun are done
+	if isTektonPod(obj) && !strings.HasPrefix(name, tektonSidecarPrefix) {
+		name = tektonSidecarPrefix + name
+	}
+	return name
+}
+
+// validates the connection name of a Cloud SQL instance. Project IDs may be prefixed by a domain
+// for legacy domain scoped projects, e.g. example.com:project:region:instance
+func validateInstance(instance string) error {
+	if !instanceNamePattern.MatchString(instance) {
+		return fmt.Errorf("Invalid Cloud SQL instance %q, expected a connection name of the form project:region:instance", instance)
+	}
+	return nil
+}
+
+// parses a TCP port the proxy listens on
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("%q is not a valid port", value)
+	}
+	return port, nil
+}
+
+// determines the database engine of the instances of the proxy, defaults to mysql
+func databaseType(obj runtime.Object, opts Options) (string, error) {
+	dbType := annotationValue(obj, annotationDBType, opts.DBType)
+	if dbType == "" {
+		dbType = defaultDBType
+	}
+	if _, supported := dbTypePorts[dbType]; !supported {
+		return "", fmt.Errorf("Unsupported database type %q", dbType)
+	}
+	return dbType, nil
+}
+
+// validates that the database engine of the instances supports the options of the proxy
+func validateDBType(obj runtime.Object, opts Options, dbType string) error {
+	// The proxy connects to SQL Server instances via TCP only, FUSE mode and project wide discovery
+	// create Unix sockets
+	if dbType == dbTypeSQLServer {
+		if isFuse(obj, opts) {
+			return fmt.Errorf("FUSE mode is not supported for instances of database type %s", dbType)
+		}
+		if projects(obj, opts) != "" {
+			return fmt.Errorf("Project wide discovery is not supported for instances of database type %s", dbType)
+		}
+	}
+	return nil
+}
+
+// determines the port the proxy listens on for the instance, defaults to the port of the database engine
+func proxyPort(obj runtime.Object, opts Options) (int, error) {
+	dbType, err := databaseType(obj, opts)
+	if err != nil {
+		return 0, err
+	}
+	port := dbTypePorts[dbType]
+	if value := annotationValue(obj, annotationPort); value != "" {
+		var err error
+		if port, err = parsePort(value); err != nil {
+			return 0, fmt.Errorf("Invalid value of annotation %s: %s", annotationPort, err)
+		}
+	}
+	return port, nil
+}
+
+// withResourceDefaults returns a copy of opts with the default resource requests of the proxy set
+// if they aren't configured
+func withResourceDefaults(opts Options) Options {
+	if opts.DefaultCPURequest == "" {
+		opts.DefaultCPURequest = defaultCPURequest
+	}
+	if opts.DefaultMemRequest == "" {
+		opts.DefaultMemRequest = defaultMemRequest
+	}
+	return opts
+}
+
+// configures the sidecar container spec and the required volumes for the podSpec based on the provided options.
+// Errors of the configuration deny the object as invalid, while failing to resolve the image is no fault
+// of the object
+func configureContainerAndVolumes(ctx context.Context, obj runtime.Object, sqlProxyContainer *corev1.Container, sqlProxyVolumes *[]corev1.Volume, opts Options) error {
+	image, err := proxyImage(ctx, obj, opts)
+	if err != nil {
+		return err
+	}
+	if err := configureProxyContainer(obj, sqlProxyContainer, sqlProxyVolumes, image, opts); err != nil {
+		return sting.Invalid(err.Error())
+	}
+	return nil
+}
+
+// proxyImage returns the image of the proxy, pinned to its digest if digests are resolved
+func proxyImage(ctx context.Context, obj runtime.Object, opts Options) (string, error) {
+	image := defaultImage
+	if opts.DefaultImage != "" {
+		image = opts.DefaultImage
+	}
+	image = mirrorImage(annotationValue(obj, annotationImage, image), opts.ImageMirror)
+	if opts.Digests == nil {
+		return image, nil
+	}
+	pinned, err := opts.Digests.ResolveDigest(ctx, image)
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve the digest of image %s: %s", image, err)
+	}
+	return pinned, nil
+}
+
+// configureProxyContainer configures the sidecar container spec running the image and the required volumes
+// based on the annotations of the object and the provided options
+func configureProxyContainer(obj runtime.Object, sqlProxyContainer *corev1.Container, sqlProxyVolumes *[]corev1.Volume, image string, opts Options) error {
+	opts = withVolumeDefaults(opts)
+	applySocketVolume(sqlProxyContainer, *sqlProxyVolumes, opts)
+	if err := configureSocketVolume(obj, *sqlProxyVolumes, opts); err != nil {
+		return err
+	}
+
+	// Retrieve values of resource request from annotations.
+	// Set default values if annotations are empty. The plural form of the limit annotations
+	// is still supported for backwards compatibility
+	opts = withResourceDefaults(opts)
+	cpu := annotationValue(obj, annotationCPURequest, opts.DefaultCPURequest)
+	mem := annotationValue(obj, annotationMemRequest, opts.DefaultMemRequest)
+	cpuLimit := annotationValue(obj, annotationCPULimit, annotationValue(obj, annotationCPULimits, opts.DefaultCPULimit))
+	memLimit := annotationValue(obj, annotationMemLimit, annotationValue(obj, annotationMemLimits, opts.DefaultMemLimit))
+
+	var err error
+	sqlProxyContainer.Resources.Requests, err = parseResources(map[corev1.ResourceName]string{
+		corev1.ResourceMemory: mem,
+		corev1.ResourceCPU:    cpu,
+	})
+	if err != nil {
+		return err
+	}
+	sqlProxyContainer.Resources.Limits, err = parseResources(map[corev1.ResourceName]string{
+		corev1.ResourceMemory: memLimit,
+		corev1.ResourceCPU:    cpuLimit,
+	})
+	if err != nil {
+		return err
 	}
 
 	sqlProxyContainer.Image = image
-	cmd := []string{}
-	cmd = append(cmd, sqlProxyCmd...)
 
-	instance := sting.AnnotationValue(obj, annotationInstance, opts.DefaultInstance)
+	port, err := proxyPort(obj, opts)
+	if err != nil {
+		return err
+	}
+	engine, _ := databaseType(obj, opts)
+	if err := validateDBType(obj, opts, engine); err != nil {
+		return err
+	}
+
+	switch policy := corev1.PullPolicy(annotationValue(obj, annotationPullPolicy, opts.ImagePullPolicy)); policy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+		sqlProxyContainer.ImagePullPolicy = policy
+	default:
+		return fmt.Errorf("Invalid image pull policy %q", policy)
+	}
 
-	secretName := sting.AnnotationValue(obj, annotationSecret, opts.DefaultSecretName)
-	if secretName != "" {
-		sqlProxyContainer.VolumeMounts = append(sqlProxyContainer.VolumeMounts, credentialMount)
-		credVolumes := credentialsVolume.DeepCopy()
-		credVolumes.VolumeSource.Secret.SecretName = secretName
-		*sqlProxyVolumes = append(*sqlProxyVolumes, *credVolumes)
-		cmd = append(cmd, "-credential_file=/credentials/credentials.json")
+	sqlProxyContainer.Name = proxyContainerName(obj, opts)
+	flags := newProxyFlags(image)
+	cmd := flags.command()
+	if !isFuse(obj, opts) {
+		cmd = append(cmd, flags.socketDir(opts.SocketPath))
 	}
 
-	caConfigName := sting.AnnotationValue(obj, annotationCaMap, opts.DefaultCertVolume)
+	instance := annotationValue(obj, annotationInstance, opts.DefaultInstance)
+
+	secretName := annotationValue(obj, annotationSecret, opts.DefaultSecretName)
+	materialized, err := secretManagerSecret(obj, opts)
+	if err != nil {
+		return err
+	}
+	if materialized != nil {
+		// The credentials of Secret Manager are materialized as Kubernetes secret before the injection
+		secretName = materialized.name
+	}
+	secretKey := defaultSecretKey
+	if opts.SecretKey != "" {
+		secretKey = opts.SecretKey
+	}
+	secretKey = annotationValue(obj, annotationSecretKey, secretKey)
+	source := annotationValue(obj, annotationCredSource, opts.CredentialsSource)
+	if err := validateCredentialsSource(source); err != nil {
+		return fmt.Errorf("Invalid value of annotation %s: %s", annotationCredSource, err)
+	}
+	if audience := annotationValue(obj, annotationAudience, opts.TokenAudience); audience != "" {
+		// Workload identity federation exchanges a projected service account token for GCP credentials
+		configName := annotationValue(obj, annotationFedConfig, opts.FederatedConfig)
+		if configName == "" {
+			return fmt.Errorf("Workload identity federation requires a credential configuration via annotation %s", annotationFedConfig)
+		}
+		sqlProxyContainer.VolumeMounts = append(sqlProxyContainer.VolumeMounts, federatedMount)
+		*sqlProxyVolumes = append(*sqlProxyVolumes, federatedVolume(audience, configName))
+		cmd = append(cmd, flags.credentialsFile(path.Join(federatedMount.MountPath, federatedConfigFile)))
+	} else if source == credentialsSourceCSI {
+		// The keys are provided by an external secret store instead of a secret
+		providerClass := annotationValue(obj, annotationCSIClass, opts.SecretProviderClass)
+		file, err := configureCredentialsCSI(sqlProxyContainer, sqlProxyVolumes, providerClass, secretKey, opts)
+		if err != nil {
+			return err
+		}
+		cmd = append(cmd, flags.credentialsFile(file))
+	} else if source == credentialsSourceVault {
+		// The annotations requesting the credentials from the Vault Agent injector are set by configureVault
+		file, err := configureCredentialsVault(annotationValue(obj, annotationVaultPath, opts.VaultSecretPath), secretKey)
+		if err != nil {
+			return err
+		}
+		cmd = append(cmd, flags.credentialsFile(file))
+	} else if secretName != "" {
+		if source == credentialsSourceEnv {
+			configureCredentialsEnv(sqlProxyContainer, sqlProxyVolumes, secretName, secretKey, opts)
+		} else {
+			mount := credentialMount
+			mount.Name, mount.MountPath = opts.CredentialsVolume, opts.CredentialsPath
+			sqlProxyContainer.VolumeMounts = append(sqlProxyContainer.VolumeMounts, mount)
+			credVolumes := credentialsVolume.DeepCopy()
+			credVolumes.Name = opts.CredentialsVolume
+			credVolumes.VolumeSource.Secret.SecretName = secretName
+			if secretKey != defaultSecretKey {
+				// Only mount the specified key, so the file name is known and doesn't collide
+				credVolumes.VolumeSource.Secret.Items = []corev1.KeyToPath{{Key: secretKey, Path: secretKey}}
+			}
+			*sqlProxyVolumes = append(*sqlProxyVolumes, *credVolumes)
+			cmd = append(cmd, flags.credentialsFile(path.Join(opts.CredentialsPath, secretKey)))
+		}
+	}
+
+	caConfigName := annotationValue(obj, annotationCaMap, opts.DefaultCertVolume)
 	if caConfigName != "" {
 		caVolume := caCertVolume.DeepCopy()
+		caVolume.Name = opts.CAVolume
 		caVolume.VolumeSource.ConfigMap.Name = caConfigName
-		sqlProxyContainer.VolumeMounts = append(sqlProxyContainer.VolumeMounts, caCertMount)
+		mount := caCertMount
+		mount.Name, mount.MountPath = opts.CAVolume, opts.CAPath
+		sqlProxyContainer.VolumeMounts = append(sqlProxyContainer.VolumeMounts, mount)
 		*sqlProxyVolumes = append(*sqlProxyVolumes, *caVolume)
 	}
 
-	cmd = append(cmd, fmt.Sprintf("-instances=%s=tcp:127.0.0.1:3306", instance))
+	if isQuitQuitQuit(obj, opts) {
+		// The admin server of the proxy has been added in version 2
+		if !flags.v2 {
+			return fmt.Errorf("The quitquitquit endpoint requires version 2 of the cloud-sql-proxy, image %s is version 1", image)
+		}
+		cmd = append(cmd, "--quitquitquit")
+	}
+
+	// The log level takes precedence over the verbose annotation and the configured default
+	switch level := annotationValue(obj, annotationLogLevel); level {
+	case "":
+		if !annotationBool(obj, annotationVerbose, !opts.Quiet) {
+			cmd = append(cmd, flags.logLevel(logLevelError)...)
+		}
+	case logLevelDebug, logLevelInfo, logLevelError:
+		cmd = append(cmd, flags.logLevel(level)...)
+	default:
+		return fmt.Errorf("Invalid value of annotation %s: unknown log level %q", annotationLogLevel, level)
+	}
+
+	// Only version 2 of the proxy exports metrics and traces, version 1 has nothing to disable
+	if !annotationBool(obj, annotationTelemetry, !opts.DisableTelemetry) && flags.v2 {
+		cmd = append(cmd, "--disable-metrics", "--disable-traces")
+	}
+
+	// Both versions of the proxy spell automatic IAM database authentication differently, version 1
+	// only supports it for PostgreSQL instances
+	if annotationBool(obj, annotationIAMAuthn, opts.IAMAuthn) {
+		switch {
+		case engine == dbTypeSQLServer:
+			return fmt.Errorf("IAM database authentication is not supported for instances of database type %s", engine)
+		case flags.v2, engine == dbTypePostgres:
+			cmd = append(cmd, flags.iamAuthn())
+		default:
+			return fmt.Errorf("IAM database authentication of instances of database type %s requires version 2 of the cloud-sql-proxy, image %s is version 1", engine, image)
+		}
+	}
+
+	if isHardened(obj, opts) {
+		sqlProxyContainer.SecurityContext = hardenedSecurityContext(opts)
+	}
+
+	if isFuse(obj, opts) {
+		// FUSE requires access to /dev/fuse and the mount needs to be propagated to the other containers
+		privileged := true
+		propagation := fuseProxyPropagation
+		if sqlProxyContainer.SecurityContext == nil {
+			sqlProxyContainer.SecurityContext = &corev1.SecurityContext{}
+		}
+		sqlProxyContainer.SecurityContext.Privileged = &privileged
+		for i, mount := range sqlProxyContainer.VolumeMounts {
+			if mount.Name == opts.SocketVolume {
+				sqlProxyContainer.VolumeMounts[i].MountPropagation = &propagation
+			}
+		}
+		cmd = append(cmd, flags.fuse(opts.SocketPath)...)
+	} else if projects := projects(obj, opts); projects != "" {
+		// Version 2 of the proxy dropped the discovery of the instances of projects
+		if flags.v2 {
+			return fmt.Errorf("The discovery of the instances of projects requires version 1 of the cloud-sql-proxy, image %s is version 2", image)
+		}
+		cmd = append(cmd, fmt.Sprintf("-projects=%s", projects))
+	} else {
+		if instance != "" {
+			if err := validateInstance(instance); err != nil {
+				return err
+			}
+		}
+		cmd = append(cmd, flags.instance(instance, port))
+		sqlProxyContainer.Ports = mergePorts(sqlProxyContainer.Ports, []corev1.ContainerPort{{
+			Name:          proxyPortName,
+			ContainerPort: int32(port),
+			Protocol:      corev1.ProtocolTCP,
+		}})
+		if isWaitForProxy(obj, opts) {
+			// The hook would fail within distroless images, which kills the proxy
+			if !hasShell(image) {
+				return fmt.Errorf("Waiting for the proxy requires an image containing a shell and nc like the -alpine variants, image %s is distroless", image)
+			}
+			sqlProxyContainer.Lifecycle = waitForProxyHook(port)
+		}
+	}
+
+	// Behind an egress proxy the proxy needs to know it, the application containers are left untouched
+	for _, env := range []corev1.EnvVar{
+		{Name: "HTTPS_PROXY", Value: annotationValue(obj, annotationHTTPSProxy, opts.HTTPSProxy)},
+		{Name: "NO_PROXY", Value: annotationValue(obj, annotationNoProxy, opts.NoProxy)},
+	} {
+		if env.Value != "" {
+			setEnv(sqlProxyContainer, env)
+		}
+	}
+
+	// The preStop hook is set on the serialized container and the proxy is placed within the pod
+	// later on, but both are validated with the other annotations
+	delay, err := preStopDelay(obj, opts)
+	if err != nil {
+		return err
+	}
+	if delay > 0 && opts.PreStopHook == preStopHookExec && !hasShell(image) {
+		// The hook would fail within distroless images, which terminates the proxy without delay
+		return fmt.Errorf("Delaying the termination of the proxy on clusters without the sleep action requires an image containing sleep like the -alpine variants, image %s is distroless", image)
+	}
+	if _, err := proxyPlacement(obj, opts); err != nil {
+		return err
+	}
+
+	if isHealthChecks(obj, opts) {
+		if cmd, err = configureHealthChecks(obj, sqlProxyContainer, cmd, flags); err != nil {
+			return err
+		}
+	}
+
+	// Uncommon flags of the proxy can be specified verbatim
+	extraArgs, err := splitArgs(annotationValue(obj, annotationExtraArgs))
+	if err != nil {
+		return fmt.Errorf("Invalid value of annotation %s: %s", annotationExtraArgs, err)
+	}
+	cmd = append(cmd, extraArgs...)
 
 	sqlProxyContainer.Command = cmd
+
+	// Fields of the security context can be overridden to comply with the policies of the cluster
+	if securityContext := annotationValue(obj, annotationSecCtx); securityContext != "" {
+		if sqlProxyContainer.SecurityContext == nil {
+			sqlProxyContainer.SecurityContext = &corev1.SecurityContext{}
+		}
+		decoder := json.NewDecoder(strings.NewReader(securityContext))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(sqlProxyContainer.SecurityContext); err != nil {
+			return fmt.Errorf("Invalid value of annotation %s: %s", annotationSecCtx, err)
+		}
+	}
+
+	// As last resort any field of the container can be overridden
+	if override := annotationValue(obj, annotationOverride); override != "" {
+		if err := applyContainerOverride(sqlProxyContainer, override); err != nil {
+			return fmt.Errorf("Invalid value of annotation %s: %s", annotationOverride, err)
+		}
+	}
+	return nil
 }
 
-// Mutate returns a sting.MutateFunc parametrized with the specified Options
-func Mutate(opts Options) sting.MutateFunc {
+// splitArgs splits a string into arguments like a shell would do. Arguments are separated by
+// white space, which can be preserved by quoting with single or double quotes or by escaping it
+// with a backslash
+func splitArgs(s string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("Unterminated escape sequence")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated quote %c", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// setPatch sets the JSON patch from the raw object to the mutated object on the response, if there
+// is actually something to patch. The fields and volumes the API types lack are set on the serialized
+// object before the patch is created
+func setPatch(reviewResponse *v1beta1.AdmissionResponse, obj runtime.Object, original, raw []byte, fields map[string]interface{}, volumes map[string]map[string]interface{}) error {
+	mutated := &bytes.Buffer{}
+	if err := sting.Marshaler.Encode(obj, mutated); err != nil {
+		return err
+	}
+	mutatedRaw, err := setContainerFields(mutated.Bytes(), raw, podSpecPath(obj), fields)
+	if err != nil {
+		return err
+	}
+	mutatedRaw, err = setVolumeFields(mutatedRaw, podSpecPath(obj), volumes)
+	if err != nil {
+		return err
+	}
+	patched, err := sting.RawPatchResponse(raw, original, mutatedRaw)
+	if err != nil {
+		return err
+	}
+	if len(patched.Patch) > 0 {
+		reviewResponse.PatchType = patched.PatchType
+		reviewResponse.Patch = patched.Patch
+		logrus.WithFields(logrus.Fields{
+			"patch": string(patched.Patch),
+		}).Debug("Created patches")
+	}
+	return nil
+}
+
+// isIgnoredNamespace checks whether objects within the namespace are only injected if forced
+func isIgnoredNamespace(namespace string) bool {
+	for _, ignored := range ignoredNamespaces {
+		if namespace == ignored {
+			return true
+		}
+	}
+	return false
+}
+
+// forcesInjection checks whether the serialized object forces the injection by decoding its
+// metadata only. Objects which can't be decoded don't force it
+func forcesInjection(raw []byte) bool {
+	metadata := &metav1beta1.PartialObjectMetadata{}
+	if err := json.Unmarshal(raw, metadata); err != nil {
+		return false
+	}
+	return annotationHasValue(metadata, annotationInject, injectForce)
+}
 
+// Mutate returns a sting.MutateFunc parametrized with the specified Options. The lookups of the
+// mutation have no deadline, see MutateContext
+func Mutate(opts Options) sting.MutateFunc {
+	mutate := MutateContext(opts)
 	return func(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+		return mutate(context.Background(), ar)
+	}
+}
+
+// MutateContext returns a sting.MutateContextFunc parametrized with the specified Options. The
+// lookups of the mutation are canceled with the context of the admission request
+func MutateContext(opts Options) sting.MutateContextFunc {
 
+	return func(ctx context.Context, ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+
+		// Copy the options, they might be adjusted for this request only
+		opts := withVolumeDefaults(opts)
 		reviewResponse := &v1beta1.AdmissionResponse{}
 
-		// Ignore certain namespaces
-		for _, namespace := range ignoredNamespaces {
-			if ar.Request.Namespace == namespace {
+		// Ignore certain namespaces, unless the object enforces the injection. Only the metadata is
+		// decoded beforehand, so requests within these namespaces never fail because of lookups
+		if isIgnoredNamespace(ar.Request.Namespace) && !forcesInjection(ar.Request.Object.Raw) {
+			logrus.WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}).Info("Mutation ignored because of the namespace")
+			reviewResponse.Allowed = true
+			return reviewResponse
+		}
+
+		// The instance mapped to the namespace replaces the default instance, the annotations of the
+		// namespace still take precedence
+		if instance, exists := opts.NamespaceInstances[ar.Request.Namespace]; exists {
+			opts.DefaultInstance = instance
+		}
+
+		// Retrieve the namespace to take its configuration into account
+		var namespace *corev1.Namespace
+		if opts.Namespaces != nil && ar.Request.Namespace != "" {
+			var err error
+			namespace, err = opts.Namespaces.GetNamespace(ctx, ar.Request.Namespace)
+			if err != nil {
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"requestUID": ar.Request.UID,
+					"namespace":  ar.Request.Namespace,
+				}).Warn("Failed to retrieve namespace, using the configured defaults")
+			}
+		}
+		if namespace != nil && opts.NamespaceDefaults {
+			opts = namespaceDefaults(opts, namespace)
+		}
+		if namespace != nil && opts.NamespaceLabels {
+			switch namespace.Labels[labelInjection] {
+			case injectionDisabled:
 				logrus.WithFields(logrus.Fields{
 					"requestUID": ar.Request.UID,
 					"resource":   ar.Request.Resource.String(),
-					"name":       ar.Request.Name,
 					"namespace":  ar.Request.Namespace,
-				}).Info("Mutation ignored because of the namespace")
+				}).Info("Injection is disabled for the namespace, allowed")
 				reviewResponse.Allowed = true
 				return reviewResponse
+			case injectionEnabled:
+				// Objects within this namespace are injected unless they opt out
+				opts.RequireAnnotation = false
 			}
 		}
 
@@ -230,43 +1138,120 @@ func Mutate(opts Options) sting.MutateFunc {
 		volumes := make([]corev1.Volume, 0, 5)
 		volumes = append(volumes, sqlProxyVolumes...)
 
-		if ar.Request.Resource != podResource {
+		decode, supported := podSpecDecoders[ar.Request.Resource]
+		if !supported {
 			logrus.WithFields(logrus.Fields{
 				"requestUID": ar.Request.UID,
 				"resource":   ar.Request.Resource.String(),
-			}).Error("Received unknown resource")
-			return sting.ToAdmissionResponse(sting.WrongResourceError)
+			}).Debug("Received unknown resource, searching for a pod template")
+			decode = decodeUnknown
 		}
 
 		raw := ar.Request.Object.Raw
-		var obj runtime.Object
-		var podSpec *corev1.PodSpec
 
-		logrus.Info("Mutating pod resource")
+		logrus.WithFields(logrus.Fields{
+			"requestUID": ar.Request.UID,
+			"resource":   ar.Request.Resource.String(),
+		}).Info("Mutating resource")
 
-		pod := &corev1.Pod{}
-		// Deserialize a pod object
-		if _, _, err := sting.Deserializer.Decode(raw, nil, pod); err != nil {
+		// Deserialize the object and find the pod spec we need to mutate
+		obj, podSpec, err := decode(ar)
+		if err == errMissingPodTemplate {
+			logrus.WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+			}).Info("Resource has no pod template to mutate, allowed")
+			reviewResponse.Allowed = true
+			return reviewResponse
+		} else if err != nil {
 			logrus.WithError(err).WithFields(logrus.Fields{
 				"requestUID": ar.Request.UID,
-			}).Error("Failed to deserialize pod object")
+				"resource":   ar.Request.Resource.String(),
+			}).Error("Failed to deserialize object")
 			return sting.ToAdmissionResponse(err)
 		}
 
-		obj = pod
-		podSpec = &pod.Spec
+		// The patch only contains the changes to the decoded object, so fields unknown to our API
+		// types and serialization artifacts are left alone
+		original := &bytes.Buffer{}
+		if err := sting.Marshaler.Encode(obj, original); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+			}).Error("Failed to serialize object")
+			return sting.ToAdmissionResponse(err)
+		}
 
-		// Check whether we should do the mutation. If the inject annotation is true
-		// we always inject. If it is false we never mutate. If it is missing it depends
-		// whether opts.RequireAnnotation is true or not.
-		if opts.RequireAnnotation && !sting.AnnotationHasValue(obj, annotationInject, "true") {
+		// Pods created by the controller of an injected object have been injected with its template
+		if isInjectedByController(obj) {
 			logrus.WithFields(logrus.Fields{
 				"requestUID": ar.Request.UID,
 				"resource":   ar.Request.Resource.String(),
-			}).Info("Resource does not need mutation, allowed")
+				"owner":      metav1.GetControllerOf(obj.(*corev1.Pod)).Kind,
+			}).Info("Resource has already been injected via its controller, allowed")
 			reviewResponse.Allowed = true
 			return reviewResponse
-		} else if !opts.RequireAnnotation && sting.AnnotationHasValue(obj, annotationInject, "false") {
+		}
+
+		forced := annotationHasValue(obj, annotationInject, injectForce)
+
+		if legacy := legacyAnnotations(obj); len(legacy) > 0 {
+			logrus.WithFields(logrus.Fields{
+				"requestUID":  ar.Request.UID,
+				"resource":    ar.Request.Resource.String(),
+				"name":        ar.Request.Name,
+				"namespace":   ar.Request.Namespace,
+				"annotations": strings.Join(legacy, ","),
+			}).Warn("Annotation keys of the form sqlbee.connctd.io.<name> are deprecated, use sqlbee.connctd.io/<name> instead")
+			sting.AddWarning(reviewResponse, "Annotation keys of the form sqlbee.connctd.io.<name> are deprecated, use sqlbee.connctd.io/<name> instead: "+strings.Join(legacy, ", "))
+		}
+
+		// Only objects matching the selector are targeted, independent of their annotations
+		if opts.Selector != nil {
+			if !opts.Selector.Matches(objectLabels(obj)) {
+				logrus.WithFields(logrus.Fields{
+					"requestUID": ar.Request.UID,
+					"resource":   ar.Request.Resource.String(),
+					"selector":   opts.Selector.String(),
+				}).Info("Resource does not match the selector, allowed")
+				reviewResponse.Allowed = true
+				return reviewResponse
+			}
+			opts.RequireAnnotation = false
+		}
+
+		// Check whether we should do the mutation. If the inject annotation is true or force
+		// we always inject. If it is false we never inject and remove a previously injected
+		// sidecar. If it is missing it depends whether opts.RequireAnnotation is true or not.
+		if annotationHasValue(obj, annotationInject, "false") {
+			reviewResponse.Allowed = true
+			// Remove a previously injected sidecar
+			if !removeProxies(podSpec, proxyContainerName(obj, opts), opts.SocketVolume) && !isInjected(obj) {
+				logrus.WithFields(logrus.Fields{
+					"requestUID": ar.Request.UID,
+					"resource":   ar.Request.Resource.String(),
+				}).Info("Resource does not need mutation, allowed")
+				return reviewResponse
+			}
+			removeStatus(obj)
+			removeAppArmor(obj, podSpec)
+			if err := setPatch(reviewResponse, obj, original.Bytes(), raw, nil, nil); err != nil {
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"requestUID": ar.Request.UID,
+					"resource":   ar.Request.Resource.String(),
+					"name":       ar.Request.Name,
+					"namespace":  ar.Request.Namespace,
+				}).Error("Failed to create JSON patch")
+				return sting.ToAdmissionResponse(err)
+			}
+			logrus.WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}).Info("Resource mutated, cloud-sql-proxy sidecar removed")
+			return reviewResponse
+		} else if opts.RequireAnnotation && !annotationHasValue(obj, annotationInject, "true") && !forced {
 			logrus.WithFields(logrus.Fields{
 				"requestUID": ar.Request.UID,
 				"resource":   ar.Request.Resource.String(),
@@ -275,15 +1260,42 @@ func Mutate(opts Options) sting.MutateFunc {
 			return reviewResponse
 		}
 
-		//Check if we have a valid cloud sql instance
-		if sting.AnnotationValue(obj, annotationInstance, opts.DefaultInstance) == "" {
+		// During a gradual rollout only a fraction of the workloads is injected
+		if !forced && !opts.Rollout.includes(ar.Request.Namespace, obj) {
+			logrus.WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}).Info("Resource is not part of the rollout, allowed")
+			reviewResponse.Allowed = true
+			return reviewResponse
+		}
+
+		// Pods created from an injected pod template already contain the sidecar
+		if _, isPod := obj.(*corev1.Pod); isPod && isInjected(obj) && hasProxyContainer(podSpec, proxyContainerName(obj, opts)) {
+			logrus.WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+			}).Info("Resource has already been injected, allowed")
+			reviewResponse.Allowed = true
+			return reviewResponse
+		}
+
+		//Check if we have a valid cloud sql instance. In FUSE mode or with project wide discovery
+		// instances are determined at runtime. Without a default instance only the proxies of
+		// named instances are injected
+		injectDefault := isFuse(obj, opts) || projects(obj, opts) != "" ||
+			annotationValue(obj, annotationInstance, opts.DefaultInstance) != ""
+		if !injectDefault && len(namedInstances(obj)) == 0 {
 			logrus.WithFields(logrus.Fields{
 				"requestUID": ar.Request.UID,
 				"resource":   ar.Request.Resource.String(),
 				"name":       ar.Request.Name,
 				"namespace":  ar.Request.Namespace,
 			}).Error("Can't determine Cloud SQL instance, SQLBee is not correctly configured")
-			err := fmt.Errorf("Instance is not specified via defaults or via annotation %s", annotationInstance)
+			err := sting.Invalid(fmt.Sprintf("Instance is not specified via defaults or via annotation %s or %s", annotationInstance, annotationProjects),
+				sting.FieldCause(metav1.CauseTypeFieldValueRequired, annotationField(annotationInstance), "Instance of the cloud-sql-proxy is required"))
 			return sting.ToAdmissionResponse(err)
 		}
 
@@ -293,13 +1305,182 @@ func Mutate(opts Options) sting.MutateFunc {
 
 		// Configure our copies of the container spec and the volumes based on the annotations
 		// and configuration
-		configureContainerAndVolumes(obj, proxyContainer, &volumes, opts)
+		mode, err := injectionMode(obj, opts)
+		if err != nil {
+			err = sting.Invalid(err.Error())
+		} else {
+			err = configureContainerAndVolumes(ctx, obj, proxyContainer, &volumes, opts)
+		}
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}).Error("Failed to configure the cloud-sql-proxy sidecar")
+			return sting.ToAdmissionResponse(err)
+		}
 
 		// mutate the pod with our sidecar, volumes and resources
-		mutatePodSpec(volumes, proxyContainer, podSpec)
+		port := 0
+		if injectDefault {
+			if mode == modeInitSidecar {
+				mutatePodSpecNative(volumes, proxyContainer, podSpec)
+			} else {
+				mutatePodSpec(volumes, proxyContainer, podSpec)
+				placement, _ := proxyPlacement(obj, opts)
+				podSpec.Containers = placeContainer(podSpec.Containers, proxyContainer.Name, placement)
+			}
+			configureAppContainers(obj, proxyContainer, podSpec, opts)
+			port, _ = proxyPort(obj, opts)
+		}
+		if err := configureNamedInstances(ctx, obj, proxyContainer.Name, port, podSpec, opts); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}).Error("Failed to configure the cloud-sql-proxy sidecars of named instances")
+			return sting.ToAdmissionResponse(err)
+		}
+		if err := configureAppArmor(obj, podSpec, proxyContainer.Name, opts); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}).Error("Failed to configure the AppArmor profile of the cloud-sql-proxy sidecars")
+			return sting.ToAdmissionResponse(err)
+		}
+		if err := configureVault(obj, podSpec, proxyContainer.Name, opts); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}).Error("Failed to request the credentials of the cloud-sql-proxy sidecars from Vault")
+			return sting.ToAdmissionResponse(err)
+		}
+		if configureServiceAccountToken(podSpec, proxyContainer.Name) {
+			logrus.WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}).Warn("Automount of the service account token is disabled, mounted it into the cloud-sql-proxy sidecars explicitly")
+		}
+		configurePullSecrets(obj, podSpec, opts)
+		if isLinkerd(obj, opts) {
+			configureLinkerd(obj)
+		}
+		setStatus(obj, proxyContainer.Image)
 		// create the actual patch
-		patchBytes, err := sting.CreatePatch(obj, raw)
+		fields, err := proxyFields(obj, opts)
 		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}).Error("Failed to configure the cloud-sql-proxy sidecar")
+			return sting.ToAdmissionResponse(err)
+		}
+
+		// Dry runs and shadowed decisions must not have side effects
+		sideEffects := ar.Request.Namespace != "" && !isTrue(ar.Request.DryRun) && !opts.Shadow
+
+		// Materialize the credentials of Secret Manager, unless the request has no side effects
+		if opts.SecretManager != nil && sideEffects {
+			secrets := materializedSecrets(obj, podSpec, proxyContainer.Name, opts)
+			if err := materializeSecrets(ctx, opts.SecretManager, ar.Request.Namespace, secrets); err != nil {
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"requestUID": ar.Request.UID,
+					"resource":   ar.Request.Resource.String(),
+					"name":       ar.Request.Name,
+					"namespace":  ar.Request.Namespace,
+				}).Warn("Failed to materialize the credentials of Secret Manager")
+			}
+		}
+
+		// Proxies of the default image change with upgrades of sqlbee
+		if opts.DefaultImage == "" && annotationValue(obj, annotationImage, "") == "" {
+			sting.AddWarning(reviewResponse, fmt.Sprintf("The image of the cloud-sql-proxy defaulted to %s, set it via annotation %s", mirrorImage(defaultImage, opts.ImageMirror), annotationImage))
+		}
+
+		// Unpinned images change unnoticed, deprecated ones lack fixes of the proxy
+		if image := proxyContainer.Image; isUnpinnedImage(image) {
+			sting.AddWarning(reviewResponse, fmt.Sprintf("The image %s of the cloud-sql-proxy isn't pinned to a version, set a tag or digest via annotation %s", image, annotationImage))
+		} else if isDeprecatedImage(image) {
+			sting.AddWarning(reviewResponse, fmt.Sprintf("The image %s of the cloud-sql-proxy is deprecated, use at least version %s via annotation %s", image, imageTag, annotationImage))
+		}
+
+		// Pods referencing missing secrets or config maps can't be started
+		if opts.Objects != nil && opts.VerifyReferences != "" && ar.Request.Namespace != "" {
+			references := unreplicatedReferences(opts.Replicator, ar.Request.Namespace, proxyReferences(podSpec, proxyContainer.Name))
+			missing, missingOptional, err := missingReferences(ctx, opts.Objects, ar.Request.Namespace, references)
+			fields := logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}
+			if err != nil {
+				logrus.WithError(err).WithFields(fields).Warn("Failed to verify the references of the cloud-sql-proxy sidecars")
+			} else if len(missing) > 0 {
+				err := fmt.Errorf("Missing references of the cloud-sql-proxy in namespace %s: %s", ar.Request.Namespace, strings.Join(missing, "; "))
+				if opts.VerifyReferences == verifyDeny {
+					logrus.WithError(err).WithFields(fields).Error("Injected cloud-sql-proxy sidecar references missing objects")
+					causes := make([]metav1.StatusCause, len(missing))
+					for i, description := range missing {
+						causes[i] = metav1.StatusCause{Type: metav1.CauseTypeFieldValueNotFound, Message: description}
+					}
+					return sting.ToAdmissionResponse(sting.Forbidden(err.Error(), causes...))
+				}
+				logrus.WithError(err).WithFields(fields).Warn("Injected cloud-sql-proxy sidecar references missing objects")
+				sting.AddWarning(reviewResponse, err.Error())
+			}
+			// Missing optional references never refuse the injection
+			for _, description := range missingOptional {
+				logrus.WithFields(fields).WithField("reference", description).Info("Injected cloud-sql-proxy sidecar references a missing optional object")
+				sting.AddWarning(reviewResponse, "Missing optional reference of the cloud-sql-proxy: "+description)
+			}
+		}
+
+		// Proxies without credentials fail to authenticate if the service account isn't bound to GCP
+		if opts.ServiceAccounts != nil && opts.VerifyWorkloadIdentity != "" && ar.Request.Namespace != "" && usesWorkloadIdentity(podSpec, proxyContainer.Name) {
+			misconfiguration, err := verifyWorkloadIdentity(ctx, opts.ServiceAccounts, ar.Request.Namespace, podSpec)
+			fields := logrus.Fields{
+				"requestUID": ar.Request.UID,
+				"resource":   ar.Request.Resource.String(),
+				"name":       ar.Request.Name,
+				"namespace":  ar.Request.Namespace,
+			}
+			if err != nil {
+				logrus.WithError(err).WithFields(fields).Warn("Failed to verify the workload identity of the cloud-sql-proxy sidecars")
+			} else if misconfiguration != "" {
+				if opts.VerifyWorkloadIdentity == verifyDeny {
+					logrus.WithFields(fields).Error(misconfiguration)
+					return sting.ToAdmissionResponse(sting.Forbidden(misconfiguration))
+				}
+				logrus.WithFields(fields).Warn(misconfiguration)
+				sting.AddWarning(reviewResponse, misconfiguration)
+			}
+		}
+
+		// Refuse patches which would be rejected by the pod security admission anyway
+		if opts.PodSecurityRestricted && enforcesRestricted(namespace) {
+			if err := validateRestricted(podSpec, proxyContainer.Name, fields); err != nil {
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"requestUID": ar.Request.UID,
+					"resource":   ar.Request.Resource.String(),
+					"name":       ar.Request.Name,
+					"namespace":  ar.Request.Namespace,
+				}).Error("Injected cloud-sql-proxy sidecar violates the restricted pod security standard")
+				return sting.ToAdmissionResponse(sting.Forbidden(fmt.Sprintf("Namespace %s enforces the restricted pod security standard: %s", ar.Request.Namespace, err)))
+			}
+		}
+
+		if err := setPatch(reviewResponse, obj, original.Bytes(), raw, fields, volumeFields(obj, opts)); err != nil {
 			logrus.WithError(err).WithFields(logrus.Fields{
 				"requestUID": ar.Request.UID,
 				"resource":   ar.Request.Resource.String(),
@@ -309,15 +1490,27 @@ func Mutate(opts Options) sting.MutateFunc {
 			return sting.ToAdmissionResponse(err)
 		}
 
-		// Only set the patch type if we have actually something to patch
-		if len(patchBytes) > 0 {
-			pt := v1beta1.PatchTypeJSONPatch
-			reviewResponse.PatchType = &pt
-			reviewResponse.Patch = patchBytes
-			logrus.WithFields(logrus.Fields{
-				"patch": string(patchBytes),
-			}).Debug("Created patches")
+		// Copy the central credentials into the namespace once the injection is allowed, unless the
+		// request has no side effects
+		if opts.Replicator != nil && sideEffects {
+			replicated, err := replicateSecret(ctx, opts.Replicator, ar.Request.Namespace, proxyReferences(podSpec, proxyContainer.Name))
+			if err != nil {
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"requestUID": ar.Request.UID,
+					"resource":   ar.Request.Resource.String(),
+					"name":       ar.Request.Name,
+					"namespace":  ar.Request.Namespace,
+					"secret":     opts.Replicator.Name(),
+				}).Warn("Failed to replicate the credentials secret into the namespace")
+			} else if replicated {
+				logrus.WithFields(logrus.Fields{
+					"requestUID": ar.Request.UID,
+					"namespace":  ar.Request.Namespace,
+					"secret":     opts.Replicator.Name(),
+				}).Debug("Replicated the credentials secret into the namespace")
+			}
 		}
+
 		logrus.WithFields(logrus.Fields{
 			"requestUID": ar.Request.UID,
 			"resource":   ar.Request.Resource.String(),
@@ -1,18 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/mattbaird/jsonpatch"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/connctd/sqlbee/pkg/sting"
 )
 
 var testPod = `
@@ -107,7 +117,7 @@ var podJson = `
 }
 `
 
-var expectedPodPatches = `[{"op":"add","path":"/spec/volumes/1","value":{"emptyDir":{},"name":"cloudsql"}},{"op":"add","path":"/spec/volumes/2","value":{"name":"sql-service-token-account","secret":{"secretName":"cloud-sql-credentials"}}},{"op":"remove","path":"/spec/containers/0"},{"op":"add","path":"/spec/containers/0","value":{"env":[{"name":"WORDPRESS_DB_HOST","value":"wordpress-mysql"},{"name":"WORDPRESS_DB_PASSWORD","valueFrom":{"secretKeyRef":{"key":"password","name":"mysql-pass"}}}],"image":"wordpress:4.8-apache","name":"wordpress","ports":[{"containerPort":80,"name":"wordpress"}],"resources":{},"volumeMounts":[{"mountPath":"/var/www/html","name":"wordpress-persistent-storage"}]}},{"op":"add","path":"/spec/containers/1","value":{"command":["/cloud_sql_proxy","-dir=/cloudsql","-credential_file=/credentials/credentials.json","-instances=my-gcp-project-42:europe-west1:sql-master=tcp:127.0.0.1:3306"],"image":"gcr.io/cloudsql-docker/gce-proxy:1.33.1","name":"cloud-sql-proxy","resources":{"requests":{"cpu":"10m","memory":"16Mi"}},"volumeMounts":[{"mountPath":"/cloudsql","name":"cloudsql"},{"mountPath":"/credentials","name":"sql-service-token-account"}]}}]`
+var expectedPodPatches = `[{"op":"add","path":"/metadata/labels/sqlbee.connctd.io~1status","value":"injected"},{"op":"add","path":"/metadata/labels/sqlbee.connctd.io~1proxy-version","value":"1.33.1"},{"op":"add","path":"/spec/volumes/1","value":{"emptyDir":{},"name":"cloudsql"}},{"op":"add","path":"/spec/volumes/2","value":{"name":"sql-service-token-account","secret":{"secretName":"cloud-sql-credentials"}}},{"op":"add","path":"/spec/containers/1","value":{"command":["/cloud_sql_proxy","-dir=/cloudsql","-credential_file=/credentials/credentials.json","-instances=my-gcp-project-42:europe-west1:sql-master=tcp:127.0.0.1:3306"],"image":"gcr.io/cloudsql-docker/gce-proxy:1.33.1","name":"cloud-sql-proxy","ports":[{"containerPort":3306,"name":"cloudsql","protocol":"TCP"}],"resources":{"requests":{"cpu":"10m","memory":"16Mi"}},"securityContext":{"allowPrivilegeEscalation":false,"capabilities":{"drop":["ALL"]},"readOnlyRootFilesystem":true,"runAsGroup":65532,"runAsNonRoot":true,"runAsUser":65532,"seccompProfile":{"type":"RuntimeDefault"}},"volumeMounts":[{"mountPath":"/cloudsql","name":"cloudsql"},{"mountPath":"/credentials","name":"sql-service-token-account"}]}}]`
 
 func TestMutation(t *testing.T) {
 	podRequest := &v1beta1.AdmissionReview{
@@ -171,3 +181,926 @@ func AreEqualPatches(s1, s2 string) (bool, error) {
 
 	return reflect.DeepEqual(o1, o2), nil
 }
+
+// image of version 2 of the proxy, required by its admin server
+var testImageV2 = imageNameV2 + ":2.8.0"
+
+// decodes the test pod and adds the given annotations to it
+func testPodWithAnnotations(t *testing.T, annotations map[string]string) *corev1.Pod {
+	pod := &corev1.Pod{}
+	_, _, err := sting.Deserializer.Decode([]byte(podJson), nil, pod)
+	require.NoError(t, err)
+	for k, v := range annotations {
+		pod.Annotations[k] = v
+	}
+	return pod
+}
+
+// configures copies of the proxy container and its volumes for the object
+func configureProxy(obj runtime.Object, opts Options) (*corev1.Container, []corev1.Volume, error) {
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	err := configureContainerAndVolumes(context.Background(), obj, proxyContainer, &volumes, opts)
+	return proxyContainer, volumes, err
+}
+
+// injects the proxy configured for the test pod with the given annotations into it, like the mutation
+// does, and returns the pod together with the injected proxy container
+func mutatePod(t *testing.T, opts Options, annotations map[string]string) (*corev1.Pod, *corev1.Container) {
+	t.Helper()
+	pod := testPodWithAnnotations(t, annotations)
+	proxyContainer, volumes, err := configureProxy(pod, opts)
+	require.NoError(t, err)
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	configureAppContainers(pod, proxyContainer, &pod.Spec, opts)
+	return pod, containerByName(&pod.Spec, proxyContainer.Name)
+}
+
+// runs the mutation on the admission request of the pod in the namespace
+func reviewPod(t *testing.T, opts Options, namespace string, pod *corev1.Pod) *v1beta1.AdmissionResponse {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	require.NoError(t, err)
+	ar := Mutate(opts)(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Namespace: namespace,
+			Resource:  podResource,
+			Object: runtime.RawExtension{
+				Raw: raw,
+			},
+		},
+	})
+	require.NotNil(t, ar)
+	return ar
+}
+
+func TestFuseMutation(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{annotationFuse: "true"})
+
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{}))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	configureAppContainers(pod, proxyContainer, &pod.Spec, Options{})
+
+	require.Len(t, pod.Spec.Containers, 2)
+	proxy := pod.Spec.Containers[1]
+	assert.Contains(t, proxy.Command, "-fuse")
+	for _, arg := range proxy.Command {
+		assert.NotContains(t, arg, "-instances")
+	}
+	require.NotNil(t, proxy.SecurityContext)
+	require.NotNil(t, proxy.SecurityContext.Privileged)
+	assert.True(t, *proxy.SecurityContext.Privileged)
+	require.NotNil(t, proxy.VolumeMounts[0].MountPropagation)
+	assert.Equal(t, corev1.MountPropagationBidirectional, *proxy.VolumeMounts[0].MountPropagation)
+
+	app := pod.Spec.Containers[0]
+	require.Len(t, app.VolumeMounts, 2)
+	assert.Equal(t, "cloudsql", app.VolumeMounts[1].Name)
+	assert.Equal(t, "/cloudsql", app.VolumeMounts[1].MountPath)
+	require.NotNil(t, app.VolumeMounts[1].MountPropagation)
+	assert.Equal(t, corev1.MountPropagationHostToContainer, *app.VolumeMounts[1].MountPropagation)
+}
+
+func TestProjectsCommand(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		expected    string
+	}{
+		{
+			annotations: map[string]string{annotationProjects: "my-gcp-project-42"},
+			opts:        Options{DefaultInstance: "my-gcp-project-42:europe-west1:sql-master"},
+			expected:    "-projects=my-gcp-project-42",
+		},
+		{
+			annotations: map[string]string{},
+			opts:        Options{DefaultProjects: "my-gcp-project-42"},
+			expected:    "-projects=my-gcp-project-42",
+		},
+		{
+			annotations: map[string]string{annotationInstance: "my-gcp-project-42:europe-west1:sql-master"},
+			opts:        Options{DefaultProjects: "my-gcp-project-42"},
+			expected:    "-instances=my-gcp-project-42:europe-west1:sql-master=tcp:127.0.0.1:3306",
+		},
+	} {
+		_, proxyContainer := mutatePod(t, data.opts, data.annotations)
+
+		assert.Equal(t, data.expected, proxyContainer.Command[len(proxyContainer.Command)-1])
+	}
+}
+
+func TestQuitQuitQuitMutation(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{annotationQuit: "true", annotationImage: testImageV2})
+
+	opts := Options{DefaultInstance: "my-gcp-project-42:europe-west1:sql-master"}
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, opts))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	configureAppContainers(pod, proxyContainer, &pod.Spec, opts)
+
+	require.Len(t, pod.Spec.Containers, 2)
+	assert.Contains(t, pod.Spec.Containers[1].Command, "--quitquitquit")
+	assert.NotContains(t, pod.Spec.Containers[1].Env, corev1.EnvVar{Name: quitURLEnv, Value: quitURL})
+	assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: quitURLEnv, Value: quitURL})
+
+	// Version 1 of the proxy has no admin server
+	pod = testPodWithAnnotations(t, map[string]string{annotationQuit: "true"})
+	proxyContainer, volumes, err := configureProxy(pod, opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires version 2 of the cloud-sql-proxy")
+}
+
+func TestVerbosityAndTelemetryCommand(t *testing.T) {
+	quiet := []string{"--quiet", "--disable-metrics", "--disable-traces"}
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		expected    []string
+		unexpected  []string
+	}{
+		{
+			annotations: map[string]string{},
+			opts:        Options{DefaultImage: testImageV2},
+			unexpected:  quiet,
+		},
+		{
+			annotations: map[string]string{annotationVerbose: "false", annotationTelemetry: "false"},
+			opts:        Options{DefaultImage: testImageV2},
+			expected:    quiet,
+		},
+		{
+			annotations: map[string]string{},
+			opts:        Options{DefaultImage: testImageV2, Quiet: true, DisableTelemetry: true},
+			expected:    quiet,
+		},
+		{
+			annotations: map[string]string{annotationVerbose: "true", annotationTelemetry: "true"},
+			opts:        Options{DefaultImage: testImageV2, Quiet: true, DisableTelemetry: true},
+			unexpected:  quiet,
+		},
+		// Version 1 of the proxy doesn't export telemetry and doesn't know the flags
+		{
+			annotations: map[string]string{annotationVerbose: "false", annotationTelemetry: "false"},
+			opts:        Options{},
+			expected:    []string{"-verbose=false"},
+			unexpected:  []string{"--quiet", "--disable-metrics", "--disable-traces", "-disable_metrics", "-disable_traces"},
+		},
+	} {
+		_, proxyContainer := mutatePod(t, data.opts, data.annotations)
+
+		for _, arg := range data.expected {
+			assert.Contains(t, proxyContainer.Command, arg)
+		}
+		for _, arg := range data.unexpected {
+			assert.NotContains(t, proxyContainer.Command, arg)
+		}
+	}
+}
+
+func TestLogLevelAnnotation(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		expected    []string
+		unexpected  []string
+	}{
+		{
+			annotations: map[string]string{annotationLogLevel: "debug"},
+			opts:        Options{Quiet: true},
+			expected:    []string{"-verbose=true", "-log_debug_stdout"},
+			unexpected:  []string{"-verbose=false"},
+		},
+		{
+			annotations: map[string]string{annotationLogLevel: "info", annotationVerbose: "false"},
+			expected:    []string{"-verbose=true"},
+			unexpected:  []string{"-verbose=false", "-log_debug_stdout"},
+		},
+		{
+			annotations: map[string]string{annotationLogLevel: "error"},
+			expected:    []string{"-verbose=false"},
+			unexpected:  []string{"-verbose=true", "-log_debug_stdout"},
+		},
+	} {
+		_, proxyContainer := mutatePod(t, data.opts, data.annotations)
+
+		for _, arg := range data.expected {
+			assert.Contains(t, proxyContainer.Command, arg)
+		}
+		for _, arg := range data.unexpected {
+			assert.NotContains(t, proxyContainer.Command, arg)
+		}
+	}
+
+	pod := testPodWithAnnotations(t, map[string]string{annotationLogLevel: "trace"})
+	_, _, err := configureProxy(pod, Options{})
+	assert.Error(t, err)
+}
+
+func TestResourceAnnotations(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		requests    corev1.ResourceList
+		limits      corev1.ResourceList
+		err         bool
+	}{
+		{
+			annotations: map[string]string{},
+			requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(defaultCPURequest),
+				corev1.ResourceMemory: resource.MustParse(defaultMemRequest),
+			},
+			limits: corev1.ResourceList{},
+		},
+		{
+			annotations: map[string]string{
+				annotationCPURequest: "50m",
+				annotationMemRequest: "32Mi",
+				annotationCPULimit:   "100m",
+				annotationMemLimit:   "64Mi",
+			},
+			requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("50m"),
+				corev1.ResourceMemory: resource.MustParse("32Mi"),
+			},
+			limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("100m"),
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			},
+		},
+		{
+			annotations: map[string]string{
+				annotationCPULimits: "200m",
+			},
+			requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(defaultCPURequest),
+				corev1.ResourceMemory: resource.MustParse(defaultMemRequest),
+			},
+			limits: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("200m"),
+			},
+		},
+		{
+			annotations: map[string]string{
+				annotationMemRequest: "lots",
+			},
+			err: true,
+		},
+		{
+			annotations: map[string]string{
+				annotationCPURequest: "20m",
+			},
+			opts: Options{DefaultCPURequest: "5m", DefaultMemRequest: "24Mi", DefaultMemLimit: "128Mi"},
+			requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("20m"),
+				corev1.ResourceMemory: resource.MustParse("24Mi"),
+			},
+			limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+		},
+	} {
+		pod := testPodWithAnnotations(t, data.annotations)
+		proxyContainer, _, err := configureProxy(pod, data.opts)
+		if data.err {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, data.requests, proxyContainer.Resources.Requests)
+		assert.Equal(t, data.limits, proxyContainer.Resources.Limits)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	for _, data := range []struct {
+		s        string
+		expected []string
+		err      bool
+	}{
+		{s: "", expected: []string{}},
+		{s: "  -max_connections=10   -term_timeout=30s ", expected: []string{"-max_connections=10", "-term_timeout=30s"}},
+		{s: `-ip_address_types="PRIVATE PUBLIC"`, expected: []string{"-ip_address_types=PRIVATE PUBLIC"}},
+		{s: `-a 'it"s' "" -b`, expected: []string{"-a", `it"s`, "", "-b"}},
+		{s: `-a\ b 'c\d'`, expected: []string{"-a b", `c\d`}},
+		{s: `-a "b`, err: true},
+		{s: `-a \`, err: true},
+	} {
+		args, err := splitArgs(data.s)
+		if data.err {
+			assert.Error(t, err, data.s)
+			continue
+		}
+		require.NoError(t, err, data.s)
+		assert.Equal(t, data.expected, args, data.s)
+	}
+}
+
+func TestExtraArgs(t *testing.T) {
+	_, proxyContainer := mutatePod(t, Options{}, map[string]string{annotationExtraArgs: "-max_connections=10 -term_timeout=30s"})
+
+	cmd := proxyContainer.Command
+	assert.Equal(t, []string{"-max_connections=10", "-term_timeout=30s"}, cmd[len(cmd)-2:])
+
+	_, _, err := configureProxy(testPodWithAnnotations(t, map[string]string{annotationExtraArgs: "-max_connections='10"}), Options{})
+	assert.Error(t, err)
+}
+
+func TestContainerName(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		expected    string
+	}{
+		{
+			annotations: map[string]string{},
+			opts:        Options{},
+			expected:    "cloud-sql-proxy",
+		},
+		{
+			annotations: map[string]string{},
+			opts:        Options{ContainerName: "sqlbee-proxy"},
+			expected:    "sqlbee-proxy",
+		},
+		{
+			annotations: map[string]string{annotationContainer: "db-proxy"},
+			opts:        Options{ContainerName: "sqlbee-proxy"},
+			expected:    "db-proxy",
+		},
+	} {
+		pod := testPodWithAnnotations(t, data.annotations)
+		// An existing container with the configured name is replaced in place, others are kept
+		pod.Spec.Containers = append(pod.Spec.Containers,
+			corev1.Container{Name: data.expected, Image: "example.com/proxy:1.0"},
+			corev1.Container{Name: "other-injector", Image: defaultImage},
+		)
+		proxyContainer := sqlProxyContainer.DeepCopy()
+		volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+		require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, data.opts))
+		mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+
+		names := []string{}
+		for _, container := range pod.Spec.Containers {
+			names = append(names, container.Name)
+		}
+		assert.Equal(t, []string{"wordpress", data.expected, "other-injector"}, names)
+		assert.Equal(t, defaultImage, pod.Spec.Containers[1].Image)
+	}
+}
+
+func TestImagePullConfiguration(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		policy      corev1.PullPolicy
+		secrets     []corev1.LocalObjectReference
+		err         bool
+	}{
+		{
+			annotations: map[string]string{},
+			opts:        Options{},
+		},
+		{
+			annotations: map[string]string{},
+			opts:        Options{ImagePullPolicy: "Always", ImagePullSecrets: []string{"registry"}},
+			policy:      corev1.PullAlways,
+			secrets:     []corev1.LocalObjectReference{{Name: "registry"}},
+		},
+		{
+			annotations: map[string]string{
+				annotationPullPolicy: "IfNotPresent",
+				annotationPullSecret: "mirror, registry ,mirror",
+			},
+			opts:    Options{ImagePullPolicy: "Always", ImagePullSecrets: []string{"registry"}},
+			policy:  corev1.PullIfNotPresent,
+			secrets: []corev1.LocalObjectReference{{Name: "mirror"}, {Name: "registry"}},
+		},
+		{
+			annotations: map[string]string{annotationPullPolicy: "Sometimes"},
+			err:         true,
+		},
+	} {
+		pod := testPodWithAnnotations(t, data.annotations)
+		proxyContainer, _, err := configureProxy(pod, data.opts)
+		if data.err {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		configurePullSecrets(pod, &pod.Spec, data.opts)
+
+		assert.Equal(t, data.policy, proxyContainer.ImagePullPolicy)
+		assert.Equal(t, data.secrets, pod.Spec.ImagePullSecrets)
+	}
+}
+
+func TestSecurityContextAnnotation(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationFuse:   "true",
+		annotationSecCtx: `{"runAsUser": 65532, "capabilities": {"drop": ["NET_RAW"]}}`,
+	})
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{}))
+
+	securityContext := proxyContainer.SecurityContext
+	require.NotNil(t, securityContext)
+	// fields set by the injection are kept unless overridden
+	require.NotNil(t, securityContext.Privileged)
+	assert.True(t, *securityContext.Privileged)
+	require.NotNil(t, securityContext.RunAsUser)
+	assert.Equal(t, int64(65532), *securityContext.RunAsUser)
+	require.NotNil(t, securityContext.Capabilities)
+	assert.Equal(t, []corev1.Capability{"NET_RAW"}, securityContext.Capabilities.Drop)
+
+	pod = testPodWithAnnotations(t, map[string]string{annotationSecCtx: `{"runAsRoot": false}`})
+	assert.Error(t, configureContainerAndVolumes(context.Background(), pod, sqlProxyContainer.DeepCopy(), &volumes, Options{}))
+}
+
+func TestSelector(t *testing.T) {
+	selector, err := labels.Parse("team=payments")
+	require.NoError(t, err)
+	opts := Options{
+		DefaultInstance:   "proj:eu:db",
+		RequireAnnotation: true,
+		Selector:          selector,
+	}
+
+	for _, data := range []struct {
+		labels      map[string]string
+		annotations map[string]string
+		injected    bool
+	}{
+		{labels: map[string]string{"team": "payments"}, injected: true},
+		{labels: map[string]string{"team": "payments"}, annotations: map[string]string{annotationInject: "false"}, injected: false},
+		{labels: map[string]string{"team": "billing"}, injected: false},
+		{labels: map[string]string{"team": "billing"}, annotations: map[string]string{annotationInject: "true"}, injected: false},
+		{injected: false},
+	} {
+		pod := testPodWithAnnotations(t, nil)
+		delete(pod.Annotations, annotationInject)
+		for k, v := range data.annotations {
+			pod.Annotations[k] = v
+		}
+		pod.Labels = data.labels
+		ar := reviewPod(t, opts, "", pod)
+		assert.True(t, ar.Allowed)
+		assert.Equal(t, data.injected, len(ar.Patch) > 0, "%v %v", data.labels, data.annotations)
+	}
+}
+
+func TestDBType(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		port        int
+	}{
+		{annotations: map[string]string{}, port: 3306},
+		{annotations: map[string]string{}, opts: Options{DBType: "postgres"}, port: 5432},
+		{annotations: map[string]string{annotationDBType: "sqlserver"}, opts: Options{DBType: "postgres"}, port: 1433},
+		{annotations: map[string]string{annotationDBType: "postgres", annotationPort: "6432"}, port: 6432},
+	} {
+		pod := testPodWithAnnotations(t, data.annotations)
+		port, err := proxyPort(pod, data.opts)
+		require.NoError(t, err)
+		assert.Equal(t, data.port, port)
+	}
+
+	_, err := proxyPort(testPodWithAnnotations(t, map[string]string{annotationDBType: "oracle"}), Options{})
+	assert.Error(t, err)
+
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationInstance:                "proj:eu:default",
+		annotationInstance + ".reporting": "proj:eu:reporting",
+		annotationDBType + ".reporting":   "postgres",
+	})
+	require.NoError(t, configureNamedInstances(context.Background(), pod, "cloud-sql-proxy", 3306, &pod.Spec, Options{}))
+	reporting := containerByName(&pod.Spec, "cloud-sql-proxy-reporting")
+	require.NotNil(t, reporting)
+	assert.Contains(t, reporting.Command, "-instances=proj:eu:reporting=tcp:127.0.0.1:5432")
+}
+
+func TestValidateDBType(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		valid       bool
+	}{
+		{annotations: map[string]string{annotationDBType: "sqlserver"}, valid: true},
+		{annotations: map[string]string{annotationFuse: "true"}, opts: Options{DBType: "postgres"}, valid: true},
+		{annotations: map[string]string{annotationFuse: "true"}, opts: Options{DBType: "sqlserver"}},
+		{annotations: map[string]string{annotationDBType: "sqlserver", annotationProjects: "proj"}},
+		{annotations: map[string]string{annotationDBType: "sqlserver"}, opts: Options{Fuse: true}},
+	} {
+		_, _, err := configureProxy(testPodWithAnnotations(t, data.annotations), data.opts)
+		assert.Equal(t, data.valid, err == nil, "%v %v", data.annotations, err)
+	}
+}
+
+func TestIAMAuthn(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		flag        string
+		valid       bool
+	}{
+		{annotations: map[string]string{annotationIAMAuthn: "false"}, opts: Options{IAMAuthn: true}, valid: true},
+		{annotations: map[string]string{annotationDBType: "postgres"}, opts: Options{IAMAuthn: true}, flag: "-enable_iam_login", valid: true},
+		{annotations: map[string]string{annotationIAMAuthn: "true", annotationImage: testImageV2}, flag: "--auto-iam-authn", valid: true},
+		{annotations: map[string]string{annotationIAMAuthn: "true"}},
+		{annotations: map[string]string{annotationIAMAuthn: "true", annotationImage: testImageV2, annotationDBType: "sqlserver"}},
+	} {
+		pod := testPodWithAnnotations(t, data.annotations)
+		proxyContainer, _, err := configureProxy(pod, data.opts)
+		require.Equal(t, data.valid, err == nil, "%v %v", data.annotations, err)
+		if !data.valid {
+			continue
+		}
+		for _, flag := range []string{"-enable_iam_login", "--auto-iam-authn"} {
+			if flag == data.flag {
+				assert.Contains(t, proxyContainer.Command, flag)
+			} else {
+				assert.NotContains(t, proxyContainer.Command, flag)
+			}
+		}
+	}
+}
+
+func TestProxyContainerPort(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		ports       []corev1.ContainerPort
+	}{
+		{
+			annotations: map[string]string{annotationDBType: "postgres"},
+			ports:       []corev1.ContainerPort{{Name: proxyPortName, ContainerPort: 5432, Protocol: corev1.ProtocolTCP}},
+		},
+		{
+			annotations: map[string]string{annotationProjects: "proj"},
+		},
+		{
+			annotations: map[string]string{annotationFuse: "true"},
+		},
+	} {
+		_, proxyContainer := mutatePod(t, Options{DefaultInstance: "proj:eu:db"}, data.annotations)
+		assert.Equal(t, data.ports, proxyContainer.Ports)
+	}
+
+	// The port of a previously injected proxy is updated
+	pod := testPodWithAnnotations(t, nil)
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:  "cloud-sql-proxy",
+		Ports: []corev1.ContainerPort{{Name: proxyPortName, ContainerPort: 5432}, {Name: "metrics", ContainerPort: 9090}},
+	})
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{DefaultInstance: "proj:eu:db"}))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	assert.Equal(t, []corev1.ContainerPort{
+		{Name: "metrics", ContainerPort: 9090},
+		{Name: proxyPortName, ContainerPort: 3306, Protocol: corev1.ProtocolTCP},
+	}, containerByName(&pod.Spec, "cloud-sql-proxy").Ports)
+}
+
+func TestEgressProxy(t *testing.T) {
+	opts := Options{HTTPSProxy: "http://proxy.corp:3128", NoProxy: "169.254.169.254"}
+
+	pod, proxyContainer := mutatePod(t, opts, map[string]string{annotationNoProxy: "metadata.google.internal"})
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "HTTPS_PROXY", Value: "http://proxy.corp:3128"},
+		{Name: "NO_PROXY", Value: "metadata.google.internal"},
+	}, proxyContainer.Env)
+
+	for _, container := range pod.Spec.Containers {
+		if container.Name == proxyContainer.Name {
+			continue
+		}
+		for _, env := range container.Env {
+			assert.NotContains(t, []string{"HTTPS_PROXY", "NO_PROXY"}, env.Name)
+		}
+	}
+
+	_, proxyContainer = mutatePod(t, Options{}, nil)
+	assert.Empty(t, proxyContainer.Env)
+}
+
+func TestSecretKey(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		file        string
+		items       []corev1.KeyToPath
+	}{
+		{
+			annotations: map[string]string{annotationSecret: "creds"},
+			file:        "/credentials/credentials.json",
+		},
+		{
+			annotations: map[string]string{annotationSecret: "creds"},
+			opts:        Options{SecretKey: "key.json"},
+			file:        "/credentials/key.json",
+			items:       []corev1.KeyToPath{{Key: "key.json", Path: "key.json"}},
+		},
+		{
+			annotations: map[string]string{annotationSecret: "creds", annotationSecretKey: "service-account.json"},
+			opts:        Options{SecretKey: "key.json"},
+			file:        "/credentials/service-account.json",
+			items:       []corev1.KeyToPath{{Key: "service-account.json", Path: "service-account.json"}},
+		},
+	} {
+		proxyContainer, volumes, err := configureProxy(testPodWithAnnotations(t, data.annotations), data.opts)
+		require.NoError(t, err)
+
+		assert.Contains(t, proxyContainer.Command, "-credential_file="+data.file)
+		require.Len(t, volumes, 2)
+		assert.Equal(t, "creds", volumes[1].Secret.SecretName)
+		assert.Equal(t, data.items, volumes[1].Secret.Items)
+	}
+}
+
+func TestWorkloadIdentityFederation(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationSecret:    "creds",
+		annotationFedConfig: "wif-config",
+	})
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{TokenAudience: "//iam.googleapis.com/pool"}))
+
+	assert.Contains(t, proxyContainer.Command, "-credential_file=/federated/credential-configuration.json")
+	assert.NotContains(t, proxyContainer.Command, "-credential_file=/credentials/credentials.json")
+	assert.Contains(t, proxyContainer.VolumeMounts, federatedMount)
+	require.Len(t, volumes, 2)
+	require.NotNil(t, volumes[1].Projected)
+	sources := volumes[1].Projected.Sources
+	require.Len(t, sources, 2)
+	assert.Equal(t, "//iam.googleapis.com/pool", sources[0].ServiceAccountToken.Audience)
+	assert.Equal(t, "token", sources[0].ServiceAccountToken.Path)
+	assert.Equal(t, "wif-config", sources[1].ConfigMap.Name)
+
+	// The credential configuration is required
+	pod = testPodWithAnnotations(t, map[string]string{annotationAudience: "//iam.googleapis.com/pool"})
+	_, _, err := configureProxy(pod, Options{})
+	assert.Error(t, err)
+}
+
+func TestForceInjectionInIgnoredNamespace(t *testing.T) {
+	opts := Options{DefaultInstance: "proj:eu:db", RequireAnnotation: true}
+	for inject, injected := range map[string]bool{"true": false, "force": true} {
+		pod := testPodWithAnnotations(t, map[string]string{annotationInject: inject})
+		ar := reviewPod(t, opts, metav1.NamespaceSystem, pod)
+		assert.True(t, ar.Allowed)
+		assert.Equal(t, injected, len(ar.Patch) > 0, inject)
+	}
+
+	// Objects within ignored namespaces are allowed before they are decoded
+	ar := Mutate(opts)(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Namespace: metav1.NamespaceSystem,
+			Resource:  podResource,
+			Object:    runtime.RawExtension{Raw: []byte(`{"metadata":{"name":"test"},"spec":{"containers":"invalid"}}`)},
+		},
+	})
+	require.NotNil(t, ar)
+	assert.True(t, ar.Allowed)
+	assert.Empty(t, ar.Patch)
+}
+
+func TestValidateInstance(t *testing.T) {
+	for instance, valid := range map[string]bool{
+		"my-gcp-project-42:europe-west1:sql-master": true,
+		"example.com:project:us-central1:db":        true,
+		"proj:eu:db":                                true,
+		"proj:europe-west1":                         false,
+		"proj:europe-west1:db:extra":                false,
+		"Proj:europe-west1:db":                      false,
+		"proj:europe-west1:db-":                     false,
+		"proj:europe-west1:db=tcp:3306":             false,
+		"proj :europe-west1:db":                     false,
+	} {
+		assert.Equal(t, valid, validateInstance(instance) == nil, instance)
+	}
+}
+
+func TestMutateDeniesInvalidInstance(t *testing.T) {
+	ar := Mutate(Options{DefaultInstance: "proj:europe-west1"})(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Resource: podResource,
+			Object: runtime.RawExtension{
+				Raw: []byte(podJson),
+			},
+		},
+	})
+	require.NotNil(t, ar)
+	assert.False(t, ar.Allowed)
+	require.NotNil(t, ar.Result)
+	assert.Contains(t, ar.Result.Message, "project:region:instance")
+	assert.Equal(t, metav1.StatusReasonInvalid, ar.Result.Reason)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, ar.Result.Code)
+}
+
+func TestMutateDeniesMissingInstance(t *testing.T) {
+	ar := Mutate(Options{})(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Resource: podResource,
+			Object: runtime.RawExtension{
+				Raw: []byte(podJson),
+			},
+		},
+	})
+	require.NotNil(t, ar)
+	assert.False(t, ar.Allowed)
+	require.NotNil(t, ar.Result)
+	assert.Equal(t, metav1.StatusReasonInvalid, ar.Result.Reason)
+	require.NotNil(t, ar.Result.Details)
+	require.Len(t, ar.Result.Details.Causes, 1)
+	assert.Equal(t, "metadata.annotations[sqlbee.connctd.io/instance]", ar.Result.Details.Causes[0].Field)
+}
+
+func TestReinjectionWithoutChanges(t *testing.T) {
+	for _, mode := range []string{modeSidecar, modeInitSidecar} {
+		opts := Options{DefaultInstance: "proj:eu:db", Mode: mode}
+
+		// Inject the proxy like the webhook does and resubmit the resulting object
+		obj, podSpec, err := decodeDeployment(objectReview(deploymentJson))
+		require.NoError(t, err)
+		proxyContainer := sqlProxyContainer.DeepCopy()
+		volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+		require.NoError(t, configureContainerAndVolumes(context.Background(), obj, proxyContainer, &volumes, opts))
+		if mode == modeInitSidecar {
+			mutatePodSpecNative(volumes, proxyContainer, podSpec)
+		} else {
+			mutatePodSpec(volumes, proxyContainer, podSpec)
+		}
+		configureAppContainers(obj, proxyContainer, podSpec, opts)
+		setStatus(obj, proxyContainer.Image)
+		fields, err := proxyFields(obj, opts)
+		require.NoError(t, err)
+		mutated := &bytes.Buffer{}
+		require.NoError(t, sting.Marshaler.Encode(obj, mutated))
+		raw, err := setContainerFields(mutated.Bytes(), []byte(deploymentJson), podSpecPath(obj), fields)
+		require.NoError(t, err)
+
+		ar := Mutate(opts)(&v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Resource: deploymentResource,
+				Object: runtime.RawExtension{
+					Raw: raw,
+				},
+			},
+		})
+		require.NotNil(t, ar)
+		assert.True(t, ar.Allowed)
+		assert.Empty(t, string(ar.Patch), mode)
+	}
+}
+
+func TestMutatePodSpecReplacesProxyInPlace(t *testing.T) {
+	pod := testPodWithAnnotations(t, nil)
+	proxyContainer, volumes, err := configureProxy(pod, Options{})
+	require.NoError(t, err)
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+
+	// A container and volume added after the proxy keep their position
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "other"})
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "other"})
+	containers := len(pod.Spec.Containers)
+	names := volumeNames(&pod.Spec)
+
+	proxyContainer.Image = "gcr.io/cloudsql-docker/gce-proxy:1.33.0"
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	require.Len(t, pod.Spec.Containers, containers)
+	assert.Equal(t, proxyContainer.Image, pod.Spec.Containers[containers-2].Image)
+	assert.Equal(t, "other", pod.Spec.Containers[containers-1].Name)
+	assert.Equal(t, names, volumeNames(&pod.Spec))
+}
+
+func TestPatchIsTargeted(t *testing.T) {
+	// Fields unknown to our API types and fields missing due to defaulting are left alone
+	raw := strings.Replace(podJson, `"spec": {`, `"spec": {
+      "os": {"name": "linux"},`, 1)
+	require.NotEqual(t, podJson, raw)
+
+	ar := Mutate(Options{DefaultInstance: "proj:eu:db", DefaultImage: testImageV2, QuitQuitQuit: true})(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Resource: podResource,
+			Object: runtime.RawExtension{
+				Raw: []byte(raw),
+			},
+		},
+	})
+	require.NotNil(t, ar)
+	require.True(t, ar.Allowed)
+
+	var ops []jsonpatch.JsonPatchOperation
+	require.NoError(t, json.Unmarshal(ar.Patch, &ops))
+	paths := []string{}
+	for _, op := range ops {
+		assert.Equal(t, "add", op.Operation, op.Path)
+		paths = append(paths, op.Path)
+	}
+	assert.ElementsMatch(t, []string{
+		"/metadata/labels/sqlbee.connctd.io~1status",
+		"/metadata/labels/sqlbee.connctd.io~1proxy-version",
+		"/spec/containers/0/env/2",
+		"/spec/containers/1",
+		"/spec/volumes/1",
+	}, paths)
+}
+
+func TestMutateWarnings(t *testing.T) {
+	ar := Mutate(Options{
+		DefaultInstance:   "proj:eu:db",
+		DefaultSecretName: "creds",
+		Objects:           staticObjects{"secrets/creds": true},
+		VerifyReferences:  verifyDeny,
+	})(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Namespace: "team-a",
+			Resource:  podResource,
+			Object: runtime.RawExtension{
+				Raw: []byte(podJson),
+			},
+		},
+	})
+	require.NotNil(t, ar)
+	assert.True(t, ar.Allowed)
+	warnings := sting.Warnings(ar)
+	require.Len(t, warnings, 2)
+	assert.Contains(t, warnings[0], "are deprecated, use sqlbee.connctd.io/<name> instead: "+annotationInject)
+	assert.Contains(t, warnings[1], "The image of the cloud-sql-proxy defaulted to "+defaultImage)
+
+	// Only defaulted, unpinned and deprecated images are warned about
+	for image, warning := range map[string]string{
+		"": "The image of the cloud-sql-proxy defaulted to " + defaultImage,
+		"gcr.io/cloudsql-docker/gce-proxy:1.33.2":           "",
+		"gcr.io/cloud-sql-connectors/cloud-sql-proxy:2.1.0": "",
+		"gcr.io/cloudsql-docker/gce-proxy@sha256:abc":       "",
+		"gcr.io/cloudsql-docker/gce-proxy":                  "isn't pinned to a version",
+		"gcr.io/cloudsql-docker/gce-proxy:latest":           "isn't pinned to a version",
+		"gcr.io/cloudsql-docker/gce-proxy:1.13":             "is deprecated, use at least version " + imageTag,
+		"gcr.io/cloudsql-docker/gce-proxy:1.30.0-alpine":    "is deprecated",
+	} {
+		annotations := map[string]string{}
+		if image != "" {
+			annotations[annotationImage] = image
+		}
+		ar := reviewPod(t, Options{DefaultInstance: "proj:eu:db"}, "team-a", testPodWithAnnotations(t, annotations))
+		require.NotNil(t, ar)
+		require.True(t, ar.Allowed, image)
+		warnings := sting.Warnings(ar)
+		if warning == "" {
+			assert.Len(t, warnings, 1, image)
+			continue
+		}
+		require.Len(t, warnings, 2, image)
+		assert.Contains(t, warnings[1], warning, image)
+	}
+
+	ar = Mutate(Options{DefaultInstance: "proj:eu:db", DefaultImage: "gcr.io/cloudsql-docker/gce-proxy:1.33.2"})(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Resource: podResource,
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"app","annotations":{"sqlbee.connctd.io/inject":"true"}},"spec":{"containers":[{"name":"app","image":"app"}]}}`),
+			},
+		},
+	})
+	require.NotNil(t, ar)
+	assert.True(t, ar.Allowed)
+	assert.Empty(t, sting.Warnings(ar))
+}
+
+func TestMutateDenialClassification(t *testing.T) {
+	pinned := defaultImage + "@sha256:9e5ab5a7a7ba1c1d3e7e3f7c1d0b2c9a2b3a7f1f6e5e4d3c2b1a09f8e7d6c5b4"
+	opts := Options{DefaultInstance: "proj:eu:db", Digests: staticResolver{defaultImage: pinned}}
+	for _, data := range []struct {
+		annotations map[string]string
+		invalid     bool
+	}{
+		// Invalid annotations of the object
+		{annotations: map[string]string{annotationLogLevel: "verbose"}, invalid: true},
+		{annotations: map[string]string{annotationMode: "unknown"}, invalid: true},
+		{annotations: map[string]string{annotationInstance + ".reporting": "proj:eu:reporting", annotationLogLevel + ".reporting": "verbose"}, invalid: true},
+		{annotations: map[string]string{annotationAppArmor: "enforcing"}, invalid: true},
+		{annotations: map[string]string{annotationCredSource: credentialsSourceVault, annotationVaultPath: "secret/sql"}, invalid: true},
+		{annotations: map[string]string{annotationPreStop: "soon"}, invalid: true},
+		// Failures of the infrastructure, like the registry, are no fault of the object
+		{annotations: map[string]string{annotationImage: "gcr.io/cloudsql-docker/gce-proxy:1.33.2"}},
+		{annotations: map[string]string{annotationInstance + ".reporting": "proj:eu:reporting", annotationImage + ".reporting": "gcr.io/cloudsql-docker/gce-proxy:1.33.2"}},
+	} {
+		ar := reviewPod(t, opts, "team-a", testPodWithAnnotations(t, data.annotations))
+		require.NotNil(t, ar)
+		assert.False(t, ar.Allowed, "%v", data.annotations)
+		require.NotNil(t, ar.Result)
+		if data.invalid {
+			assert.Equal(t, metav1.StatusReasonInvalid, ar.Result.Reason, "%v", data.annotations)
+			assert.EqualValues(t, http.StatusUnprocessableEntity, ar.Result.Code, "%v", data.annotations)
+		} else {
+			assert.Empty(t, ar.Result.Reason, "%v", data.annotations)
+			assert.Contains(t, ar.Result.Message, "Failed to resolve the digest", "%v", data.annotations)
+		}
+	}
+}
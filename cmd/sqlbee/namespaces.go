@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// The label of namespaces which enables or disables the injection for all objects within them
+const (
+	labelInjection    = "sqlbee.connctd.io/injection"
+	injectionEnabled  = "enabled"
+	injectionDisabled = "disabled"
+)
+
+// namespaceDefaults returns a copy of opts with the defaults replaced by the values of the sqlbee
+// annotations of the namespace. This way cluster admins can configure the injection per namespace,
+// while the annotations of the workloads still take precedence
+func namespaceDefaults(opts Options, namespace *corev1.Namespace) Options {
+	annotations := sqlbeeAnnotations(namespace)
+
+	for key, field := range map[string]*string{
+		annotationInstance:   &opts.DefaultInstance,
+		annotationProjects:   &opts.DefaultProjects,
+		annotationSecret:     &opts.DefaultSecretName,
+		annotationSecretKey:  &opts.SecretKey,
+		annotationCredSource: &opts.CredentialsSource,
+		annotationCSIClass:   &opts.SecretProviderClass,
+		annotationVaultRole:  &opts.VaultRole,
+		annotationVaultPath:  &opts.VaultSecretPath,
+		annotationSMSecret:   &opts.SecretManagerSecret,
+		annotationDBType:     &opts.DBType,
+		annotationAudience:   &opts.TokenAudience,
+		annotationFedConfig:  &opts.FederatedConfig,
+		annotationCaMap:      &opts.DefaultCertVolume,
+		annotationImage:      &opts.DefaultImage,
+		annotationContainer:  &opts.ContainerName,
+		annotationPullPolicy: &opts.ImagePullPolicy,
+		annotationCPURequest: &opts.DefaultCPURequest,
+		annotationMemRequest: &opts.DefaultMemRequest,
+		annotationCPULimit:   &opts.DefaultCPULimit,
+		annotationMemLimit:   &opts.DefaultMemLimit,
+		annotationHTTPSProxy: &opts.HTTPSProxy,
+		annotationNoProxy:    &opts.NoProxy,
+		annotationMode:       &opts.Mode,
+		annotationPlacement:  &opts.Placement,
+		annotationMedium:     &opts.SocketMedium,
+		annotationSocketSize: &opts.SocketSizeLimit,
+		annotationAppArmor:   &opts.AppArmorProfile,
+	} {
+		if val, exists := annotations[key]; exists {
+			*field = val
+		}
+	}
+
+	for key, field := range map[string]*bool{
+		annotationFuse:     &opts.Fuse,
+		annotationQuit:     &opts.QuitQuitQuit,
+		annotationIAMAuthn: &opts.IAMAuthn,
+		annotationLinkerd:  &opts.Linkerd,
+		annotationHealth:   &opts.HealthChecks,
+		annotationWait:     &opts.WaitForProxy,
+	} {
+		if val, err := strconv.ParseBool(annotations[key]); err == nil {
+			*field = val
+		}
+	}
+	if verbose, err := strconv.ParseBool(annotations[annotationVerbose]); err == nil {
+		opts.Quiet = !verbose
+	}
+	if telemetry, err := strconv.ParseBool(annotations[annotationTelemetry]); err == nil {
+		opts.DisableTelemetry = !telemetry
+	}
+
+	if val := annotations[annotationPullSecret]; val != "" {
+		opts.ImagePullSecrets = nil
+		for _, secret := range strings.Split(val, ",") {
+			if secret = strings.TrimSpace(secret); secret != "" {
+				opts.ImagePullSecrets = append(opts.ImagePullSecrets, secret)
+			}
+		}
+	}
+	return opts
+}
+
+// parseNamespaceInstances parses a mapping of namespaces to default instances like
+// team-a=proj:eu:db-a,team-b=proj:eu:db-b. Entries are separated by commas or new lines, lines
+// starting with # are ignored so the mapping can be maintained in a file
+func parseNamespaceInstances(mapping string) (map[string]string, error) {
+	instances := map[string]string{}
+	for _, line := range strings.Split(mapping, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, entry := range strings.Split(line, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("Invalid entry %q, expected namespace=instance", entry)
+			}
+			namespace, instance := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+				return nil, fmt.Errorf("Invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+			}
+			if err := validateInstance(instance); err != nil {
+				return nil, err
+			}
+			if _, exists := instances[namespace]; exists {
+				return nil, fmt.Errorf("Duplicate entry for namespace %s", namespace)
+			}
+			instances[namespace] = instance
+		}
+	}
+	return instances, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type staticNamespaces map[string]*corev1.Namespace
+
+func (s staticNamespaces) GetNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	namespace, exists := s[name]
+	if !exists {
+		return nil, errors.New("namespace not found")
+	}
+	return namespace, nil
+}
+
+func TestNamespaceDefaults(t *testing.T) {
+	namespace := &corev1.Namespace{}
+	namespace.Annotations = map[string]string{
+		annotationInstance:   "proj:eu:db-a",
+		annotationSecret:     "team-a-credentials",
+		annotationImage:      "registry.internal/gce-proxy:1.33.1",
+		annotationFuse:       "true",
+		annotationVerbose:    "false",
+		annotationPullSecret: "registry, mirror",
+		"unrelated":          "value",
+	}
+	opts := namespaceDefaults(Options{
+		DefaultInstance:   "proj:eu:db",
+		DefaultSecretName: "credentials",
+		DefaultCertVolume: "ca-certificates",
+		RequireAnnotation: true,
+	}, namespace)
+
+	assert.Equal(t, Options{
+		DefaultInstance:   "proj:eu:db-a",
+		DefaultSecretName: "team-a-credentials",
+		DefaultCertVolume: "ca-certificates",
+		DefaultImage:      "registry.internal/gce-proxy:1.33.1",
+		RequireAnnotation: true,
+		Fuse:              true,
+		Quiet:             true,
+		ImagePullSecrets:  []string{"registry", "mirror"},
+	}, opts)
+}
+
+func TestMutateWithNamespaceDefaults(t *testing.T) {
+	teamA := &corev1.Namespace{}
+	teamA.Name = "team-a"
+	teamA.Annotations = map[string]string{annotationInstance: "proj:eu:db-a"}
+
+	mut := Mutate(Options{
+		RequireAnnotation: true,
+		Namespaces:        staticNamespaces{"team-a": teamA},
+		NamespaceDefaults: true,
+	})
+	for _, data := range []struct {
+		namespace string
+		allowed   bool
+	}{
+		{namespace: "team-a", allowed: true},
+		// without a namespace default there is no instance to connect to
+		{namespace: "team-b", allowed: false},
+	} {
+		ar := mut(&v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Namespace: data.namespace,
+				Resource:  podResource,
+				Object: runtime.RawExtension{
+					Raw: []byte(podJson),
+				},
+			},
+		})
+		require.NotNil(t, ar)
+		assert.Equal(t, data.allowed, ar.Allowed, data.namespace)
+		if data.allowed {
+			assert.Contains(t, string(ar.Patch), "-instances=proj:eu:db-a=tcp:127.0.0.1:3306")
+		}
+	}
+}
+
+func TestMutateWithNamespaceLabels(t *testing.T) {
+	namespaces := staticNamespaces{}
+	for name, label := range map[string]string{"enabled": injectionEnabled, "disabled": injectionDisabled, "unlabeled": ""} {
+		namespace := &corev1.Namespace{}
+		namespace.Name = name
+		if label != "" {
+			namespace.Labels = map[string]string{labelInjection: label}
+		}
+		namespaces[name] = namespace
+	}
+
+	mut := Mutate(Options{
+		DefaultInstance:   "proj:eu:db",
+		RequireAnnotation: true,
+		Namespaces:        namespaces,
+		NamespaceLabels:   true,
+	})
+	for _, data := range []struct {
+		namespace   string
+		annotations string
+		injected    bool
+	}{
+		{namespace: "enabled", annotations: `{}`, injected: true},
+		{namespace: "enabled", annotations: `{"sqlbee.connctd.io.inject": "false"}`, injected: false},
+		{namespace: "disabled", annotations: `{"sqlbee.connctd.io.inject": "true"}`, injected: false},
+		{namespace: "unlabeled", annotations: `{}`, injected: false},
+		{namespace: "unlabeled", annotations: `{"sqlbee.connctd.io.inject": "true"}`, injected: true},
+	} {
+		raw := strings.Replace(podJson, `{
+         "sqlbee.connctd.io.inject": "true"
+      }`, data.annotations, 1)
+		ar := mut(&v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Namespace: data.namespace,
+				Resource:  podResource,
+				Object: runtime.RawExtension{
+					Raw: []byte(raw),
+				},
+			},
+		})
+		require.NotNil(t, ar)
+		assert.True(t, ar.Allowed)
+		assert.Equal(t, data.injected, len(ar.Patch) > 0, "%s %s", data.namespace, data.annotations)
+	}
+}
+
+func TestParseNamespaceInstances(t *testing.T) {
+	instances, err := parseNamespaceInstances("team-a=proj:eu:db-a, team-b=proj:eu:db-b\n# comment\nteam-c = proj:eu:db-c\n")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"team-a": "proj:eu:db-a",
+		"team-b": "proj:eu:db-b",
+		"team-c": "proj:eu:db-c",
+	}, instances)
+
+	instances, err = parseNamespaceInstances("")
+	require.NoError(t, err)
+	assert.Empty(t, instances)
+
+	for _, mapping := range []string{
+		"team-a",
+		"Team_A=proj:eu:db-a",
+		"team-a=invalid",
+		"team-a=proj:eu:db-a,team-a=proj:eu:db-b",
+	} {
+		_, err := parseNamespaceInstances(mapping)
+		assert.Error(t, err, mapping)
+	}
+}
+
+func TestMutateWithNamespaceInstances(t *testing.T) {
+	namespace := &corev1.Namespace{}
+	namespace.Name = "team-c"
+	namespace.Annotations = map[string]string{annotationInstance: "proj:eu:db-annotated"}
+	mutate := Mutate(Options{
+		DefaultInstance: "proj:eu:default",
+		NamespaceInstances: map[string]string{
+			"team-a": "proj:eu:db-a",
+			"team-c": "proj:eu:db-c",
+		},
+		Namespaces:        staticNamespaces{"team-c": namespace},
+		NamespaceDefaults: true,
+	})
+
+	for namespace, instance := range map[string]string{
+		"team-a": "proj:eu:db-a",
+		"team-b": "proj:eu:default",
+		"team-c": "proj:eu:db-annotated",
+	} {
+		raw, err := json.Marshal(testPodWithAnnotations(t, map[string]string{annotationInject: "true"}))
+		require.NoError(t, err)
+		ar := mutate(&v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Resource:  podResource,
+				Namespace: namespace,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		require.True(t, ar.Allowed, namespace)
+		assert.Contains(t, string(ar.Patch), "-instances="+instance+"=tcp", namespace)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+)
+
+// Modes in which the proxy can be injected. Native sidecars are init containers with restartPolicy
+// Always, supported since Kubernetes 1.28. They start before the other init containers and don't
+// keep Jobs from completing
+const (
+	modeSidecar     = "sidecar"
+	modeInitSidecar = "init-sidecar"
+	// selects the mode based on the version of the cluster at startup
+	modeAuto = "auto"
+
+	restartPolicyAlways = "Always"
+
+	// Native sidecars are enabled by default since this version
+	nativeSidecarMajor = 1
+	nativeSidecarMinor = 29
+)
+
+// versionGetter retrieves the version of the cluster
+type versionGetter interface {
+	ServerVersion(ctx context.Context) (*kube.Version, error)
+}
+
+// detectMode chooses native sidecars if the cluster supports them by default
+func detectMode(ctx context.Context, getter versionGetter) (string, error) {
+	version, err := getter.ServerVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	native, err := version.AtLeast(nativeSidecarMajor, nativeSidecarMinor)
+	if err != nil {
+		return "", err
+	}
+	if native {
+		return modeInitSidecar, nil
+	}
+	return modeSidecar, nil
+}
+
+// injectionMode determines whether the proxy is injected as classic or native sidecar
+func injectionMode(obj runtime.Object, opts Options) (string, error) {
+	mode := opts.Mode
+	if mode == "" {
+		mode = modeSidecar
+	}
+	switch mode := annotationValue(obj, annotationMode, mode); mode {
+	case modeSidecar, modeInitSidecar:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("Invalid value of annotation %s: unknown mode %q", annotationMode, mode)
+	}
+}
+
+// removeContainer removes the container with the given name from the list. The removed container is
+// returned as well, if it existed
+func removeContainer(containers []corev1.Container, name string) ([]corev1.Container, *corev1.Container) {
+	for i, container := range containers {
+		if container.Name == name {
+			return append(containers[:i], containers[i+1:]...), &container
+		}
+	}
+	return containers, nil
+}
+
+// mergeContainer merges the proxy onto the container with the same name in place. Returns whether
+// such a container exists
+func mergeContainer(containers []corev1.Container, proxyContainer *corev1.Container) bool {
+	for i := range containers {
+		if containers[i].Name == proxyContainer.Name {
+			containers[i] = mergeProxyContainer(&containers[i], proxyContainer)
+			return true
+		}
+	}
+	return false
+}
+
+// mutates a corev1.PodSpec to contain a cloud sql proxy as native sidecar. The proxy is added after
+// the native sidecars injected before, so it is started before any other init container. An existing
+// native sidecar is updated in place
+func mutatePodSpecNative(volumes []corev1.Volume, proxyContainer *corev1.Container, podSpec *corev1.PodSpec) {
+	containers, existing := removeContainer(podSpec.Containers, proxyContainer.Name)
+	podSpec.Containers = containers
+	merged := mergeProxyContainer(existing, proxyContainer)
+	if mergeContainer(podSpec.InitContainers, &merged) {
+		replaceVolumes(volumes, podSpec)
+		return
+	}
+
+	i := 0
+	for i < len(podSpec.InitContainers) && isProxyCommand(podSpec.InitContainers[i]) {
+		i++
+	}
+	initContainers := append([]corev1.Container{}, podSpec.InitContainers[:i]...)
+	initContainers = append(initContainers, merged)
+	podSpec.InitContainers = append(initContainers, podSpec.InitContainers[i:]...)
+
+	replaceVolumes(volumes, podSpec)
+}
+
+// containerFields are the JSON fields of containers known to our API types
+var containerFields = func() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(corev1.Container{})
+	for i := 0; i < t.NumField(); i++ {
+		fields[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+	return fields
+}()
+
+// setContainerFields adjusts the serialized mutated object for fields of containers our API types don't
+// know about. Unknown fields of the original containers, like the restartPolicy of native sidecars
+// of other tools, are preserved. The proxies injected as init containers are marked as native sidecars
+// and receive startup probes if their health checks are enabled. The fields are set on all proxies
+// unless they are already set
+func setContainerFields(mutated, raw []byte, path []string, fields map[string]interface{}) ([]byte, error) {
+	if path == nil {
+		return mutated, nil
+	}
+	mutatedObj, err := decodeJSONObject(mutated)
+	if err != nil {
+		return nil, err
+	}
+	rawObj, err := decodeJSONObject(raw)
+	if err != nil {
+		return nil, err
+	}
+	mutatedSpec, rawSpec := nestedObject(mutatedObj, path), nestedObject(rawObj, path)
+	if mutatedSpec == nil {
+		return mutated, nil
+	}
+
+	for _, field := range []string{"initContainers", "containers"} {
+		original := map[string]map[string]interface{}{}
+		if rawSpec != nil {
+			for _, container := range objectList(rawSpec[field]) {
+				if name, ok := container["name"].(string); ok {
+					original[name] = container
+				}
+			}
+		}
+		for _, container := range objectList(mutatedSpec[field]) {
+			name, _ := container["name"].(string)
+			for key, value := range original[name] {
+				if _, exists := container[key]; !exists && !containerFields[key] {
+					container[key] = value
+				}
+			}
+			if !isProxyCommandJSON(container) {
+				continue
+			}
+			if field == "initContainers" {
+				container["restartPolicy"] = restartPolicyAlways
+			}
+			setStartupProbe(container)
+			mergeFields(container, fields)
+		}
+	}
+	return json.Marshal(mutatedObj)
+}
+
+// decodeJSONObject decodes a JSON object, keeping numbers as they are
+func decodeJSONObject(buf []byte) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// nestedObject returns the object at the path or nil if it doesn't exist
+func nestedObject(obj map[string]interface{}, path []string) map[string]interface{} {
+	for _, field := range path {
+		next, ok := obj[field].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		obj = next
+	}
+	return obj
+}
+
+// objectList returns the objects of a JSON array, skipping other values
+func objectList(value interface{}) []map[string]interface{} {
+	objects := []map[string]interface{}{}
+	list, _ := value.([]interface{})
+	for _, item := range list {
+		if obj, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+// isProxyCommand checks whether the container runs the cloud sql proxy of either version
+func isProxyCommand(container corev1.Container) bool {
+	return len(container.Command) > 0 && isProxyBinary(container.Command[0])
+}
+
+// isProxyCommandJSON checks whether the serialized container runs the cloud sql proxy of either version
+func isProxyCommandJSON(container map[string]interface{}) bool {
+	command, _ := container["command"].([]interface{})
+	if len(command) == 0 {
+		return false
+	}
+	binary, _ := command[0].(string)
+	return isProxyBinary(binary)
+}
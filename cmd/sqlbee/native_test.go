@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+)
+
+func TestInjectionMode(t *testing.T) {
+	for annotations, expected := range map[string]string{
+		"":             modeSidecar,
+		"sidecar":      modeSidecar,
+		"init-sidecar": modeInitSidecar,
+	} {
+		pod := testPodWithAnnotations(t, nil)
+		if annotations != "" {
+			pod.Annotations[annotationMode] = annotations
+		}
+		mode, err := injectionMode(pod, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, expected, mode)
+	}
+
+	mode, err := injectionMode(testPodWithAnnotations(t, nil), Options{Mode: modeInitSidecar})
+	require.NoError(t, err)
+	assert.Equal(t, modeInitSidecar, mode)
+	mode, err = injectionMode(testPodWithAnnotations(t, map[string]string{annotationMode: modeSidecar}), Options{Mode: modeInitSidecar})
+	require.NoError(t, err)
+	assert.Equal(t, modeSidecar, mode)
+
+	_, err = injectionMode(testPodWithAnnotations(t, map[string]string{annotationMode: "native"}), Options{})
+	assert.Error(t, err)
+}
+
+type staticVersion kube.Version
+
+func (s staticVersion) ServerVersion(ctx context.Context) (*kube.Version, error) {
+	version := kube.Version(s)
+	return &version, nil
+}
+
+func TestDetectMode(t *testing.T) {
+	for version, expected := range map[staticVersion]string{
+		{Major: "1", Minor: "27"}:  modeSidecar,
+		{Major: "1", Minor: "28+"}: modeSidecar,
+		{Major: "1", Minor: "29"}:  modeInitSidecar,
+		{Major: "1", Minor: "31+"}: modeInitSidecar,
+	} {
+		mode, err := detectMode(context.Background(), version)
+		require.NoError(t, err)
+		assert.Equal(t, expected, mode, version)
+	}
+
+	_, err := detectMode(context.Background(), staticVersion{Major: "one"})
+	assert.Error(t, err)
+}
+
+func TestDetectPreStopHook(t *testing.T) {
+	for version, expected := range map[staticVersion]string{
+		{Major: "1", Minor: "29"}:  preStopHookExec,
+		{Major: "1", Minor: "30+"}: preStopHookSleep,
+	} {
+		hook, err := detectPreStopHook(context.Background(), version)
+		require.NoError(t, err)
+		assert.Equal(t, expected, hook, version)
+	}
+
+	_, err := detectPreStopHook(context.Background(), staticVersion{Minor: "one"})
+	assert.Error(t, err)
+}
+
+func TestMutatePodSpecNative(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationMode:                    modeInitSidecar,
+		annotationInstance:                "proj:eu:default",
+		annotationInstance + ".reporting": "proj:eu:reporting",
+	})
+	pod.Spec.InitContainers = []corev1.Container{{Name: "migrate", Image: "migrate"}}
+	original := pod.Spec.DeepCopy()
+
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{}))
+	mutatePodSpecNative(volumes, proxyContainer, &pod.Spec)
+	require.NoError(t, configureNamedInstances(context.Background(), pod, proxyContainer.Name, 3306, &pod.Spec, Options{}))
+
+	names := []string{}
+	for _, container := range pod.Spec.InitContainers {
+		names = append(names, container.Name)
+	}
+	assert.Equal(t, []string{"cloud-sql-proxy", "cloud-sql-proxy-reporting", "migrate"}, names)
+	assert.Equal(t, original.Containers, pod.Spec.Containers)
+	assert.True(t, hasProxyContainer(&pod.Spec, proxyContainer.Name))
+
+	// Switching the mode moves the proxy
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	assert.Len(t, pod.Spec.InitContainers, 2)
+	assert.Len(t, pod.Spec.Containers, len(original.Containers)+1)
+
+	assert.True(t, removeProxies(&pod.Spec, proxyContainer.Name, defaultSocketVolume))
+	assert.Equal(t, original.InitContainers, pod.Spec.InitContainers)
+	assert.Equal(t, original.Containers, pod.Spec.Containers)
+	assert.Equal(t, original.Volumes, pod.Spec.Volumes)
+}
+
+func TestSetContainerFields(t *testing.T) {
+	raw := []byte(`{"spec":{"initContainers":[{"name":"mesh","image":"mesh","restartPolicy":"Always"}],"containers":[{"name":"app","resizePolicy":[{"resourceName":"cpu"}],"env":[{"name":"A","value":"a"}]}]}}`)
+	mutated := []byte(`{"spec":{"initContainers":[{"name":"cloud-sql-proxy","command":["/cloud_sql_proxy"]},{"name":"mesh","image":"mesh"}],"containers":[{"name":"app","ports":[{"containerPort":8080}]}]}}`)
+
+	result, err := setContainerFields(mutated, raw, []string{"spec"}, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"spec":{
+		"initContainers":[
+			{"name":"cloud-sql-proxy","command":["/cloud_sql_proxy"],"restartPolicy":"Always"},
+			{"name":"mesh","image":"mesh","restartPolicy":"Always"}
+		],
+		"containers":[{"name":"app","resizePolicy":[{"resourceName":"cpu"}],"ports":[{"containerPort":8080}]}]
+	}}`, string(result))
+
+	result, err = setContainerFields(mutated, raw, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, mutated, result)
+}
+
+func TestMutateNativeSidecar(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationInstance: "proj:eu:db",
+		annotationMode:     modeInitSidecar,
+	})
+	ar := reviewPod(t, Options{}, "", pod)
+	require.True(t, ar.Allowed)
+
+	patches := []struct {
+		Op    string
+		Path  string
+		Value json.RawMessage
+	}{}
+	require.NoError(t, json.Unmarshal(ar.Patch, &patches))
+	found := false
+	for _, patch := range patches {
+		if patch.Path == "/spec/initContainers" {
+			found = true
+			assert.Contains(t, string(patch.Value), `"name":"cloud-sql-proxy"`)
+			assert.Contains(t, string(patch.Value), `"restartPolicy":"Always"`)
+		}
+		if strings.HasPrefix(patch.Path, "/spec/containers") {
+			assert.NotContains(t, string(patch.Value), "cloud_sql_proxy", patch.Path)
+		}
+	}
+	assert.True(t, found, string(ar.Patch))
+
+	pod.Annotations[annotationMode] = "native"
+	ar = reviewPod(t, Options{}, "", pod)
+	assert.False(t, ar.Allowed)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// applyContainerOverride merges a partial container spec in JSON or YAML onto the container. Fields
+// present in the override replace the generated ones, objects are merged recursively. Like a strategic
+// merge patch environment variables, volume mounts and ports are merged by their name, mount path or
+// port instead of replacing the whole list
+func applyContainerOverride(container *corev1.Container, override string) error {
+	raw, err := yaml.YAMLToJSON([]byte(override))
+	if err != nil {
+		return err
+	}
+
+	// Validate the override strictly so typos don't go unnoticed
+	patch := corev1.Container{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		return err
+	}
+
+	env := mergeEnv(container.Env, patch.Env)
+	mounts := mergeVolumeMounts(container.VolumeMounts, patch.VolumeMounts)
+	ports := mergePorts(container.Ports, patch.Ports)
+	if err := json.Unmarshal(raw, container); err != nil {
+		return err
+	}
+	container.Env = env
+	container.VolumeMounts = mounts
+	container.Ports = ports
+	return nil
+}
+
+// The environment variables of the proxy owned by SQLBee. Existing ones are replaced on re-injection, so
+// changed annotations take effect even if the variable isn't generated anymore
+var proxyEnvNames = map[string]bool{
+	"HTTPS_PROXY":  true,
+	"NO_PROXY":     true,
+	credentialsEnv: true,
+}
+
+// mergeProxyContainer merges the generated proxy container onto an existing container with the same
+// name. The parts SQLBee owns like the image, command, resources, its environment variables and volume
+// mounts are updated, while the resources, environment variables and mounts added by users are preserved.
+// Without an existing container the proxy is used as it is
+func mergeProxyContainer(existing, proxyContainer *corev1.Container) corev1.Container {
+	merged := *proxyContainer.DeepCopy()
+	if existing == nil {
+		return merged
+	}
+	merged.Resources.Requests = mergeResources(existing.Resources.Requests, proxyContainer.Resources.Requests)
+	merged.Resources.Limits = mergeResources(existing.Resources.Limits, proxyContainer.Resources.Limits)
+	env := []corev1.EnvVar{}
+	for _, e := range existing.Env {
+		if !proxyEnvNames[e.Name] {
+			env = append(env, e)
+		}
+	}
+	merged.Env = mergeEnv(env, proxyContainer.Env)
+	merged.VolumeMounts = mergeVolumeMounts(existing.VolumeMounts, proxyContainer.VolumeMounts)
+	// The listen port might have changed, the proxy declares only one
+	ports := []corev1.ContainerPort{}
+	for _, port := range existing.Ports {
+		if port.Name != proxyPortName {
+			ports = append(ports, port)
+		}
+	}
+	merged.Ports = mergePorts(ports, proxyContainer.Ports)
+	return merged
+}
+
+// mergeResources replaces the existing quantities with the patched ones of the same resource and keeps
+// the remaining ones
+func mergeResources(resources, patch corev1.ResourceList) corev1.ResourceList {
+	if len(resources) == 0 {
+		return patch
+	}
+	merged := resources.DeepCopy()
+	for name, quantity := range patch {
+		merged[name] = quantity
+	}
+	return merged
+}
+
+// mergeEnv replaces existing environment variables with the patched ones of the same name and appends
+// the remaining ones
+func mergeEnv(envs, patch []corev1.EnvVar) []corev1.EnvVar {
+	merged := append([]corev1.EnvVar{}, envs...)
+outer:
+	for _, p := range patch {
+		for i := range merged {
+			if merged[i].Name == p.Name {
+				merged[i] = p
+				continue outer
+			}
+		}
+		merged = append(merged, p)
+	}
+	return merged
+}
+
+// mergeVolumeMounts replaces existing volume mounts with the patched ones of the same mount path and
+// appends the remaining ones
+func mergeVolumeMounts(mounts, patch []corev1.VolumeMount) []corev1.VolumeMount {
+	merged := append([]corev1.VolumeMount{}, mounts...)
+outer:
+	for _, p := range patch {
+		for i := range merged {
+			if merged[i].MountPath == p.MountPath {
+				merged[i] = p
+				continue outer
+			}
+		}
+		merged = append(merged, p)
+	}
+	return merged
+}
+
+// mergePorts replaces existing ports with the patched ones of the same container port and appends
+// the remaining ones
+func mergePorts(ports, patch []corev1.ContainerPort) []corev1.ContainerPort {
+	merged := append([]corev1.ContainerPort{}, ports...)
+outer:
+	for _, p := range patch {
+		for i := range merged {
+			if merged[i].ContainerPort == p.ContainerPort {
+				merged[i] = p
+				continue outer
+			}
+		}
+		merged = append(merged, p)
+	}
+	return merged
+}
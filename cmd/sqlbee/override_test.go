@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverrideAnnotation(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationQuit:  "true",
+		annotationImage: testImageV2,
+		annotationOverride: `
+env:
+- name: FOO
+  value: bar
+resources:
+  limits:
+    memory: 64Mi
+readinessProbe:
+  tcpSocket:
+    port: 3306
+volumeMounts:
+- name: other-cloudsql
+  mountPath: /cloudsql
+`,
+	})
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	proxyContainer.Env = []corev1.EnvVar{{Name: "FOO", Value: "foo"}, {Name: "BAZ", Value: "baz"}}
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{}))
+
+	assert.Equal(t, []corev1.EnvVar{{Name: "FOO", Value: "bar"}, {Name: "BAZ", Value: "baz"}}, proxyContainer.Env)
+	assert.Equal(t, "64Mi", proxyContainer.Resources.Limits.Memory().String())
+	assert.Equal(t, "10m", proxyContainer.Resources.Requests.Cpu().String())
+	require.NotNil(t, proxyContainer.ReadinessProbe)
+	assert.Equal(t, 3306, proxyContainer.ReadinessProbe.TCPSocket.Port.IntValue())
+	require.Len(t, proxyContainer.VolumeMounts, 1)
+	assert.Equal(t, "other-cloudsql", proxyContainer.VolumeMounts[0].Name)
+	assert.Contains(t, proxyContainer.Command, "--quitquitquit")
+
+	for _, override := range []string{`{"unknownField": true}`, `env: {`} {
+		pod := testPodWithAnnotations(t, map[string]string{annotationOverride: override})
+		proxyContainer := sqlProxyContainer.DeepCopy()
+		volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+		assert.Error(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{}), override)
+	}
+}
+
+func TestMergeExistingProxyContainer(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{annotationHTTPSProxy: "http://proxy:3128"})
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:    "cloud-sql-proxy",
+		Image:   "gcr.io/cloudsql-docker/gce-proxy:1.11",
+		Command: []string{"/cloud_sql_proxy"},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:              resource.MustParse("250m"),
+				corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+			},
+		},
+		Env: []corev1.EnvVar{
+			{Name: "GODEBUG", Value: "netdns=go"},
+			{Name: "HTTPS_PROXY", Value: "http://old-proxy:3128"},
+		},
+		VolumeMounts: []corev1.VolumeMount{{Name: "tmp", MountPath: "/tmp"}},
+	})
+
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{DefaultInstance: "proj:eu:db"}))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+
+	merged := containerByName(&pod.Spec, "cloud-sql-proxy")
+	require.NotNil(t, merged)
+	assert.Equal(t, proxyContainer.Image, merged.Image)
+	assert.Equal(t, proxyContainer.Command, merged.Command)
+	assert.Equal(t, corev1.ResourceList{
+		corev1.ResourceCPU:              resource.MustParse(defaultCPURequest),
+		corev1.ResourceMemory:           resource.MustParse(defaultMemRequest),
+		corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+	}, merged.Resources.Requests)
+	assert.Contains(t, merged.Env, corev1.EnvVar{Name: "GODEBUG", Value: "netdns=go"})
+	assert.Contains(t, merged.Env, corev1.EnvVar{Name: "HTTPS_PROXY", Value: "http://proxy:3128"})
+	assert.Contains(t, merged.VolumeMounts, corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"})
+	for _, mount := range proxyContainer.VolumeMounts {
+		assert.Contains(t, merged.VolumeMounts, mount)
+	}
+}
+
+func TestReinjectChangedAnnotations(t *testing.T) {
+	opts := Options{DefaultInstance: "proj:eu:db"}
+	pod, _ := mutatePod(t, opts, map[string]string{
+		annotationHTTPSProxy: "http://proxy:3128",
+		annotationCPURequest: "50m",
+	})
+	proxy := containerByName(&pod.Spec, "cloud-sql-proxy")
+	proxy.Env = append(proxy.Env, corev1.EnvVar{Name: "GODEBUG", Value: "netdns=go"})
+
+	// The annotations changed since the proxy has been injected
+	pod.Annotations = map[string]string{annotationCPURequest: "100m", annotationInstance: "proj:eu:other"}
+	proxyContainer, volumes, err := configureProxy(pod, opts)
+	require.NoError(t, err)
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+
+	merged := containerByName(&pod.Spec, "cloud-sql-proxy")
+	require.NotNil(t, merged)
+	assert.Equal(t, proxyContainer.Command, merged.Command)
+	assert.Equal(t, resource.MustParse("100m"), merged.Resources.Requests[corev1.ResourceCPU])
+	assert.Equal(t, []corev1.EnvVar{{Name: "GODEBUG", Value: "netdns=go"}}, merged.Env)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Placements of the proxy within the containers of a pod. By default the proxy is appended, it can be
+// inserted as first container or before a named container like before:app instead
+const (
+	placementFirst  = "first"
+	placementLast   = "last"
+	placementBefore = "before:"
+)
+
+// validatePlacement checks whether the placement of the proxy is supported
+func validatePlacement(placement string) error {
+	switch {
+	case placement == "", placement == placementFirst, placement == placementLast:
+		return nil
+	case strings.HasPrefix(placement, placementBefore) && len(placement) > len(placementBefore):
+		return nil
+	}
+	return fmt.Errorf("Invalid placement %q, expected %s, %s or %s<container>", placement, placementFirst, placementLast, placementBefore)
+}
+
+// proxyPlacement determines where the proxy is placed within the containers of a pod. If the application
+// containers wait for the proxy, it is placed before them
+func proxyPlacement(obj runtime.Object, opts Options) (string, error) {
+	placement := annotationValue(obj, annotationPlacement, opts.Placement)
+	if err := validatePlacement(placement); err != nil {
+		return "", fmt.Errorf("Invalid value of annotation %s: %s", annotationPlacement, err)
+	}
+	if (placement == "" || placement == placementLast) && isWaitForProxy(obj, opts) {
+		return placementFirst, nil
+	}
+	return placement, nil
+}
+
+// placeContainer moves the container with the given name to its placement. Placed first it follows
+// the other proxies at the beginning. A container which is already placed correctly keeps its position,
+// so re-injecting an object causes no changes. If the container to be placed before doesn't exist, the
+// container is left where it is
+func placeContainer(containers []corev1.Container, name, placement string) []corev1.Container {
+	index := -1
+	for i := range containers {
+		if containers[i].Name == name {
+			index = i
+		}
+	}
+	if index < 0 {
+		return containers
+	}
+
+	target := index
+	switch {
+	case placement == placementFirst:
+		target = 0
+		for target < len(containers) && (target == index || isProxyCommand(containers[target])) {
+			target++
+		}
+		if target > index {
+			return containers
+		}
+	case strings.HasPrefix(placement, placementBefore):
+		target = -1
+		for i := range containers {
+			if containers[i].Name == strings.TrimPrefix(placement, placementBefore) {
+				target = i
+			}
+		}
+		if target < 0 || index < target {
+			return containers
+		}
+	default:
+		return containers
+	}
+
+	container := containers[index]
+	placed := append([]corev1.Container{}, containers[:index]...)
+	placed = append(placed, containers[index+1:]...)
+	placed = append(placed[:target], append([]corev1.Container{container}, placed[target:]...)...)
+	return placed
+}
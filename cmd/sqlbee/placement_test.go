@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func containerNames(containers []corev1.Container) []string {
+	names := []string{}
+	for _, container := range containers {
+		names = append(names, container.Name)
+	}
+	return names
+}
+
+func TestValidatePlacement(t *testing.T) {
+	for placement, valid := range map[string]bool{
+		"":           true,
+		"first":      true,
+		"last":       true,
+		"before:app": true,
+		"before:":    false,
+		"middle":     false,
+	} {
+		assert.Equal(t, valid, validatePlacement(placement) == nil, placement)
+	}
+
+	_, err := proxyPlacement(testPodWithAnnotations(t, map[string]string{annotationPlacement: "middle"}), Options{})
+	assert.Error(t, err)
+}
+
+func TestPlaceContainer(t *testing.T) {
+	proxy := corev1.Container{Name: "proxy", Command: []string{"/cloud_sql_proxy"}}
+	other := corev1.Container{Name: "other-proxy", Command: []string{"/cloud_sql_proxy"}}
+	app := corev1.Container{Name: "app"}
+	logger := corev1.Container{Name: "logger"}
+
+	for _, data := range []struct {
+		containers []corev1.Container
+		placement  string
+		expected   []string
+	}{
+		{[]corev1.Container{app, logger, proxy}, "", []string{"app", "logger", "proxy"}},
+		{[]corev1.Container{app, logger, proxy}, "last", []string{"app", "logger", "proxy"}},
+		{[]corev1.Container{app, logger, proxy}, "first", []string{"proxy", "app", "logger"}},
+		{[]corev1.Container{other, app, proxy}, "first", []string{"other-proxy", "proxy", "app"}},
+		{[]corev1.Container{other, proxy, app}, "first", []string{"other-proxy", "proxy", "app"}},
+		{[]corev1.Container{app, logger, proxy}, "before:logger", []string{"app", "proxy", "logger"}},
+		{[]corev1.Container{proxy, app, logger}, "before:logger", []string{"proxy", "app", "logger"}},
+		{[]corev1.Container{app, logger, proxy}, "before:missing", []string{"app", "logger", "proxy"}},
+	} {
+		placed := placeContainer(data.containers, "proxy", data.placement)
+		assert.Equal(t, data.expected, containerNames(placed), "%s %v", data.placement, containerNames(data.containers))
+	}
+}
+
+func TestPlacementAnnotation(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationPlacement:               "first",
+		annotationInstance + ".reporting": "proj:eu:reporting",
+	})
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	opts := Options{DefaultInstance: "proj:eu:db", Placement: placementLast}
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, opts))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	placement, err := proxyPlacement(pod, opts)
+	require.NoError(t, err)
+	pod.Spec.Containers = placeContainer(pod.Spec.Containers, proxyContainer.Name, placement)
+	require.NoError(t, configureNamedInstances(context.Background(), pod, proxyContainer.Name, 3306, &pod.Spec, opts))
+
+	assert.Equal(t, []string{"cloud-sql-proxy", "cloud-sql-proxy-reporting", "wordpress"}, containerNames(pod.Spec.Containers))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The label of namespaces which enforces a pod security standard
+const (
+	labelPodSecurityEnforce = "pod-security.kubernetes.io/enforce"
+	podSecurityRestricted   = "restricted"
+)
+
+// enforcesRestricted checks whether the namespace enforces the restricted pod security standard
+func enforcesRestricted(namespace *corev1.Namespace) bool {
+	return namespace != nil && namespace.Labels[labelPodSecurityEnforce] == podSecurityRestricted
+}
+
+// isRestrictedVolume checks whether the type of the volume is allowed by the restricted pod
+// security standard. Inline CSI and ephemeral volumes are allowed as well, but unknown to our API types
+func isRestrictedVolume(volume corev1.Volume) bool {
+	source := volume.VolumeSource
+	if source == (corev1.VolumeSource{}) {
+		// Inline CSI and ephemeral volumes decode without a known source
+		return true
+	}
+	return source.ConfigMap != nil || source.DownwardAPI != nil || source.EmptyDir != nil ||
+		source.PersistentVolumeClaim != nil || source.Projected != nil || source.Secret != nil
+}
+
+// validateRestricted checks whether the proxies injected into the podSpec and their volumes comply with
+// the restricted pod security standard. Seccomp profiles are only known to the fields set on the
+// serialized proxies
+func validateRestricted(podSpec *corev1.PodSpec, proxyName string, fields map[string]interface{}) error {
+	podContext := podSpec.SecurityContext
+	if podContext == nil {
+		podContext = &corev1.PodSecurityContext{}
+	}
+	seccomp := nestedObject(fields, []string{"securityContext", "seccompProfile"})
+
+	volumes := map[string]corev1.Volume{}
+	for _, volume := range podSpec.Volumes {
+		volumes[volume.Name] = volume
+	}
+
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range containers {
+			if !isProxyContainer(container, proxyName) {
+				continue
+			}
+			ctx := container.SecurityContext
+			if ctx == nil {
+				ctx = &corev1.SecurityContext{}
+			}
+			switch {
+			case ctx.Privileged != nil && *ctx.Privileged:
+				return fmt.Errorf("Container %s must not be privileged", container.Name)
+			case ctx.AllowPrivilegeEscalation == nil || *ctx.AllowPrivilegeEscalation:
+				return fmt.Errorf("Container %s must not allow privilege escalation", container.Name)
+			case ctx.Capabilities == nil || !dropsAllCapabilities(ctx.Capabilities):
+				return fmt.Errorf("Container %s must drop all capabilities", container.Name)
+			case !addsOnlyNetBindService(ctx.Capabilities):
+				return fmt.Errorf("Container %s may only add the capability NET_BIND_SERVICE", container.Name)
+			case !isTrue(ctx.RunAsNonRoot) && (ctx.RunAsNonRoot != nil || !isTrue(podContext.RunAsNonRoot)):
+				return fmt.Errorf("Container %s must run as non-root", container.Name)
+			case isZero(ctx.RunAsUser) || (ctx.RunAsUser == nil && isZero(podContext.RunAsUser)):
+				return fmt.Errorf("Container %s must not run as root user", container.Name)
+			case seccomp == nil || (seccomp["type"] != "RuntimeDefault" && seccomp["type"] != "Localhost"):
+				return fmt.Errorf("Container %s requires the seccomp profile RuntimeDefault or Localhost", container.Name)
+			}
+			for _, port := range container.Ports {
+				if port.HostPort != 0 {
+					return fmt.Errorf("Container %s must not use host ports", container.Name)
+				}
+			}
+			for _, mount := range container.VolumeMounts {
+				if volume, exists := volumes[mount.Name]; exists && !isRestrictedVolume(volume) {
+					return fmt.Errorf("Volume %s of container %s is not allowed", mount.Name, container.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// dropsAllCapabilities checks whether the capabilities drop ALL
+func dropsAllCapabilities(capabilities *corev1.Capabilities) bool {
+	for _, capability := range capabilities.Drop {
+		if capability == "ALL" {
+			return true
+		}
+	}
+	return false
+}
+
+// addsOnlyNetBindService checks whether the capabilities add no other capability than NET_BIND_SERVICE
+func addsOnlyNetBindService(capabilities *corev1.Capabilities) bool {
+	for _, capability := range capabilities.Add {
+		if capability != "NET_BIND_SERVICE" {
+			return false
+		}
+	}
+	return true
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
+func isZero(i *int64) bool {
+	return i != nil && *i == 0
+}
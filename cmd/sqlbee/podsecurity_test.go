@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func restrictedPodSpec(t *testing.T, annotations map[string]string, opts Options) (*corev1.PodSpec, map[string]interface{}) {
+	pod, _ := mutatePod(t, opts, annotations)
+	fields, err := proxyFields(pod, opts)
+	require.NoError(t, err)
+	return &pod.Spec, fields
+}
+
+func TestValidateRestricted(t *testing.T) {
+	opts := Options{DefaultInstance: "proj:eu:db", PodSecurityRestricted: true, DisableHardening: true}
+	podSpec, fields := restrictedPodSpec(t, nil, opts)
+	assert.NoError(t, validateRestricted(podSpec, "cloud-sql-proxy", fields))
+	assert.Error(t, validateRestricted(podSpec, "cloud-sql-proxy", nil))
+
+	for _, annotations := range []map[string]string{
+		{annotationFuse: "true"},
+		{annotationSecCtx: `{"runAsUser": 0}`},
+		{annotationSecCtx: `{"capabilities": {"add": ["NET_ADMIN"], "drop": ["ALL"]}}`},
+		{annotationOverride: `{"ports": [{"containerPort": 3306, "hostPort": 3306}]}`},
+	} {
+		podSpec, fields := restrictedPodSpec(t, annotations, opts)
+		assert.Error(t, validateRestricted(podSpec, "cloud-sql-proxy", fields), "%v", annotations)
+	}
+
+	// Volumes of the proxy need to be of an allowed type
+	podSpec, fields = restrictedPodSpec(t, nil, opts)
+	podSpec.Volumes[len(podSpec.Volumes)-1].VolumeSource = corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/credentials"}}
+	assert.Error(t, validateRestricted(podSpec, "cloud-sql-proxy", fields))
+}
+
+func TestMutateInRestrictedNamespace(t *testing.T) {
+	restricted := &corev1.Namespace{}
+	restricted.Labels = map[string]string{labelPodSecurityEnforce: podSecurityRestricted}
+	mut := Mutate(Options{
+		DefaultInstance:       "proj:eu:db",
+		PodSecurityRestricted: true,
+		Namespaces:            staticNamespaces{"restricted": restricted},
+	})
+
+	for _, data := range []struct {
+		namespace   string
+		annotations string
+		allowed     bool
+	}{
+		{namespace: "restricted", annotations: `{"sqlbee.connctd.io.inject": "true"}`, allowed: true},
+		{namespace: "restricted", annotations: `{"sqlbee.connctd.io.inject": "true", "sqlbee.connctd.io.fuse": "true"}`, allowed: false},
+		{namespace: "other", annotations: `{"sqlbee.connctd.io.inject": "true", "sqlbee.connctd.io.fuse": "true"}`, allowed: true},
+	} {
+		raw := strings.Replace(podJson, `{
+         "sqlbee.connctd.io.inject": "true"
+      }`, data.annotations, 1)
+		ar := mut(&v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Namespace: data.namespace,
+				Resource:  podResource,
+				Object: runtime.RawExtension{
+					Raw: []byte(raw),
+				},
+			},
+		})
+		require.NotNil(t, ar)
+		assert.Equal(t, data.allowed, ar.Allowed, "%s %s", data.namespace, data.annotations)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+// usesKubernetes determines whether the mutation retrieves objects from the API server, e.g.
+// namespaces, service accounts or secrets
+func usesKubernetes(opts Options) bool {
+	return opts.Namespaces != nil || opts.SecretManager != nil || opts.Replicator != nil ||
+		opts.ServiceAccounts != nil || opts.Objects != nil
+}
+
+// kubernetesHealthCheck checks whether the API server is reachable, so SQLBee isn't ready as long as
+// mutations relying on it would fail
+func kubernetesHealthCheck(getter versionGetter) sting.HealthCheck {
+	return sting.HealthCheck{
+		Name: "kubernetes",
+		Check: func(ctx context.Context) error {
+			if _, err := getter.ServerVersion(ctx); err != nil {
+				return fmt.Errorf("Kubernetes API unreachable: %s", err)
+			}
+			return nil
+		},
+	}
+}
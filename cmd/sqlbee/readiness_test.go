@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+)
+
+// unreachableVersion fails like a client whose API server can't be reached
+type unreachableVersion struct{}
+
+func (unreachableVersion) ServerVersion(ctx context.Context) (*kube.Version, error) {
+	return nil, errors.New("dial tcp 10.0.0.1:443: connect: connection refused")
+}
+
+func TestUsesKubernetes(t *testing.T) {
+	assert.False(t, usesKubernetes(Options{}))
+	assert.True(t, usesKubernetes(Options{Namespaces: staticNamespaces{}}))
+}
+
+func TestKubernetesHealthCheck(t *testing.T) {
+	check := kubernetesHealthCheck(staticVersion{Major: "1", Minor: "29"})
+	assert.Equal(t, "kubernetes", check.Name)
+	assert.NoError(t, check.Check(context.Background()))
+
+	err := kubernetesHealthCheck(unreachableVersion{}).Check(context.Background())
+	assert.EqualError(t, err, "Kubernetes API unreachable: dial tcp 10.0.0.1:443: connect: connection refused")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+)
+
+// Whether injections referencing secrets or config maps which don't exist are only logged or refused
+const (
+	verifyWarn = "warn"
+	verifyDeny = "deny"
+)
+
+// validateVerifyReferences checks whether the verification mode of the references is supported
+func validateVerifyReferences(mode string) error {
+	switch mode {
+	case "", verifyWarn, verifyDeny:
+		return nil
+	}
+	return fmt.Errorf("Invalid verification mode %q, expected %s or %s", mode, verifyWarn, verifyDeny)
+}
+
+// objectReference is a secret or config map referenced by a proxy
+type objectReference struct {
+	resource string
+	name     string
+	optional bool
+}
+
+// proxyReferences returns the secrets and config maps the proxies depend on, referenced by their
+// volumes or environment variables. An object referenced both optionally and required is required
+func proxyReferences(podSpec *corev1.PodSpec, proxyName string) []objectReference {
+	references := []objectReference{}
+	add := func(resource, name string, optional *bool) {
+		if name == "" {
+			return
+		}
+		for i := range references {
+			if references[i].resource == resource && references[i].name == name {
+				references[i].optional = references[i].optional && isTrue(optional)
+				return
+			}
+		}
+		references = append(references, objectReference{resource: resource, name: name, optional: isTrue(optional)})
+	}
+
+	volumes := map[string]corev1.Volume{}
+	for _, volume := range podSpec.Volumes {
+		volumes[volume.Name] = volume
+	}
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range containers {
+			if !isProxyContainer(container, proxyName) {
+				continue
+			}
+			for _, mount := range container.VolumeMounts {
+				volume := volumes[mount.Name]
+				if volume.Secret != nil {
+					add("secrets", volume.Secret.SecretName, volume.Secret.Optional)
+				}
+				if volume.ConfigMap != nil {
+					add("configmaps", volume.ConfigMap.Name, volume.ConfigMap.Optional)
+				}
+				if volume.Projected == nil {
+					continue
+				}
+				for _, source := range volume.Projected.Sources {
+					if source.Secret != nil {
+						add("secrets", source.Secret.Name, source.Secret.Optional)
+					}
+					if source.ConfigMap != nil {
+						add("configmaps", source.ConfigMap.Name, source.ConfigMap.Optional)
+					}
+				}
+			}
+			for _, env := range container.Env {
+				if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+					add("secrets", env.ValueFrom.SecretKeyRef.Name, env.ValueFrom.SecretKeyRef.Optional)
+				}
+			}
+		}
+	}
+	return references
+}
+
+// missingReferences checks whether the secrets and config maps the proxies depend on exist in the
+// namespace. Returns a description of every missing required and every missing optional reference,
+// telling how to resolve it. The proxy is started without missing optional references
+func missingReferences(ctx context.Context, checker kube.ObjectChecker, namespace string, references []objectReference) ([]string, []string, error) {
+	missing := []string{}
+	missingOptional := []string{}
+	for _, reference := range references {
+		exists, err := checker.ObjectExists(ctx, namespace, reference.resource, reference.name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to verify %s %s: %s", reference.resource, reference.name, err)
+		}
+		if exists {
+			continue
+		}
+		switch {
+		case reference.optional && reference.resource == "secrets":
+			missingOptional = append(missingOptional, fmt.Sprintf("optional secret %s doesn't exist, the cloud-sql-proxy is started without it", reference.name))
+		case reference.optional:
+			missingOptional = append(missingOptional, fmt.Sprintf("optional config map %s doesn't exist, the cloud-sql-proxy is started without it", reference.name))
+		case reference.resource == "secrets":
+			missing = append(missing, fmt.Sprintf("secret %s doesn't exist, create it or reference an existing one via annotation %s", reference.name, annotationSecret))
+		default:
+			missing = append(missing, fmt.Sprintf("config map %s doesn't exist, create it or reference an existing one via annotation %s or %s", reference.name, annotationCaMap, annotationFedConfig))
+		}
+	}
+	return missing, missingOptional, nil
+}
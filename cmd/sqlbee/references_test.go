@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticObjects contains the existing objects as resource/name, other objects fail to be retrieved
+type staticObjects map[string]bool
+
+func (s staticObjects) ObjectExists(ctx context.Context, namespace, resource, name string) (bool, error) {
+	if namespace != "team-a" {
+		return false, errors.New("forbidden")
+	}
+	return s[resource+"/"+name], nil
+}
+
+func TestProxyReferences(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationSecret:                    "creds",
+		annotationCaMap:                     "certs",
+		annotationInstance + ".reporting":   "proj:eu:reporting",
+		annotationCredSource + ".reporting": "env",
+	})
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         "app-secret",
+		VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "app"}},
+	})
+	optional := true
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         "extra-certs",
+		VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "extra", Optional: &optional}},
+	})
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	opts := Options{DefaultInstance: "proj:eu:db"}
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, opts))
+	proxyContainer.VolumeMounts = append(proxyContainer.VolumeMounts, corev1.VolumeMount{Name: "extra-certs", MountPath: "/extra"})
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	require.NoError(t, configureNamedInstances(context.Background(), pod, proxyContainer.Name, 3306, &pod.Spec, opts))
+
+	assert.Equal(t, []objectReference{
+		{resource: "secrets", name: "creds"},
+		{resource: "configmaps", name: "certs"},
+		{resource: "secrets", name: "extra", optional: true},
+	}, proxyReferences(&pod.Spec, proxyContainer.Name))
+
+	missing, missingOptional, err := missingReferences(context.Background(), staticObjects{"secrets/creds": true}, "team-a", proxyReferences(&pod.Spec, proxyContainer.Name))
+	require.NoError(t, err)
+	require.Len(t, missing, 1)
+	assert.Contains(t, missing[0], "config map certs doesn't exist")
+	require.Len(t, missingOptional, 1)
+	assert.Contains(t, missingOptional[0], "optional secret extra doesn't exist")
+}
+
+func TestMutateVerifiesReferences(t *testing.T) {
+	objects := staticObjects{"secrets/creds": true}
+	for _, data := range []struct {
+		mode      string
+		secret    string
+		namespace string
+		allowed   bool
+	}{
+		{mode: verifyDeny, secret: "creds", namespace: "team-a", allowed: true},
+		{mode: verifyDeny, secret: "unknown", namespace: "team-a", allowed: false},
+		{mode: verifyWarn, secret: "unknown", namespace: "team-a", allowed: true},
+		// Failures to verify the references don't prevent the injection
+		{mode: verifyDeny, secret: "unknown", namespace: "team-b", allowed: true},
+	} {
+		ar := Mutate(Options{
+			DefaultInstance:   "proj:eu:db",
+			DefaultSecretName: data.secret,
+			Objects:           objects,
+			VerifyReferences:  data.mode,
+		})(&v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Namespace: data.namespace,
+				Resource:  podResource,
+				Object: runtime.RawExtension{
+					Raw: []byte(podJson),
+				},
+			},
+		})
+		require.NotNil(t, ar)
+		assert.Equal(t, data.allowed, ar.Allowed, "%+v", data)
+		if !data.allowed {
+			require.NotNil(t, ar.Result)
+			assert.Contains(t, ar.Result.Message, "secret unknown doesn't exist, create it or reference an existing one via annotation "+annotationSecret)
+			assert.Equal(t, metav1.StatusReasonForbidden, ar.Result.Reason)
+			assert.EqualValues(t, http.StatusForbidden, ar.Result.Code)
+			require.NotNil(t, ar.Result.Details)
+			assert.NotEmpty(t, ar.Result.Details.Causes)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// isProxyContainer checks whether a container is a proxy injected by SQLBee, either the default proxy
+// with the given name or the proxy of a named instance
+func isProxyContainer(container corev1.Container, proxyName string) bool {
+	if !isProxyCommand(container) {
+		return false
+	}
+	return container.Name == proxyName || strings.HasPrefix(container.Name, proxyName+"-")
+}
+
+// hasProxyContainer checks whether the podSpec contains a proxy injected by SQLBee, either as sidecar
+// or as native sidecar
+func hasProxyContainer(podSpec *corev1.PodSpec, proxyName string) bool {
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range containers {
+			if isProxyContainer(container, proxyName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasContainer checks whether the podSpec contains a container or init container with the name
+func hasContainer(podSpec *corev1.PodSpec, name string) bool {
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range containers {
+			if container.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeProxies removes previously injected proxy containers from the podSpec, together with their
+// volumes and the configuration of the application containers. Returns whether anything was removed
+func removeProxies(podSpec *corev1.PodSpec, proxyName, socketVolume string) bool {
+	proxyVolumes := map[string]bool{}
+	removed := false
+	for _, list := range []*[]corev1.Container{&podSpec.InitContainers, &podSpec.Containers} {
+		containers := []corev1.Container{}
+		for _, container := range *list {
+			if !isProxyContainer(container, proxyName) {
+				containers = append(containers, container)
+				continue
+			}
+			for _, mount := range container.VolumeMounts {
+				proxyVolumes[mount.Name] = true
+			}
+		}
+		if len(containers) != len(*list) {
+			*list = containers
+			removed = true
+		}
+	}
+	if !removed {
+		return false
+	}
+
+	// The application containers may mount the sockets in FUSE mode and know the quitquitquit URLs
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		mounts := []corev1.VolumeMount{}
+		for _, mount := range container.VolumeMounts {
+			if mount.Name != socketVolume {
+				mounts = append(mounts, mount)
+			}
+		}
+		container.VolumeMounts = mounts
+		env := []corev1.EnvVar{}
+		for _, e := range container.Env {
+			if e.Name != quitURLEnv && !strings.HasPrefix(e.Name, quitURLEnv+"_") {
+				env = append(env, e)
+			}
+		}
+		container.Env = env
+	}
+
+	// Volumes are only removed if no other container relies on them
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range containers {
+			for _, mount := range container.VolumeMounts {
+				delete(proxyVolumes, mount.Name)
+			}
+		}
+	}
+	volumes := []corev1.Volume{}
+	for _, volume := range podSpec.Volumes {
+		if !proxyVolumes[volume.Name] {
+			volumes = append(volumes, volume)
+		}
+	}
+	podSpec.Volumes = volumes
+	return true
+}
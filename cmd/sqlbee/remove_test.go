@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveProxies(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationFuse:                    "true",
+		annotationQuit:                    "true",
+		annotationImage:                   testImageV2,
+		annotationSecret:                  "creds",
+		annotationInstance + ".reporting": "proj:eu:reporting",
+	})
+	original := pod.Spec.DeepCopy()
+
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{}))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	configureAppContainers(pod, proxyContainer, &pod.Spec, Options{})
+	require.NoError(t, configureNamedInstances(context.Background(), pod, proxyContainer.Name, 3306, &pod.Spec, Options{}))
+	require.Len(t, pod.Spec.Containers, 3)
+
+	assert.True(t, removeProxies(&pod.Spec, proxyContainer.Name, defaultSocketVolume))
+	assert.Equal(t, original.Containers, pod.Spec.Containers)
+	assert.Equal(t, original.Volumes, pod.Spec.Volumes)
+
+	assert.False(t, removeProxies(&pod.Spec, proxyContainer.Name, defaultSocketVolume))
+}
+
+func TestRemoveProxiesKeepsForeignContainers(t *testing.T) {
+	podSpec := &corev1.PodSpec{
+		Containers: []corev1.Container{
+			{Name: "app"},
+			{Name: "cloud-sql-proxy-like", Command: []string{"/bin/proxy"}},
+		},
+	}
+	assert.False(t, removeProxies(podSpec, "cloud-sql-proxy", defaultSocketVolume))
+	assert.Len(t, podSpec.Containers, 2)
+}
+
+func TestMutateRemovesProxy(t *testing.T) {
+	opts := Options{DefaultInstance: "proj:eu:db"}
+	pod, _ := mutatePod(t, opts, nil)
+	pod.Annotations[annotationInject] = "false"
+
+	ar := reviewPod(t, opts, "", pod)
+	assert.True(t, ar.Allowed)
+	assert.Contains(t, string(ar.Patch), `"op":"remove","path":"/spec/containers/1"`)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+)
+
+// replicateSecret replicates the secret into the namespace, if it is referenced by the proxies and the
+// namespace opted in. This way the central credentials don't need to be created in every namespace manually
+func replicateSecret(ctx context.Context, replicator kube.Replicator, namespace string, references []objectReference) (bool, error) {
+	if !replicator.Replicates(namespace) {
+		return false, nil
+	}
+	for _, reference := range references {
+		if reference.resource == "secrets" && reference.name == replicator.Name() {
+			return true, replicator.Replicate(ctx, namespace)
+		}
+	}
+	return false, nil
+}
+
+// unreplicatedReferences returns the references except the one to the secret replicated into the
+// namespace, which is only created once the injection has been allowed
+func unreplicatedReferences(replicator kube.Replicator, namespace string, references []objectReference) []objectReference {
+	if replicator == nil || !replicator.Replicates(namespace) {
+		return references
+	}
+	unreplicated := []objectReference{}
+	for _, reference := range references {
+		if reference.resource != "secrets" || reference.name != replicator.Name() {
+			unreplicated = append(unreplicated, reference)
+		}
+	}
+	return unreplicated
+}
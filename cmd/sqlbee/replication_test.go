@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingReplicator struct {
+	allowed    []string
+	namespaces []string
+}
+
+func (r *recordingReplicator) Name() string {
+	return "cloud-sql-proxy-credentials"
+}
+
+func (r *recordingReplicator) Replicates(namespace string) bool {
+	for _, allowed := range r.allowed {
+		if namespace == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *recordingReplicator) Replicate(ctx context.Context, namespace string) error {
+	r.namespaces = append(r.namespaces, namespace)
+	return nil
+}
+
+func TestMutateReplicatesSecret(t *testing.T) {
+	replicator := &recordingReplicator{allowed: []string{"team-a"}}
+	dryRun := true
+	for _, data := range []struct {
+		namespace   string
+		annotations map[string]string
+		dryRun      *bool
+		allowed     bool
+	}{
+		{namespace: "team-a", allowed: true},
+		{namespace: "team-a", dryRun: &dryRun, allowed: true},
+		{namespace: "team-a", annotations: map[string]string{annotationSecret: "other-credentials"}, allowed: true},
+		// Namespaces which didn't opt in don't receive replicas
+		{namespace: "team-b", allowed: true},
+		// Denied injections have no side effects
+		{namespace: "team-a", annotations: map[string]string{annotationCaMap: "missing-ca"}},
+	} {
+		opts := Options{
+			DefaultInstance:   "proj:eu:db",
+			DefaultSecretName: "cloud-sql-proxy-credentials",
+			Replicator:        replicator,
+			Objects:           staticObjects{"secrets/other-credentials": true},
+			VerifyReferences:  verifyDeny,
+		}
+		raw, err := json.Marshal(testPodWithAnnotations(t, data.annotations))
+		require.NoError(t, err)
+		ar := Mutate(opts)(&v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Namespace: data.namespace,
+				Resource:  podResource,
+				DryRun:    data.dryRun,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		require.NotNil(t, ar)
+		assert.Equal(t, data.allowed, ar.Allowed, "%s %v", data.namespace, data.annotations)
+	}
+	assert.Equal(t, []string{"team-a"}, replicator.namespaces)
+}
+
+func TestMutateShadowHasNoSideEffects(t *testing.T) {
+	materializer := &recordingMaterializer{}
+	replicator := &recordingReplicator{allowed: []string{"team-a"}}
+	opts := Options{
+		DefaultInstance:      "proj:eu:db",
+		DefaultSecretName:    "cloud-sql-proxy-credentials",
+		SecretManagerSecret:  "projects/p/secrets/sql",
+		SecretManagerAllowed: []string{"projects/p/secrets/sql"},
+		SecretManager:        materializer,
+		Replicator:           replicator,
+		Shadow:               true,
+	}
+	ar := reviewPod(t, opts, "team-a", testPodWithAnnotations(t, map[string]string{
+		annotationCredSource: credentialsSourceVault,
+		annotationVaultRole:  "sqlbee",
+		annotationVaultPath:  "secret/sql",
+	}))
+	require.NotNil(t, ar)
+	// The decision is made as usual
+	assert.True(t, ar.Allowed)
+	assert.Contains(t, string(ar.Patch), "cloud-sql-proxy")
+	assert.Contains(t, string(ar.Patch), "vault.hashicorp.com~1role")
+	assert.Empty(t, materializer.secrets)
+	assert.Empty(t, replicator.namespaces)
+
+	opts.Shadow = false
+	ar = reviewPod(t, opts, "team-a", testPodWithAnnotations(t, nil))
+	require.NotNil(t, ar)
+	assert.True(t, ar.Allowed)
+	assert.Len(t, materializer.secrets, 1)
+	assert.Equal(t, []string{"team-a"}, replicator.namespaces)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+var (
+	deploymentResource         = metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	deploymentBeta1Resource    = metav1.GroupVersionResource{Group: "apps", Version: "v1beta1", Resource: "deployments"}
+	deploymentBeta2Resource    = metav1.GroupVersionResource{Group: "apps", Version: "v1beta2", Resource: "deployments"}
+	deploymentExtBeta1Resource = metav1.GroupVersionResource{Group: "extensions", Version: "v1beta1", Resource: "deployments"}
+	statefulSetResource        = metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}
+	daemonSetResource          = metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}
+	replicaSetResource         = metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}
+	rcResource                 = metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "replicationcontrollers"}
+	jobResource                = metav1.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+	cronJobResource            = metav1.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}
+	cronJobBetaResource        = metav1.GroupVersionResource{Group: "batch", Version: "v1beta1", Resource: "cronjobs"}
+	rolloutResource            = metav1.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+	deployConfResource         = metav1.GroupVersionResource{Group: "apps.openshift.io", Version: "v1", Resource: "deploymentconfigs"}
+)
+
+var (
+	// errMissingPodTemplate is returned by decoders if the object has no pod template to inject
+	errMissingPodTemplate = sting.ErrMissingPodTemplate
+)
+
+// podSpecDecoder deserializes the object of an admission review and returns it together with the pod
+// spec which needs to be mutated to inject the cloud sql proxy
+type podSpecDecoder func(ar *v1beta1.AdmissionReview) (runtime.Object, *corev1.PodSpec, error)
+
+// Tekton labels the pods it creates for TaskRuns and treats all containers whose name is prefixed
+// with "sidecar-" as sidecars, which are stopped as soon as all steps have finished
+const (
+	tektonTaskRunLabel  = "tekton.dev/taskRun"
+	tektonSidecarPrefix = "sidecar-"
+)
+
+// decoders for all resources SQLBee is able to inject
+var podSpecDecoders = map[metav1.GroupVersionResource]podSpecDecoder{
+	podResource: decodePod,
+	// Deployments are still served in several versions by older clusters
+	deploymentResource:         decodeDeployment,
+	deploymentBeta1Resource:    decodeDeployment,
+	deploymentBeta2Resource:    decodeDeployment,
+	deploymentExtBeta1Resource: decodeDeployment,
+	statefulSetResource:        decodeInto(func() runtime.Object { return &appsv1.StatefulSet{} }),
+	daemonSetResource:          decodeInto(func() runtime.Object { return &appsv1.DaemonSet{} }),
+	replicaSetResource:         decodeInto(func() runtime.Object { return &appsv1.ReplicaSet{} }),
+	rcResource:                 decodeInto(func() runtime.Object { return &corev1.ReplicationController{} }),
+	jobResource:                decodeInto(func() runtime.Object { return &batchv1.Job{} }),
+	cronJobResource:            decodeCronJob,
+	cronJobBetaResource:        decodeCronJob,
+	rolloutResource:            decodeUnstructured("spec", "template", "spec"),
+	// The OpenShift API types aren't part of our scheme, DeploymentConfigs are handled unstructured
+	deployConfResource: decodeUnstructured("spec", "template", "spec"),
+}
+
+func decodePod(ar *v1beta1.AdmissionReview) (runtime.Object, *corev1.PodSpec, error) {
+	obj, gvk, err := sting.DecodeObject(ar)
+	if err != nil {
+		return nil, nil, err
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil, fmt.Errorf("Expected a Pod but received %s", gvk)
+	}
+	return pod, &pod.Spec, nil
+}
+
+// Deployments are decoded into the type of the version they have been sent in, as registered in
+// our scheme. This way they are encoded again in their original version and the patch only
+// contains our modifications
+func decodeDeployment(ar *v1beta1.AdmissionReview) (runtime.Object, *corev1.PodSpec, error) {
+	obj, gvk, err := sting.DecodeObject(ar)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch deployment := obj.(type) {
+	case *appsv1.Deployment:
+		return deployment, &deployment.Spec.Template.Spec, nil
+	case *appsv1beta1.Deployment:
+		return deployment, &deployment.Spec.Template.Spec, nil
+	case *appsv1beta2.Deployment:
+		return deployment, &deployment.Spec.Template.Spec, nil
+	case *extensionsv1beta1.Deployment:
+		return deployment, &deployment.Spec.Template.Spec, nil
+	default:
+		return nil, nil, fmt.Errorf("Expected a Deployment but received %s", gvk)
+	}
+}
+
+// decodeInto returns a podSpecDecoder decoding objects of the type of the object created by newObject.
+// The pod template of a ReplicationController is optional, if it is missing there is nothing to inject
+func decodeInto(newObject func() runtime.Object) podSpecDecoder {
+	return func(ar *v1beta1.AdmissionReview) (runtime.Object, *corev1.PodSpec, error) {
+		obj, gvk, err := sting.DecodeObject(ar)
+		if err != nil {
+			return nil, nil, err
+		}
+		if expected := newObject(); reflect.TypeOf(obj) != reflect.TypeOf(expected) {
+			return nil, nil, fmt.Errorf("Expected a %s but received %s", reflect.TypeOf(expected).Elem().Name(), gvk)
+		}
+		podSpec, err := sting.PodSpecOf(obj)
+		if err != nil {
+			return nil, nil, err
+		}
+		return obj, podSpec, nil
+	}
+}
+
+// CronJobs are served as batch/v1 by newer clusters, which isn't part of our API types. As the
+// structure of both versions is identical the object is unmarshaled directly into a batch/v1beta1
+// CronJob, keeping the apiVersion of the request
+func decodeCronJob(ar *v1beta1.AdmissionReview) (runtime.Object, *corev1.PodSpec, error) {
+	cronJob := &batchv1beta1.CronJob{}
+	if err := json.Unmarshal(ar.Request.Object.Raw, cronJob); err != nil {
+		return nil, nil, err
+	}
+	return cronJob, &cronJob.Spec.JobTemplate.Spec.Template.Spec, nil
+}
+
+// decodeUnknown decodes objects of unknown kinds as unstructured objects and searches the
+// well known pod spec paths for a pod spec
+func decodeUnknown(ar *v1beta1.AdmissionReview) (runtime.Object, *corev1.PodSpec, error) {
+	for _, path := range sting.PodSpecPaths {
+		obj, podSpec, err := decodeUnstructured(path...)(ar)
+		if err != errMissingPodTemplate {
+			return obj, podSpec, err
+		}
+	}
+	return nil, nil, errMissingPodTemplate
+}
+
+// unstructuredObject is an object of a kind which is not known to our scheme, like CRDs. The pod
+// spec found at path is decoded separately so it can be mutated like the pod specs of known kinds
+type unstructuredObject struct {
+	*unstructured.Unstructured
+	path    []string
+	podSpec *corev1.PodSpec
+	// the labels and annotations of the pod template, which is the parent of the pod spec
+	template metav1.ObjectMeta
+}
+
+// templateMetadataPath returns the path of a field of the metadata of the pod template
+func (u *unstructuredObject) templateMetadataPath(field string) []string {
+	return append(append([]string{}, u.path[:len(u.path)-1]...), "metadata", field)
+}
+
+// templateMetadataFields returns the metadata fields of the pod template which are decoded
+func (u *unstructuredObject) templateMetadataFields() map[string]*map[string]string {
+	return map[string]*map[string]string{
+		"labels":      &u.template.Labels,
+		"annotations": &u.template.Annotations,
+	}
+}
+
+// MarshalJSON writes the possibly mutated pod spec back into the unstructured content before
+// marshaling the whole object
+func (u *unstructuredObject) MarshalJSON() ([]byte, error) {
+	podSpec, err := runtime.DefaultUnstructuredConverter.ToUnstructured(u.podSpec)
+	if err != nil {
+		return nil, err
+	}
+	if err := unstructured.SetNestedField(u.Object, podSpec, u.path...); err != nil {
+		return nil, err
+	}
+	for field, values := range u.templateMetadataFields() {
+		if len(*values) > 0 {
+			if err := unstructured.SetNestedStringMap(u.Object, *values, u.templateMetadataPath(field)...); err != nil {
+				return nil, err
+			}
+		} else {
+			unstructured.RemoveNestedField(u.Object, u.templateMetadataPath(field)...)
+		}
+	}
+	return u.Unstructured.MarshalJSON()
+}
+
+// decodeUnstructured returns a podSpecDecoder for objects of unknown kinds which contain a pod spec at
+// the given path
+func decodeUnstructured(path ...string) podSpecDecoder {
+	return func(ar *v1beta1.AdmissionReview) (runtime.Object, *corev1.PodSpec, error) {
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(ar.Request.Object.Raw); err != nil {
+			return nil, nil, err
+		}
+		content, found, err := unstructured.NestedMap(obj.Object, path...)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !found {
+			return nil, nil, errMissingPodTemplate
+		}
+		podSpec := &corev1.PodSpec{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, podSpec); err != nil {
+			return nil, nil, err
+		}
+		u := &unstructuredObject{Unstructured: obj, path: path, podSpec: podSpec}
+		for field, values := range u.templateMetadataFields() {
+			if *values, _, err = unstructured.NestedStringMap(obj.Object, u.templateMetadataPath(field)...); err != nil {
+				return nil, nil, err
+			}
+		}
+		return u, podSpec, nil
+	}
+}
+
+// templateMetadata returns the metadata of the pod template of an object, for pods their own
+// metadata. Returns nil for objects without pod template
+func templateMetadata(obj runtime.Object) *metav1.ObjectMeta {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return &o.ObjectMeta
+	case *appsv1.Deployment:
+		return &o.Spec.Template.ObjectMeta
+	case *appsv1beta1.Deployment:
+		return &o.Spec.Template.ObjectMeta
+	case *appsv1beta2.Deployment:
+		return &o.Spec.Template.ObjectMeta
+	case *extensionsv1beta1.Deployment:
+		return &o.Spec.Template.ObjectMeta
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template.ObjectMeta
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template.ObjectMeta
+	case *appsv1.ReplicaSet:
+		return &o.Spec.Template.ObjectMeta
+	case *corev1.ReplicationController:
+		if o.Spec.Template == nil {
+			return nil
+		}
+		return &o.Spec.Template.ObjectMeta
+	case *batchv1.Job:
+		return &o.Spec.Template.ObjectMeta
+	case *batchv1beta1.CronJob:
+		return &o.Spec.JobTemplate.Spec.Template.ObjectMeta
+	case *unstructuredObject:
+		return &o.template
+	default:
+		return nil
+	}
+}
+
+// podSpecPath returns the path of the pod spec within the serialized object. Returns nil for
+// objects without pod template
+func podSpecPath(obj runtime.Object) []string {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return []string{"spec"}
+	case *corev1.ReplicationController:
+		if o.Spec.Template == nil {
+			return nil
+		}
+		return []string{"spec", "template", "spec"}
+	case *batchv1beta1.CronJob:
+		return []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	case *unstructuredObject:
+		return o.path
+	default:
+		if templateMetadata(obj) == nil {
+			return nil
+		}
+		return []string{"spec", "template", "spec"}
+	}
+}
+
+// templateLabels returns a pointer to the labels of the pod template of an object. Returns nil for
+// objects without pod template
+func templateLabels(obj runtime.Object) *map[string]string {
+	if meta := templateMetadata(obj); meta != nil {
+		return &meta.Labels
+	}
+	return nil
+}
+
+// templateAnnotations returns a pointer to the annotations of the pod template of an object. Returns
+// nil for objects without pod template
+func templateAnnotations(obj runtime.Object) *map[string]string {
+	if meta := templateMetadata(obj); meta != nil {
+		return &meta.Annotations
+	}
+	return nil
+}
+
+// isTektonPod checks whether the object is a pod created by Tekton for a TaskRun
+func isTektonPod(obj runtime.Object) bool {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return false
+	}
+	_, exists := pod.Labels[tektonTaskRunLabel]
+	return exists
+}
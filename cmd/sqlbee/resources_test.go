@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattbaird/jsonpatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var deploymentJson = `
+{
+   "apiVersion": "apps/v1",
+   "kind": "Deployment",
+   "metadata": {
+      "name": "wordpress",
+      "annotations": {
+         "sqlbee.connctd.io.inject": "true"
+      }
+   },
+   "spec": {
+      "selector": {
+         "matchLabels": {
+            "app": "wordpress"
+         }
+      },
+      "template": {
+         "metadata": {
+            "labels": {
+               "app": "wordpress"
+            }
+         },
+         "spec": {
+            "containers": [
+               {
+                  "image": "wordpress:4.8-apache",
+                  "name": "wordpress"
+               }
+            ]
+         }
+      }
+   }
+}
+`
+
+var statefulSetJson = `
+{
+   "apiVersion": "apps/v1",
+   "kind": "StatefulSet",
+   "metadata": {
+      "name": "wordpress",
+      "annotations": {
+         "sqlbee.connctd.io.inject": "true"
+      }
+   },
+   "spec": {
+      "serviceName": "wordpress",
+      "selector": {
+         "matchLabels": {
+            "app": "wordpress"
+         }
+      },
+      "template": {
+         "metadata": {
+            "labels": {
+               "app": "wordpress"
+            }
+         },
+         "spec": {
+            "containers": [
+               {
+                  "image": "wordpress:4.8-apache",
+                  "name": "wordpress"
+               }
+            ]
+         }
+      },
+      "volumeClaimTemplates": [
+         {
+            "metadata": {
+               "name": "data"
+            },
+            "spec": {
+               "accessModes": ["ReadWriteOnce"]
+            }
+         }
+      ]
+   }
+}
+`
+
+var daemonSetJson = `
+{
+   "apiVersion": "apps/v1",
+   "kind": "DaemonSet",
+   "metadata": {
+      "name": "node-agent",
+      "annotations": {
+         "sqlbee.connctd.io.inject": "true"
+      }
+   },
+   "spec": {
+      "selector": {
+         "matchLabels": {
+            "app": "node-agent"
+         }
+      },
+      "template": {
+         "metadata": {
+            "labels": {
+               "app": "node-agent"
+            }
+         },
+         "spec": {
+            "containers": [
+               {
+                  "image": "node-agent:1.0",
+                  "name": "node-agent"
+               }
+            ]
+         }
+      }
+   }
+}
+`
+
+var replicaSetJson = `
+{
+   "apiVersion": "apps/v1",
+   "kind": "ReplicaSet",
+   "metadata": {
+      "name": "wordpress",
+      "annotations": {
+         "sqlbee.connctd.io.inject": "true"
+      }
+   },
+   "spec": {
+      "selector": {
+         "matchLabels": {
+            "app": "wordpress"
+         }
+      },
+      "template": {
+         "metadata": {
+            "labels": {
+               "app": "wordpress"
+            }
+         },
+         "spec": {
+            "containers": [
+               {
+                  "image": "wordpress:4.8-apache",
+                  "name": "wordpress"
+               }
+            ]
+         }
+      }
+   }
+}
+`
+
+var replicationControllerJson = `
+{
+   "apiVersion": "v1",
+   "kind": "ReplicationController",
+   "metadata": {
+      "name": "wordpress",
+      "annotations": {
+         "sqlbee.connctd.io.inject": "true"
+      }
+   },
+   "spec": {
+      "selector": {
+         "app": "wordpress"
+      },
+      "template": {
+         "metadata": {
+            "labels": {
+               "app": "wordpress"
+            }
+         },
+         "spec": {
+            "containers": [
+               {
+                  "image": "wordpress:4.8-apache",
+                  "name": "wordpress"
+               }
+            ]
+         }
+      }
+   }
+}
+`
+
+var jobJson = `
+{
+   "apiVersion": "batch/v1",
+   "kind": "Job",
+   "metadata": {
+      "name": "migration",
+      "annotations": {
+         "sqlbee.connctd.io.inject": "true"
+      }
+   },
+   "spec": {
+      "template": {
+         "spec": {
+            "restartPolicy": "Never",
+            "containers": [
+               {
+                  "image": "migration:1.0",
+                  "name": "migration"
+               }
+            ]
+         }
+      }
+   }
+}
+`
+
+var cronJobJson = `
+{
+   "apiVersion": "batch/v1",
+   "kind": "CronJob",
+   "metadata": {
+      "name": "cleanup",
+      "annotations": {
+         "sqlbee.connctd.io.inject": "true"
+      }
+   },
+   "spec": {
+      "schedule": "0 * * * *",
+      "jobTemplate": {
+         "spec": {
+            "template": {
+               "spec": {
+                  "restartPolicy": "Never",
+                  "containers": [
+                     {
+                        "image": "cleanup:1.0",
+                        "name": "cleanup"
+                     }
+                  ]
+               }
+            }
+         }
+      }
+   }
+}
+`
+
+var rolloutJson = `
+{
+   "apiVersion": "argoproj.io/v1alpha1",
+   "kind": "Rollout",
+   "metadata": {
+      "name": "wordpress",
+      "annotations": {
+         "sqlbee.connctd.io.inject": "true"
+      }
+   },
+   "spec": {
+      "replicas": 2,
+      "strategy": {
+         "canary": {
+            "steps": [
+               {
+                  "setWeight": 20
+               }
+            ]
+         }
+      },
+      "template": {
+         "metadata": {
+            "labels": {
+               "app": "wordpress"
+            }
+         },
+         "spec": {
+            "containers": [
+               {
+                  "image": "wordpress:4.8-apache",
+                  "name": "wordpress",
+                  "ports": [
+                     {
+                        "containerPort": 80
+                     }
+                  ]
+               }
+            ]
+         }
+      }
+   }
+}
+`
+
+var deploymentConfigJson = `
+{
+   "apiVersion": "apps.openshift.io/v1",
+   "kind": "DeploymentConfig",
+   "metadata": {
+      "name": "wordpress",
+      "annotations": {
+         "sqlbee.connctd.io.inject": "true"
+      }
+   },
+   "spec": {
+      "replicas": 1,
+      "selector": {
+         "app": "wordpress"
+      },
+      "triggers": [
+         {
+            "type": "ConfigChange"
+         }
+      ],
+      "template": {
+         "metadata": {
+            "labels": {
+               "app": "wordpress"
+            }
+         },
+         "spec": {
+            "containers": [
+               {
+                  "image": "wordpress:4.8-apache",
+                  "name": "wordpress"
+               }
+            ]
+         }
+      }
+   }
+}
+`
+
+// runs the mutation on the given raw object and returns the resulting patch operations
+func mutatedPatches(t *testing.T, resource string, raw string) []jsonpatch.JsonPatchOperation {
+	gvr, supported := resourceByName(resource, raw)
+	require.True(t, supported)
+	review := &v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Resource: gvr,
+			Object: runtime.RawExtension{
+				Raw: []byte(raw),
+			},
+		},
+	}
+	mut := Mutate(Options{DefaultInstance: "my-gcp-project-42:europe-west1:sql-master", RequireAnnotation: true})
+	ar := mut(review)
+	require.NotNil(t, ar)
+	require.True(t, ar.Allowed, "mutation was not allowed: %v", ar.Result)
+
+	var ops []jsonpatch.JsonPatchOperation
+	require.NoError(t, json.Unmarshal(ar.Patch, &ops))
+	return ops
+}
+
+func TestMutateResources(t *testing.T) {
+	for _, data := range []struct {
+		resource    string
+		raw         string
+		podSpecPath string
+	}{
+		{
+			resource:    "statefulsets",
+			raw:         statefulSetJson,
+			podSpecPath: "/spec/template/spec",
+		},
+		{
+			resource:    "daemonsets",
+			raw:         daemonSetJson,
+			podSpecPath: "/spec/template/spec",
+		},
+		{
+			resource:    "replicasets",
+			raw:         replicaSetJson,
+			podSpecPath: "/spec/template/spec",
+		},
+		{
+			resource:    "replicationcontrollers",
+			raw:         replicationControllerJson,
+			podSpecPath: "/spec/template/spec",
+		},
+		{
+			resource:    "jobs",
+			raw:         jobJson,
+			podSpecPath: "/spec/template/spec",
+		},
+		{
+			resource:    "cronjobs",
+			raw:         cronJobJson,
+			podSpecPath: "/spec/jobTemplate/spec/template/spec",
+		},
+		{
+			resource:    "cronjobs",
+			raw:         strings.Replace(cronJobJson, "batch/v1", "batch/v1beta1", 1),
+			podSpecPath: "/spec/jobTemplate/spec/template/spec",
+		},
+		{
+			resource:    "rollouts",
+			raw:         rolloutJson,
+			podSpecPath: "/spec/template/spec",
+		},
+		{
+			resource:    "deploymentconfigs",
+			raw:         deploymentConfigJson,
+			podSpecPath: "/spec/template/spec",
+		},
+	} {
+		ops := mutatedPatches(t, data.resource, data.raw)
+		paths := map[string]bool{}
+		for _, op := range ops {
+			paths[op.Path] = true
+		}
+		assert.True(t, paths[data.podSpecPath+"/containers/1"], "%s: sidecar not injected: %v", data.resource, ops)
+		assert.True(t, paths[data.podSpecPath+"/volumes"], "%s: volumes not injected: %v", data.resource, ops)
+		metadataPath := strings.TrimSuffix(data.podSpecPath, "/spec") + "/metadata"
+		assert.True(t, paths[metadataPath] || paths[metadataPath+"/labels"] || paths[metadataPath+"/labels/sqlbee.connctd.io~1status"],
+			"%s: status not recorded: %v", data.resource, ops)
+	}
+}
+
+func TestMutateUnstructuredKeepsUnknownFields(t *testing.T) {
+	ops := mutatedPatches(t, "rollouts", rolloutJson)
+	for _, op := range ops {
+		assert.False(t, strings.HasPrefix(op.Path, "/spec/strategy"), "unknown field modified: %v", op)
+		assert.NotEqual(t, "/spec/replicas", op.Path)
+	}
+}
+
+// looks up the GroupVersionResource of a supported resource by its plural name and the
+// apiVersion of the raw object
+func resourceByName(resource string, raw string) (gvr metav1.GroupVersionResource, supported bool) {
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal([]byte(raw), &typeMeta); err != nil {
+		return gvr, false
+	}
+	for gvr := range podSpecDecoders {
+		groupVersion := metav1.GroupVersion{Group: gvr.Group, Version: gvr.Version}
+		if gvr.Resource == resource && groupVersion.String() == typeMeta.APIVersion {
+			return gvr, true
+		}
+	}
+	return gvr, false
+}
+
+func TestMutateReplicationControllerWithoutTemplate(t *testing.T) {
+	review := &v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Resource: rcResource,
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"ReplicationController","metadata":{"name":"wordpress","annotations":{"sqlbee.connctd.io.inject":"true"}},"spec":{"replicas":0}}`),
+			},
+		},
+	}
+	ar := Mutate(Options{DefaultInstance: "my-gcp-project-42:europe-west1:sql-master"})(review)
+	require.NotNil(t, ar)
+	assert.True(t, ar.Allowed, "mutation was not allowed: %v", ar.Result)
+	assert.Empty(t, ar.Patch)
+}
+
+func TestDecodeUnknown(t *testing.T) {
+	for _, data := range []struct {
+		raw        string
+		containers int
+		err        error
+	}{
+		{
+			raw:        strings.Replace(rolloutJson, "argoproj.io/v1alpha1", "example.com/v1", 1),
+			containers: 1,
+		},
+		{
+			raw:        strings.Replace(cronJobJson, "batch/v1", "example.com/v1", 1),
+			containers: 1,
+		},
+		{
+			raw: `{"apiVersion":"example.com/v1","kind":"Database","spec":{"size":"10Gi"}}`,
+			err: errMissingPodTemplate,
+		},
+	} {
+		obj, podSpec, err := decodeUnknown(objectReview(data.raw))
+		assert.Equal(t, data.err, err)
+		if data.err == nil {
+			require.NotNil(t, obj)
+			require.NotNil(t, podSpec)
+			assert.Len(t, podSpec.Containers, data.containers)
+		}
+	}
+}
+
+// objectReview returns an admission review of the raw object
+func objectReview(raw string) *v1beta1.AdmissionReview {
+	return &v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{Object: runtime.RawExtension{Raw: []byte(raw)}}}
+}
+
+func TestTektonPodSidecarName(t *testing.T) {
+	for _, data := range []struct {
+		labels   map[string]string
+		expected string
+	}{
+		{
+			labels:   map[string]string{"app": "wordpress"},
+			expected: "cloud-sql-proxy",
+		},
+		{
+			labels:   map[string]string{tektonTaskRunLabel: "migrate-schema-run-1"},
+			expected: "sidecar-cloud-sql-proxy",
+		},
+	} {
+		pod := testPodWithAnnotations(t, map[string]string{})
+		pod.Labels = data.labels
+		proxyContainer := sqlProxyContainer.DeepCopy()
+		volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+		require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, Options{}))
+
+		assert.Equal(t, data.expected, proxyContainer.Name)
+	}
+}
+
+func TestDecodeRequestKind(t *testing.T) {
+	// Objects lacking their kind are decoded as the kind of the request
+	ar := objectReview(`{"metadata":{"name":"app"},"spec":{"containers":[{"name":"app","image":"app"}]}}`)
+	ar.Request.Kind = metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	obj, podSpec, err := decodePod(ar)
+	require.NoError(t, err)
+	assert.IsType(t, &corev1.Pod{}, obj)
+	assert.Len(t, podSpec.Containers, 1)
+
+	// Objects of other kinds than the resource are refused
+	_, _, err = decodePod(objectReview(deploymentJson))
+	assert.EqualError(t, err, "Expected a Pod but received apps/v1, Kind=Deployment")
+	_, _, err = decodeInto(func() runtime.Object { return &appsv1.DaemonSet{} })(objectReview(statefulSetJson))
+	assert.EqualError(t, err, "Expected a DaemonSet but received apps/v1, Kind=StatefulSet")
+}
+
+func TestDecodeDeploymentKeepsVersion(t *testing.T) {
+	for _, apiVersion := range []string{"apps/v1", "apps/v1beta1", "apps/v1beta2", "extensions/v1beta1"} {
+		raw := strings.Replace(deploymentJson, "apps/v1", apiVersion, 1)
+		obj, podSpec, err := decodeDeployment(objectReview(raw))
+		require.NoError(t, err)
+		require.NotNil(t, podSpec)
+		assert.Equal(t, apiVersion, obj.GetObjectKind().GroupVersionKind().GroupVersion().String())
+
+		for _, op := range mutatedPatches(t, "deployments", raw) {
+			assert.NotEqual(t, "/apiVersion", op.Path)
+			assert.NotEqual(t, "/kind", op.Path)
+		}
+	}
+}
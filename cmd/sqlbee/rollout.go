@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Rollout restricts the injection to a fraction of the eligible workloads, so a new proxy image or
+// version of SQLBee can be rolled out gradually and rolled back by lowering the percentage
+type Rollout struct {
+	// Percentage of the workloads being injected, from 0 to 100
+	Percent int `json:"percent"`
+	// If set, only the workloads within these namespaces are injected
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// newRollout validates the percentage and the comma separated namespaces of the rollout
+func newRollout(percent int, namespaces string) (*Rollout, error) {
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("Invalid rollout percentage %d, expected 0 to 100", percent)
+	}
+	rollout := &Rollout{Percent: percent}
+	for _, namespace := range strings.Split(namespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return nil, fmt.Errorf("Invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+		}
+		rollout.Namespaces = append(rollout.Namespaces, namespace)
+	}
+	return rollout, nil
+}
+
+// workloadKey identifies the workload an object belongs to, so all of its pods share the decision of
+// the rollout. Pods are identified by their controller, pods of a ReplicaSet by its Deployment, as the
+// name of the ReplicaSet changes with every revision
+func workloadKey(namespace string, obj runtime.Object) string {
+	o, ok := obj.(metav1.Object)
+	if !ok {
+		return namespace
+	}
+	name := o.GetName()
+	if name == "" {
+		name = o.GetGenerateName()
+	}
+	if owner := metav1.GetControllerOf(o); owner != nil {
+		name = owner.Name
+		if hash := o.GetLabels()["pod-template-hash"]; owner.Kind == "ReplicaSet" && hash != "" {
+			name = strings.TrimSuffix(name, "-"+hash)
+		}
+	}
+	return namespace + "/" + name
+}
+
+// includes checks whether the workload is part of the rollout. Workloads are assigned to a percentile
+// by the hash of their namespace and name, so raising the percentage only adds workloads. A nil
+// rollout includes every workload
+func (r *Rollout) includes(namespace string, obj runtime.Object) bool {
+	if r == nil {
+		return true
+	}
+	if len(r.Namespaces) > 0 {
+		found := false
+		for _, allowed := range r.Namespaces {
+			found = found || namespace == allowed
+		}
+		if !found {
+			return false
+		}
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(workloadKey(namespace, obj)))
+	return int(hash.Sum32()%100) < r.Percent
+}
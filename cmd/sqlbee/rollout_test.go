@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestNewRollout(t *testing.T) {
+	rollout, err := newRollout(20, "team-a, team-b,")
+	require.NoError(t, err)
+	assert.Equal(t, &Rollout{Percent: 20, Namespaces: []string{"team-a", "team-b"}}, rollout)
+
+	_, err = newRollout(101, "")
+	assert.EqualError(t, err, "Invalid rollout percentage 101, expected 0 to 100")
+	_, err = newRollout(-1, "")
+	assert.Error(t, err)
+	_, err = newRollout(50, "Team_A")
+	assert.Error(t, err)
+}
+
+func TestWorkloadKey(t *testing.T) {
+	controller := true
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		GenerateName:    "app-7d4b9c-",
+		Labels:          map[string]string{"pod-template-hash": "7d4b9c"},
+		OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "app-7d4b9c", Controller: &controller}},
+	}}
+	// Pods of a Deployment share its decision
+	assert.Equal(t, "team-a/app", workloadKey("team-a", deployment))
+	assert.Equal(t, "team-a/app", workloadKey("team-a", pod))
+
+	standalone := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "debug-"}}
+	assert.Equal(t, "team-a/debug-", workloadKey("team-a", standalone))
+}
+
+func TestRolloutIncludes(t *testing.T) {
+	var disabled *Rollout
+	assert.True(t, disabled.includes("team-a", &appsv1.Deployment{}))
+
+	workloads := make([]runtime.Object, 1000)
+	for i := range workloads {
+		workloads[i] = &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("app-%d", i)}}
+	}
+	included := func(rollout *Rollout, namespace string) map[int]bool {
+		included := map[int]bool{}
+		for i, workload := range workloads {
+			if rollout.includes(namespace, workload) {
+				included[i] = true
+			}
+		}
+		return included
+	}
+
+	assert.Empty(t, included(&Rollout{Percent: 0}, "team-a"))
+	assert.Len(t, included(&Rollout{Percent: 100}, "team-a"), len(workloads))
+
+	// Raising the percentage only adds workloads
+	quarter := included(&Rollout{Percent: 25}, "team-a")
+	half := included(&Rollout{Percent: 50}, "team-a")
+	assert.InDelta(t, 250, len(quarter), 50)
+	assert.InDelta(t, 500, len(half), 50)
+	for i := range quarter {
+		assert.True(t, half[i], i)
+	}
+
+	// Other namespaces aren't injected
+	assert.Len(t, included(&Rollout{Percent: 100, Namespaces: []string{"team-a"}}, "team-a"), len(workloads))
+	assert.Empty(t, included(&Rollout{Percent: 100, Namespaces: []string{"team-a"}}, "team-b"))
+}
+
+func TestMutateWithRollout(t *testing.T) {
+	mut := Mutate(Options{
+		DefaultInstance: "proj:eu:db",
+		Rollout:         &Rollout{Percent: 100, Namespaces: []string{"team-a"}},
+	})
+	for _, data := range []struct {
+		namespace string
+		inject    string
+		injected  bool
+	}{
+		{namespace: "team-a", inject: "true", injected: true},
+		{namespace: "team-b", inject: "true", injected: false},
+		// Forced injections aren't subject to the rollout
+		{namespace: "team-b", inject: injectForce, injected: true},
+	} {
+		pod := testPodWithAnnotations(t, map[string]string{annotationInject: data.inject})
+		raw, err := json.Marshal(pod)
+		require.NoError(t, err)
+
+		ar := mut(&v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Namespace: data.namespace,
+				Resource:  podResource,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		require.NotNil(t, ar)
+		assert.True(t, ar.Allowed)
+		assert.Equal(t, data.injected, len(ar.Patch) > 0, "%s %s", data.namespace, data.inject)
+	}
+}
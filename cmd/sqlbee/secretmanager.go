@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/connctd/sqlbee/pkg/secretmanager"
+)
+
+// The name of the Kubernetes secret credentials of Secret Manager are materialized as, if no secret is
+// specified
+const secretManagerSecretName = "cloud-sql-proxy-credentials"
+
+// materializedSecret is a Kubernetes secret materialized from a secret of Secret Manager
+type materializedSecret struct {
+	source string
+	name   string
+	key    string
+}
+
+// secretManagerSecret returns the secret of Secret Manager containing the credentials of the proxy
+// and the Kubernetes secret it is materialized as
+func secretManagerSecret(obj runtime.Object, opts Options) (*materializedSecret, error) {
+	source := annotationValue(obj, annotationSMSecret, opts.SecretManagerSecret)
+	if source == "" {
+		return nil, nil
+	}
+	if _, err := secretmanager.VersionName(source); err != nil {
+		return nil, fmt.Errorf("Invalid value of annotation %s: %s", annotationSMSecret, err)
+	}
+	if !secretmanager.Allowed(source, opts.SecretManagerAllowed) {
+		return nil, fmt.Errorf("Invalid value of annotation %s: secret %s is not allowed, allowed are %s", annotationSMSecret, source, strings.Join(opts.SecretManagerAllowed, ", "))
+	}
+	secretKey := defaultSecretKey
+	if opts.SecretKey != "" {
+		secretKey = opts.SecretKey
+	}
+	name := annotationValue(obj, annotationSecret, opts.DefaultSecretName)
+	if name == "" {
+		name = secretManagerSecretName
+	}
+	return &materializedSecret{
+		source: source,
+		name:   name,
+		key:    annotationValue(obj, annotationSecretKey, secretKey),
+	}, nil
+}
+
+// materializedSecrets returns the secrets of Secret Manager which need to be materialized for the
+// injected proxies, including the ones of named instances
+func materializedSecrets(obj runtime.Object, podSpec *corev1.PodSpec, proxyName string, opts Options) []materializedSecret {
+	objects := []runtime.Object{}
+	if hasContainer(podSpec, proxyName) {
+		objects = append(objects, obj)
+	}
+	for _, name := range namedInstances(obj) {
+		objects = append(objects, namedInstanceObject(obj, name))
+	}
+	secrets := []materializedSecret{}
+	for _, object := range objects {
+		// Invalid secrets have already been refused while configuring the proxies
+		if secret, _ := secretManagerSecret(object, opts); secret != nil {
+			secrets = append(secrets, *secret)
+		}
+	}
+	return secrets
+}
+
+// materializeSecrets creates or refreshes the Kubernetes secrets containing the credentials of Secret
+// Manager in the namespace, before the injected proxies reference them
+func materializeSecrets(ctx context.Context, materializer secretmanager.Materializer, namespace string, secrets []materializedSecret) error {
+	for _, secret := range secrets {
+		if err := materializer.Materialize(ctx, secret.source, namespace, secret.name, secret.key); err != nil {
+			return fmt.Errorf("Failed to materialize %s as secret %s: %s", secret.source, secret.name, err)
+		}
+	}
+	return nil
+}
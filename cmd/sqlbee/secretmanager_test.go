@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingMaterializer struct {
+	secrets []string
+}
+
+func (r *recordingMaterializer) Materialize(ctx context.Context, source, namespace, name, key string) error {
+	r.secrets = append(r.secrets, strings.Join([]string{source, namespace, name, key}, " "))
+	return nil
+}
+
+func TestMutateMaterializesSecretManagerSecrets(t *testing.T) {
+	materializer := &recordingMaterializer{}
+	raw := strings.Replace(podJson, `"sqlbee.connctd.io.inject": "true"`, `"sqlbee.connctd.io.inject": "true",
+         "sqlbee.connctd.io.instance.reporting": "proj:eu:reporting",
+         "sqlbee.connctd.io.secret.reporting": "reporting-credentials",
+         "sqlbee.connctd.io.secretManagerSecret.reporting": "projects/p/secrets/reporting/versions/2"`, 1)
+	require.NotEqual(t, podJson, raw)
+	opts := Options{
+		DefaultInstance:      "proj:eu:db",
+		SecretManagerSecret:  "projects/p/secrets/sql",
+		SecretManagerAllowed: []string{"projects/p/secrets/sql", "projects/p/secrets/reporting"},
+		SecretManager:        materializer,
+	}
+	mut := Mutate(opts)
+
+	for _, dryRun := range []bool{false, true} {
+		dryRun := dryRun
+		ar := mut(&v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Namespace: "team-a",
+				Resource:  podResource,
+				DryRun:    &dryRun,
+				Object: runtime.RawExtension{
+					Raw: []byte(raw),
+				},
+			},
+		})
+		require.NotNil(t, ar)
+		require.True(t, ar.Allowed)
+		assert.Contains(t, string(ar.Patch), `"secretName":"cloud-sql-proxy-credentials"`)
+		assert.Contains(t, string(ar.Patch), `"secretName":"reporting-credentials"`)
+	}
+	// Dry run requests don't materialize secrets
+	assert.Equal(t, []string{
+		"projects/p/secrets/sql team-a cloud-sql-proxy-credentials credentials.json",
+		"projects/p/secrets/reporting/versions/2 team-a reporting-credentials credentials.json",
+	}, materializer.secrets)
+
+	// Secrets which aren't allowed are refused and not materialized
+	for _, source := range []string{"projects/p/secrets/other", "projects/p/secrets/sql-admin", "projects/other/secrets/sql"} {
+		ar := reviewPod(t, opts, "team-a", testPodWithAnnotations(t, map[string]string{annotationSMSecret: source}))
+		require.NotNil(t, ar)
+		assert.False(t, ar.Allowed, source)
+		assert.Contains(t, ar.Result.Message, "not allowed", source)
+	}
+	assert.Len(t, materializer.secrets, 2)
+
+	ar := Mutate(Options{DefaultInstance: "proj:eu:db", SecretManagerSecret: "sql"})(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Resource: podResource,
+			Object: runtime.RawExtension{
+				Raw: []byte(podJson),
+			},
+		},
+	})
+	require.NotNil(t, ar)
+	assert.False(t, ar.Allowed)
+}
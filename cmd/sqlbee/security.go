@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+// The proxy runs as the nonroot user of its distroless image by default
+const defaultProxyUser = 65532
+
+// Kinds of the preStop hook delaying the termination of the proxy. The sleep action is enabled by default
+// since Kubernetes 1.30, on older clusters sleep is executed within the proxy container, which requires
+// an image containing it like the -alpine variants
+const (
+	preStopHookSleep = "sleep"
+	preStopHookExec  = "exec"
+	// selects the kind based on the version of the cluster at startup
+	preStopHookAuto = "auto"
+
+	sleepActionMajor = 1
+	sleepActionMinor = 30
+)
+
+// seccompRuntimeDefault restricts the syscalls of the proxy to the defaults of the container runtime.
+// Seccomp profiles aren't known to our API types, so they are set on the serialized container
+var seccompRuntimeDefault = map[string]interface{}{
+	"securityContext": map[string]interface{}{
+		"seccompProfile": map[string]interface{}{"type": "RuntimeDefault"},
+	},
+}
+
+// isHardened determines whether the proxy runs with a hardened security context. In FUSE mode the
+// proxy needs to be privileged. In restricted mode the hardening can't be disabled
+func isHardened(obj runtime.Object, opts Options) bool {
+	return (!opts.DisableHardening || opts.PodSecurityRestricted) && !isFuse(obj, opts)
+}
+
+// hardenedSecurityContext returns a security context which satisfies the restricted pod security
+// standard, except the seccomp profile
+func hardenedSecurityContext(opts Options) *corev1.SecurityContext {
+	user := opts.ProxyUser
+	if user == 0 {
+		user = defaultProxyUser
+	}
+	nonRoot, readOnly, escalation := true, true, false
+	return &corev1.SecurityContext{
+		RunAsUser:                &user,
+		RunAsGroup:               &user,
+		RunAsNonRoot:             &nonRoot,
+		ReadOnlyRootFilesystem:   &readOnly,
+		AllowPrivilegeEscalation: &escalation,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+}
+
+// proxyFields returns the fields set on the serialized proxy containers of the object
+func proxyFields(obj runtime.Object, opts Options) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if isHardened(obj, opts) {
+		mergeFields(fields, seccompRuntimeDefault)
+	}
+	delay, err := preStopDelay(obj, opts)
+	if err != nil {
+		return nil, sting.Invalid(err.Error())
+	}
+	if delay > 0 {
+		mergeFields(fields, preStopSleep(delay, opts.PreStopHook))
+	}
+	return fields, nil
+}
+
+// mergeFields sets the fields on the object unless they are already set. Nested objects are merged
+func mergeFields(obj, fields map[string]interface{}) {
+	for key, value := range fields {
+		nested, isObject := value.(map[string]interface{})
+		existing, exists := obj[key]
+		if !exists {
+			if isObject {
+				// Copy nested objects so the fields aren't shared between containers
+				copied := map[string]interface{}{}
+				mergeFields(copied, nested)
+				value = copied
+			}
+			obj[key] = value
+			continue
+		}
+		if existingObject, ok := existing.(map[string]interface{}); ok && isObject {
+			mergeFields(existingObject, nested)
+		}
+	}
+}
+
+// preStopDelay determines how long the termination of the proxy is delayed
+func preStopDelay(obj runtime.Object, opts Options) (time.Duration, error) {
+	value := annotationValue(obj, annotationPreStop)
+	if value == "" {
+		return opts.PreStopDelay, nil
+	}
+	delay, err := time.ParseDuration(value)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("Invalid value of annotation %s: %q is no valid duration", annotationPreStop, value)
+	}
+	return delay, nil
+}
+
+// detectPreStopHook chooses the sleep action if the cluster supports it by default
+func detectPreStopHook(ctx context.Context, getter versionGetter) (string, error) {
+	version, err := getter.ServerVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	supported, err := version.AtLeast(sleepActionMajor, sleepActionMinor)
+	if err != nil {
+		return "", err
+	}
+	if supported {
+		return preStopHookSleep, nil
+	}
+	return preStopHookExec, nil
+}
+
+// preStopSleep returns a preStop hook which delays the termination of the proxy, so it keeps serving
+// while the application finishes in-flight requests. The image of the proxy contains no shell, so the
+// sleep action of Kubernetes is used unless the hook executes sleep. The action isn't known to our API
+// types, so the hook is set on the serialized container
+func preStopSleep(delay time.Duration, hook string) map[string]interface{} {
+	seconds := int64((delay + time.Second - 1) / time.Second)
+	action := map[string]interface{}{
+		"sleep": map[string]interface{}{"seconds": seconds},
+	}
+	if hook == preStopHookExec {
+		action = map[string]interface{}{
+			"exec": map[string]interface{}{"command": []interface{}{"sleep", strconv.FormatInt(seconds, 10)}},
+		}
+	}
+	return map[string]interface{}{
+		"lifecycle": map[string]interface{}{
+			"preStop": action,
+		},
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHardenedSecurityContext(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		hardened    bool
+		user        int64
+	}{
+		{annotations: map[string]string{}, hardened: true, user: defaultProxyUser},
+		{annotations: map[string]string{}, opts: Options{ProxyUser: 1000}, hardened: true, user: 1000},
+		{annotations: map[string]string{}, opts: Options{DisableHardening: true}},
+		{annotations: map[string]string{annotationFuse: "true"}},
+		{annotations: map[string]string{annotationSecCtx: `{"runAsUser":2000}`}, hardened: true, user: 2000},
+	} {
+		pod, proxyContainer := mutatePod(t, data.opts, data.annotations)
+		assert.Equal(t, data.hardened, isHardened(pod, data.opts))
+
+		fields, err := proxyFields(pod, data.opts)
+		require.NoError(t, err)
+		securityContext := proxyContainer.SecurityContext
+		if !data.hardened {
+			if securityContext != nil {
+				assert.Nil(t, securityContext.RunAsNonRoot)
+			}
+			assert.Empty(t, fields)
+			continue
+		}
+		require.NotNil(t, securityContext)
+		assert.True(t, *securityContext.RunAsNonRoot)
+		assert.Equal(t, data.user, *securityContext.RunAsUser)
+		assert.True(t, *securityContext.ReadOnlyRootFilesystem)
+		assert.False(t, *securityContext.AllowPrivilegeEscalation)
+		assert.Equal(t, []corev1.Capability{"ALL"}, securityContext.Capabilities.Drop)
+		assert.Equal(t, seccompRuntimeDefault, fields)
+	}
+}
+
+func TestMergeFields(t *testing.T) {
+	container := map[string]interface{}{
+		"name":            "cloud-sql-proxy",
+		"securityContext": map[string]interface{}{"runAsNonRoot": true},
+	}
+	mergeFields(container, seccompRuntimeDefault)
+	assert.Equal(t, map[string]interface{}{
+		"runAsNonRoot":   true,
+		"seccompProfile": map[string]interface{}{"type": "RuntimeDefault"},
+	}, container["securityContext"])
+
+	// Existing fields are kept and the defaults aren't shared
+	localhost := map[string]interface{}{"securityContext": map[string]interface{}{"seccompProfile": map[string]interface{}{"type": "Localhost"}}}
+	mergeFields(localhost, seccompRuntimeDefault)
+	assert.Equal(t, "Localhost", localhost["securityContext"].(map[string]interface{})["seccompProfile"].(map[string]interface{})["type"])
+
+	empty := map[string]interface{}{}
+	mergeFields(empty, seccompRuntimeDefault)
+	empty["securityContext"].(map[string]interface{})["seccompProfile"].(map[string]interface{})["type"] = "Unconfined"
+	assert.Equal(t, "RuntimeDefault", seccompRuntimeDefault["securityContext"].(map[string]interface{})["seccompProfile"].(map[string]interface{})["type"])
+}
+
+func TestPreStopDelay(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		seconds     int64
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{}, opts: Options{PreStopDelay: 10 * time.Second}, seconds: 10},
+		{annotations: map[string]string{annotationPreStop: "1500ms"}, seconds: 2},
+		{annotations: map[string]string{annotationPreStop: "0s"}, opts: Options{PreStopDelay: 10 * time.Second}},
+	} {
+		pod := testPodWithAnnotations(t, data.annotations)
+		fields, err := proxyFields(pod, Options{DisableHardening: true, PreStopDelay: data.opts.PreStopDelay})
+		require.NoError(t, err)
+		if data.seconds == 0 {
+			assert.Empty(t, fields, data.annotations)
+			continue
+		}
+		assert.Equal(t, preStopSleep(time.Duration(data.seconds)*time.Second, ""), fields)
+	}
+
+	// Clusters without the sleep action execute sleep within the proxy container
+	fields, err := proxyFields(testPodWithAnnotations(t, nil), Options{DisableHardening: true, PreStopDelay: 5 * time.Second, PreStopHook: preStopHookExec})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"exec": map[string]interface{}{"command": []interface{}{"sleep", "5"}}}, fields["lifecycle"].(map[string]interface{})["preStop"])
+
+	pod := testPodWithAnnotations(t, map[string]string{annotationPreStop: "-5s"})
+	_, _, err = configureProxy(pod, Options{})
+	assert.Error(t, err)
+
+	// Distroless images contain no sleep to execute
+	opts := Options{PreStopDelay: 5 * time.Second, PreStopHook: preStopHookExec}
+	_, _, err = configureProxy(testPodWithAnnotations(t, nil), opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires an image containing sleep")
+	_, _, err = configureProxy(testPodWithAnnotations(t, map[string]string{annotationImage: defaultImage + "-alpine"}), opts)
+	assert.NoError(t, err)
+	_, _, err = configureProxy(testPodWithAnnotations(t, map[string]string{annotationPreStop: "0s"}), opts)
+	assert.NoError(t, err)
+}
+
+func TestMutatePreStopDelay(t *testing.T) {
+	raw, err := json.Marshal(testPodWithAnnotations(t, map[string]string{annotationInstance: "proj:eu:db"}))
+	require.NoError(t, err)
+	ar := Mutate(Options{PreStopDelay: 5 * time.Second})(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Resource: podResource,
+			Object:   runtime.RawExtension{Raw: raw},
+		},
+	})
+	require.True(t, ar.Allowed)
+	assert.Contains(t, string(ar.Patch), `"lifecycle":{"preStop":{"sleep":{"seconds":5}}}`)
+}
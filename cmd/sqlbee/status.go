@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Labels of pod templates recording the injection, so operators can audit it via label selectors
+const (
+	labelStatus       = "sqlbee.connctd.io/status"
+	labelProxyVersion = "sqlbee.connctd.io/proxy-version"
+	statusInjected    = "injected"
+)
+
+// imageVersion returns the tag of an image, if it is a valid label value. Images referenced only by
+// digest or without tag have no version
+func imageVersion(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	version := image[i+1:]
+	if len(validation.IsValidLabelValue(version)) > 0 {
+		return ""
+	}
+	return version
+}
+
+// setStatus records the injection of the proxy with the given image in the labels of the pod template
+func setStatus(obj runtime.Object, image string) {
+	labels := templateLabels(obj)
+	if labels == nil {
+		return
+	}
+	if *labels == nil {
+		*labels = map[string]string{}
+	}
+	(*labels)[labelStatus] = statusInjected
+	if version := imageVersion(image); version != "" {
+		(*labels)[labelProxyVersion] = version
+	} else {
+		delete(*labels, labelProxyVersion)
+	}
+}
+
+// removeStatus removes the labels recording the injection from the pod template
+func removeStatus(obj runtime.Object) {
+	labels := templateLabels(obj)
+	if labels == nil {
+		return
+	}
+	delete(*labels, labelStatus)
+	delete(*labels, labelProxyVersion)
+}
+
+// isInjected checks whether the labels of the pod template of an object record the injection
+func isInjected(obj runtime.Object) bool {
+	labels := templateLabels(obj)
+	return labels != nil && (*labels)[labelStatus] == statusInjected
+}
+
+// isInjectedByController checks whether a pod has been created by a controller from an injected pod
+// template, so the proxy has already been injected with the template
+func isInjectedByController(obj runtime.Object) bool {
+	pod, isPod := obj.(*corev1.Pod)
+	return isPod && metav1.GetControllerOf(pod) != nil && isInjected(pod)
+}
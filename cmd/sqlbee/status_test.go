@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageVersion(t *testing.T) {
+	for image, version := range map[string]string{
+		"gcr.io/cloudsql-docker/gce-proxy:1.33.1":           "1.33.1",
+		"registry:5000/cloudsql/gce-proxy":                  "",
+		"registry:5000/cloudsql/gce-proxy:latest":           "latest",
+		"gcr.io/cloudsql-docker/gce-proxy@sha256:ab":        "",
+		"gcr.io/cloudsql-docker/gce-proxy:1.33.1@sha256:ab": "1.33.1",
+		"gce-proxy": "",
+	} {
+		assert.Equal(t, version, imageVersion(image), image)
+	}
+}
+
+func TestStatusLabels(t *testing.T) {
+	pod := testPodWithAnnotations(t, nil)
+	pod.Labels = nil
+	setStatus(pod, "gcr.io/cloudsql-docker/gce-proxy:1.33.1")
+	assert.Equal(t, map[string]string{labelStatus: statusInjected, labelProxyVersion: "1.33.1"}, pod.Labels)
+	assert.True(t, isInjected(pod))
+
+	removeStatus(pod)
+	assert.Empty(t, pod.Labels)
+	assert.False(t, isInjected(pod))
+}
+
+func TestMutateSkipsInjectedPods(t *testing.T) {
+	opts := Options{DefaultInstance: "proj:eu:db"}
+	pod, proxyContainer := mutatePod(t, opts, nil)
+	setStatus(pod, proxyContainer.Image)
+
+	ar := reviewPod(t, opts, "", pod)
+	assert.True(t, ar.Allowed)
+	assert.Empty(t, ar.Patch)
+}
+
+func TestMutateSkipsPodsOfInjectedControllers(t *testing.T) {
+	controller := true
+	for _, data := range []struct {
+		owners   []metav1.OwnerReference
+		injected bool
+	}{
+		{owners: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "wordpress-5d8f", Controller: &controller}}, injected: false},
+		{owners: []metav1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: "wordpress"}}, injected: true},
+		{owners: nil, injected: true},
+	} {
+		// The status label has been set on the template of the owner, the pod itself isn't mutated yet
+		pod := testPodWithAnnotations(t, nil)
+		pod.OwnerReferences = data.owners
+		pod.Labels = map[string]string{labelStatus: statusInjected}
+
+		ar := reviewPod(t, Options{DefaultInstance: "proj:eu:db"}, "", pod)
+		assert.True(t, ar.Allowed)
+		assert.Equal(t, data.injected, len(ar.Patch) > 0, "%v", data.owners)
+	}
+}
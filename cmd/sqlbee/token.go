@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// The volume providing the service account token to proxies of pods which don't mount it
+	serviceAccountTokenVolume = "sql-service-account-token"
+	// The proxy expects the token where Kubernetes mounts it by default
+	serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// requested validity of the token, like the one Kubernetes mounts by default
+	serviceAccountTokenExpiration int64 = 3607
+)
+
+// The flags of both versions of the proxy passing credentials, without their leading dashes
+var credentialFlags = map[string]bool{
+	"credential_file":  true,
+	"json_credentials": true,
+	"credentials-file": true,
+	"json-credentials": true,
+}
+
+// serviceAccountTokenSource returns a projected volume source equivalent to the one Kubernetes mounts
+// by default, containing the token, the root certificate of the cluster and the namespace
+func serviceAccountTokenSource() corev1.VolumeSource {
+	expiration := serviceAccountTokenExpiration
+	return corev1.VolumeSource{
+		Projected: &corev1.ProjectedVolumeSource{
+			Sources: []corev1.VolumeProjection{
+				{
+					ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+						ExpirationSeconds: &expiration,
+						Path:              "token",
+					},
+				},
+				{
+					ConfigMap: &corev1.ConfigMapProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"},
+						Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+					},
+				},
+				{
+					DownwardAPI: &corev1.DownwardAPIProjection{
+						Items: []corev1.DownwardAPIVolumeFile{{
+							Path:     "namespace",
+							FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.namespace"},
+						}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// usesMetadataCredentials checks whether the proxy authenticates via the identity of the pod instead
+// of a credential file. The flags are recognized with one or two dashes, like both versions accept them
+func usesMetadataCredentials(container corev1.Container) bool {
+	for _, env := range container.Env {
+		if env.Name == credentialsEnv {
+			return false
+		}
+	}
+	for _, arg := range container.Command {
+		name := strings.TrimLeft(strings.SplitN(arg, "=", 2)[0], "-")
+		if strings.HasPrefix(arg, "-") && credentialFlags[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// configureServiceAccountToken mounts the service account token explicitly into the proxies relying
+// on the identity of the pod, if the pod disables the automount of the token. Tokens mounted by the
+// workload itself are kept. Returns whether the token has been mounted
+func configureServiceAccountToken(podSpec *corev1.PodSpec, proxyName string) bool {
+	if podSpec.AutomountServiceAccountToken == nil || *podSpec.AutomountServiceAccountToken {
+		return false
+	}
+	mounted := false
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for i := range containers {
+			container := &containers[i]
+			if !isProxyContainer(*container, proxyName) || !usesMetadataCredentials(*container) {
+				continue
+			}
+			exists := false
+			for _, mount := range container.VolumeMounts {
+				exists = exists || mount.MountPath == serviceAccountTokenPath
+			}
+			if !exists {
+				container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+					Name:      serviceAccountTokenVolume,
+					MountPath: serviceAccountTokenPath,
+					ReadOnly:  true,
+				})
+				mounted = true
+			}
+		}
+	}
+	if mounted {
+		replaceVolumes([]corev1.Volume{{Name: serviceAccountTokenVolume, VolumeSource: serviceAccountTokenSource()}}, podSpec)
+	}
+	return mounted
+}
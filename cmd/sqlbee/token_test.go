@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureServiceAccountToken(t *testing.T) {
+	disabled := false
+	pod := testPodWithAnnotations(t, map[string]string{})
+	pod.Spec.AutomountServiceAccountToken = &disabled
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	opts := Options{DefaultInstance: "proj:eu:db"}
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, opts))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+
+	assert.True(t, configureServiceAccountToken(&pod.Spec, proxyContainer.Name))
+	proxy := pod.Spec.Containers[len(pod.Spec.Containers)-1]
+	assert.Contains(t, proxy.VolumeMounts, corev1.VolumeMount{Name: serviceAccountTokenVolume, MountPath: serviceAccountTokenPath, ReadOnly: true})
+	assert.NotContains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: serviceAccountTokenVolume, MountPath: serviceAccountTokenPath, ReadOnly: true})
+	assert.Contains(t, pod.Spec.Volumes, corev1.Volume{Name: serviceAccountTokenVolume, VolumeSource: serviceAccountTokenSource()})
+
+	// Re-injecting the pod causes no changes
+	assert.False(t, configureServiceAccountToken(&pod.Spec, proxyContainer.Name))
+}
+
+func TestServiceAccountTokenNotRequired(t *testing.T) {
+	disabled := false
+	enabled := true
+	for name, tc := range map[string]struct {
+		automount *bool
+		opts      Options
+	}{
+		"automount default": {nil, Options{DefaultInstance: "proj:eu:db"}},
+		"automount enabled": {&enabled, Options{DefaultInstance: "proj:eu:db"}},
+		"secret":            {&disabled, Options{DefaultInstance: "proj:eu:db", DefaultSecretName: "sql-credentials"}},
+		"secret version 2":  {&disabled, Options{DefaultInstance: "proj:eu:db", DefaultSecretName: "sql-credentials", DefaultImage: testImageV2}},
+	} {
+		pod := testPodWithAnnotations(t, map[string]string{})
+		pod.Spec.AutomountServiceAccountToken = tc.automount
+		proxyContainer := sqlProxyContainer.DeepCopy()
+		volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+		require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, tc.opts), name)
+		mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+
+		assert.False(t, configureServiceAccountToken(&pod.Spec, proxyContainer.Name), name)
+		for _, volume := range pod.Spec.Volumes {
+			assert.NotEqual(t, serviceAccountTokenVolume, volume.Name, name)
+		}
+	}
+}
+
+func TestUsesMetadataCredentials(t *testing.T) {
+	for _, data := range []struct {
+		command  []string
+		metadata bool
+	}{
+		{command: []string{"/cloud_sql_proxy", "-dir=/cloudsql"}, metadata: true},
+		{command: []string{"/cloud_sql_proxy", "-credential_file=/credentials/credentials.json"}},
+		{command: []string{"/cloud_sql_proxy", "-json_credentials=$(CLOUDSQL_CREDENTIALS)"}},
+		{command: []string{"/cloud-sql-proxy", "--unix-socket=/cloudsql"}, metadata: true},
+		{command: []string{"/cloud-sql-proxy", "--credentials-file=/credentials/credentials.json"}},
+		{command: []string{"/cloud-sql-proxy", "--credentials-file", "/credentials/credentials.json"}},
+		{command: []string{"/cloud-sql-proxy", "--json-credentials=$(CLOUDSQL_CREDENTIALS)"}},
+	} {
+		assert.Equal(t, data.metadata, usesMetadataCredentials(corev1.Container{Command: data.command}), "%v", data.command)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+const (
+	// Credentials rendered by the Vault Agent injector from a secret stored in Vault
+	credentialsSourceVault = "vault"
+
+	// The annotations of the Vault Agent injector and the directory it renders the secrets to
+	vaultAnnotationBase   = "vault.hashicorp.com/"
+	vaultAnnotationInject = vaultAnnotationBase + "agent-inject"
+	vaultAnnotationRole   = vaultAnnotationBase + "role"
+	vaultAnnotationSecret = vaultAnnotationBase + "agent-inject-secret-"
+	vaultAnnotationTmpl   = vaultAnnotationBase + "agent-inject-template-"
+	vaultSecretsPath      = "/vault/secrets"
+)
+
+var vaultFileReplacer = strings.NewReplacer("/", "-", ".", "-")
+
+// vaultFile returns the name of the file the credentials at the Vault path are rendered to. Proxies
+// using the same secret share the file
+func vaultFile(secretPath, secretKey string) string {
+	return strings.Trim(vaultFileReplacer.Replace(secretPath), "-") + "-" + secretKey
+}
+
+// vaultTemplate renders the field of the secret named like the secret key, which contains the JSON
+// credentials. Secrets of the KV version 2 engine nest their fields within data
+func vaultTemplate(secretPath, secretKey string) string {
+	return fmt.Sprintf(`{{- with secret %q -}}{{ index .Data.data %q }}{{- end }}`, secretPath, secretKey)
+}
+
+// configureCredentialsVault returns the path of the credentials rendered by the Vault Agent injector.
+// The annotations requesting them are set by configureVault
+func configureCredentialsVault(secretPath, secretKey string) (string, error) {
+	if secretPath == "" {
+		return "", fmt.Errorf("Credentials rendered by the Vault Agent injector require the path of the secret via annotation %s", annotationVaultPath)
+	}
+	return path.Join(vaultSecretsPath, vaultFile(secretPath, secretKey)), nil
+}
+
+// configureVault annotates the pod template, so the Vault Agent injector renders the credentials of the
+// proxies using the vault credentials source. Annotations set by the workload itself are kept. All
+// proxies need to authenticate with the same Vault role
+func configureVault(obj runtime.Object, podSpec *corev1.PodSpec, proxyName string, opts Options) error {
+	objects := []runtime.Object{}
+	if hasContainer(podSpec, proxyName) {
+		objects = append(objects, obj)
+	}
+	for _, name := range namedInstances(obj) {
+		objects = append(objects, namedInstanceObject(obj, name))
+	}
+
+	type vaultSecret struct {
+		path string
+		key  string
+	}
+	secrets := map[string]vaultSecret{}
+	role := ""
+	for _, object := range objects {
+		if annotationValue(object, annotationCredSource, opts.CredentialsSource) != credentialsSourceVault {
+			continue
+		}
+		objectRole := annotationValue(object, annotationVaultRole, opts.VaultRole)
+		if objectRole == "" {
+			return sting.Invalid(fmt.Sprintf("Credentials rendered by the Vault Agent injector require a Vault role via annotation %s", annotationVaultRole))
+		}
+		if role != "" && role != objectRole {
+			return sting.Invalid(fmt.Sprintf("All proxies need to use the same Vault role, got %s and %s", role, objectRole))
+		}
+		role = objectRole
+
+		secretKey := defaultSecretKey
+		if opts.SecretKey != "" {
+			secretKey = opts.SecretKey
+		}
+		secretKey = annotationValue(object, annotationSecretKey, secretKey)
+		secretPath := annotationValue(object, annotationVaultPath, opts.VaultSecretPath)
+		secrets[vaultFile(secretPath, secretKey)] = vaultSecret{path: secretPath, key: secretKey}
+	}
+	if role == "" {
+		return nil
+	}
+
+	annotations := templateAnnotations(obj)
+	if annotations == nil {
+		return nil
+	}
+	if *annotations == nil {
+		*annotations = map[string]string{}
+	}
+	set := func(key, value string) {
+		if _, exists := (*annotations)[key]; !exists {
+			(*annotations)[key] = value
+		}
+	}
+	set(vaultAnnotationInject, "true")
+	set(vaultAnnotationRole, role)
+	for file, secret := range secrets {
+		set(vaultAnnotationSecret+file, secret.path)
+		set(vaultAnnotationTmpl+file, vaultTemplate(secret.path, secret.key))
+	}
+	return nil
+}
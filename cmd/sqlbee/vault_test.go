@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureVault(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationCredSource:                "vault",
+		annotationInstance + ".reporting":   "proj:eu:reporting",
+		annotationVaultPath + ".reporting":  "secret/data/reporting",
+		vaultAnnotationSecret + "gcp-other": "secret/data/other",
+	})
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	opts := Options{DefaultInstance: "proj:eu:db", VaultRole: "sqlbee", VaultSecretPath: "secret/data/gcp"}
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, opts))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	require.NoError(t, configureNamedInstances(context.Background(), pod, proxyContainer.Name, 3306, &pod.Spec, opts))
+	require.NoError(t, configureVault(pod, &pod.Spec, proxyContainer.Name, opts))
+
+	assert.Contains(t, proxyContainer.Command, "-credential_file=/vault/secrets/secret-data-gcp-credentials.json")
+	assert.Equal(t, "true", pod.Annotations[vaultAnnotationInject])
+	assert.Equal(t, "sqlbee", pod.Annotations[vaultAnnotationRole])
+	assert.Equal(t, "secret/data/gcp", pod.Annotations[vaultAnnotationSecret+"secret-data-gcp-credentials.json"])
+	assert.Equal(t, `{{- with secret "secret/data/gcp" -}}{{ index .Data.data "credentials.json" }}{{- end }}`,
+		pod.Annotations[vaultAnnotationTmpl+"secret-data-gcp-credentials.json"])
+	assert.Equal(t, "secret/data/reporting", pod.Annotations[vaultAnnotationSecret+"secret-data-reporting-credentials.json"])
+	assert.Equal(t, "secret/data/other", pod.Annotations[vaultAnnotationSecret+"gcp-other"])
+
+	// All proxies need to use the same role
+	pod.Annotations[annotationVaultRole+".reporting"] = "reporting"
+	assert.Error(t, configureVault(pod, &pod.Spec, proxyContainer.Name, opts))
+}
+
+func TestCredentialsVaultRequiresPath(t *testing.T) {
+	pod := testPodWithAnnotations(t, map[string]string{annotationCredSource: "vault"})
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	assert.Error(t, configureContainerAndVolumes(context.Background(), pod, sqlProxyContainer.DeepCopy(), &volumes, Options{DefaultInstance: "proj:eu:db"}))
+
+	// Without role the Vault Agent injector can't authenticate
+	pod = testPodWithAnnotations(t, map[string]string{annotationCredSource: "vault"})
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "cloud-sql-proxy", Command: []string{"/cloud_sql_proxy"}})
+	assert.Error(t, configureVault(pod, &pod.Spec, "cloud-sql-proxy", Options{VaultSecretPath: "secret/data/gcp"}))
+}
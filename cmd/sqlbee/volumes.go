@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Default names and mount paths of the volumes SQLBee injects
+const (
+	defaultSocketVolume      = "cloudsql"
+	defaultSocketPath        = "/cloudsql"
+	defaultCredentialsVolume = "sql-service-token-account"
+	defaultCredentialsPath   = "/credentials"
+	defaultCAVolume          = "sql-ca-certificates"
+	defaultCAPath            = "/etc/ssl/certs"
+)
+
+// withVolumeDefaults returns a copy of opts with the default volume names and mount paths applied
+// to all fields which aren't configured
+func withVolumeDefaults(opts Options) Options {
+	for field, def := range map[*string]string{
+		&opts.SocketVolume:      defaultSocketVolume,
+		&opts.SocketPath:        defaultSocketPath,
+		&opts.CredentialsVolume: defaultCredentialsVolume,
+		&opts.CredentialsPath:   defaultCredentialsPath,
+		&opts.CAVolume:          defaultCAVolume,
+		&opts.CAPath:            defaultCAPath,
+	} {
+		if *field == "" {
+			*field = def
+		}
+	}
+	return opts
+}
+
+// applySocketVolume applies the configured name and mount path to the socket volume, which is part
+// of the predefined container and volumes
+func applySocketVolume(container *corev1.Container, volumes []corev1.Volume, opts Options) {
+	for i := range container.VolumeMounts {
+		if container.VolumeMounts[i].Name == defaultSocketVolume {
+			container.VolumeMounts[i].Name = opts.SocketVolume
+			container.VolumeMounts[i].MountPath = opts.SocketPath
+		}
+	}
+	for i := range volumes {
+		if volumes[i].Name == defaultSocketVolume {
+			volumes[i].Name = opts.SocketVolume
+		}
+	}
+}
+
+// configureSocketVolume sets the storage medium and the size limit of the socket volume. Backed by
+// memory the sockets and temporary files of the proxy cause no disk I/O and don't count against
+// ephemeral storage quotas
+func configureSocketVolume(obj runtime.Object, volumes []corev1.Volume, opts Options) error {
+	medium := corev1.StorageMedium(annotationValue(obj, annotationMedium, opts.SocketMedium))
+	if medium != corev1.StorageMediumDefault && medium != corev1.StorageMediumMemory {
+		return fmt.Errorf("Invalid value of annotation %s: unsupported medium %q", annotationMedium, medium)
+	}
+	var sizeLimit *resource.Quantity
+	if value := annotationValue(obj, annotationSocketSize, opts.SocketSizeLimit); value != "" {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("Invalid value of annotation %s: %s", annotationSocketSize, err)
+		}
+		sizeLimit = &quantity
+	}
+
+	for i := range volumes {
+		if volumes[i].Name == opts.SocketVolume && volumes[i].EmptyDir != nil {
+			// The predefined volumes are shared between requests
+			volumes[i].EmptyDir = &corev1.EmptyDirVolumeSource{Medium: medium, SizeLimit: sizeLimit}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigurableVolumes(t *testing.T) {
+	opts := Options{
+		SocketVolume:      "proxy-sockets",
+		SocketPath:        "/sockets",
+		CredentialsVolume: "proxy-credentials",
+		CredentialsPath:   "/var/run/credentials",
+		CAVolume:          "proxy-ca",
+		CAPath:            "/certs",
+	}
+	pod := testPodWithAnnotations(t, map[string]string{
+		annotationFuse:   "true",
+		annotationSecret: "creds",
+		annotationCaMap:  "ca",
+	})
+	proxyContainer := sqlProxyContainer.DeepCopy()
+	volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+	require.NoError(t, configureContainerAndVolumes(context.Background(), pod, proxyContainer, &volumes, opts))
+	mutatePodSpec(volumes, proxyContainer, &pod.Spec)
+	configureAppContainers(pod, proxyContainer, &pod.Spec, opts)
+
+	assert.Contains(t, proxyContainer.Command, "-dir=/sockets")
+	assert.Contains(t, proxyContainer.Command, "-credential_file=/var/run/credentials/credentials.json")
+
+	mounts := map[string]string{}
+	for _, mount := range proxyContainer.VolumeMounts {
+		mounts[mount.Name] = mount.MountPath
+	}
+	assert.Equal(t, map[string]string{
+		"proxy-sockets":     "/sockets",
+		"proxy-credentials": "/var/run/credentials",
+		"proxy-ca":          "/certs",
+	}, mounts)
+	names := volumeNames(&pod.Spec)
+	for name := range mounts {
+		assert.Contains(t, names, name)
+	}
+	assert.NotContains(t, names, defaultSocketVolume)
+	assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:             "proxy-sockets",
+		MountPath:        "/sockets",
+		MountPropagation: &fuseAppPropagation,
+	})
+
+	require.True(t, removeProxies(&pod.Spec, proxyContainer.Name, opts.SocketVolume))
+	assert.Equal(t, testPodWithAnnotations(t, nil).Spec.Volumes, pod.Spec.Volumes)
+}
+
+func quantity(value string) *resource.Quantity {
+	q := resource.MustParse(value)
+	return &q
+}
+
+func TestSocketVolumeMedium(t *testing.T) {
+	for _, data := range []struct {
+		annotations map[string]string
+		opts        Options
+		emptyDir    corev1.EmptyDirVolumeSource
+	}{
+		{
+			emptyDir: corev1.EmptyDirVolumeSource{},
+		},
+		{
+			opts:     Options{SocketMedium: "Memory", SocketSizeLimit: "16Mi"},
+			emptyDir: corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory, SizeLimit: quantity("16Mi")},
+		},
+		{
+			annotations: map[string]string{annotationMedium: "", annotationSocketSize: "1Mi"},
+			opts:        Options{SocketMedium: "Memory"},
+			emptyDir:    corev1.EmptyDirVolumeSource{SizeLimit: quantity("1Mi")},
+		},
+	} {
+		pod := testPodWithAnnotations(t, data.annotations)
+		volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+		require.NoError(t, configureContainerAndVolumes(context.Background(), pod, sqlProxyContainer.DeepCopy(), &volumes, data.opts))
+		require.NotNil(t, volumes[0].EmptyDir)
+		assert.Equal(t, data.emptyDir, *volumes[0].EmptyDir)
+	}
+	// The predefined volume is left untouched
+	assert.Equal(t, corev1.EmptyDirVolumeSource{}, *sqlProxyVolumes[0].EmptyDir)
+
+	for _, annotations := range []map[string]string{
+		{annotationMedium: "HugePages"},
+		{annotationSocketSize: "lots"},
+	} {
+		pod := testPodWithAnnotations(t, annotations)
+		volumes := append([]corev1.Volume{}, sqlProxyVolumes...)
+		assert.Error(t, configureContainerAndVolumes(context.Background(), pod, sqlProxyContainer.DeepCopy(), &volumes, Options{}))
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// How long the postStart hook of the proxy waits for it to accept connections before the proxy is
+// restarted
+const waitForProxyTimeout = 60
+
+// isWaitForProxy determines whether the application containers are only started once the proxy
+// accepts connections. Native sidecars are started before the application containers anyway
+func isWaitForProxy(obj runtime.Object, opts Options) bool {
+	mode, err := injectionMode(obj, opts)
+	return err == nil && mode == modeSidecar && annotationBool(obj, annotationWait, opts.WaitForProxy)
+}
+
+// hasShell checks whether the image of the proxy contains a shell and nc, which only the -alpine
+// variants do. The other images of the proxy are distroless
+func hasShell(image string) bool {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	_, tag := splitImage(image)
+	return strings.HasSuffix(tag, "-alpine")
+}
+
+// waitForProxyHook returns a postStart hook which blocks until the proxy accepts connections on the
+// port. The kubelet starts the containers of a pod in order and only starts the next one once the
+// hook has completed, so the proxy needs to be placed before the application containers. An init
+// container can't wait for the proxy, as the proxy isn't started before all init containers have
+// completed. The hook requires a shell and nc within the image of the proxy, like the alpine
+// variants of the proxy images provide
+func waitForProxyHook(port int) *corev1.Lifecycle {
+	script := fmt.Sprintf("for i in $(seq %d); do nc -z 127.0.0.1 %d && exit 0; sleep 1; done; exit 1", waitForProxyTimeout, port)
+	return &corev1.Lifecycle{
+		PostStart: &corev1.Handler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"/bin/sh", "-c", script},
+			},
+		},
+	}
+}
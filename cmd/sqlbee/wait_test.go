@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForProxy(t *testing.T) {
+	opts := Options{DefaultInstance: "proj:eu:db", WaitForProxy: true, DefaultImage: defaultImage + "-alpine"}
+
+	pod, proxyContainer := mutatePod(t, opts, map[string]string{annotationPort: "5432"})
+	require.NotNil(t, proxyContainer.Lifecycle)
+	require.NotNil(t, proxyContainer.Lifecycle.PostStart)
+	command := proxyContainer.Lifecycle.PostStart.Exec.Command
+	require.Len(t, command, 3)
+	assert.Contains(t, command[2], "nc -z 127.0.0.1 5432")
+
+	// The proxy is placed before the application containers unless placed explicitly
+	placement, err := proxyPlacement(pod, opts)
+	require.NoError(t, err)
+	assert.Equal(t, placementFirst, placement)
+	pod = testPodWithAnnotations(t, map[string]string{annotationPlacement: "before:wordpress"})
+	placement, err = proxyPlacement(pod, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "before:wordpress", placement)
+
+	for _, annotations := range []map[string]string{
+		{annotationWait: "false"},
+		{annotationMode: modeInitSidecar},
+		{annotationFuse: "true"},
+	} {
+		_, proxyContainer := mutatePod(t, opts, annotations)
+		assert.Nil(t, proxyContainer.Lifecycle, "%v", annotations)
+	}
+
+	// Distroless images have no shell to run the hook
+	for image, valid := range map[string]bool{
+		defaultImage:                        false,
+		defaultImage + "-alpine@sha256:abc": true,
+		imageNameV2 + ":2.8.0-alpine":       true,
+		"registry.internal/alpine/proxy":    false,
+	} {
+		_, _, err := configureProxy(testPodWithAnnotations(t, map[string]string{annotationImage: image}), opts)
+		assert.Equal(t, valid, err == nil, image)
+	}
+}
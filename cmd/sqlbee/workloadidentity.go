@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+)
+
+// The annotation of Kubernetes service accounts binding them to a GCP service account via GKE workload
+// identity
+const annotationGCPServiceAccount = "iam.gke.io/gcp-service-account"
+
+// usesWorkloadIdentity checks whether any proxy of the podSpec authenticates via the identity of the pod
+func usesWorkloadIdentity(podSpec *corev1.PodSpec, proxyName string) bool {
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range containers {
+			if isProxyContainer(container, proxyName) && usesMetadataCredentials(container) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyWorkloadIdentity checks whether the service account of the pod is bound to a GCP service account,
+// so proxies without credentials can authenticate. Returns a description of the misconfiguration, telling
+// how to resolve it, or an empty string if the service account is bound
+func verifyWorkloadIdentity(ctx context.Context, getter kube.ServiceAccountGetter, namespace string, podSpec *corev1.PodSpec) (string, error) {
+	name := podSpec.ServiceAccountName
+	if name == "" {
+		name = "default"
+	}
+	serviceAccount, err := getter.GetServiceAccount(ctx, namespace, name)
+	if kube.IsNotFound(err) {
+		return fmt.Sprintf("The proxy authenticates via workload identity, but the service account %s doesn't exist in namespace %s", name, namespace), nil
+	} else if err != nil {
+		return "", err
+	}
+	if serviceAccount.Annotations[annotationGCPServiceAccount] == "" {
+		return fmt.Sprintf("The proxy authenticates via workload identity, but the service account %s in namespace %s has no annotation %s. Annotate it with the GCP service account or specify a credentials secret via annotation %s",
+			name, namespace, annotationGCPServiceAccount, annotationSecret), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+// staticServiceAccounts contains the service accounts of the namespace team-a by name
+type staticServiceAccounts map[string]*corev1.ServiceAccount
+
+func (s staticServiceAccounts) GetServiceAccount(ctx context.Context, namespace, name string) (*corev1.ServiceAccount, error) {
+	serviceAccount, exists := s[name]
+	if namespace != "team-a" || !exists {
+		return nil, &kube.StatusError{Code: http.StatusNotFound}
+	}
+	return serviceAccount, nil
+}
+
+func TestMutateVerifiesWorkloadIdentity(t *testing.T) {
+	bound := &corev1.ServiceAccount{}
+	bound.Annotations = map[string]string{annotationGCPServiceAccount: "sql@proj.iam.gserviceaccount.com"}
+	serviceAccounts := staticServiceAccounts{"default": &corev1.ServiceAccount{}, "bound": bound}
+
+	for _, data := range []struct {
+		mode           string
+		secret         string
+		serviceAccount string
+		allowed        bool
+		warnings       int
+	}{
+		{mode: verifyDeny, serviceAccount: "bound", allowed: true},
+		{mode: verifyDeny, allowed: false},
+		{mode: verifyDeny, serviceAccount: "unknown", allowed: false},
+		{mode: verifyWarn, allowed: true, warnings: 1},
+		// Proxies with credentials don't rely on workload identity
+		{mode: verifyDeny, secret: "creds", allowed: true},
+	} {
+		pod := testPodWithAnnotations(t, map[string]string{annotationInject: "true"})
+		pod.Spec.ServiceAccountName = data.serviceAccount
+		ar := reviewPod(t, Options{
+			DefaultInstance:        "proj:eu:db",
+			DefaultSecretName:      data.secret,
+			ServiceAccounts:        serviceAccounts,
+			VerifyWorkloadIdentity: data.mode,
+		}, "team-a", pod)
+		assert.Equal(t, data.allowed, ar.Allowed, "%+v", data)
+		warnings := 0
+		for _, warning := range sting.Warnings(ar) {
+			if strings.Contains(warning, "workload identity") {
+				warnings++
+			}
+		}
+		assert.Equal(t, data.warnings, warnings, "%+v", data)
+		if !data.allowed {
+			assert.Contains(t, ar.Result.Message, "workload identity")
+			assert.Equal(t, metav1.StatusReasonForbidden, ar.Result.Reason)
+		}
+	}
+}
@@ -1,3 +1,8 @@
 {{- define "sqlbee.name" -}}
 {{- default .Chart.Name .Values.nameOverride | trunc 63 | trimSuffix "-" -}}
 {{- end -}}
+
+{{/* The port of the first listen address, the service forwards to */}}
+{{- define "sqlbee.port" -}}
+{{- regexReplaceAll ".*:" (first (splitList "," .Values.listenAddrs)) "" -}}
+{{- end -}}
@@ -3,19 +3,21 @@ module github.com/connctd/sqlbee
 go 1.17
 
 require (
+	github.com/docker/distribution v2.7.1+incompatible
 	github.com/gorilla/mux v1.7.0
 	github.com/howeyc/fsnotify v0.9.0
 	github.com/mattbaird/jsonpatch v0.0.0-20171005235357-81af80346b1a
+	github.com/opencontainers/go-digest v1.0.0-rc1
 	github.com/sirupsen/logrus v1.3.0
 	github.com/stretchr/testify v1.2.2
 	k8s.io/api v0.0.0-20190206011303-7d75eb91fcfa
 	k8s.io/apimachinery v0.0.0-20190205091131-4b4ea28f2790
 	k8s.io/kubernetes v1.14.0-alpha.2.0.20190206111254-bf8dd697b20e
+	sigs.k8s.io/yaml v1.1.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/docker/distribution v2.7.1+incompatible // indirect
 	github.com/gogo/protobuf v1.2.0 // indirect
 	github.com/golang/protobuf v1.2.0 // indirect
 	github.com/google/gofuzz v0.0.0-20170612174753-24818f796faf // indirect
@@ -23,7 +25,6 @@ require (
 	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.1 // indirect
-	github.com/opencontainers/go-digest v1.0.0-rc1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.3 // indirect
 	golang.org/x/crypto v0.0.0-20180904163835-0709b304e793 // indirect
@@ -34,5 +35,4 @@ require (
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	k8s.io/klog v0.1.0 // indirect
 	k8s.io/utils v0.0.0-20190204185745-a326ccf4f02b // indirect
-	sigs.k8s.io/yaml v1.1.0 // indirect
 )
@@ -0,0 +1,253 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CACertKey is the key of the CA certificate within bootstrapped certificate secrets
+	CACertKey = "ca.crt"
+
+	// How long bootstrapped certificates are valid and how long before they expire they are renewed
+	certificateValidity = 365 * 24 * time.Hour
+	certificateRenewal  = 30 * 24 * time.Hour
+)
+
+// CertificateIssuer issues serving certificates for DNS names. It returns the data of a TLS secret
+// containing the certificate and its private key, as well as the CA certificate
+type CertificateIssuer interface {
+	Issue(ctx context.Context, dnsNames []string) (map[string][]byte, error)
+}
+
+// ServiceDNSNames returns the DNS names the API server uses to reach the service
+func ServiceDNSNames(service, namespace string) []string {
+	return []string{service + "." + namespace + ".svc", service + "." + namespace}
+}
+
+// CertificateBootstrapper obtains a serving certificate from an issuer, stores it in a secret and
+// sets its CA as CA bundle of the webhook configuration. Valid certificates within the secret are
+// reused, so all replicas serve the same certificate. When the certificate is renewed, the CA bundle
+// contains the previous CA as well, so replicas still serving the previous certificate are trusted
+// until they reload it
+type CertificateBootstrapper struct {
+	issuer    CertificateIssuer
+	secrets   SecretClient
+	webhooks  WebhookPatcher
+	namespace string
+	name      string
+	webhook   string
+	dnsNames  []string
+}
+
+// NewCertificateBootstrapper creates a new CertificateBootstrapper storing the certificates of the
+// issuer in the secret with the name in the namespace and patching the webhook configuration
+func NewCertificateBootstrapper(issuer CertificateIssuer, secrets SecretClient, webhooks WebhookPatcher, namespace, name, webhook string, dnsNames []string) *CertificateBootstrapper {
+	return &CertificateBootstrapper{
+		issuer:    issuer,
+		secrets:   secrets,
+		webhooks:  webhooks,
+		namespace: namespace,
+		name:      name,
+		webhook:   webhook,
+		dnsNames:  dnsNames,
+	}
+}
+
+// Bootstrap ensures that the secret contains a valid certificate and its CA is the CA bundle of the
+// webhook configuration. Certificates which are invalid, e.g. not signed by the CA within the secret,
+// or expire soon are replaced
+func (b *CertificateBootstrapper) Bootstrap(ctx context.Context) error {
+	secret, err := b.secrets.GetSecret(ctx, b.namespace, b.name)
+	if err != nil && !IsNotFound(err) {
+		return err
+	}
+	if err != nil || b.validate(secret, time.Now()) != nil {
+		if secret, err = b.store(ctx, secret); err != nil {
+			return err
+		}
+	}
+	return b.webhooks.PatchWebhookCABundle(ctx, b.webhook, secret.Data[CACertKey])
+}
+
+// Run bootstraps the certificate periodically until the context is done, so it is renewed before
+// it expires. Errors are passed to onError
+func (b *CertificateBootstrapper) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.Bootstrap(ctx); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// store issues a new certificate and creates the secret or replaces the data of the existing one.
+// Before a certificate is replaced, the CA bundle of the webhook configuration is extended by the new
+// CA. If another replica has stored a certificate meanwhile, that one is used
+func (b *CertificateBootstrapper) store(ctx context.Context, existing *corev1.Secret) (*corev1.Secret, error) {
+	data, err := b.issuer.Issue(ctx, b.dnsNames)
+	if err != nil {
+		return nil, err
+	}
+	var secret *corev1.Secret
+	if existing != nil {
+		data[CACertKey] = caBundle(data[CACertKey], existing.Data[CACertKey], time.Now())
+		if err := b.webhooks.PatchWebhookCABundle(ctx, b.webhook, data[CACertKey]); err != nil {
+			return nil, err
+		}
+		secret = existing.DeepCopy()
+		secret.Data = data
+		err = b.secrets.UpdateSecret(ctx, secret)
+	} else {
+		secret = &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: b.namespace, Name: b.name},
+			Type:       corev1.SecretTypeTLS,
+			Data:       data,
+		}
+		err = b.secrets.CreateSecret(ctx, secret)
+	}
+	if !IsConflict(err) {
+		return secret, err
+	}
+
+	secret, err = b.secrets.GetSecret(ctx, b.namespace, b.name)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.validate(secret, time.Now()); err != nil {
+		return nil, fmt.Errorf("Secret %s/%s has been changed concurrently: %s", b.namespace, b.name, err)
+	}
+	return secret, nil
+}
+
+// caBundle returns the PEM encoded CA certificate followed by the first certificate of the previous
+// CA bundle, the previous CA, if it is a different certificate which didn't expire yet
+func caBundle(caCert, previous []byte, now time.Time) []byte {
+	block, _ := pem.Decode(previous)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return caCert
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || now.After(cert.NotAfter) {
+		return caCert
+	}
+	previousCA := pem.EncodeToMemory(block)
+	if bytes.Contains(caCert, previousCA) {
+		return caCert
+	}
+	bundle := append([]byte{}, caCert...)
+	if len(bundle) > 0 && bundle[len(bundle)-1] != '\n' {
+		bundle = append(bundle, '\n')
+	}
+	return append(bundle, previousCA...)
+}
+
+// validate checks whether the secret contains a keypair for the DNS names, which is signed by the CA
+// of the secret and doesn't need to be renewed yet. Certificates are renewed 30 days before they
+// expire, short lived ones after two thirds of their lifetime
+func (b *CertificateBootstrapper) validate(secret *corev1.Secret, now time.Time) error {
+	pair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	renewal := certificateRenewal
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime/3 < renewal {
+		renewal = lifetime / 3
+	}
+	if now.Add(renewal).After(cert.NotAfter) {
+		return fmt.Errorf("Certificate expires at %s", cert.NotAfter)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(secret.Data[CACertKey]) {
+		return fmt.Errorf("Secret doesn't contain a CA certificate")
+	}
+	for _, dnsName := range b.dnsNames {
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: dnsName, Roots: roots, CurrentTime: now}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SelfSignedIssuer generates a CA and a serving certificate signed by it. The key of the CA is
+// discarded, as the CA is replaced together with the certificate
+type SelfSignedIssuer struct{}
+
+// Issue generates a CA and a serving certificate for the DNS names
+func (SelfSignedIssuer) Issue(ctx context.Context, dnsNames []string) (map[string][]byte, error) {
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(now.UnixNano()),
+		Subject:               pkix.Name{CommonName: "sqlbee-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certificateValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano() + 1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	return tlsSecretData(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), key)
+}
+
+// tlsSecretData returns the data of a TLS secret containing the PEM encoded certificates and the key
+func tlsSecretData(caCert, cert []byte, key *ecdsa.PrivateKey) (map[string][]byte, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		CACertKey:               caCert,
+		corev1.TLSCertKey:       cert,
+		corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
@@ -0,0 +1,119 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeWebhooks stores the CA bundles by the name of the webhook configuration
+type fakeWebhooks map[string][]byte
+
+func (f fakeWebhooks) PatchWebhookCABundle(ctx context.Context, name string, caBundle []byte) error {
+	f[name] = caBundle
+	return nil
+}
+
+// racingSecrets creates the secret of another replica, before the creation of the secret fails
+type racingSecrets struct {
+	fakeSecrets
+	other *corev1.Secret
+}
+
+func (r racingSecrets) CreateSecret(ctx context.Context, secret *corev1.Secret) error {
+	r.fakeSecrets[secret.Namespace+"/"+secret.Name] = r.other
+	return &StatusError{Code: http.StatusConflict}
+}
+
+func TestCertificateBootstrapper(t *testing.T) {
+	secrets, webhooks := fakeSecrets{}, fakeWebhooks{}
+	dnsNames := ServiceDNSNames("sqlbee-svc", "sqlbee")
+	bootstrapper := NewCertificateBootstrapper(SelfSignedIssuer{}, secrets, webhooks, "sqlbee", "sqlbee-certs", "sqlbee-mutating-webhook-config", dnsNames)
+
+	require.NoError(t, bootstrapper.Bootstrap(context.Background()))
+	secret := secrets["sqlbee/sqlbee-certs"]
+	require.NotNil(t, secret)
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	assert.NoError(t, bootstrapper.validate(secret, time.Now()))
+	assert.Equal(t, secret.Data[CACertKey], webhooks["sqlbee-mutating-webhook-config"])
+
+	// Valid certificates are reused
+	require.NoError(t, bootstrapper.Bootstrap(context.Background()))
+	assert.Equal(t, secret.Data, secrets["sqlbee/sqlbee-certs"].Data)
+
+	// Certificates are renewed before they expire
+	assert.Error(t, bootstrapper.validate(secret, time.Now().Add(certificateValidity-certificateRenewal+time.Hour)))
+
+	// Certificates which aren't signed by the CA or issued for other names are replaced
+	data, err := SelfSignedIssuer{}.Issue(context.Background(), ServiceDNSNames("sqlbee-svc", "other"))
+	require.NoError(t, err)
+	assert.Error(t, bootstrapper.validate(&corev1.Secret{Data: data}, time.Now()))
+	data[CACertKey] = data[corev1.TLSCertKey]
+	secret.Data = data
+	require.NoError(t, bootstrapper.Bootstrap(context.Background()))
+	assert.NoError(t, bootstrapper.validate(secrets["sqlbee/sqlbee-certs"], time.Now()))
+	assert.Equal(t, secrets["sqlbee/sqlbee-certs"].Data[CACertKey], webhooks["sqlbee-mutating-webhook-config"])
+}
+
+// orderedWebhooks records the serving certificate stored in the secret whenever the CA bundle is patched
+type orderedWebhooks struct {
+	fakeWebhooks
+	secrets fakeSecrets
+	served  [][]byte
+}
+
+func (o *orderedWebhooks) PatchWebhookCABundle(ctx context.Context, name string, caBundle []byte) error {
+	o.served = append(o.served, o.secrets["sqlbee/sqlbee-certs"].Data[corev1.TLSCertKey])
+	return o.fakeWebhooks.PatchWebhookCABundle(ctx, name, caBundle)
+}
+
+func TestCertificateBootstrapperRenewal(t *testing.T) {
+	dnsNames := ServiceDNSNames("sqlbee-svc", "sqlbee")
+	previous, err := SelfSignedIssuer{}.Issue(context.Background(), dnsNames)
+	require.NoError(t, err)
+	// The certificate needs to be renewed, the previous CA is still valid
+	other, err := SelfSignedIssuer{}.Issue(context.Background(), ServiceDNSNames("sqlbee-svc", "other"))
+	require.NoError(t, err)
+	expiring := map[string][]byte{CACertKey: previous[CACertKey], corev1.TLSCertKey: other[corev1.TLSCertKey], corev1.TLSPrivateKeyKey: other[corev1.TLSPrivateKeyKey]}
+	secrets := fakeSecrets{"sqlbee/sqlbee-certs": &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "sqlbee", Name: "sqlbee-certs"}, Data: expiring}}
+	webhooks := &orderedWebhooks{fakeWebhooks: fakeWebhooks{}, secrets: secrets}
+	bootstrapper := NewCertificateBootstrapper(SelfSignedIssuer{}, secrets, webhooks, "sqlbee", "sqlbee-certs", "sqlbee-mutating-webhook-config", dnsNames)
+
+	require.NoError(t, bootstrapper.Bootstrap(context.Background()))
+	secret := secrets["sqlbee/sqlbee-certs"]
+	assert.NoError(t, bootstrapper.validate(secret, time.Now()))
+	bundle := webhooks.fakeWebhooks["sqlbee-mutating-webhook-config"]
+	assert.Equal(t, secret.Data[CACertKey], bundle)
+	assert.True(t, bytes.HasSuffix(bundle, previous[CACertKey]), "The previous CA stays trusted")
+	assert.NotEqual(t, previous[CACertKey], bundle)
+	// The new CA is trusted before the new certificate is stored
+	require.Len(t, webhooks.served, 2)
+	assert.Equal(t, other[corev1.TLSCertKey], webhooks.served[0])
+
+	// The CA bundle contains only the current and the previous CA
+	secret.Data[corev1.TLSCertKey] = other[corev1.TLSCertKey]
+	secret.Data[corev1.TLSPrivateKeyKey] = other[corev1.TLSPrivateKeyKey]
+	require.NoError(t, bootstrapper.Bootstrap(context.Background()))
+	renewed := secrets["sqlbee/sqlbee-certs"].Data[CACertKey]
+	assert.NotContains(t, string(renewed), string(previous[CACertKey]))
+	assert.Equal(t, 2, bytes.Count(renewed, []byte("BEGIN CERTIFICATE")))
+}
+
+func TestCertificateBootstrapperConcurrently(t *testing.T) {
+	dnsNames := ServiceDNSNames("sqlbee-svc", "sqlbee")
+	data, err := SelfSignedIssuer{}.Issue(context.Background(), dnsNames)
+	require.NoError(t, err)
+	other := &corev1.Secret{Data: data}
+
+	webhooks := fakeWebhooks{}
+	bootstrapper := NewCertificateBootstrapper(SelfSignedIssuer{}, racingSecrets{fakeSecrets{}, other}, webhooks, "sqlbee", "sqlbee-certs", "sqlbee-mutating-webhook-config", dnsNames)
+	require.NoError(t, bootstrapper.Bootstrap(context.Background()))
+	assert.Equal(t, data[CACertKey], webhooks["sqlbee-mutating-webhook-config"])
+}
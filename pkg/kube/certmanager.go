@@ -0,0 +1,91 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AnnotationInjectCAFrom makes the CA injector of cert-manager set the CA of the certificate as CA
+// bundle of the annotated webhook configuration
+const AnnotationInjectCAFrom = "cert-manager.io/inject-ca-from"
+
+// Certificate is a certificate of the cert-manager.io/v1 API. It is issued and renewed by
+// cert-manager, which stores it in the secret
+type Certificate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec CertificateSpec `json:"spec"`
+}
+
+// CertificateSpec contains the DNS names, the issuer and the secret of the certificate
+type CertificateSpec struct {
+	SecretName string          `json:"secretName"`
+	DNSNames   []string        `json:"dnsNames"`
+	Usages     []string        `json:"usages,omitempty"`
+	IssuerRef  IssuerReference `json:"issuerRef"`
+}
+
+// IssuerReference references an Issuer or ClusterIssuer of cert-manager
+type IssuerReference struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Group string `json:"group,omitempty"`
+}
+
+// CertificateApplier creates certificates of cert-manager or updates them to the desired spec
+type CertificateApplier interface {
+	ApplyCertificate(ctx context.Context, certificate *Certificate) error
+}
+
+// NewServingCertificate returns a certificate for the DNS names issued by the issuer of the kind,
+// stored in the secret with the same name as the certificate
+func NewServingCertificate(namespace, name string, dnsNames []string, issuerKind, issuerName string) *Certificate {
+	return &Certificate{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "cert-manager.io/v1", Kind: "Certificate"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: CertificateSpec{
+			SecretName: name,
+			DNSNames:   dnsNames,
+			Usages:     []string{"digital signature", "key encipherment", "server auth"},
+			IssuerRef:  IssuerReference{Kind: issuerKind, Name: issuerName, Group: "cert-manager.io"},
+		},
+	}
+}
+
+// ApplyCertificate creates the certificate or updates the spec of the existing one if it differs
+func (c *Client) ApplyCertificate(ctx context.Context, certificate *Certificate) error {
+	path := "/apis/cert-manager.io/v1/namespaces/" + certificate.Namespace + "/certificates"
+	existing := &Certificate{}
+	err := c.Get(ctx, path+"/"+certificate.Name, existing)
+	if IsNotFound(err) {
+		return c.Do(ctx, http.MethodPost, path, "application/json", certificate, nil)
+	} else if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(existing.Spec, certificate.Spec) {
+		return nil
+	}
+	existing.Spec = certificate.Spec
+	return c.Do(ctx, http.MethodPut, path+"/"+certificate.Name, "application/json", existing, nil)
+}
+
+// WaitForSecret polls the secret until it exists or the context is done, e.g. until cert-manager
+// has issued a certificate
+func WaitForSecret(ctx context.Context, secrets SecretClient, namespace, name string, interval time.Duration) error {
+	for {
+		_, err := secrets.GetSecret(ctx, namespace, name)
+		if !IsNotFound(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestClientApplyCertificate(t *testing.T) {
+	var stored *Certificate
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet && stored == nil:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind":"Status","code":404}`))
+		case r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(stored)
+		default:
+			stored = &Certificate{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(stored))
+			stored.ResourceVersion = "1"
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, "", server.Client())
+
+	certificate := NewServingCertificate("sqlbee", "sqlbee-certs", ServiceDNSNames("sqlbee-svc", "sqlbee"), "Issuer", "sqlbee-ca")
+	require.NoError(t, client.ApplyCertificate(context.Background(), certificate))
+	require.NoError(t, client.ApplyCertificate(context.Background(), certificate))
+	certificate = NewServingCertificate("sqlbee", "sqlbee-certs", ServiceDNSNames("sqlbee-svc", "sqlbee"), "ClusterIssuer", "cluster-ca")
+	require.NoError(t, client.ApplyCertificate(context.Background(), certificate))
+
+	assert.Equal(t, []string{
+		"GET /apis/cert-manager.io/v1/namespaces/sqlbee/certificates/sqlbee-certs",
+		"POST /apis/cert-manager.io/v1/namespaces/sqlbee/certificates",
+		"GET /apis/cert-manager.io/v1/namespaces/sqlbee/certificates/sqlbee-certs",
+		"GET /apis/cert-manager.io/v1/namespaces/sqlbee/certificates/sqlbee-certs",
+		"PUT /apis/cert-manager.io/v1/namespaces/sqlbee/certificates/sqlbee-certs",
+	}, requests)
+	assert.Equal(t, "1", stored.ResourceVersion)
+	assert.Equal(t, IssuerReference{Kind: "ClusterIssuer", Name: "cluster-ca", Group: "cert-manager.io"}, stored.Spec.IssuerRef)
+	assert.Equal(t, "sqlbee-certs", stored.Spec.SecretName)
+}
+
+func TestWaitForSecret(t *testing.T) {
+	secret := &corev1.Secret{}
+	secret.Namespace, secret.Name = "sqlbee", "sqlbee-certs"
+	secrets := fakeSecrets{"sqlbee/sqlbee-certs": secret}
+	assert.NoError(t, WaitForSecret(context.Background(), secrets, "sqlbee", "sqlbee-certs", time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, WaitForSecret(ctx, secrets, "sqlbee", "unknown", time.Millisecond))
+}
@@ -0,0 +1,172 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ServiceAccountDir is the directory the credentials of the service account are mounted to
+	// in every pod
+	ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+var (
+	// ErrNotInCluster is returned if an in cluster client is requested outside of a Kubernetes pod
+	ErrNotInCluster = errors.New("Not running within a Kubernetes cluster")
+)
+
+// StatusError is returned if the API server responded with an error status
+type StatusError struct {
+	Code   int
+	Status metav1.Status
+}
+
+func (e *StatusError) Error() string {
+	if e.Status.Message != "" {
+		return fmt.Sprintf("API server responded with %d: %s", e.Code, e.Status.Message)
+	}
+	return fmt.Sprintf("API server responded with %d", e.Code)
+}
+
+// IsNotFound checks whether the error indicates that the requested object doesn't exist
+func IsNotFound(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return ok && statusErr.Code == http.StatusNotFound
+}
+
+// IsConflict checks whether the error indicates that the object already exists or has been changed
+// meanwhile
+func IsConflict(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return ok && statusErr.Code == http.StatusConflict
+}
+
+// Client is a minimal client for the Kubernetes API. It supports only what SQLBee needs and
+// authenticates with a bearer token, which is re-read for every request so rotated service
+// account tokens are picked up
+type Client struct {
+	host       string
+	tokenFile  string
+	httpClient *http.Client
+}
+
+// NewClient creates a new Client for the API server reachable at host. If tokenFile is not empty
+// its content is used as bearer token
+func NewClient(host, tokenFile string, httpClient *http.Client) *Client {
+	return &Client{
+		host:       strings.TrimSuffix(host, "/"),
+		tokenFile:  tokenFile,
+		httpClient: httpClient,
+	}
+}
+
+// NewInClusterClient creates a new Client using the service account of the pod it is running in
+func NewInClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+	caCert, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("Failed to parse the CA certificate of the cluster")
+	}
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+	return NewClient("https://"+net.JoinHostPort(host, port), filepath.Join(ServiceAccountDir, "token"), httpClient), nil
+}
+
+// Do sends a request to the API server. If body is not nil it is sent JSON encoded with the given
+// content type. If result is not nil the response is decoded into it
+func (c *Client) Do(ctx context.Context, method, path, contentType string, body, result interface{}) error {
+	resp, err := c.send(ctx, c.httpClient, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Stream sends a GET request to the API server and returns the body of the response, which is read
+// without the timeout of the HTTP client, e.g. to watch objects. The body needs to be closed
+func (c *Client) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+	resp, err := c.send(ctx, &httpClient, http.MethodGet, path, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// send sends a request to the API server and returns the response if its status is successful
+func (c *Client) send(ctx context.Context, httpClient *http.Client, method, path, contentType string, body interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, c.host+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.tokenFile != "" {
+		token, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		statusErr := &StatusError{Code: resp.StatusCode}
+		// The status is optional, we can report the error without it
+		_ = json.NewDecoder(resp.Body).Decode(&statusErr.Status)
+		return nil, statusErr
+	}
+	return resp, nil
+}
+
+// Get retrieves the object at the given API path
+func (c *Client) Get(ctx context.Context, path string, result interface{}) error {
+	return c.Do(ctx, http.MethodGet, path, "", nil, result)
+}
@@ -0,0 +1,45 @@
+package kube
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientGetNamespace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret-token", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/v1/namespaces/team-a":
+			w.Write([]byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"team-a","annotations":{"sqlbee.connctd.io.instance":"proj:eu:db-a"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"apiVersion":"v1","kind":"Status","status":"Failure","message":"namespaces \"unknown\" not found","reason":"NotFound","code":404}`))
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "kube")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("secret-token\n"), 0600))
+
+	client := NewClient(server.URL, tokenFile, server.Client())
+
+	namespace, err := client.GetNamespace(context.Background(), "team-a")
+	require.NoError(t, err)
+	assert.Equal(t, "team-a", namespace.Name)
+	assert.Equal(t, "proj:eu:db-a", namespace.Annotations["sqlbee.connctd.io.instance"])
+
+	_, err = client.GetNamespace(context.Background(), "unknown")
+	assert.True(t, IsNotFound(err))
+	assert.EqualError(t, err, `API server responded with 404: namespaces "unknown" not found`)
+}
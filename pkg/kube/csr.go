@@ -0,0 +1,164 @@
+package kube
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// How long to wait for a CertificateSigningRequest to be issued and how often it is checked
+	csrTimeout      = 2 * time.Minute
+	csrPollInterval = 2 * time.Second
+)
+
+// CertificateSigningRequest is a request to sign a certificate of the certificates.k8s.io/v1 API
+type CertificateSigningRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CertificateSigningRequestSpec   `json:"spec"`
+	Status CertificateSigningRequestStatus `json:"status,omitempty"`
+}
+
+// CertificateSigningRequestSpec contains the PEM encoded request and the signer to sign it
+type CertificateSigningRequestSpec struct {
+	Request    []byte   `json:"request"`
+	SignerName string   `json:"signerName"`
+	Usages     []string `json:"usages,omitempty"`
+}
+
+// CertificateSigningRequestStatus contains the conditions and the issued certificate
+type CertificateSigningRequestStatus struct {
+	Conditions  []CertificateSigningRequestCondition `json:"conditions,omitempty"`
+	Certificate []byte                               `json:"certificate,omitempty"`
+}
+
+// CertificateSigningRequestCondition is a condition like Approved, Denied or Failed
+type CertificateSigningRequestCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// CSRClient creates, retrieves and approves CertificateSigningRequests
+type CSRClient interface {
+	CreateCSR(ctx context.Context, csr *CertificateSigningRequest) (*CertificateSigningRequest, error)
+	GetCSR(ctx context.Context, name string) (*CertificateSigningRequest, error)
+	ApproveCSR(ctx context.Context, csr *CertificateSigningRequest) error
+}
+
+// CreateCSR creates the CertificateSigningRequest and returns it as created, e.g. with its generated name
+func (c *Client) CreateCSR(ctx context.Context, csr *CertificateSigningRequest) (*CertificateSigningRequest, error) {
+	created := &CertificateSigningRequest{}
+	if err := c.Do(ctx, http.MethodPost, "/apis/certificates.k8s.io/v1/certificatesigningrequests", "application/json", csr, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetCSR retrieves a CertificateSigningRequest from the API server
+func (c *Client) GetCSR(ctx context.Context, name string) (*CertificateSigningRequest, error) {
+	csr := &CertificateSigningRequest{}
+	if err := c.Get(ctx, "/apis/certificates.k8s.io/v1/certificatesigningrequests/"+name, csr); err != nil {
+		return nil, err
+	}
+	return csr, nil
+}
+
+// ApproveCSR approves the CertificateSigningRequest, which requires the permission to approve
+// requests of its signer
+func (c *Client) ApproveCSR(ctx context.Context, csr *CertificateSigningRequest) error {
+	approved := *csr
+	approved.Status.Conditions = append(append([]CertificateSigningRequestCondition{}, csr.Status.Conditions...), CertificateSigningRequestCondition{
+		Type:    "Approved",
+		Status:  "True",
+		Reason:  "SQLBeeApproved",
+		Message: "Serving certificate of SQLBee",
+	})
+	return c.Do(ctx, http.MethodPut, "/apis/certificates.k8s.io/v1/certificatesigningrequests/"+csr.Name+"/approval", "application/json", &approved, nil)
+}
+
+// CSRIssuer issues serving certificates via the CertificateSigningRequest API of Kubernetes. The
+// requests are either approved by the issuer itself or need to be approved by another controller
+type CSRIssuer struct {
+	client       CSRClient
+	signerName   string
+	caCert       []byte
+	approve      bool
+	pollInterval time.Duration
+}
+
+// NewCSRIssuer creates a new CSRIssuer requesting certificates of the signer. The CA certificate of
+// the signer is stored alongside the issued certificates
+func NewCSRIssuer(client CSRClient, signerName string, caCert []byte, approve bool) *CSRIssuer {
+	return &CSRIssuer{
+		client:       client,
+		signerName:   signerName,
+		caCert:       caCert,
+		approve:      approve,
+		pollInterval: csrPollInterval,
+	}
+}
+
+// Issue requests a certificate for the DNS names and waits until it has been issued
+func (i *CSRIssuer) Issue(ctx context.Context, dnsNames []string) (map[string][]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	request, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: dnsNames[0]},
+		DNSNames: dnsNames,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := i.client.CreateCSR(ctx, &CertificateSigningRequest{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "certificates.k8s.io/v1", Kind: "CertificateSigningRequest"},
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "sqlbee-"},
+		Spec: CertificateSigningRequestSpec{
+			Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request}),
+			SignerName: i.signerName,
+			Usages:     []string{"digital signature", "key encipherment", "server auth"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if i.approve {
+		if err := i.client.ApproveCSR(ctx, csr); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, csrTimeout)
+	defer cancel()
+	for len(csr.Status.Certificate) == 0 {
+		for _, condition := range csr.Status.Conditions {
+			if (condition.Type == "Denied" || condition.Type == "Failed") && condition.Status == "True" {
+				return nil, fmt.Errorf("CertificateSigningRequest %s has been %s: %s", csr.Name, condition.Type, condition.Message)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("CertificateSigningRequest %s hasn't been issued: %s", csr.Name, ctx.Err())
+		case <-time.After(i.pollInterval):
+		}
+		if csr, err = i.client.GetCSR(ctx, csr.Name); err != nil {
+			return nil, err
+		}
+	}
+	return tlsSecretData(i.caCert, csr.Status.Certificate, key)
+}
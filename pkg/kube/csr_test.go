@@ -0,0 +1,110 @@
+package kube
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// fakeSigner signs approved requests with its CA and denies requests which haven't been approved
+type fakeSigner struct {
+	t        *testing.T
+	ca       *x509.Certificate
+	caKey    *ecdsa.PrivateKey
+	requests map[string]*CertificateSigningRequest
+}
+
+func newFakeSigner(t *testing.T) *fakeSigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cluster-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &fakeSigner{t: t, ca: ca, caKey: key, requests: map[string]*CertificateSigningRequest{}}
+}
+
+func (f *fakeSigner) caCert() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.ca.Raw})
+}
+
+func (f *fakeSigner) CreateCSR(ctx context.Context, csr *CertificateSigningRequest) (*CertificateSigningRequest, error) {
+	created := *csr
+	created.Name = csr.GenerateName + "abcde"
+	f.requests[created.Name] = &created
+	return &created, nil
+}
+
+func (f *fakeSigner) GetCSR(ctx context.Context, name string) (*CertificateSigningRequest, error) {
+	csr := f.requests[name]
+	if len(csr.Status.Conditions) == 0 {
+		csr.Status.Conditions = []CertificateSigningRequestCondition{{Type: "Denied", Status: "True", Message: "Not approved"}}
+		return csr, nil
+	}
+	block, _ := pem.Decode(csr.Spec.Request)
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(f.t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      request.Subject,
+		DNSNames:     request.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, f.ca, request.PublicKey, f.caKey)
+	require.NoError(f.t, err)
+	csr.Status.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return csr, nil
+}
+
+func (f *fakeSigner) ApproveCSR(ctx context.Context, csr *CertificateSigningRequest) error {
+	f.requests[csr.Name].Status.Conditions = []CertificateSigningRequestCondition{{Type: "Approved", Status: "True"}}
+	return nil
+}
+
+func TestCSRIssuer(t *testing.T) {
+	signer := newFakeSigner(t)
+	issuer := NewCSRIssuer(signer, "example.com/webhook-serving", signer.caCert(), true)
+	issuer.pollInterval = time.Millisecond
+	dnsNames := ServiceDNSNames("sqlbee-svc", "sqlbee")
+
+	data, err := issuer.Issue(context.Background(), dnsNames)
+	require.NoError(t, err)
+	csr := signer.requests["sqlbee-abcde"]
+	assert.Equal(t, "example.com/webhook-serving", csr.Spec.SignerName)
+	assert.Equal(t, []string{"digital signature", "key encipherment", "server auth"}, csr.Spec.Usages)
+	assert.Equal(t, signer.caCert(), data[CACertKey])
+
+	// Certificates with a lifetime of a day are renewed after two thirds of it
+	bootstrapper := NewCertificateBootstrapper(issuer, fakeSecrets{}, fakeWebhooks{}, "sqlbee", "sqlbee-certs", "", dnsNames)
+	secret := &corev1.Secret{Data: data}
+	assert.NoError(t, bootstrapper.validate(secret, time.Now()))
+	assert.Error(t, bootstrapper.validate(secret, time.Now().Add(17*time.Hour)))
+
+	// Requests which aren't approved by another controller are denied by the fake signer
+	issuer = NewCSRIssuer(signer, "example.com/webhook-serving", signer.caCert(), false)
+	issuer.pollInterval = time.Millisecond
+	_, err = issuer.Issue(context.Background(), dnsNames)
+	assert.EqualError(t, err, "CertificateSigningRequest sqlbee-abcde has been Denied: Not approved")
+}
@@ -0,0 +1,40 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EventCreator creates events
+type EventCreator interface {
+	CreateEvent(ctx context.Context, event *corev1.Event) error
+}
+
+// CreateEvent creates the event in the namespace of its involved object
+func (c *Client) CreateEvent(ctx context.Context, event *corev1.Event) error {
+	return c.Do(ctx, http.MethodPost, "/api/v1/namespaces/"+event.Namespace+"/events", "application/json", event, nil)
+}
+
+// NewEvent returns an event about the involved object reported by the component, like the events
+// of the event recorder of client-go
+func NewEvent(involvedObject corev1.ObjectReference, eventType, reason, message, component string) *corev1.Event {
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		InvolvedObject: involvedObject,
+		Reason:         reason,
+		Message:        message,
+		Source:         corev1.EventSource{Component: component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+	event.Name = fmt.Sprintf("%v.%x", involvedObject.Name, now.UnixNano())
+	event.Namespace = involvedObject.Namespace
+	return event
+}
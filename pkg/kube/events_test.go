@@ -0,0 +1,39 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestClientCreateEvent(t *testing.T) {
+	created := &corev1.Event{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v1/namespaces/team-a/events", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(created))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, "", server.Client())
+
+	involved := corev1.ObjectReference{Kind: "ReplicaSet", Namespace: "team-a", Name: "app-7d9f8", UID: "uid"}
+	event := NewEvent(involved, corev1.EventTypeNormal, "Injected", "Injected the proxy", "sqlbee")
+	require.NoError(t, client.CreateEvent(context.Background(), event))
+
+	assert.Equal(t, "team-a", created.Namespace)
+	assert.True(t, strings.HasPrefix(created.Name, "app-7d9f8."))
+	assert.Equal(t, involved, created.InvolvedObject)
+	assert.Equal(t, "Injected", created.Reason)
+	assert.Equal(t, "sqlbee", created.Source.Component)
+	assert.EqualValues(t, 1, created.Count)
+	assert.Equal(t, corev1.EventTypeNormal, created.Type)
+}
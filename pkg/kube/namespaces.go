@@ -0,0 +1,67 @@
+package kube
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NamespaceGetter retrieves namespaces by their name
+type NamespaceGetter interface {
+	GetNamespace(ctx context.Context, name string) (*corev1.Namespace, error)
+}
+
+// GetNamespace retrieves a namespace from the API server
+func (c *Client) GetNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	namespace := &corev1.Namespace{}
+	if err := c.Get(ctx, "/api/v1/namespaces/"+name, namespace); err != nil {
+		return nil, err
+	}
+	return namespace, nil
+}
+
+type namespaceEntry struct {
+	namespace *corev1.Namespace
+	expires   time.Time
+}
+
+// NamespaceCache caches the namespaces retrieved by another NamespaceGetter for a limited time,
+// so admission requests don't need to wait for the API server each time
+type NamespaceCache struct {
+	getter  NamespaceGetter
+	ttl     time.Duration
+	lock    *sync.Mutex
+	entries map[string]namespaceEntry
+}
+
+// NewNamespaceCache creates a new NamespaceCache which caches namespaces for the duration of ttl
+func NewNamespaceCache(getter NamespaceGetter, ttl time.Duration) *NamespaceCache {
+	return &NamespaceCache{
+		getter:  getter,
+		ttl:     ttl,
+		lock:    &sync.Mutex{},
+		entries: map[string]namespaceEntry{},
+	}
+}
+
+// GetNamespace returns the cached namespace or retrieves it if it isn't cached or expired.
+// Returned namespaces are shared and must not be modified
+func (n *NamespaceCache) GetNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	n.lock.Lock()
+	entry, exists := n.entries[name]
+	n.lock.Unlock()
+	if exists && time.Now().Before(entry.expires) {
+		return entry.namespace, nil
+	}
+
+	namespace, err := n.getter.GetNamespace(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	n.lock.Lock()
+	n.entries[name] = namespaceEntry{namespace: namespace, expires: time.Now().Add(n.ttl)}
+	n.lock.Unlock()
+	return namespace, nil
+}
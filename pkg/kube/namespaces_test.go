@@ -0,0 +1,45 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type countingGetter struct {
+	calls int
+}
+
+func (c *countingGetter) GetNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	c.calls++
+	namespace := &corev1.Namespace{}
+	namespace.Name = name
+	return namespace, nil
+}
+
+func TestNamespaceCache(t *testing.T) {
+	getter := &countingGetter{}
+	cache := NewNamespaceCache(getter, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		namespace, err := cache.GetNamespace(context.Background(), "team-a")
+		require.NoError(t, err)
+		assert.Equal(t, "team-a", namespace.Name)
+	}
+	assert.Equal(t, 1, getter.calls)
+
+	_, err := cache.GetNamespace(context.Background(), "team-b")
+	require.NoError(t, err)
+	assert.Equal(t, 2, getter.calls)
+
+	expiring := NewNamespaceCache(getter, -time.Second)
+	for i := 0; i < 2; i++ {
+		_, err := expiring.GetNamespace(context.Background(), "team-a")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 4, getter.calls)
+}
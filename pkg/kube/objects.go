@@ -0,0 +1,35 @@
+package kube
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ObjectChecker checks whether namespaced core objects like secrets or config maps exist
+type ObjectChecker interface {
+	ObjectExists(ctx context.Context, namespace, resource, name string) (bool, error)
+}
+
+// ObjectExists checks whether the object of the core resource, e.g. secrets, exists in the namespace
+func (c *Client) ObjectExists(ctx context.Context, namespace, resource, name string) (bool, error) {
+	err := c.Get(ctx, "/api/v1/namespaces/"+namespace+"/"+resource+"/"+name, nil)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ServiceAccountGetter retrieves service accounts by their namespace and name
+type ServiceAccountGetter interface {
+	GetServiceAccount(ctx context.Context, namespace, name string) (*corev1.ServiceAccount, error)
+}
+
+// GetServiceAccount retrieves a service account from the API server
+func (c *Client) GetServiceAccount(ctx context.Context, namespace, name string) (*corev1.ServiceAccount, error) {
+	serviceAccount := &corev1.ServiceAccount{}
+	if err := c.Get(ctx, "/api/v1/namespaces/"+namespace+"/serviceaccounts/"+name, serviceAccount); err != nil {
+		return nil, err
+	}
+	return serviceAccount, nil
+}
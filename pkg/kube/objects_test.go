@@ -0,0 +1,37 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientObjectExists(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces/team-a/secrets/creds":
+			w.Write([]byte(`{"apiVersion":"v1","kind":"Secret","metadata":{"name":"creds","namespace":"team-a"}}`))
+		case "/api/v1/namespaces/team-a/configmaps/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, "", server.Client())
+
+	exists, err := client.ObjectExists(context.Background(), "team-a", "secrets", "creds")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = client.ObjectExists(context.Background(), "team-a", "configmaps", "certs")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	_, err = client.ObjectExists(context.Background(), "team-a", "configmaps", "forbidden")
+	assert.Error(t, err)
+}
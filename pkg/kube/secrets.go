@@ -0,0 +1,233 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// LabelReplica marks secrets which have been replicated by a SecretReplicator
+	LabelReplica = "sqlbee.connctd.io/replica"
+	// AnnotationReplicaOf contains the namespace and name of the secret a replica has been copied from
+	AnnotationReplicaOf = "sqlbee.connctd.io/replica-of"
+)
+
+// SecretClient retrieves, creates and updates secrets
+type SecretClient interface {
+	GetSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error)
+	CreateSecret(ctx context.Context, secret *corev1.Secret) error
+	UpdateSecret(ctx context.Context, secret *corev1.Secret) error
+}
+
+// InClusterNamespace returns the namespace of the pod the process is running in
+func InClusterNamespace() (string, error) {
+	namespace, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "namespace"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(namespace)), nil
+}
+
+// GetSecret retrieves a secret from the API server
+func (c *Client) GetSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, "/api/v1/namespaces/"+namespace+"/secrets/"+name, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// CreateSecret creates the secret in its namespace
+func (c *Client) CreateSecret(ctx context.Context, secret *corev1.Secret) error {
+	return c.Do(ctx, http.MethodPost, "/api/v1/namespaces/"+secret.Namespace+"/secrets", "application/json", secret, nil)
+}
+
+// UpdateSecret replaces the secret, its resource version needs to be the current one
+func (c *Client) UpdateSecret(ctx context.Context, secret *corev1.Secret) error {
+	return c.Do(ctx, http.MethodPut, "/api/v1/namespaces/"+secret.Namespace+"/secrets/"+secret.Name, "application/json", secret, nil)
+}
+
+// WatchSecret watches the secret for changes after the resource version and calls onChange with
+// every added or modified version of it. It returns once the API server ends the watch, or with
+// an error if the watch failed, e.g. because the resource version is too old
+func (c *Client) WatchSecret(ctx context.Context, namespace, name, resourceVersion string, onChange func(*corev1.Secret)) error {
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("fieldSelector", "metadata.name="+name)
+	query.Set("resourceVersion", resourceVersion)
+	body, err := c.Stream(ctx, "/api/v1/namespaces/"+namespace+"/secrets?"+query.Encode())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	decoder := json.NewDecoder(body)
+	for {
+		event := struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}{}
+		if err := decoder.Decode(&event); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			secret := &corev1.Secret{}
+			if err := json.Unmarshal(event.Object, secret); err != nil {
+				return err
+			}
+			onChange(secret)
+		case "ERROR":
+			statusErr := &StatusError{}
+			if err := json.Unmarshal(event.Object, &statusErr.Status); err != nil {
+				return err
+			}
+			statusErr.Code = int(statusErr.Status.Code)
+			return statusErr
+		}
+	}
+}
+
+// Replicator replicates the secret with its name into the namespaces which opted in
+type Replicator interface {
+	Name() string
+	Replicates(namespace string) bool
+	Replicate(ctx context.Context, namespace string) error
+}
+
+// SecretReplicator copies a secret into other namespaces and keeps the replicas in sync with it, so
+// e.g. rotated credentials are picked up. Only the allowed namespaces receive replicas, so the
+// permissions to manage secrets can be limited to them. Secrets which haven't been created by the
+// replicator are never overwritten
+type SecretReplicator struct {
+	client     SecretClient
+	namespace  string
+	name       string
+	namespaces []string
+}
+
+// NewSecretReplicator creates a new SecretReplicator for the secret with the name in the namespace,
+// which replicates it into the allowed namespaces
+func NewSecretReplicator(client SecretClient, namespace, name string, namespaces []string) *SecretReplicator {
+	return &SecretReplicator{
+		client:     client,
+		namespace:  namespace,
+		name:       name,
+		namespaces: namespaces,
+	}
+}
+
+// Name returns the name of the replicated secret
+func (r *SecretReplicator) Name() string {
+	return r.name
+}
+
+// Replicates checks whether the namespace receives a replica of the secret
+func (r *SecretReplicator) Replicates(namespace string) bool {
+	for _, allowed := range r.namespaces {
+		if namespace == allowed && namespace != r.namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Replicate creates or updates the replica of the secret in the namespace, if it is allowed
+func (r *SecretReplicator) Replicate(ctx context.Context, namespace string) error {
+	if namespace == r.namespace {
+		return nil
+	}
+	if !r.Replicates(namespace) {
+		return fmt.Errorf("Namespace %s doesn't receive replicas of secret %s/%s", namespace, r.namespace, r.name)
+	}
+	source, err := r.client.GetSecret(ctx, r.namespace, r.name)
+	if err != nil {
+		return err
+	}
+	replica, err := r.client.GetSecret(ctx, namespace, r.name)
+	if IsNotFound(err) {
+		return r.client.CreateSecret(ctx, r.replica(source, namespace))
+	} else if err != nil {
+		return err
+	}
+	return r.update(ctx, source, replica)
+}
+
+// Sync updates the replicas of the secret within the allowed namespaces
+func (r *SecretReplicator) Sync(ctx context.Context) error {
+	source, err := r.client.GetSecret(ctx, r.namespace, r.name)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range r.namespaces {
+		replica, err := r.client.GetSecret(ctx, namespace, r.name)
+		if IsNotFound(err) || namespace == r.namespace {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := r.update(ctx, source, replica); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run syncs the replicas periodically until the context is done. Errors are passed to onError
+func (r *SecretReplicator) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Sync(ctx); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// replica returns a copy of the source secret for the namespace
+func (r *SecretReplicator) replica(source *corev1.Secret, namespace string) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        r.name,
+			Namespace:   namespace,
+			Labels:      map[string]string{LabelReplica: "true"},
+			Annotations: map[string]string{AnnotationReplicaOf: r.namespace + "/" + r.name},
+		},
+		Type: source.Type,
+		Data: source.Data,
+	}
+}
+
+// update changes the data of the replica to the one of the source, if it has been created by the
+// replicator and differs from the source
+func (r *SecretReplicator) update(ctx context.Context, source, replica *corev1.Secret) error {
+	if replica.Labels[LabelReplica] != "true" || replica.Annotations[AnnotationReplicaOf] != r.namespace+"/"+r.name {
+		return nil
+	}
+	if reflect.DeepEqual(replica.Data, source.Data) {
+		return nil
+	}
+	updated := r.replica(source, replica.Namespace)
+	updated.ResourceVersion = replica.ResourceVersion
+	return r.client.UpdateSecret(ctx, updated)
+}
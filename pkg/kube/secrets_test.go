@@ -0,0 +1,94 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// fakeSecrets stores secrets by namespace/name in memory
+type fakeSecrets map[string]*corev1.Secret
+
+func (f fakeSecrets) GetSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	secret, exists := f[namespace+"/"+name]
+	if !exists {
+		return nil, &StatusError{Code: http.StatusNotFound}
+	}
+	return secret.DeepCopy(), nil
+}
+
+func (f fakeSecrets) CreateSecret(ctx context.Context, secret *corev1.Secret) error {
+	f[secret.Namespace+"/"+secret.Name] = secret.DeepCopy()
+	return nil
+}
+
+func (f fakeSecrets) UpdateSecret(ctx context.Context, secret *corev1.Secret) error {
+	f[secret.Namespace+"/"+secret.Name] = secret.DeepCopy()
+	return nil
+}
+
+func TestSecretReplicator(t *testing.T) {
+	source := &corev1.Secret{Data: map[string][]byte{"credentials.json": []byte("v1")}}
+	source.Namespace, source.Name = "sqlbee", "cloud-sql-proxy-credentials"
+	foreign := &corev1.Secret{Data: map[string][]byte{"credentials.json": []byte("team-b")}}
+	foreign.Namespace, foreign.Name = "team-b", "cloud-sql-proxy-credentials"
+	secrets := fakeSecrets{"sqlbee/cloud-sql-proxy-credentials": source, "team-b/cloud-sql-proxy-credentials": foreign}
+	replicator := NewSecretReplicator(secrets, "sqlbee", "cloud-sql-proxy-credentials", []string{"team-a", "team-b", "sqlbee"})
+
+	for _, namespace := range []string{"team-a", "team-b", "sqlbee"} {
+		require.NoError(t, replicator.Replicate(context.Background(), namespace))
+	}
+	// Namespaces which didn't opt in never receive replicas
+	assert.False(t, replicator.Replicates("team-c"))
+	assert.False(t, replicator.Replicates("sqlbee"))
+	assert.Error(t, replicator.Replicate(context.Background(), "team-c"))
+	assert.NotContains(t, secrets, "team-c/cloud-sql-proxy-credentials")
+	replica := secrets["team-a/cloud-sql-proxy-credentials"]
+	require.NotNil(t, replica)
+	assert.Equal(t, []byte("v1"), replica.Data["credentials.json"])
+	assert.Equal(t, "true", replica.Labels[LabelReplica])
+	assert.Equal(t, "sqlbee/cloud-sql-proxy-credentials", replica.Annotations[AnnotationReplicaOf])
+	// Secrets not created by the replicator are left alone
+	assert.Equal(t, []byte("team-b"), secrets["team-b/cloud-sql-proxy-credentials"].Data["credentials.json"])
+
+	// Rotated credentials are synced to the replicas
+	source.Data["credentials.json"] = []byte("v2")
+	require.NoError(t, replicator.Sync(context.Background()))
+	assert.Equal(t, []byte("v2"), secrets["team-a/cloud-sql-proxy-credentials"].Data["credentials.json"])
+	assert.Equal(t, []byte("team-b"), secrets["team-b/cloud-sql-proxy-credentials"].Data["credentials.json"])
+}
+
+func TestClientWatchSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/namespaces/sqlbee/secrets", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("watch"))
+		assert.Equal(t, "metadata.name=sqlbee-certs", r.URL.Query().Get("fieldSelector"))
+		switch r.URL.Query().Get("resourceVersion") {
+		case "1":
+			w.Write([]byte(`{"type":"MODIFIED","object":{"kind":"Secret","metadata":{"name":"sqlbee-certs","resourceVersion":"2"},"data":{"tls.crt":"Y2VydA=="}}}
+{"type":"DELETED","object":{"kind":"Secret","metadata":{"name":"sqlbee-certs","resourceVersion":"3"}}}
+{"type":"ADDED","object":{"kind":"Secret","metadata":{"name":"sqlbee-certs","resourceVersion":"4"}}}
+`))
+		default:
+			w.Write([]byte(`{"type":"ERROR","object":{"kind":"Status","status":"Failure","message":"too old resource version: 0 (5)","reason":"Expired","code":410}}
+`))
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, "", server.Client())
+
+	versions := []string{}
+	onChange := func(secret *corev1.Secret) {
+		versions = append(versions, secret.ResourceVersion)
+	}
+	require.NoError(t, client.WatchSecret(context.Background(), "sqlbee", "sqlbee-certs", "1", onChange))
+	assert.Equal(t, []string{"2", "4"}, versions)
+
+	err := client.WatchSecret(context.Background(), "sqlbee", "sqlbee-certs", "0", onChange)
+	assert.EqualError(t, err, "API server responded with 410: too old resource version: 0 (5)")
+}
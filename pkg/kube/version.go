@@ -0,0 +1,38 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the API server as reported by its /version endpoint
+type Version struct {
+	Major      string `json:"major"`
+	Minor      string `json:"minor"`
+	GitVersion string `json:"gitVersion"`
+}
+
+// ServerVersion retrieves the version of the API server
+func (c *Client) ServerVersion(ctx context.Context) (*Version, error) {
+	version := &Version{}
+	if err := c.Get(ctx, "/version", version); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// AtLeast checks whether the version is equal to or newer than major.minor. Managed clusters
+// report minor versions like 28+, the suffix is ignored
+func (v *Version) AtLeast(major, minor int) (bool, error) {
+	vMajor, err := strconv.Atoi(strings.TrimRight(v.Major, "+"))
+	if err != nil {
+		return false, fmt.Errorf("Invalid major version %q", v.Major)
+	}
+	vMinor, err := strconv.Atoi(strings.TrimRight(v.Minor, "+"))
+	if err != nil {
+		return false, fmt.Errorf("Invalid minor version %q", v.Minor)
+	}
+	return vMajor > major || (vMajor == major && vMinor >= minor), nil
+}
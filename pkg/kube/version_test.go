@@ -0,0 +1,46 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/version", r.URL.Path)
+		w.Write([]byte(`{"major":"1","minor":"29+","gitVersion":"v1.29.4-gke.1043002"}`))
+	}))
+	defer server.Close()
+
+	version, err := NewClient(server.URL, "", server.Client()).ServerVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "v1.29.4-gke.1043002", version.GitVersion)
+
+	atLeast, err := version.AtLeast(1, 29)
+	require.NoError(t, err)
+	assert.True(t, atLeast)
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	for _, data := range []struct {
+		version Version
+		atLeast bool
+	}{
+		{version: Version{Major: "1", Minor: "28"}, atLeast: false},
+		{version: Version{Major: "1", Minor: "29"}, atLeast: true},
+		{version: Version{Major: "1", Minor: "30+"}, atLeast: true},
+		{version: Version{Major: "2", Minor: "0"}, atLeast: true},
+	} {
+		atLeast, err := data.version.AtLeast(1, 29)
+		require.NoError(t, err)
+		assert.Equal(t, data.atLeast, atLeast, data.version)
+	}
+
+	_, err := (&Version{Major: "1", Minor: ""}).AtLeast(1, 29)
+	assert.Error(t, err)
+}
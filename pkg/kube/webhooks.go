@@ -0,0 +1,54 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// WebhookPatcher sets the CA bundle of webhook configurations
+type WebhookPatcher interface {
+	PatchWebhookCABundle(ctx context.Context, name string, caBundle []byte) error
+}
+
+// PatchWebhookCABundle sets the CA bundle of all webhooks of the mutating webhook configuration, so
+// the API server trusts the certificates signed by it. Configurations already containing the CA
+// bundle are left unchanged
+func (c *Client) PatchWebhookCABundle(ctx context.Context, name string, caBundle []byte) error {
+	path := "/apis/admissionregistration.k8s.io/v1/mutatingwebhookconfigurations/" + name
+	config := struct {
+		Webhooks []struct {
+			Name         string `json:"name"`
+			ClientConfig struct {
+				CABundle []byte `json:"caBundle"`
+			} `json:"clientConfig"`
+		} `json:"webhooks"`
+	}{}
+	if err := c.Get(ctx, path, &config); err != nil {
+		return err
+	}
+
+	patch := []map[string]interface{}{}
+	for i, webhook := range config.Webhooks {
+		if bytes.Equal(webhook.ClientConfig.CABundle, caBundle) {
+			continue
+		}
+		// The webhooks are identified by their index, so the patch fails if they have been changed meanwhile
+		prefix := "/webhooks/" + strconv.Itoa(i)
+		patch = append(patch,
+			map[string]interface{}{"op": "test", "path": prefix + "/name", "value": webhook.Name},
+			map[string]interface{}{"op": "add", "path": prefix + "/clientConfig/caBundle", "value": caBundle},
+		)
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	return c.Do(ctx, http.MethodPatch, path, "application/json-patch+json", patch, nil)
+}
+
+// AnnotateWebhook sets the annotations of the mutating webhook configuration, keeping its other ones
+func (c *Client) AnnotateWebhook(ctx context.Context, name string, annotations map[string]string) error {
+	patch := map[string]interface{}{"metadata": map[string]interface{}{"annotations": annotations}}
+	return c.Do(ctx, http.MethodPatch, "/apis/admissionregistration.k8s.io/v1/mutatingwebhookconfigurations/"+name, "application/merge-patch+json", patch, nil)
+}
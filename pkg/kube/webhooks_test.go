@@ -0,0 +1,57 @@
+package kube
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPatchWebhookCABundle(t *testing.T) {
+	patches := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/apis/admissionregistration.k8s.io/v1/mutatingwebhookconfigurations/sqlbee-mutating-webhook-config", r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"kind":"MutatingWebhookConfiguration","webhooks":[{"name":"sqlbee-svc.sqlbee.svc","clientConfig":{"caBundle":"Y2E="}},{"name":"sqlbee-svc.other.svc","clientConfig":{}}]}`))
+		case http.MethodPatch:
+			assert.Equal(t, "application/json-patch+json", r.Header.Get("Content-Type"))
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			patches = append(patches, string(body))
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, "", server.Client())
+
+	require.NoError(t, client.PatchWebhookCABundle(context.Background(), "sqlbee-mutating-webhook-config", []byte("ca")))
+	require.Len(t, patches, 1)
+	assert.JSONEq(t, `[
+		{"op":"test","path":"/webhooks/1/name","value":"sqlbee-svc.other.svc"},
+		{"op":"add","path":"/webhooks/1/clientConfig/caBundle","value":"Y2E="}
+	]`, patches[0])
+
+	require.NoError(t, client.PatchWebhookCABundle(context.Background(), "sqlbee-mutating-webhook-config", []byte("new-ca")))
+	assert.Len(t, patches, 2)
+}
+
+func TestClientAnnotateWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPatch, r.Method)
+		assert.Equal(t, "/apis/admissionregistration.k8s.io/v1/mutatingwebhookconfigurations/sqlbee-mutating-webhook-config", r.URL.Path)
+		assert.Equal(t, "application/merge-patch+json", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"metadata":{"annotations":{"cert-manager.io/inject-ca-from":"sqlbee/sqlbee-certs"}}}`, string(body))
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, "", server.Client())
+
+	require.NoError(t, client.AnnotateWebhook(context.Background(), "sqlbee-mutating-webhook-config", map[string]string{AnnotationInjectCAFrom: "sqlbee/sqlbee-certs"}))
+}
@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The number of images cached at most, further images evict the entry expiring first
+const digestCacheSize = 1000
+
+type digestEntry struct {
+	image   string
+	err     error
+	expires time.Time
+}
+
+// DigestCache caches the images resolved by another DigestResolver for a limited time, so admission
+// requests don't need to wait for the registry each time. Failures are cached as well, so an unavailable
+// registry doesn't delay every request
+type DigestCache struct {
+	resolver   DigestResolver
+	ttl        time.Duration
+	failureTTL time.Duration
+	size       int
+	lock       *sync.Mutex
+	entries    map[string]digestEntry
+}
+
+// NewDigestCache creates a new DigestCache which caches resolved images for the duration of ttl and
+// failures to resolve them for the duration of failureTTL
+func NewDigestCache(resolver DigestResolver, ttl, failureTTL time.Duration) *DigestCache {
+	return &DigestCache{
+		resolver:   resolver,
+		ttl:        ttl,
+		failureTTL: failureTTL,
+		size:       digestCacheSize,
+		lock:       &sync.Mutex{},
+		entries:    map[string]digestEntry{},
+	}
+}
+
+// ResolveDigest returns the cached pinned image or failure, or resolves it if it isn't cached or expired
+func (d *DigestCache) ResolveDigest(ctx context.Context, image string) (string, error) {
+	d.lock.Lock()
+	entry, exists := d.entries[image]
+	d.lock.Unlock()
+	if exists && time.Now().Before(entry.expires) {
+		return entry.image, entry.err
+	}
+
+	pinned, err := d.resolver.ResolveDigest(ctx, image)
+	if err != nil {
+		// Canceled requests tell nothing about the registry
+		if ctx.Err() == nil && d.failureTTL > 0 {
+			d.store(image, digestEntry{err: err, expires: time.Now().Add(d.failureTTL)})
+		}
+		return "", err
+	}
+	d.store(image, digestEntry{image: pinned, expires: time.Now().Add(d.ttl)})
+	return pinned, nil
+}
+
+// store caches the entry of the image. If the cache is full, expired entries are evicted or else the
+// entry expiring first
+func (d *DigestCache) store(image string, entry digestEntry) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, exists := d.entries[image]; !exists && len(d.entries) >= d.size {
+		now := time.Now()
+		first := ""
+		for key, cached := range d.entries {
+			if !now.Before(cached.expires) {
+				delete(d.entries, key)
+			} else if first == "" || cached.expires.Before(d.entries[first].expires) {
+				first = key
+			}
+		}
+		if len(d.entries) >= d.size {
+			delete(d.entries, first)
+		}
+	}
+	d.entries[image] = entry
+}
@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingResolver struct {
+	calls int
+	err   error
+}
+
+func (c *countingResolver) ResolveDigest(ctx context.Context, image string) (string, error) {
+	c.calls++
+	if c.err != nil {
+		return "", c.err
+	}
+	return image + "@" + testDigest, nil
+}
+
+func TestDigestCache(t *testing.T) {
+	resolver := &countingResolver{}
+	cache := NewDigestCache(resolver, time.Hour, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		image, err := cache.ResolveDigest(context.Background(), "gce-proxy:1.33.1")
+		require.NoError(t, err)
+		assert.Equal(t, "gce-proxy:1.33.1@"+testDigest, image)
+	}
+	assert.Equal(t, 1, resolver.calls)
+
+	expiring := NewDigestCache(resolver, 0, 0)
+	for i := 0; i < 2; i++ {
+		_, err := expiring.ResolveDigest(context.Background(), "gce-proxy:1.33.1")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, resolver.calls)
+
+	// Failures are cached unless the request gave up
+	unavailable := &countingResolver{err: errors.New("unavailable")}
+	failing := NewDigestCache(unavailable, time.Hour, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := failing.ResolveDigest(ctx, "gce-proxy:1.33.1")
+	assert.Error(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := failing.ResolveDigest(context.Background(), "gce-proxy:1.33.1")
+		assert.EqualError(t, err, "unavailable")
+	}
+	assert.Equal(t, 2, unavailable.calls)
+}
+
+func TestDigestCacheEviction(t *testing.T) {
+	resolver := &countingResolver{}
+	cache := NewDigestCache(resolver, time.Hour, time.Hour)
+	cache.size = 2
+	cache.entries["gce-proxy:1.33.0"] = digestEntry{expires: time.Now().Add(time.Minute)}
+	cache.entries["gce-proxy:1.33.1"] = digestEntry{expires: time.Now().Add(2 * time.Minute)}
+
+	_, err := cache.ResolveDigest(context.Background(), "gce-proxy:1.33.2")
+	require.NoError(t, err)
+	assert.Len(t, cache.entries, 2)
+	// The entry expiring first has been evicted
+	assert.NotContains(t, cache.entries, "gce-proxy:1.33.0")
+
+	// Expired entries are evicted first
+	cache.entries["gce-proxy:1.33.2"] = digestEntry{expires: time.Now().Add(-time.Second)}
+	_, err = cache.ResolveDigest(context.Background(), "gce-proxy:1.33.3")
+	require.NoError(t, err)
+	assert.Len(t, cache.entries, 2)
+	assert.Contains(t, cache.entries, "gce-proxy:1.33.1")
+	assert.Contains(t, cache.entries, "gce-proxy:1.33.3")
+}
@@ -0,0 +1,229 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// Docker Hub images are normalized to docker.io, but served by a different host
+	dockerHubDomain   = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+	// Docker Hub issues its tokens from yet another host
+	dockerHubAuth = "auth.docker.io"
+)
+
+// Accepted manifest types. Multi-platform images are resolved to the digest of their index
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// StatusError is returned if the registry responded with an error status
+type StatusError struct {
+	Code  int
+	Image string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Registry responded with %d for image %s", e.Code, e.Image)
+}
+
+// DigestResolver pins images to the digest their tag currently refers to
+type DigestResolver interface {
+	ResolveDigest(ctx context.Context, image string) (string, error)
+}
+
+// Client is a minimal client for the Docker registry HTTP API V2. It supports only what SQLBee needs
+// and authenticates anonymously, e.g. against gcr.io, if the registry requests a bearer token
+type Client struct {
+	httpClient *http.Client
+	registries map[string]bool
+}
+
+// NewClient creates a new Client using the httpClient for requests to the registries. Images of other
+// registries aren't resolved, as the images are chosen by the annotations of untrusted objects
+func NewClient(httpClient *http.Client, registries []string) *Client {
+	allowed := map[string]bool{}
+	for _, registry := range registries {
+		allowed[registry] = true
+	}
+	return &Client{httpClient: httpClient, registries: allowed}
+}
+
+// ImageRegistry returns the registry of an image, e.g. gcr.io of gcr.io/cloudsql-docker/gce-proxy:1.33.1
+func ImageRegistry(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	return reference.Domain(named), nil
+}
+
+// ResolveDigest returns the image pinned to the digest of its tag, e.g.
+// gcr.io/cloudsql-docker/gce-proxy:1.33.1@sha256:... Images without tag refer to latest, images
+// which are already pinned are returned unchanged
+func (c *Client) ResolveDigest(ctx context.Context, image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	if _, pinned := named.(reference.Digested); pinned {
+		return image, nil
+	}
+	tagged, ok := reference.TagNameOnly(named).(reference.NamedTagged)
+	if !ok {
+		return "", fmt.Errorf("Image %s has no tag", image)
+	}
+
+	host := reference.Domain(tagged)
+	if !c.registries[host] {
+		return "", fmt.Errorf("Registry %s of image %s is not allowed", host, image)
+	}
+	if host == dockerHubDomain {
+		host = dockerHubRegistry
+	}
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, reference.Path(tagged), tagged.Tag())
+
+	resp, err := c.headManifest(ctx, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := c.token(ctx, host, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = c.headManifest(ctx, manifestURL, token); err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{Code: resp.StatusCode, Image: image}
+	}
+
+	dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		return "", fmt.Errorf("Invalid digest of image %s: %s", image, err)
+	}
+	pinned, err := reference.WithDigest(tagged, dgst)
+	if err != nil {
+		return "", err
+	}
+	return reference.FamiliarString(pinned), nil
+}
+
+// headManifest requests the headers of a manifest, authenticated with the bearer token if not empty
+func (c *Client) headManifest(ctx context.Context, manifestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// token retrieves an anonymous bearer token as requested by the challenge of the registry at host. The
+// token endpoint needs to be served via HTTPS by the registry itself or another allowed registry
+func (c *Client) token(ctx context.Context, host, challenge string) (string, error) {
+	params := parseChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("Unsupported authentication challenge %q", challenge)
+	}
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	if tokenURL.Scheme != "https" {
+		return "", fmt.Errorf("Token endpoint %s of registry %s doesn't use HTTPS", realm, host)
+	}
+	if tokenURL.Host != host && !c.registries[tokenURL.Host] && !(host == dockerHubRegistry && tokenURL.Host == dockerHubAuth) {
+		return "", fmt.Errorf("Token endpoint %s of registry %s is not allowed", realm, host)
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Token endpoint %s responded with %d", realm, resp.StatusCode)
+	}
+	result := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.Token != "" {
+		return result.Token, nil
+	}
+	return result.AccessToken, nil
+}
+
+// parseChallenge parses the parameters of a bearer challenge like
+// Bearer realm="https://gcr.io/v2/token",service="gcr.io",scope="repository:cloudsql-docker/gce-proxy:pull"
+func parseChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+	scheme, rest := challenge, ""
+	if i := strings.Index(challenge, " "); i >= 0 {
+		scheme, rest = challenge[:i], challenge[i+1:]
+	}
+	if !strings.EqualFold(scheme, "Bearer") {
+		return params
+	}
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		i := strings.Index(rest, "=")
+		if i < 0 {
+			break
+		}
+		key, value := strings.TrimSpace(rest[:i]), ""
+		rest = rest[i+1:]
+		if strings.HasPrefix(rest, `"`) {
+			rest = rest[1:]
+			if end := strings.Index(rest, `"`); end >= 0 {
+				value, rest = rest[:end], rest[end+1:]
+			} else {
+				value, rest = rest, ""
+			}
+		} else if end := strings.Index(rest, ","); end >= 0 {
+			value, rest = rest[:end], rest[end:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[strings.ToLower(key)] = value
+	}
+	return params
+}
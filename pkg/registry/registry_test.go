@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDigest = "sha256:9e5ab5a7a7ba1c1d3e7e3f7c1d0b2c9a2b3a7f1f6e5e4d3c2b1a09f8e7d6c5b4"
+
+func TestResolveDigest(t *testing.T) {
+	realm := ""
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			assert.Equal(t, "registry", r.URL.Query().Get("service"))
+			assert.Equal(t, "repository:cloudsql/gce-proxy:pull", r.URL.Query().Get("scope"))
+			w.Write([]byte(`{"token":"anonymous"}`))
+		case "/v2/cloudsql/gce-proxy/manifests/1.33.1":
+			if r.Header.Get("Authorization") != "Bearer anonymous" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`",service="registry",scope="repository:cloudsql/gce-proxy:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			assert.Equal(t, http.MethodHead, r.Method)
+			assert.Contains(t, r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json")
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	realm = server.URL + "/token"
+	host := strings.TrimPrefix(server.URL, "https://")
+	client := NewClient(server.Client(), []string{host})
+
+	image, err := client.ResolveDigest(context.Background(), host+"/cloudsql/gce-proxy:1.33.1")
+	require.NoError(t, err)
+	assert.Equal(t, host+"/cloudsql/gce-proxy:1.33.1@"+testDigest, image)
+
+	pinned := host + "/cloudsql/gce-proxy@" + testDigest
+	image, err = client.ResolveDigest(context.Background(), pinned)
+	require.NoError(t, err)
+	assert.Equal(t, pinned, image)
+
+	_, err = client.ResolveDigest(context.Background(), host+"/cloudsql/gce-proxy:unknown")
+	assert.EqualError(t, err, "Registry responded with 404 for image "+host+"/cloudsql/gce-proxy:unknown")
+
+	// Neither the images nor the registries choose the hosts requested
+	_, err = NewClient(server.Client(), []string{"gcr.io"}).ResolveDigest(context.Background(), host+"/cloudsql/gce-proxy:1.33.1")
+	assert.EqualError(t, err, "Registry "+host+" of image "+host+"/cloudsql/gce-proxy:1.33.1 is not allowed")
+	for endpoint, message := range map[string]string{
+		"http://" + host + "/token":                "doesn't use HTTPS",
+		"https://169.254.169.254/computeMetadata/": "is not allowed",
+	} {
+		realm = endpoint
+		_, err = client.ResolveDigest(context.Background(), host+"/cloudsql/gce-proxy:1.33.1")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), message)
+	}
+}
+
+func TestImageRegistry(t *testing.T) {
+	for image, expected := range map[string]string{
+		"gcr.io/cloudsql-docker/gce-proxy:1.33.1": "gcr.io",
+		"registry.internal:5000/cloudsql/proxy":   "registry.internal:5000",
+		"busybox":                                 "docker.io",
+	} {
+		registry, err := ImageRegistry(image)
+		require.NoError(t, err)
+		assert.Equal(t, expected, registry)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"realm":   "https://gcr.io/v2/token",
+		"service": "gcr.io",
+		"scope":   "repository:cloudsql-docker/gce-proxy:pull",
+	}, parseChallenge(`Bearer realm="https://gcr.io/v2/token",service="gcr.io",scope="repository:cloudsql-docker/gce-proxy:pull"`))
+	assert.Equal(t, map[string]string{"realm": "https://auth", "service": "registry"}, parseChallenge(`bearer realm="https://auth", service=registry`))
+	assert.Empty(t, parseChallenge(`Basic realm="registry"`))
+}
@@ -0,0 +1,159 @@
+package secretmanager
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEndpoint is the endpoint of the Secret Manager API
+	DefaultEndpoint = "https://secretmanager.googleapis.com"
+	// DefaultTokenURL is the endpoint of the metadata server providing access tokens of the service
+	// account of the workload, e.g. via GKE workload identity
+	DefaultTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// StatusError is returned if the Secret Manager API or the metadata server responded with an error status
+type StatusError struct {
+	Code int
+	URL  string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s responded with %d", e.URL, e.Code)
+}
+
+// SecretAccessor retrieves the payload of secret versions
+type SecretAccessor interface {
+	AccessSecret(ctx context.Context, name string) ([]byte, error)
+}
+
+// VersionName returns the resource name of the secret version. Secrets like projects/p/secrets/s refer
+// to their latest version. Returns an error if the name is no valid resource name of a secret
+func VersionName(name string) (string, error) {
+	parts := strings.Split(name, "/")
+	valid := (len(parts) == 4 || len(parts) == 6) && parts[0] == "projects" && parts[2] == "secrets"
+	for _, part := range parts {
+		valid = valid && part != ""
+	}
+	if len(parts) == 6 {
+		valid = valid && parts[4] == "versions"
+	}
+	if !valid {
+		return "", fmt.Errorf("Invalid secret %q, expected projects/<project>/secrets/<secret>[/versions/<version>]", name)
+	}
+	if len(parts) == 4 {
+		return name + "/versions/latest", nil
+	}
+	return name, nil
+}
+
+// Allowed returns whether the secret version source refers to is one of the allowed secrets. Allowed
+// secrets without a version allow each of their versions
+func Allowed(source string, allowed []string) bool {
+	version, err := VersionName(source)
+	if err != nil {
+		return false
+	}
+	for _, secret := range allowed {
+		allowedVersion, err := VersionName(secret)
+		if err != nil {
+			continue
+		}
+		if version == allowedVersion || (strings.Count(secret, "/") == 3 && strings.HasPrefix(version, secret+"/versions/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client is a minimal client for the Secret Manager API. It supports only what SQLBee needs and
+// authenticates with access tokens of the metadata server, which are cached until they expire
+type Client struct {
+	httpClient *http.Client
+	endpoint   string
+	tokenURL   string
+
+	lock    *sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewClient creates a new Client sending requests to the endpoint, authenticated with tokens of tokenURL
+func NewClient(httpClient *http.Client, endpoint, tokenURL string) *Client {
+	return &Client{
+		httpClient: httpClient,
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		tokenURL:   tokenURL,
+		lock:       &sync.Mutex{},
+	}
+}
+
+// AccessSecret returns the payload of the secret version
+func (c *Client) AccessSecret(ctx context.Context, name string) ([]byte, error) {
+	name, err := VersionName(name)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}{}
+	if err := c.get(ctx, c.endpoint+"/v1/"+name+":access", map[string]string{"Authorization": "Bearer " + token}, &result); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.Payload.Data)
+}
+
+// accessToken returns the cached access token or retrieves a new one from the metadata server
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+	result := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err := c.get(ctx, c.tokenURL, map[string]string{"Metadata-Flavor": "Google"}, &result); err != nil {
+		return "", err
+	}
+	// Renew the token a minute before it expires
+	c.token = result.AccessToken
+	c.expires = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// get sends a GET request with the headers and decodes the JSON response into result
+func (c *Client) get(ctx context.Context, url string, headers map[string]string, result interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Code: resp.StatusCode, URL: url}
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
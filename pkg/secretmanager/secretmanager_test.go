@@ -0,0 +1,59 @@
+package secretmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"projects/p/secrets/s":             "projects/p/secrets/s/versions/latest",
+		"projects/p/secrets/s/versions/3":  "projects/p/secrets/s/versions/3",
+		"projects/p/secrets":               "",
+		"projects/p/secrets/s/versions/":   "",
+		"projects/p/configs/s/versions/3":  "",
+		"projects/p/secrets/s/revisions/3": "",
+	} {
+		version, err := VersionName(name)
+		if expected == "" {
+			assert.Error(t, err, name)
+			continue
+		}
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, version)
+	}
+}
+
+func TestClientAccessSecret(t *testing.T) {
+	tokens := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokens++
+			assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+			w.Write([]byte(`{"access_token":"secret-token","expires_in":3599,"token_type":"Bearer"}`))
+		case "/v1/projects/p/secrets/sql/versions/latest:access":
+			assert.Equal(t, "Bearer secret-token", r.Header.Get("Authorization"))
+			w.Write([]byte(`{"name":"projects/1/secrets/sql/versions/2","payload":{"data":"eyJ0eXBlIjoic2VydmljZV9hY2NvdW50In0="}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.Client(), server.URL, server.URL+"/token")
+
+	for i := 0; i < 2; i++ {
+		payload, err := client.AccessSecret(context.Background(), "projects/p/secrets/sql")
+		require.NoError(t, err)
+		assert.Equal(t, `{"type":"service_account"}`, string(payload))
+	}
+	assert.Equal(t, 1, tokens)
+
+	_, err := client.AccessSecret(context.Background(), "projects/p/secrets/unknown")
+	assert.Error(t, err)
+}
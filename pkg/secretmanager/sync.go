@@ -0,0 +1,153 @@
+package secretmanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+)
+
+const (
+	// LabelManaged marks secrets which are materialized from Secret Manager
+	LabelManaged = "sqlbee.connctd.io/secret-manager"
+	// AnnotationSource contains the resource name of the secret a Kubernetes secret is materialized from
+	AnnotationSource = "sqlbee.connctd.io/secret-manager-source"
+)
+
+// Materializer materializes secrets of Secret Manager as Kubernetes secrets
+type Materializer interface {
+	Materialize(ctx context.Context, source, namespace, name, key string) error
+}
+
+// The number of payloads cached at most, further payloads evict the entry expiring first
+const payloadCacheSize = 100
+
+type payloadEntry struct {
+	payload []byte
+	expires time.Time
+}
+
+// SecretSync materializes secrets of Secret Manager as Kubernetes secrets. Payloads are cached for a
+// limited time, so admission requests don't need to wait for Secret Manager each time, while rotated
+// versions are picked up afterwards. Kubernetes secrets which haven't been materialized by the sync
+// are never overwritten and only allowed secrets are materialized into the namespaces which opted in
+type SecretSync struct {
+	accessor   SecretAccessor
+	secrets    kube.SecretClient
+	allowed    []string
+	namespaces []string
+	ttl        time.Duration
+	size       int
+	lock       *sync.Mutex
+	entries    map[string]payloadEntry
+}
+
+// NewSecretSync creates a new SecretSync materializing the allowed secrets into the namespaces and caching
+// the payloads of their versions for the duration of ttl
+func NewSecretSync(accessor SecretAccessor, secrets kube.SecretClient, allowed, namespaces []string, ttl time.Duration) *SecretSync {
+	return &SecretSync{
+		accessor:   accessor,
+		secrets:    secrets,
+		allowed:    allowed,
+		namespaces: namespaces,
+		ttl:        ttl,
+		size:       payloadCacheSize,
+		lock:       &sync.Mutex{},
+		entries:    map[string]payloadEntry{},
+	}
+}
+
+// Materialize creates or updates the Kubernetes secret in the namespace, containing the payload of the
+// Secret Manager secret under the key
+func (s *SecretSync) Materialize(ctx context.Context, source, namespace, name, key string) error {
+	if !Allowed(source, s.allowed) {
+		return fmt.Errorf("Secret %s is not allowed to be materialized", source)
+	}
+	optedIn := false
+	for _, allowed := range s.namespaces {
+		optedIn = optedIn || allowed == namespace
+	}
+	if !optedIn {
+		return fmt.Errorf("Namespace %s didn't opt in to receive secrets of Secret Manager", namespace)
+	}
+	payload, err := s.payload(ctx, source)
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{LabelManaged: "true"},
+			Annotations: map[string]string{AnnotationSource: source},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{key: payload},
+	}
+
+	existing, err := s.secrets.GetSecret(ctx, namespace, name)
+	if kube.IsNotFound(err) {
+		return s.secrets.CreateSecret(ctx, secret)
+	} else if err != nil {
+		return err
+	}
+	if existing.Labels[LabelManaged] != "true" {
+		return nil
+	}
+	if existing.Annotations[AnnotationSource] == source && len(existing.Data) == 1 && bytes.Equal(existing.Data[key], payload) {
+		return nil
+	}
+	secret.ResourceVersion = existing.ResourceVersion
+	return s.secrets.UpdateSecret(ctx, secret)
+}
+
+// payload returns the cached payload of the secret or accesses it if it isn't cached or expired
+func (s *SecretSync) payload(ctx context.Context, source string) ([]byte, error) {
+	s.lock.Lock()
+	entry, exists := s.entries[source]
+	if exists && !time.Now().Before(entry.expires) {
+		// The credentials aren't kept in memory longer than needed
+		delete(s.entries, source)
+	}
+	s.lock.Unlock()
+	if exists && time.Now().Before(entry.expires) {
+		return entry.payload, nil
+	}
+
+	payload, err := s.accessor.AccessSecret(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	s.store(source, payloadEntry{payload: payload, expires: time.Now().Add(s.ttl)})
+	return payload, nil
+}
+
+// store caches the payload of the secret. Expired payloads are evicted, and the payload expiring first
+// if the cache is full
+func (s *SecretSync) store(source string, entry payloadEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	first := ""
+	for key, cached := range s.entries {
+		if !now.Before(cached.expires) {
+			delete(s.entries, key)
+		} else if key != source && (first == "" || cached.expires.Before(s.entries[first].expires)) {
+			first = key
+		}
+	}
+	if !now.Before(entry.expires) {
+		return
+	}
+	if _, exists := s.entries[source]; !exists && len(s.entries) >= s.size {
+		delete(s.entries, first)
+	}
+	s.entries[source] = entry
+}
@@ -0,0 +1,102 @@
+package secretmanager
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/connctd/sqlbee/pkg/kube"
+)
+
+type staticAccessor struct {
+	payloads map[string]string
+	calls    int
+}
+
+func (s *staticAccessor) AccessSecret(ctx context.Context, name string) ([]byte, error) {
+	s.calls++
+	return []byte(s.payloads[name]), nil
+}
+
+// fakeSecrets stores secrets by namespace/name in memory
+type fakeSecrets map[string]*corev1.Secret
+
+func (f fakeSecrets) GetSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	secret, exists := f[namespace+"/"+name]
+	if !exists {
+		return nil, &kube.StatusError{Code: http.StatusNotFound}
+	}
+	return secret.DeepCopy(), nil
+}
+
+func (f fakeSecrets) CreateSecret(ctx context.Context, secret *corev1.Secret) error {
+	f[secret.Namespace+"/"+secret.Name] = secret.DeepCopy()
+	return nil
+}
+
+func (f fakeSecrets) UpdateSecret(ctx context.Context, secret *corev1.Secret) error {
+	f[secret.Namespace+"/"+secret.Name] = secret.DeepCopy()
+	return nil
+}
+
+func TestSecretSync(t *testing.T) {
+	accessor := &staticAccessor{payloads: map[string]string{"projects/p/secrets/sql": "v1"}}
+	foreign := &corev1.Secret{Data: map[string][]byte{"credentials.json": []byte("team-b")}}
+	secrets := fakeSecrets{"team-b/creds": foreign}
+	sync := NewSecretSync(accessor, secrets, []string{"projects/p/secrets/sql"}, []string{"team-a", "team-b"}, time.Hour)
+
+	for _, namespace := range []string{"team-a", "team-a", "team-b"} {
+		require.NoError(t, sync.Materialize(context.Background(), "projects/p/secrets/sql", namespace, "creds", "credentials.json"))
+	}
+	assert.Equal(t, 1, accessor.calls)
+	secret := secrets["team-a/creds"]
+	require.NotNil(t, secret)
+	assert.Equal(t, []byte("v1"), secret.Data["credentials.json"])
+	assert.Equal(t, "true", secret.Labels[LabelManaged])
+	assert.Equal(t, "projects/p/secrets/sql", secret.Annotations[AnnotationSource])
+	// Secrets not materialized by the sync are left alone
+	assert.Equal(t, []byte("team-b"), secrets["team-b/creds"].Data["credentials.json"])
+
+	// Rotated versions are picked up once the cached payload expires
+	accessor.payloads["projects/p/secrets/sql"] = "v2"
+	expiring := NewSecretSync(accessor, secrets, []string{"projects/p/secrets/sql"}, []string{"team-a"}, -time.Second)
+	require.NoError(t, expiring.Materialize(context.Background(), "projects/p/secrets/sql", "team-a", "creds", "credentials.json"))
+	assert.Equal(t, []byte("v2"), secrets["team-a/creds"].Data["credentials.json"])
+	// Expired payloads aren't kept
+	assert.Empty(t, expiring.entries)
+
+	// Secrets which aren't allowed are never accessed or materialized
+	calls := accessor.calls
+	assert.Error(t, sync.Materialize(context.Background(), "projects/p/secrets/other", "team-a", "other", "credentials.json"))
+	assert.Equal(t, calls, accessor.calls)
+	assert.NotContains(t, secrets, "team-a/other")
+
+	// Namespaces which didn't opt in don't receive secrets
+	assert.Error(t, sync.Materialize(context.Background(), "projects/p/secrets/sql", "team-c", "creds", "credentials.json"))
+	assert.NotContains(t, secrets, "team-c/creds")
+}
+
+func TestSecretSyncEviction(t *testing.T) {
+	accessor := &staticAccessor{payloads: map[string]string{}}
+	sync := NewSecretSync(accessor, fakeSecrets{}, []string{"projects/p/secrets/sql"}, []string{"team-a"}, time.Hour)
+	sync.size = 2
+	sync.entries["projects/p/secrets/sql/versions/1"] = payloadEntry{expires: time.Now().Add(time.Minute)}
+	sync.entries["projects/p/secrets/sql/versions/2"] = payloadEntry{expires: time.Now().Add(2 * time.Minute)}
+
+	require.NoError(t, sync.Materialize(context.Background(), "projects/p/secrets/sql/versions/3", "team-a", "creds", "credentials.json"))
+	assert.Len(t, sync.entries, 2)
+	// The payload expiring first has been evicted
+	assert.NotContains(t, sync.entries, "projects/p/secrets/sql/versions/1")
+
+	// Expired payloads are evicted first
+	sync.entries["projects/p/secrets/sql/versions/3"] = payloadEntry{expires: time.Now().Add(-time.Second)}
+	require.NoError(t, sync.Materialize(context.Background(), "projects/p/secrets/sql/versions/4", "team-a", "creds", "credentials.json"))
+	assert.Len(t, sync.entries, 2)
+	assert.Contains(t, sync.entries, "projects/p/secrets/sql/versions/2")
+	assert.Contains(t, sync.entries, "projects/p/secrets/sql/versions/4")
+}
@@ -0,0 +1,160 @@
+package sting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Decisions of the AuditRecord
+const (
+	DecisionAllowed = "allowed"
+	DecisionMutated = "mutated"
+	DecisionDenied  = "denied"
+)
+
+// AuditRecord records an admission decision, e.g. who got an object mutated and when
+type AuditRecord struct {
+	Time      time.Time         `json:"time"`
+	UID       types.UID         `json:"uid"`
+	RequestID string            `json:"requestID,omitempty"`
+	Endpoint  string            `json:"endpoint"`
+	Operation v1beta1.Operation `json:"operation,omitempty"`
+	Kind      string            `json:"kind,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	User      string            `json:"user,omitempty"`
+	Decision  string            `json:"decision"`
+	// Patch summarizes the operations of the patch like add /spec/containers/-
+	Patch []string `json:"patch,omitempty"`
+	// JSONPatch is the full JSON patch of mutated objects, e.g. for compliance systems
+	JSONPatch json.RawMessage `json:"jsonPatch,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Warnings  []string        `json:"warnings,omitempty"`
+	// Code, StatusReason and Causes distinguish the denials, e.g. invalid objects from policy violations
+	Code         int32               `json:"code,omitempty"`
+	StatusReason metav1.StatusReason `json:"statusReason,omitempty"`
+	Causes       []string            `json:"causes,omitempty"`
+	// Shadow marks decisions which haven't been enforced, as the InjectServer runs in shadow mode
+	Shadow bool `json:"shadow,omitempty"`
+}
+
+// Auditor records the admission decisions of the InjectServer
+type Auditor interface {
+	Audit(record AuditRecord) error
+}
+
+// JSONAuditor writes the audit records as JSON, one per line
+type JSONAuditor struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewJSONAuditor creates an Auditor writing to w, like os.Stdout or a RotatingFile
+func NewJSONAuditor(w io.Writer) *JSONAuditor {
+	return &JSONAuditor{w: w}
+}
+
+// Audit writes the record
+func (j *JSONAuditor) Audit(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	_, err = j.w.Write(append(line, '\n'))
+	return err
+}
+
+// auditRecord returns the record of the admission decision
+func auditRecord(ctx context.Context, ar *v1beta1.AdmissionReview, response *v1beta1.AdmissionResponse) AuditRecord {
+	info, _ := RequestInfoFromContext(ctx)
+	record := AuditRecord{
+		Time:      time.Now().UTC(),
+		UID:       info.UID,
+		RequestID: info.RequestID,
+		Endpoint:  info.Endpoint,
+		Operation: info.Operation,
+		Namespace: info.Namespace,
+		Name:      info.Name,
+		Decision:  DecisionAllowed,
+		Warnings:  Warnings(response),
+	}
+	if ar.Request != nil {
+		record.Kind = ar.Request.Kind.Kind
+		record.User = ar.Request.UserInfo.Username
+		// The name of created objects is often generated
+		if record.Name == "" && len(ar.Request.Object.Raw) > 0 {
+			object := struct {
+				Metadata struct {
+					GenerateName string `json:"generateName"`
+				} `json:"metadata"`
+			}{}
+			if json.Unmarshal(ar.Request.Object.Raw, &object) == nil {
+				record.Name = object.Metadata.GenerateName
+			}
+		}
+	}
+	if len(record.Warnings) == 0 {
+		record.Warnings = nil
+	}
+	if response.Result != nil {
+		record.Reason = response.Result.Message
+		record.Code = response.Result.Code
+		record.StatusReason = response.Result.Reason
+		if response.Result.Details != nil {
+			for _, cause := range response.Result.Details.Causes {
+				record.Causes = append(record.Causes, causeSummary(cause))
+			}
+		}
+	}
+	if !response.Allowed {
+		record.Decision = DecisionDenied
+		return record
+	}
+	operations := []struct {
+		Op   string `json:"op"`
+		Path string `json:"path"`
+	}{}
+	if len(response.Patch) > 0 && json.Unmarshal(response.Patch, &operations) == nil && len(operations) > 0 {
+		record.Decision = DecisionMutated
+		record.JSONPatch = json.RawMessage(response.Patch)
+		for _, operation := range operations {
+			record.Patch = append(record.Patch, fmt.Sprintf("%s %s", operation.Op, operation.Path))
+		}
+	}
+	return record
+}
+
+// causeSummary summarizes the cause of a denial like field: message
+func causeSummary(cause metav1.StatusCause) string {
+	if cause.Field == "" {
+		return cause.Message
+	}
+	return fmt.Sprintf("%s: %s", cause.Field, cause.Message)
+}
+
+// audit keeps the admission decision for the endpoint /decisions and records it if an Auditor is
+// configured
+func (i *InjectServer) audit(ctx context.Context, ar *v1beta1.AdmissionReview, response *v1beta1.AdmissionResponse) {
+	if i.auditor == nil && i.decisions == nil {
+		return
+	}
+	record := auditRecord(ctx, ar, response)
+	record.Shadow = i.shadow
+	i.decisions.add(recentDecision(ctx, record, response))
+	if i.auditor == nil {
+		return
+	}
+	if err := i.auditor.Audit(record); err != nil {
+		LoggerFromContext(ctx).Error("Failed to record the admission decision", err, nil)
+	}
+}
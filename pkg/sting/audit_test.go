@@ -0,0 +1,88 @@
+package sting
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAudit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	opts := &Options{
+		Mutators: []Mutator{
+			{Name: "patch", Mutate: func(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+				response := &v1beta1.AdmissionResponse{Allowed: true, Patch: []byte(`[{"op":"add","path":"/spec/containers/-","value":{}},{"op":"replace","path":"/spec/volumes","value":[]}]`)}
+				AddWarning(response, "defaulted")
+				return response
+			}},
+			{Name: "deny", Mutate: denyWith("instance missing")},
+			{Name: "skip", Mutate: denyWith("unused"), NeedsMutate: func(*v1beta1.AdmissionReview) bool { return false }},
+		},
+		Auditor: NewJSONAuditor(buf),
+	}
+	mutators, err := opts.mutators()
+	require.NoError(t, err)
+	r := (&InjectServer{logger: newStdLogger(), auditor: opts.Auditor}).router(opts, mutators)
+
+	review := `{"request":{"uid":"uid","kind":{"version":"v1","kind":"Pod"},"namespace":"team-a","operation":"CREATE",` +
+		`"userInfo":{"username":"system:serviceaccount:kube-system:replicaset-controller"},"object":{"metadata":{"generateName":"app-"}}}}`
+	for _, path := range []string{"/api/v1beta/mutate/patch", "/api/v1beta/mutate/deny", "/api/v1beta/mutate/skip"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(review))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	records := make([]AuditRecord, len(lines))
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal([]byte(line), &records[i]))
+		assert.False(t, records[i].Time.IsZero())
+		records[i].Time = records[0].Time
+	}
+	expected := AuditRecord{
+		Time:      records[0].Time,
+		UID:       "uid",
+		RequestID: "uid",
+		Operation: v1beta1.Create,
+		Kind:      "Pod",
+		Namespace: "team-a",
+		Name:      "app-",
+		User:      "system:serviceaccount:kube-system:replicaset-controller",
+	}
+
+	mutated := expected
+	mutated.Endpoint, mutated.Decision, mutated.Warnings = "patch", DecisionMutated, []string{"defaulted"}
+	mutated.Patch = []string{"add /spec/containers/-", "replace /spec/volumes"}
+	mutated.JSONPatch = json.RawMessage(`[{"op":"add","path":"/spec/containers/-","value":{}},{"op":"replace","path":"/spec/volumes","value":[]}]`)
+	denied := expected
+	denied.Endpoint, denied.Decision, denied.Reason = "deny", DecisionDenied, "instance missing"
+	allowed := expected
+	allowed.Endpoint, allowed.Decision, allowed.Reason = "skip", DecisionAllowed, "This resource does not need mutation"
+	assert.Equal(t, []AuditRecord{mutated, denied, allowed}, records)
+}
+
+func TestAuditRecordWithoutPatch(t *testing.T) {
+	ar := &v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{UID: "uid"}}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1beta/admit", nil)
+	ctx, cancel := requestContext(r, ar, admitEndpoint, 0, newStdLogger())
+	defer cancel()
+
+	record := auditRecord(ctx, ar, &v1beta1.AdmissionResponse{Allowed: true, Patch: []byte("[]"), Result: &metav1.Status{}})
+	assert.Equal(t, DecisionAllowed, record.Decision)
+	assert.Empty(t, record.Patch)
+	assert.Empty(t, record.JSONPatch)
+	assert.Nil(t, record.Warnings)
+	assert.Equal(t, admitEndpoint, record.Endpoint)
+}
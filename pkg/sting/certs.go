@@ -0,0 +1,217 @@
+package sting
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/howeyc/fsnotify"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// How long to wait before the certificate secret is retrieved again after its watch failed
+const certSecretRetryDelay = 5 * time.Second
+
+// SecretWatcher retrieves a secret and watches it for changes, like the client of package kube
+type SecretWatcher interface {
+	GetSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error)
+	// WatchSecret calls onChange with every change of the secret after the resource version and
+	// returns once the watch has ended
+	WatchSecret(ctx context.Context, namespace, name, resourceVersion string, onChange func(*corev1.Secret)) error
+}
+
+// CertificateSource provides the serving certificate of the admission endpoint. It is asked during
+// every TLS handshake, so rotated certificates are served immediately
+type CertificateSource interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// CertificateSourceFunc adapts a function like the GetCertificate of tls.Config to a CertificateSource
+type CertificateSourceFunc func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+
+// GetCertificate calls the function
+func (f CertificateSourceFunc) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return f(hello)
+}
+
+// certFromSecret parses the keypair of a TLS secret, like the ones managed by cert-manager
+func certFromSecret(secret *corev1.Secret) (*tls.Certificate, error) {
+	if len(secret.Data[corev1.TLSCertKey]) == 0 || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		return nil, fmt.Errorf("Secret %s/%s doesn't contain %s and %s", secret.Namespace, secret.Name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+	pair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, err
+	}
+	return &pair, nil
+}
+
+// loadCertSecret retrieves the certificate secret and uses its keypair. It returns the resource
+// version of the secret
+func (i *InjectServer) loadCertSecret(ctx context.Context, watcher SecretWatcher, ref *corev1.SecretReference) (string, error) {
+	secret, err := watcher.GetSecret(ctx, ref.Namespace, ref.Name)
+	if err != nil {
+		return "", err
+	}
+	pair, err := certFromSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	i.setCert(pair)
+	return secret.ResourceVersion, nil
+}
+
+// watchCertSecret reloads the keypair whenever the certificate secret changes, until the context
+// is done. Updates of the secret which can't be parsed are logged and the current keypair is kept
+func (i *InjectServer) watchCertSecret(ctx context.Context, watcher SecretWatcher, ref *corev1.SecretReference, resourceVersion string) {
+	fields := Fields{"namespace": ref.Namespace, "secret": ref.Name}
+	for ctx.Err() == nil {
+		err := watcher.WatchSecret(ctx, ref.Namespace, ref.Name, resourceVersion, func(secret *corev1.Secret) {
+			resourceVersion = secret.ResourceVersion
+			pair, err := certFromSecret(secret)
+			if err != nil {
+				i.logger.Error("Failed to reload keypair from secret", err, fields)
+				return
+			}
+			i.logger.Info("Certificate secret has been updated reloading keypair", fields)
+			i.setCert(pair)
+		})
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+
+		// The resource version might be outdated, so the secret is retrieved again before watching it
+		i.logger.Error("Failed to watch certificate secret", err, fields)
+		for ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(certSecretRetryDelay):
+			}
+			if resourceVersion, err = i.loadCertSecret(ctx, watcher, ref); err == nil || ctx.Err() != nil {
+				break
+			}
+			i.logger.Error("Failed to reload keypair from secret", err, fields)
+		}
+	}
+}
+
+func (i *InjectServer) setCert(pair *tls.Certificate) {
+	i.certLock.Lock()
+	defer i.certLock.Unlock()
+	i.cert = pair
+}
+
+// loadCertFiles loads the keypair from the certificate and key file and checks that it can be
+// served. Pairs being rotated file by file are mismatched until both files have been replaced
+func loadCertFiles(certFile, keyFile string) (*tls.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("Certificate expired at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return &pair, nil
+}
+
+// watchCertFile reloads the keypair whenever the certificate or the key file changes. The directories
+// of the files are watched, so the atomic updates of mounted secrets replacing a symlink are noticed
+func (i *InjectServer) watchCertFile(opts *Options) error {
+	certWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := []string{filepath.Dir(opts.CertFile)}
+	if dir := filepath.Dir(opts.KeyFile); dir != dirs[0] {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		if err := certWatcher.Watch(dir); err != nil {
+			i.logger.Error("Failed to creat file watcher for certificate", err, Fields{
+				"certPath": opts.CertFile,
+				"keyPath":  opts.KeyFile,
+				"dir":      dir,
+			})
+			return err
+		}
+	}
+	i.certWatcher = certWatcher
+
+	go func(watcher *fsnotify.Watcher, opts *Options) {
+		for {
+			select {
+			case _, ok := <-watcher.Event:
+				if !ok {
+					return
+				}
+				i.reloadCertFiles(opts)
+			case err, ok := <-watcher.Error:
+				if !ok {
+					return
+				}
+				i.logger.Warn("Failed to watch the certificate files", Fields{"error": err})
+			}
+		}
+	}(certWatcher, opts)
+	return nil
+}
+
+// reloadCertFiles replaces the served keypair if the files contain a different one which can be
+// served. Otherwise the current keypair is kept, e.g. while only one of the files has been rotated
+func (i *InjectServer) reloadCertFiles(opts *Options) error {
+	pair, err := loadCertFiles(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		i.logger.Warn("Certificate files don't contain a valid keypair, keeping the current one", Fields{
+			"certPath": opts.CertFile,
+			"keyPath":  opts.KeyFile,
+			"error":    err,
+		})
+		return err
+	}
+	if current, _ := i.getCert(nil); current != nil && bytes.Equal(current.Certificate[0], pair.Certificate[0]) {
+		return nil
+	}
+	i.logger.Info("Certificate has been updated reloading keypair", Fields{
+		"certPath": opts.CertFile,
+		"keyPath":  opts.KeyFile,
+	})
+	i.setCert(pair)
+	return nil
+}
+
+// reloadCertsHandler reloads the keypair on demand, as not every rotation of the files is noticed,
+// e.g. the ones of some projected volumes. It requires the admin token and responds with the served
+// certificate
+func (i *InjectServer) reloadCertsHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAuthorized(r, token) {
+			i.logger.Warn("Unauthorized request to reload the certificate", Fields{"remoteAddr": r.RemoteAddr})
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if i.reloadCerts == nil {
+			http.Error(w, "The certificate isn't loaded by the InjectServer", http.StatusConflict)
+			return
+		}
+		if err := i.reloadCerts(r.Context()); err != nil {
+			i.logger.Error("Failed to reload the certificate on demand", err, Fields{"remoteAddr": r.RemoteAddr})
+			http.Error(w, fmt.Sprintf("Failed to reload the certificate: %s", err), http.StatusInternalServerError)
+			return
+		}
+		i.logger.Info("Reloaded the certificate on demand", Fields{"remoteAddr": r.RemoteAddr})
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(i.certificateHealth()); err != nil {
+			i.logger.Error("Failed to serialize certificate to JSON", err, nil)
+		}
+	}
+}
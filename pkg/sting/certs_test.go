@@ -0,0 +1,292 @@
+package sting
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// certSecret returns a TLS secret with a self signed certificate for the common name
+func certSecret(t *testing.T, commonName, resourceVersion string) *corev1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "sqlbee", Name: "sqlbee-certs", ResourceVersion: resourceVersion},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
+
+// fakeSecretWatcher returns the current secret and passes the updates to the watch
+type fakeSecretWatcher struct {
+	secret  *corev1.Secret
+	updates chan *corev1.Secret
+}
+
+func (f *fakeSecretWatcher) GetSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	return f.secret, nil
+}
+
+func (f *fakeSecretWatcher) WatchSecret(ctx context.Context, namespace, name, resourceVersion string, onChange func(*corev1.Secret)) error {
+	select {
+	case secret := <-f.updates:
+		onChange(secret)
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func commonName(t *testing.T, i *InjectServer) string {
+	pair, err := i.getCert(nil)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	return cert.Subject.CommonName
+}
+
+func TestCertFromSecret(t *testing.T) {
+	_, err := certFromSecret(certSecret(t, "sqlbee-svc.sqlbee.svc", "1"))
+	assert.NoError(t, err)
+
+	_, err = certFromSecret(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "sqlbee", Name: "sqlbee-certs"}})
+	assert.EqualError(t, err, "Secret sqlbee/sqlbee-certs doesn't contain tls.crt and tls.key")
+}
+
+func TestWatchCertSecret(t *testing.T) {
+	watcher := &fakeSecretWatcher{
+		secret:  certSecret(t, "initial", "1"),
+		updates: make(chan *corev1.Secret),
+	}
+	ref := &corev1.SecretReference{Namespace: "sqlbee", Name: "sqlbee-certs"}
+	i := &InjectServer{certLock: &sync.Mutex{}, logger: newStdLogger()}
+
+	resourceVersion, err := i.loadCertSecret(context.Background(), watcher, ref)
+	require.NoError(t, err)
+	assert.Equal(t, "1", resourceVersion)
+	assert.Equal(t, "initial", commonName(t, i))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go i.watchCertSecret(ctx, watcher, ref, resourceVersion)
+
+	// Invalid updates keep the current keypair
+	watcher.updates <- &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "sqlbee", Name: "sqlbee-certs", ResourceVersion: "2"}}
+	watcher.updates <- certSecret(t, "rotated", "3")
+	waitForCommonName(t, i, "rotated")
+}
+
+// writeCertFiles writes the keypair of the TLS secret to the certificate and key file, if their
+// paths are set
+func writeCertFiles(t *testing.T, secret *corev1.Secret, certFile, keyFile string) {
+	if certFile != "" {
+		require.NoError(t, ioutil.WriteFile(certFile, secret.Data[corev1.TLSCertKey], 0600))
+	}
+	if keyFile != "" {
+		require.NoError(t, ioutil.WriteFile(keyFile, secret.Data[corev1.TLSPrivateKeyKey], 0600))
+	}
+}
+
+// waitForCommonName waits up to a second for the served certificate to have the common name
+func waitForCommonName(t *testing.T, i *InjectServer, expected string) {
+	for deadline := time.Now().Add(time.Second); commonName(t, i) != expected && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, expected, commonName(t, i))
+}
+
+func TestWatchCertFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sting")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	opts := &Options{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}
+	writeCertFiles(t, certSecret(t, "initial", "1"), opts.CertFile, opts.KeyFile)
+
+	i := &InjectServer{certLock: &sync.Mutex{}, logger: newStdLogger()}
+	i.reloadCertFiles(opts)
+	assert.Equal(t, "initial", commonName(t, i))
+	require.NoError(t, i.watchCertFile(opts))
+	defer i.certWatcher.Close()
+
+	// The current keypair is served until both files have been rotated
+	rotated := certSecret(t, "rotated", "2")
+	writeCertFiles(t, rotated, opts.CertFile, "")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "initial", commonName(t, i))
+	writeCertFiles(t, rotated, "", opts.KeyFile)
+	waitForCommonName(t, i, "rotated")
+
+	_, err = loadCertFiles(opts.CertFile, filepath.Join(dir, "missing.key"))
+	assert.Error(t, err)
+}
+
+func TestWatchCertFileOfMountedSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sting")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Mounted secrets are updated by replacing the symlink ..data to the directory of the files
+	mount := func(secret *corev1.Secret, version string) {
+		versionDir := filepath.Join(dir, version)
+		require.NoError(t, os.Mkdir(versionDir, 0700))
+		writeCertFiles(t, secret, filepath.Join(versionDir, "tls.crt"), filepath.Join(versionDir, "tls.key"))
+		require.NoError(t, os.Symlink(version, filepath.Join(dir, "..data_tmp")))
+		require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	}
+	mount(certSecret(t, "initial", "1"), "..v1")
+	for _, name := range []string{"tls.crt", "tls.key"} {
+		require.NoError(t, os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name)))
+	}
+	opts := &Options{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}
+
+	i := &InjectServer{certLock: &sync.Mutex{}, logger: newStdLogger()}
+	i.reloadCertFiles(opts)
+	assert.Equal(t, "initial", commonName(t, i))
+	require.NoError(t, i.watchCertFile(opts))
+	defer i.certWatcher.Close()
+
+	mount(certSecret(t, "rotated", "2"), "..v2")
+	waitForCommonName(t, i, "rotated")
+}
+
+func TestCertificateSource(t *testing.T) {
+	initial, err := certFromSecret(certSecret(t, "initial", "1"))
+	require.NoError(t, err)
+	rotated, err := certFromSecret(certSecret(t, "rotated", "2"))
+	require.NoError(t, err)
+
+	// An in-memory rotation, like the ones of SPIRE or Vault clients
+	current := initial
+	lock := &sync.Mutex{}
+	i := &InjectServer{certLock: &sync.Mutex{}, certSource: CertificateSourceFunc(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return current, nil
+	})}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	i.configureTLS(server, &Options{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	servedName := func() string {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "initial", servedName())
+	assert.Equal(t, "CN=initial", i.certificateHealth().Subject)
+
+	lock.Lock()
+	current = rotated
+	lock.Unlock()
+	assert.Equal(t, "rotated", servedName())
+	assert.Equal(t, "CN=rotated", i.certificateHealth().Subject)
+
+	// Failures of the source don't break the health endpoint
+	i.certSource = CertificateSourceFunc(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return nil, errors.New("SPIRE agent unavailable")
+	})
+	assert.Nil(t, i.certificateHealth())
+}
+
+func TestReloadCertsHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sting")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	opts := &Options{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}
+	writeCertFiles(t, certSecret(t, "initial", "1"), opts.CertFile, opts.KeyFile)
+
+	i := &InjectServer{certLock: &sync.Mutex{}, logger: newStdLogger()}
+	require.NoError(t, i.reloadCertFiles(opts))
+	handler := i.reloadCertsHandler("secret")
+	request := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/reload-certs", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Without a reload function the certificate isn't ours to reload
+	assert.Equal(t, http.StatusConflict, request("secret").Code)
+	i.reloadCerts = func(ctx context.Context) error {
+		return i.reloadCertFiles(opts)
+	}
+
+	// A rotation missed by the watcher
+	writeCertFiles(t, certSecret(t, "rotated", "2"), opts.CertFile, opts.KeyFile)
+	for _, token := range []string{"", "wrong"} {
+		assert.Equal(t, http.StatusUnauthorized, request(token).Code, token)
+	}
+	assert.Equal(t, "initial", commonName(t, i))
+
+	rec := request("secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"subject":"CN=rotated"`)
+	assert.Equal(t, "rotated", commonName(t, i))
+
+	// An invalid keypair is reported and the current one is kept
+	writeCertFiles(t, certSecret(t, "invalid", "3"), opts.CertFile, "")
+	rec = request("secret")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Failed to reload the certificate")
+	assert.Equal(t, "rotated", commonName(t, i))
+}
+
+func TestReloadCertsFromSecret(t *testing.T) {
+	watcher := &fakeSecretWatcher{secret: certSecret(t, "initial", "1")}
+	i := &InjectServer{certLock: &sync.Mutex{}, logger: newStdLogger()}
+	ref := &corev1.SecretReference{Namespace: "sqlbee", Name: "sqlbee-certs"}
+	_, err := i.loadCertSecret(context.Background(), watcher, ref)
+	require.NoError(t, err)
+	i.reloadCerts = func(ctx context.Context) error {
+		_, err := i.loadCertSecret(ctx, watcher, ref)
+		return err
+	}
+
+	watcher.secret = certSecret(t, "rotated", "2")
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/reload-certs", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	i.reloadCertsHandler("secret").ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "rotated", commonName(t, i))
+}
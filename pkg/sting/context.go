@@ -0,0 +1,160 @@
+package sting
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// MutateContextFunc is a MutateFunc receiving the context of the admission request. The context is
+// canceled once the handler timeout is exceeded or the API server stops waiting, and carries the
+// logger and the metadata of the request
+type MutateContextFunc func(ctx context.Context, ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse
+
+// IsAdmittedContextFunc is an IsAdmittedFunc receiving the context of the admission request
+type IsAdmittedContextFunc func(ctx context.Context, ar *v1beta1.AdmissionReview) (*v1beta1.AdmissionResponse, error)
+
+// MutateWithContext adapts a MutateFunc ignoring the context
+func MutateWithContext(mutate MutateFunc) MutateContextFunc {
+	return func(ctx context.Context, ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+		return mutate(ar)
+	}
+}
+
+// IsAdmittedWithContext adapts an IsAdmittedFunc ignoring the context
+func IsAdmittedWithContext(isAdmitted IsAdmittedFunc) IsAdmittedContextFunc {
+	return func(ctx context.Context, ar *v1beta1.AdmissionReview) (*v1beta1.AdmissionResponse, error) {
+		return isAdmitted(ar)
+	}
+}
+
+// RequestInfo is the metadata of the admission request being handled
+type RequestInfo struct {
+	UID       types.UID
+	Name      string
+	Namespace string
+	Resource  metav1.GroupVersionResource
+	Operation v1beta1.Operation
+	// Endpoint is the name of the mutator or admit for the non mutating admission endpoint
+	Endpoint   string
+	RemoteAddr string
+	// Received is when the request was read
+	Received time.Time
+	// RequestID correlates the logs, the response and the audit record of the request. It is the UID of
+	// the admission request, or generated if the request has none
+	RequestID string
+}
+
+// RequestIDHeader is the response header containing the ID of the admission request
+const RequestIDHeader = "X-Request-Id"
+
+// setRequestID adds the ID of the admission request to the headers of the response
+func setRequestID(ctx context.Context, w http.ResponseWriter) {
+	if info, ok := RequestInfoFromContext(ctx); ok && info.RequestID != "" {
+		w.Header().Set(RequestIDHeader, info.RequestID)
+	}
+}
+
+// newRequestID returns a random ID for admission requests without UID
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+type contextKey int
+
+const (
+	requestInfoKey contextKey = iota
+	loggerKey
+	slotKey
+)
+
+// RequestInfoFromContext returns the metadata of the admission request the context belongs to
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey).(RequestInfo)
+	return info, ok
+}
+
+// LoggerFromContext returns the logger of the admission request the context belongs to, which adds
+// the metadata of the request to every entry. Defaults to a logger writing to stderr
+func LoggerFromContext(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(loggerKey).(Logger); ok {
+		return logger
+	}
+	return newStdLogger()
+}
+
+// requestContext returns the context of the admission request. Its deadline is the handler timeout,
+// or less if the timeout the API server passes as query parameter, i.e. how long it waits for the
+// response, leaves less time
+func requestContext(r *http.Request, ar *v1beta1.AdmissionReview, endpoint string, handlerTimeout time.Duration, logger Logger) (context.Context, context.CancelFunc) {
+	info := RequestInfo{Endpoint: endpoint, RemoteAddr: r.RemoteAddr, Received: time.Now()}
+	if ar.Request != nil {
+		info.UID = ar.Request.UID
+		info.Name = ar.Request.Name
+		info.Namespace = ar.Request.Namespace
+		info.Resource = ar.Request.Resource
+		info.Operation = ar.Request.Operation
+		info.RequestID = string(ar.Request.UID)
+	}
+	if info.RequestID == "" {
+		info.RequestID = newRequestID()
+	}
+	ctx := context.WithValue(r.Context(), requestInfoKey, info)
+	ctx = context.WithValue(ctx, loggerKey, Logger(&fieldLogger{logger: logger, fields: Fields{
+		"requestUID":   info.UID,
+		"requestID":    info.RequestID,
+		"name":         info.Name,
+		"namespace":    info.Namespace,
+		"groupVersion": info.Resource.String(),
+		"endpoint":     info.Endpoint,
+	}}))
+
+	apiServerTimeout, _ := time.ParseDuration(r.URL.Query().Get("timeout"))
+	if deadline := handlerDeadline(handlerTimeout, apiServerTimeout); deadline > 0 {
+		return context.WithTimeout(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// fieldLogger adds its fields to every entry of the logger
+type fieldLogger struct {
+	logger Logger
+	fields Fields
+}
+
+func (f *fieldLogger) Debug(msg string, fields Fields) {
+	f.logger.Debug(msg, f.with(fields))
+}
+
+func (f *fieldLogger) Info(msg string, fields Fields) {
+	f.logger.Info(msg, f.with(fields))
+}
+
+func (f *fieldLogger) Warn(msg string, fields Fields) {
+	f.logger.Warn(msg, f.with(fields))
+}
+
+func (f *fieldLogger) Error(msg string, err error, fields Fields) {
+	f.logger.Error(msg, err, f.with(fields))
+}
+
+func (f *fieldLogger) with(fields Fields) Fields {
+	merged := Fields{}
+	for key, value := range f.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return merged
+}
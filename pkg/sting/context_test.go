@@ -0,0 +1,144 @@
+package sting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordingLogger records the fields of the entries logged
+type recordingLogger struct {
+	entries []Fields
+}
+
+func (r *recordingLogger) Debug(msg string, fields Fields) { r.entries = append(r.entries, fields) }
+func (r *recordingLogger) Info(msg string, fields Fields)  { r.entries = append(r.entries, fields) }
+func (r *recordingLogger) Warn(msg string, fields Fields)  { r.entries = append(r.entries, fields) }
+func (r *recordingLogger) Error(msg string, err error, fields Fields) {
+	r.entries = append(r.entries, fields)
+}
+
+func TestRequestContext(t *testing.T) {
+	logger := &recordingLogger{}
+	ar := &v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+		UID:       "uid",
+		Name:      "app",
+		Namespace: "team-a",
+		Operation: v1beta1.Create,
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+	}}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1beta/mutate?timeout=10s", nil)
+	ctx, cancel := requestContext(r, ar, "cloudsql", defaultHandlerTimeout, logger)
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(9*time.Second), deadline, 100*time.Millisecond)
+
+	info, ok := RequestInfoFromContext(ctx)
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now(), info.Received, 100*time.Millisecond)
+	assert.Equal(t, RequestInfo{
+		UID:        "uid",
+		Name:       "app",
+		Namespace:  "team-a",
+		Resource:   metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Operation:  v1beta1.Create,
+		Endpoint:   "cloudsql",
+		RemoteAddr: r.RemoteAddr,
+		Received:   info.Received,
+		RequestID:  "uid",
+	}, info)
+
+	LoggerFromContext(ctx).Info("Looked up", Fields{"namespace": "other", "object": "config"})
+	require.Len(t, logger.entries, 1)
+	assert.Equal(t, "other", logger.entries[0]["namespace"])
+	assert.Equal(t, "config", logger.entries[0]["object"])
+	assert.EqualValues(t, "uid", logger.entries[0]["requestUID"])
+	assert.Equal(t, "uid", logger.entries[0]["requestID"])
+	assert.Equal(t, "cloudsql", logger.entries[0]["endpoint"])
+
+	// Without timeout the context has no deadline, but is canceled with the request
+	ctx, cancel = requestContext(httptest.NewRequest(http.MethodPost, "/api/v1beta/mutate", nil), &v1beta1.AdmissionReview{}, "cloudsql", 0, logger)
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+	// Requests without UID get a generated ID
+	info, _ = RequestInfoFromContext(ctx)
+	assert.Len(t, info.RequestID, 32)
+	other, cancelOther := requestContext(httptest.NewRequest(http.MethodPost, "/api/v1beta/mutate", nil), &v1beta1.AdmissionReview{}, "cloudsql", 0, logger)
+	defer cancelOther()
+	otherInfo, _ := RequestInfoFromContext(other)
+	assert.NotEqual(t, info.RequestID, otherInfo.RequestID)
+	cancel()
+	assert.Error(t, ctx.Err())
+
+	_, ok = RequestInfoFromContext(context.Background())
+	assert.False(t, ok)
+	assert.NotNil(t, LoggerFromContext(context.Background()))
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	i, err := NewEmbedded(&Options{Mutate: denyWith("denied")})
+	require.NoError(t, err)
+	for _, data := range []struct {
+		review string
+		id     string
+	}{
+		{review: `{"request":{"uid":"uid","kind":{"version":"v1","kind":"Pod"}}}`, id: "uid"},
+		{review: `{"request":{"kind":{"version":"v1","kind":"Pod"}}}`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1beta/mutate", strings.NewReader(data.review))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		i.Handler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		if data.id != "" {
+			assert.Equal(t, data.id, rec.Header().Get(RequestIDHeader))
+		} else {
+			assert.Len(t, rec.Header().Get(RequestIDHeader), 32)
+		}
+	}
+}
+
+func TestMutateContext(t *testing.T) {
+	opts := &Options{
+		MutateContext: func(ctx context.Context, ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+			info, _ := RequestInfoFromContext(ctx)
+			_, hasDeadline := ctx.Deadline()
+			return &v1beta1.AdmissionResponse{Allowed: hasDeadline, Result: &metav1.Status{Message: info.Endpoint}}
+		},
+		IsAdmitted: func(ar *v1beta1.AdmissionReview) (*v1beta1.AdmissionResponse, error) {
+			return &v1beta1.AdmissionResponse{Allowed: true}, nil
+		},
+	}
+	mutators, err := opts.mutators()
+	require.NoError(t, err)
+	i := &InjectServer{logger: newStdLogger(), isAdmitted: opts.isAdmitted()}
+	r := i.router(opts, mutators)
+
+	for path, expected := range map[string]v1beta1.AdmissionResponse{
+		"/api/v1beta/mutate?timeout=5s": {UID: "uid", Allowed: true, Result: &metav1.Status{Message: defaultMutatorName}},
+		"/api/v1beta/admit":             {UID: "uid", Allowed: true},
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(`{"request":{"uid":"uid"}}`)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		review := v1beta1.AdmissionReview{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &review))
+		require.NotNil(t, review.Response, path)
+		assert.Equal(t, expected, *review.Response, path)
+	}
+}
@@ -0,0 +1,73 @@
+package sting
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/api/admission/v1beta1"
+)
+
+// Webhook configurations default to a timeoutSeconds of 10s. The handler gives up a second earlier, so
+// the API server receives the response of the failure policy instead of timing out itself
+const defaultHandlerTimeout = 9 * time.Second
+
+// Share of the timeout passed by the API server the admission decision may take, the remainder is
+// left to write the response in time
+const apiServerTimeoutShare = 0.9
+
+// handlerDeadline returns how long the admission decision may take. The timeout passed by the API
+// server wins if it leaves less time than the handler timeout. No deadline is set if neither is known
+func handlerDeadline(handlerTimeout, apiServerTimeout time.Duration) time.Duration {
+	deadline := handlerTimeout
+	if apiServerTimeout > 0 {
+		share := time.Duration(float64(apiServerTimeout) * apiServerTimeoutShare)
+		if deadline <= 0 || share < deadline {
+			deadline = share
+		}
+	}
+	return deadline
+}
+
+// decision is the outcome of an admission decision
+type decision struct {
+	response *v1beta1.AdmissionResponse
+	err      error
+}
+
+// withinDeadline runs the admission decision until its context is done. If the deadline is hit first,
+// the response of the failure policy is returned, while the decision is left to observe the canceled
+// context in the background. It holds the concurrency slot of the request until it returns, so
+// abandoned decisions can't pile up beyond the concurrency limit
+func (i *InjectServer) withinDeadline(ctx context.Context, decide func() (*v1beta1.AdmissionResponse, error)) (*v1beta1.AdmissionResponse, error) {
+	decided := make(chan decision, 1)
+	slot := slotFromContext(ctx)
+	slot.hold()
+	go func() {
+		defer slot.done()
+		response, err := decide()
+		decided <- decision{response: response, err: err}
+	}()
+
+	select {
+	case d := <-decided:
+		return d.response, d.err
+	case <-ctx.Done():
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		// The API server went away, nobody reads the response anyway
+		return nil, ctx.Err()
+	}
+
+	info, _ := RequestInfoFromContext(ctx)
+	i.metrics.deadlineExceeded.Inc(info.Endpoint)
+	LoggerFromContext(ctx).Warn("Admission decision exceeded its deadline", Fields{
+		"failurePolicy": i.failurePolicy,
+	})
+	if i.failurePolicy == FailurePolicyIgnore {
+		response := &v1beta1.AdmissionResponse{Allowed: true}
+		AddWarning(response, "The admission webhook didn't decide in time, the object is admitted unchanged")
+		return response, nil
+	}
+	return ToAdmissionResponse(fmt.Errorf("The admission webhook didn't decide in time: %s", ctx.Err())), nil
+}
@@ -0,0 +1,93 @@
+package sting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/api/admission/v1beta1"
+)
+
+func TestHandlerDeadline(t *testing.T) {
+	assert.Equal(t, 9*time.Second, handlerDeadline(9*time.Second, 0))
+	assert.Equal(t, 9*time.Second, handlerDeadline(9*time.Second, 30*time.Second))
+	assert.Equal(t, 4500*time.Millisecond, handlerDeadline(9*time.Second, 5*time.Second))
+	assert.Equal(t, 900*time.Millisecond, handlerDeadline(0, time.Second))
+	assert.Equal(t, time.Duration(0), handlerDeadline(0, 0))
+}
+
+func TestWithinDeadline(t *testing.T) {
+	// Blocks until the context is canceled, like a lookup hanging on an unresponsive API
+	blocking := func(ctx context.Context, ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+		<-ctx.Done()
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+	for _, data := range []struct {
+		policy   FailurePolicy
+		allowed  bool
+		warnings int
+	}{
+		{policy: FailurePolicyFail, allowed: false},
+		{policy: FailurePolicyIgnore, allowed: true, warnings: 1},
+	} {
+		opts := &Options{
+			MutateContext: blocking,
+			FailurePolicy: data.policy,
+			IsAdmittedContext: func(ctx context.Context, ar *v1beta1.AdmissionReview) (*v1beta1.AdmissionResponse, error) {
+				return blocking(ctx, ar), nil
+			},
+		}
+		mutators, err := opts.mutators()
+		require.NoError(t, err)
+		i := &InjectServer{
+			logger:         newStdLogger(),
+			isAdmitted:     opts.isAdmitted(),
+			failurePolicy:  opts.failurePolicy(),
+			handlerTimeout: 50 * time.Millisecond,
+			metrics:        newServerMetrics(noCertificate),
+		}
+		r := i.router(opts, mutators)
+
+		for _, path := range []string{"/api/v1beta/mutate", "/api/v1beta/admit"} {
+			req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(`{"request":{"uid":"uid"}}`)))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			started := time.Now()
+			r.ServeHTTP(rec, req)
+			assert.True(t, time.Since(started) < time.Second, path)
+
+			require.Equal(t, http.StatusOK, rec.Code, "%s %+v", path, data)
+			review := v1beta1.AdmissionReview{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &review))
+			require.NotNil(t, review.Response)
+			assert.EqualValues(t, "uid", review.Response.UID)
+			assert.Equal(t, data.allowed, review.Response.Allowed, "%s %+v", path, data)
+			assert.Len(t, responseWarnings(t, rec.Body.Bytes()), data.warnings, "%s %+v", path, data)
+			if !data.allowed {
+				assert.Contains(t, review.Response.Result.Message, "didn't decide in time")
+			}
+		}
+		assert.EqualValues(t, 1, i.metrics.deadlineExceeded.Value(defaultMutatorName))
+		assert.EqualValues(t, 1, i.metrics.deadlineExceeded.Value(admitEndpoint))
+		assert.EqualValues(t, 1, i.metrics.mutateDuration.Count(defaultMutatorName))
+	}
+}
+
+func TestWithinDeadlineInTime(t *testing.T) {
+	i := &InjectServer{logger: newStdLogger(), metrics: newServerMetrics(noCertificate)}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	response, err := i.withinDeadline(ctx, func() (*v1beta1.AdmissionResponse, error) {
+		return &v1beta1.AdmissionResponse{Allowed: true}, nil
+	})
+	require.NoError(t, err)
+	assert.True(t, response.Allowed)
+	assert.EqualValues(t, 0, i.metrics.deadlineExceeded.Value(""))
+}
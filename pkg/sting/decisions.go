@@ -0,0 +1,110 @@
+package sting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RecentDecision is an admission decision kept in memory for the endpoint /decisions of the admin
+// server, to see what the webhook recently did without searching the logs
+type RecentDecision struct {
+	Time      time.Time         `json:"time"`
+	UID       types.UID         `json:"uid"`
+	Endpoint  string            `json:"endpoint"`
+	Operation v1beta1.Operation `json:"operation,omitempty"`
+	Kind      string            `json:"kind,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Allowed   bool              `json:"allowed"`
+	Decision  string            `json:"decision"`
+	Reason    string            `json:"reason,omitempty"`
+	// PatchSize is the size of the JSON patch in bytes
+	PatchSize int `json:"patchSize"`
+	// Duration is how long the request took until the decision, in seconds
+	Duration float64 `json:"durationSeconds"`
+	// Shadow marks decisions which haven't been enforced
+	Shadow bool `json:"shadow,omitempty"`
+}
+
+// decisionLog is a ring buffer of the last admission decisions. A nil decisionLog keeps nothing
+type decisionLog struct {
+	lock      sync.Mutex
+	decisions []RecentDecision
+	next      int
+	full      bool
+}
+
+func newDecisionLog(size int) *decisionLog {
+	if size <= 0 {
+		return nil
+	}
+	return &decisionLog{decisions: make([]RecentDecision, size)}
+}
+
+// add keeps the decision, replacing the oldest one once the buffer is full
+func (d *decisionLog) add(decision RecentDecision) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.decisions[d.next] = decision
+	d.next = (d.next + 1) % len(d.decisions)
+	d.full = d.full || d.next == 0
+}
+
+// recent returns the kept decisions, the latest first
+func (d *decisionLog) recent() []RecentDecision {
+	recent := []RecentDecision{}
+	if d == nil {
+		return recent
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	count := d.next
+	if d.full {
+		count = len(d.decisions)
+	}
+	for n := 1; n <= count; n++ {
+		recent = append(recent, d.decisions[(d.next-n+len(d.decisions))%len(d.decisions)])
+	}
+	return recent
+}
+
+// recentDecision returns the decision kept for the audit record of the response
+func recentDecision(ctx context.Context, record AuditRecord, response *v1beta1.AdmissionResponse) RecentDecision {
+	decision := RecentDecision{
+		Time:      record.Time,
+		UID:       record.UID,
+		Endpoint:  record.Endpoint,
+		Operation: record.Operation,
+		Kind:      record.Kind,
+		Namespace: record.Namespace,
+		Name:      record.Name,
+		Allowed:   response.Allowed,
+		Decision:  record.Decision,
+		Reason:    record.Reason,
+		PatchSize: len(response.Patch),
+		Shadow:    record.Shadow,
+	}
+	if info, ok := RequestInfoFromContext(ctx); ok && !info.Received.IsZero() {
+		decision.Duration = record.Time.Sub(info.Received).Seconds()
+	}
+	return decision
+}
+
+// decisionsHandler returns the recent admission decisions as JSON, the latest first
+func (i *InjectServer) decisionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Decisions []RecentDecision `json:"decisions"`
+	}{Decisions: i.decisions.recent()}); err != nil {
+		i.logger.Error("Failed to serialize recent decisions to JSON", err, nil)
+	}
+}
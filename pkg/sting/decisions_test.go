@@ -0,0 +1,96 @@
+package sting
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestDecisionLog(t *testing.T) {
+	disabled := newDecisionLog(0)
+	disabled.add(RecentDecision{UID: "ignored"})
+	assert.Empty(t, disabled.recent())
+
+	decisions := newDecisionLog(3)
+	assert.Empty(t, decisions.recent())
+	uids := func() []types.UID {
+		uids := []types.UID{}
+		for _, decision := range decisions.recent() {
+			uids = append(uids, decision.UID)
+		}
+		return uids
+	}
+	decisions.add(RecentDecision{UID: "1"})
+	decisions.add(RecentDecision{UID: "2"})
+	assert.Equal(t, []types.UID{"2", "1"}, uids())
+
+	// The oldest decisions are replaced
+	for n := 3; n <= 7; n++ {
+		decisions.add(RecentDecision{UID: types.UID(fmt.Sprint(n))})
+	}
+	assert.Equal(t, []types.UID{"7", "6", "5"}, uids())
+}
+
+func TestDecisionsEndpoint(t *testing.T) {
+	patch := `[{"op":"add","path":"/spec/containers/-","value":{}}]`
+	i, err := NewEmbedded(&Options{
+		Mutators: []Mutator{
+			{Name: "patch", Mutate: func(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+				return &v1beta1.AdmissionResponse{Allowed: true, Patch: []byte(patch)}
+			}},
+			{Name: "deny", Mutate: denyWith("instance missing")},
+		},
+		RecentDecisions: 10,
+	})
+	require.NoError(t, err)
+
+	review := `{"request":{"uid":"%s","kind":{"version":"v1","kind":"Pod"},"namespace":"team-a","name":"app","operation":"CREATE"}}`
+	for _, mutator := range []string{"patch", "deny"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1beta/mutate/"+mutator, strings.NewReader(fmt.Sprintf(review, mutator)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		i.Handler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	i.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/decisions", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	response := struct {
+		Decisions []RecentDecision `json:"decisions"`
+	}{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.Len(t, response.Decisions, 2)
+	for n := range response.Decisions {
+		assert.False(t, response.Decisions[n].Time.IsZero())
+		assert.True(t, response.Decisions[n].Duration >= 0)
+		response.Decisions[n].Time, response.Decisions[n].Duration = response.Decisions[0].Time, 0
+	}
+	expected := RecentDecision{
+		Time:      response.Decisions[0].Time,
+		Operation: v1beta1.Create,
+		Kind:      "Pod",
+		Namespace: "team-a",
+		Name:      "app",
+	}
+	denied := expected
+	denied.UID, denied.Endpoint, denied.Decision, denied.Reason = "deny", "deny", DecisionDenied, "instance missing"
+	mutated := expected
+	mutated.UID, mutated.Endpoint, mutated.Decision, mutated.Allowed, mutated.PatchSize = "patch", "patch", DecisionMutated, true, len(patch)
+	assert.Equal(t, []RecentDecision{denied, mutated}, response.Decisions)
+
+	// The endpoint only exists if decisions are kept
+	i, err = NewEmbedded(&Options{Mutate: denyWith("denied")})
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	i.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/decisions", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,47 @@
+package sting
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ErrMissingObject is returned by DecodeObject and DecodeOldObject if the request doesn't contain the
+// object, e.g. the old object of a CREATE request
+var ErrMissingObject = errors.New("Admission request contains no object")
+
+// DecodeObject decodes the object of the admission request into its type registered in RuntimeScheme,
+// keeping the version it has been sent in. The kind of the request is used if the object lacks its
+// apiVersion or kind
+func DecodeObject(ar *v1beta1.AdmissionReview) (runtime.Object, *schema.GroupVersionKind, error) {
+	if ar == nil || ar.Request == nil {
+		return nil, nil, errors.New("Admission review contains no request")
+	}
+	return decodeRequestObject(ar.Request.Object.Raw, ar.Request.Kind)
+}
+
+// DecodeOldObject decodes the existing object of UPDATE and DELETE requests like DecodeObject
+func DecodeOldObject(ar *v1beta1.AdmissionReview) (runtime.Object, *schema.GroupVersionKind, error) {
+	if ar == nil || ar.Request == nil {
+		return nil, nil, errors.New("Admission review contains no request")
+	}
+	return decodeRequestObject(ar.Request.OldObject.Raw, ar.Request.Kind)
+}
+
+func decodeRequestObject(raw []byte, kind metav1.GroupVersionKind) (runtime.Object, *schema.GroupVersionKind, error) {
+	if len(raw) == 0 {
+		return nil, nil, ErrMissingObject
+	}
+	defaults := &schema.GroupVersionKind{Group: kind.Group, Version: kind.Version, Kind: kind.Kind}
+	obj, gvk, err := Deserializer.Decode(raw, defaults, nil)
+	if runtime.IsNotRegisteredError(err) {
+		return nil, gvk, fmt.Errorf("Kind %s is not registered in the scheme: %s", gvk, err)
+	} else if err != nil {
+		return nil, gvk, fmt.Errorf("Failed to decode the object of the admission request: %s", err)
+	}
+	return obj, gvk, nil
+}
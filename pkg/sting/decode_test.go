@@ -0,0 +1,62 @@
+package sting
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestDecodeObject(t *testing.T) {
+	ar := &v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"},
+		Object:    runtime.RawExtension{Raw: []byte(`{"apiVersion":"apps/v1beta1","kind":"Deployment","metadata":{"name":"app"}}`)},
+		OldObject: runtime.RawExtension{Raw: []byte(`{"metadata":{"name":"old"}}`)},
+	}}
+
+	// Objects keep the version they have been sent in
+	obj, gvk, err := DecodeObject(ar)
+	require.NoError(t, err)
+	require.IsType(t, &appsv1beta1.Deployment{}, obj)
+	assert.Equal(t, "app", obj.(*appsv1beta1.Deployment).Name)
+	assert.Equal(t, &schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"}, gvk)
+
+	// The kind of the request is used if the object lacks it
+	obj, _, err = DecodeOldObject(ar)
+	require.NoError(t, err)
+	require.IsType(t, &appsv1beta1.Deployment{}, obj)
+	assert.Equal(t, "old", obj.(*appsv1beta1.Deployment).Name)
+
+	ar.Request.Kind = metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	ar.Request.Object.Raw = []byte(`{"metadata":{"name":"pod"}}`)
+	obj, _, err = DecodeObject(ar)
+	require.NoError(t, err)
+	assert.IsType(t, &corev1.Pod{}, obj)
+}
+
+func TestDecodeObjectErrors(t *testing.T) {
+	_, _, err := DecodeObject(&v1beta1.AdmissionReview{})
+	assert.EqualError(t, err, "Admission review contains no request")
+
+	_, _, err = DecodeOldObject(&v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{}})
+	assert.Equal(t, ErrMissingObject, err)
+
+	_, gvk, err := DecodeObject(&v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+		Object: runtime.RawExtension{Raw: []byte(`{"apiVersion":"example.com/v1","kind":"Widget"}`)},
+	}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Kind example.com/v1, Kind=Widget is not registered")
+	assert.Equal(t, "Widget", gvk.Kind)
+
+	_, _, err = DecodeObject(&v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+		Object: runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Pod","spec":[]}`)},
+	}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to decode the object of the admission request")
+}
@@ -0,0 +1,54 @@
+package sting
+
+import (
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DenialError denies an object with the reason and HTTP status code of the status of the admission
+// response, so clients and audit logs can distinguish configuration errors from policy denials.
+// ToAdmissionResponse sets them for DenialErrors and errors wrapping them
+type DenialError struct {
+	Code    int32
+	Reason  metav1.StatusReason
+	Message string
+	// Causes are the individual problems of the object, e.g. its invalid fields
+	Causes []metav1.StatusCause
+}
+
+func (e *DenialError) Error() string {
+	return e.Message
+}
+
+// Invalid denies an object whose configuration is invalid, e.g. an annotation with an unsupported
+// value, with 422 Unprocessable Entity
+func Invalid(message string, causes ...metav1.StatusCause) *DenialError {
+	return &DenialError{Code: http.StatusUnprocessableEntity, Reason: metav1.StatusReasonInvalid, Message: message, Causes: causes}
+}
+
+// Forbidden denies an object violating a policy, e.g. referencing objects which don't exist, with
+// 403 Forbidden
+func Forbidden(message string, causes ...metav1.StatusCause) *DenialError {
+	return &DenialError{Code: http.StatusForbidden, Reason: metav1.StatusReasonForbidden, Message: message, Causes: causes}
+}
+
+// FieldCause returns the cause of a denial due to the field of the object, like
+// metadata.annotations[sqlbee.connctd.io/instance]
+func FieldCause(causeType metav1.CauseType, field, message string) metav1.StatusCause {
+	return metav1.StatusCause{Type: causeType, Field: field, Message: message}
+}
+
+// status returns the status of the admission response denying the object
+func (e *DenialError) status() *metav1.Status {
+	status := &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: e.Message,
+		Reason:  e.Reason,
+		Code:    e.Code,
+	}
+	if len(e.Causes) > 0 {
+		status.Details = &metav1.StatusDetails{Causes: e.Causes}
+	}
+	return status
+}
@@ -0,0 +1,63 @@
+package sting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestToAdmissionResponseOfDenials(t *testing.T) {
+	cause := FieldCause(metav1.CauseTypeFieldValueRequired, "metadata.annotations[sqlbee.connctd.io/instance]", "Instance is not specified")
+	for _, data := range []struct {
+		err      error
+		expected *metav1.Status
+	}{
+		{
+			err:      errors.New("Failed to deserialize object"),
+			expected: &metav1.Status{Message: "Failed to deserialize object"},
+		},
+		{
+			err: Invalid("Instance is not specified", cause),
+			expected: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: "Instance is not specified",
+				Reason:  metav1.StatusReasonInvalid,
+				Code:    http.StatusUnprocessableEntity,
+				Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{cause}},
+			},
+		},
+		{
+			err: fmt.Errorf("Namespace team-a: %w", Forbidden("Secret credentials doesn't exist")),
+			expected: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: "Namespace team-a: Secret credentials doesn't exist",
+				Reason:  metav1.StatusReasonForbidden,
+				Code:    http.StatusForbidden,
+			},
+		},
+	} {
+		response := ToAdmissionResponse(data.err)
+		assert.False(t, response.Allowed)
+		assert.Equal(t, data.expected, response.Result)
+	}
+}
+
+func TestAuditRecordOfDenial(t *testing.T) {
+	ar := &v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{UID: "uid"}}
+	response := ToAdmissionResponse(Invalid("Invalid annotations",
+		FieldCause(metav1.CauseTypeFieldValueInvalid, "metadata.annotations[sqlbee.connctd.io/placement]", "Invalid placement"),
+		metav1.StatusCause{Message: "Unknown database type"}))
+	record := auditRecord(context.Background(), ar, response)
+	require.Equal(t, DecisionDenied, record.Decision)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, record.Code)
+	assert.Equal(t, metav1.StatusReasonInvalid, record.StatusReason)
+	assert.Equal(t, []string{"metadata.annotations[sqlbee.connctd.io/placement]: Invalid placement", "Unknown database type"}, record.Causes)
+}
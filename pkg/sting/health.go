@@ -0,0 +1,69 @@
+package sting
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// health is the response of the health endpoint
+type health struct {
+	Status      string             `json:"status"`
+	Version     string             `json:"version"`
+	Commit      string             `json:"commit"`
+	BuildDate   string             `json:"buildDate"`
+	Certificate *certificateHealth `json:"certificate,omitempty"`
+	Defaults    interface{}        `json:"defaults,omitempty"`
+}
+
+// certificateHealth describes the serving certificate currently loaded
+type certificateHealth struct {
+	Subject  string    `json:"subject"`
+	DNSNames []string  `json:"dnsNames,omitempty"`
+	NotAfter time.Time `json:"notAfter"`
+	Expired  bool      `json:"expired"`
+}
+
+// certificateHealth returns the description of the serving certificate, nil if none is loaded
+func (i *InjectServer) certificateHealth() *certificateHealth {
+	cert, err := i.getCert(&tls.ClientHelloInfo{})
+	if err != nil || cert == nil || len(cert.Certificate) == 0 {
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return &certificateHealth{
+		Subject:  leaf.Subject.String(),
+		DNSNames: leaf.DNSNames,
+		NotAfter: leaf.NotAfter.UTC(),
+		Expired:  time.Now().After(leaf.NotAfter),
+	}
+}
+
+// certificateExpiry returns when the serving certificate currently loaded expires
+func (i *InjectServer) certificateExpiry() (time.Time, bool) {
+	cert := i.certificateHealth()
+	if cert == nil {
+		return time.Time{}, false
+	}
+	return cert.NotAfter, true
+}
+
+// healthHandler returns the build, the serving certificate and the configured defaults as JSON
+func (i *InjectServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(health{
+		Status:      "ok",
+		Version:     Version,
+		Commit:      Commit,
+		BuildDate:   BuildDate,
+		Certificate: i.certificateHealth(),
+		Defaults:    i.defaults,
+	}); err != nil {
+		i.logger.Error("Failed to serialize health to JSON", err, nil)
+	}
+}
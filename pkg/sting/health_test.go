@@ -0,0 +1,50 @@
+package sting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	cert, err := certFromSecret(certSecret(t, "sqlbee-svc.sqlbee.svc", "1"))
+	require.NoError(t, err)
+	i := &InjectServer{
+		cert:     cert,
+		certLock: &sync.Mutex{},
+		logger:   newStdLogger(),
+		defaults: map[string]string{"instance": "proj:eu:db"},
+	}
+
+	rec := httptest.NewRecorder()
+	i.healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	status := struct {
+		health
+		Defaults map[string]string `json:"defaults"`
+	}{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, "ok", status.Status)
+	assert.Equal(t, Version, status.Version)
+	assert.Equal(t, Commit, status.Commit)
+	assert.Equal(t, BuildDate, status.BuildDate)
+	require.NotNil(t, status.Certificate)
+	assert.Equal(t, "CN=sqlbee-svc.sqlbee.svc", status.Certificate.Subject)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), status.Certificate.NotAfter, time.Minute)
+	assert.False(t, status.Certificate.Expired)
+	assert.Equal(t, map[string]string{"instance": "proj:eu:db"}, status.Defaults)
+
+	// Without certificate and defaults only the build is returned
+	i = &InjectServer{certLock: &sync.Mutex{}, logger: newStdLogger()}
+	rec = httptest.NewRecorder()
+	i.healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.JSONEq(t, `{"status":"ok","version":"unset","commit":"unset","buildDate":"unset"}`, rec.Body.String())
+}
@@ -0,0 +1,247 @@
+package sting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the HTTPAuditor
+const (
+	defaultAuditQueueSize      = 1000
+	defaultAuditMaxRetries     = 5
+	defaultAuditInitialBackoff = 500 * time.Millisecond
+	defaultAuditMaxBackoff     = 30 * time.Second
+	defaultAuditFlushTimeout   = 5 * time.Second
+)
+
+// ErrAuditQueueFull is returned by the HTTPAuditor if a record is dropped, as the queue is full
+var ErrAuditQueueFull = errors.New("Audit queue is full, the record is dropped")
+
+// HTTPAuditorOptions configure the HTTPAuditor, only the URL is required
+type HTTPAuditorOptions struct {
+	// URL the records are posted to via HTTP or HTTPS
+	URL string
+	// Token is sent as bearer token if set
+	Token string
+	// Client posts the records. Default is a client with a timeout of 10s
+	Client *http.Client
+	// QueueSize is the number of records waiting for their delivery, further records are dropped.
+	// Default is 1000
+	QueueSize int
+	// MaxRetries is how often a failed delivery is retried, waiting from InitialBackoff up to
+	// MaxBackoff in between. Default is 5 retries, starting at 500ms up to 30s
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// FlushTimeout is how long Close waits for the queued records to be delivered. Default is 5s
+	FlushTimeout time.Duration
+	// Logger receives the failed deliveries. Defaults to a logger writing to stderr
+	Logger Logger
+}
+
+// HTTPAuditor posts the audit records as JSON to an endpoint, e.g. of a compliance system. Records
+// are delivered in the background, so a slow endpoint doesn't delay the admission. Deliveries failing
+// with a network error, 429 or 5xx are retried with exponential backoff
+type HTTPAuditor struct {
+	opts   HTTPAuditorOptions
+	lock   sync.RWMutex
+	closed bool
+	queue  chan AuditRecord
+	// ctx cancels the deliveries and retries once the flush timeout of Close expired
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewHTTPAuditor creates an HTTPAuditor and starts delivering the records
+func NewHTTPAuditor(opts HTTPAuditorOptions) (*HTTPAuditor, error) {
+	endpoint, err := url.Parse(opts.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("Invalid URL of the audit endpoint %q, expected http(s)://<host>/<path>", opts.URL)
+	}
+	if opts.MaxRetries < 0 {
+		return nil, fmt.Errorf("Invalid number of retries %d", opts.MaxRetries)
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultAuditQueueSize
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = defaultAuditMaxRetries
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaultAuditInitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultAuditMaxBackoff
+	}
+	if opts.FlushTimeout <= 0 {
+		opts.FlushTimeout = defaultAuditFlushTimeout
+	}
+	if opts.Logger == nil {
+		opts.Logger = newStdLogger()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &HTTPAuditor{
+		opts:   opts,
+		queue:  make(chan AuditRecord, opts.QueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go h.run()
+	return h, nil
+}
+
+// Audit queues the record for its delivery. ErrAuditQueueFull is returned if the queue is full
+func (h *HTTPAuditor) Audit(record AuditRecord) error {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if h.closed {
+		return errors.New("Audit endpoint is closed, the record is dropped")
+	}
+	select {
+	case h.queue <- record:
+		return nil
+	default:
+		return ErrAuditQueueFull
+	}
+}
+
+// Close stops accepting records and waits up to the flush timeout for the queued records to be
+// delivered, including their retries. Records undelivered by then are dropped
+func (h *HTTPAuditor) Close() error {
+	h.lock.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.queue)
+	}
+	h.lock.Unlock()
+
+	timer := time.NewTimer(h.opts.FlushTimeout)
+	defer timer.Stop()
+	select {
+	case <-h.done:
+		h.cancel()
+		return nil
+	case <-timer.C:
+		h.cancel()
+		<-h.done
+		return fmt.Errorf("Failed to deliver the audit records within %s", h.opts.FlushTimeout)
+	}
+}
+
+func (h *HTTPAuditor) run() {
+	defer close(h.done)
+	for record := range h.queue {
+		h.deliver(record)
+	}
+}
+
+// deliver posts the record, retrying failed deliveries until the retries are exhausted or the
+// flush timeout expired
+func (h *HTTPAuditor) deliver(record AuditRecord) {
+	body, err := json.Marshal(record)
+	if err != nil {
+		h.opts.Logger.Error("Failed to serialize audit record to JSON", err, Fields{"requestUID": record.UID})
+		return
+	}
+	backoff := h.opts.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(body)
+		if err == nil {
+			return
+		}
+		fields := Fields{"requestUID": record.UID, "attempt": attempt + 1}
+		if !retry || attempt >= h.opts.MaxRetries {
+			h.opts.Logger.Error("Failed to deliver audit record, dropping it", err, fields)
+			return
+		}
+		h.opts.Logger.Warn("Failed to deliver audit record, retrying", Fields{
+			"requestUID": record.UID,
+			"attempt":    attempt + 1,
+			"backoff":    backoff.String(),
+			"error":      err,
+		})
+		select {
+		case <-time.After(backoff):
+		case <-h.ctx.Done():
+			h.opts.Logger.Error("Failed to deliver audit record before shutdown, dropping it", err, fields)
+			return
+		}
+		if backoff *= 2; backoff > h.opts.MaxBackoff {
+			backoff = h.opts.MaxBackoff
+		}
+	}
+}
+
+// post sends the record once. Returns whether a failed delivery may be retried
+func (h *HTTPAuditor) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(h.ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if h.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.opts.Token)
+	}
+	resp, err := h.opts.Client.Do(req)
+	if err != nil {
+		return h.ctx.Err() == nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("Audit endpoint responded with %s", resp.Status)
+}
+
+// multiAuditor records the decisions with every Auditor
+type multiAuditor []Auditor
+
+// NewMultiAuditor creates an Auditor recording the decisions with all of the auditors, e.g. to a file
+// and an HTTPAuditor. Auditors implementing io.Closer are closed with it
+func NewMultiAuditor(auditors ...Auditor) Auditor {
+	return multiAuditor(auditors)
+}
+
+func (m multiAuditor) Audit(record AuditRecord) error {
+	messages := []string{}
+	for _, auditor := range m {
+		if err := auditor.Audit(record); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+func (m multiAuditor) Close() error {
+	messages := []string{}
+	for _, auditor := range m {
+		if closer, ok := auditor.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				messages = append(messages, err.Error())
+			}
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
+}
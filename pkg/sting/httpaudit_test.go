@@ -0,0 +1,149 @@
+package sting
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// auditEndpoint records the posted audit records, failing the first requests with the status
+type auditEndpoint struct {
+	lock     sync.Mutex
+	failures int
+	status   int
+	attempts int
+	records  []AuditRecord
+	tokens   []string
+}
+
+func (a *auditEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.attempts++
+	a.tokens = append(a.tokens, r.Header.Get("Authorization"))
+	if a.failures > 0 {
+		a.failures--
+		w.WriteHeader(a.status)
+		return
+	}
+	record := AuditRecord{}
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	a.records = append(a.records, record)
+}
+
+func (a *auditEndpoint) state() (int, []AuditRecord) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.attempts, append([]AuditRecord{}, a.records...)
+}
+
+func TestHTTPAuditor(t *testing.T) {
+	endpoint := &auditEndpoint{failures: 2, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	auditor, err := NewHTTPAuditor(HTTPAuditorOptions{URL: server.URL, Token: "secret", InitialBackoff: time.Millisecond})
+	require.NoError(t, err)
+	require.NoError(t, auditor.Audit(AuditRecord{UID: "uid", Decision: DecisionMutated, Patch: []string{"add /spec/containers/-"}, JSONPatch: json.RawMessage(`[{"op":"add","path":"/spec/containers/-","value":{}}]`)}))
+	require.NoError(t, auditor.Close())
+
+	// Unavailable endpoints are retried
+	attempts, records := endpoint.state()
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, []AuditRecord{{UID: "uid", Decision: DecisionMutated, Patch: []string{"add /spec/containers/-"}, JSONPatch: json.RawMessage(`[{"op":"add","path":"/spec/containers/-","value":{}}]`)}}, records)
+	assert.Equal(t, "Bearer secret", endpoint.tokens[0])
+
+	assert.Error(t, auditor.Audit(AuditRecord{UID: "closed"}))
+}
+
+func TestHTTPAuditorGivesUp(t *testing.T) {
+	for _, data := range []struct {
+		status   int
+		attempts int
+	}{
+		// Rejected records aren't retried
+		{status: http.StatusBadRequest, attempts: 1},
+		{status: http.StatusInternalServerError, attempts: 3},
+	} {
+		endpoint := &auditEndpoint{failures: 10, status: data.status}
+		server := httptest.NewServer(endpoint)
+
+		auditor, err := NewHTTPAuditor(HTTPAuditorOptions{URL: server.URL, MaxRetries: 2, InitialBackoff: time.Millisecond})
+		require.NoError(t, err)
+		require.NoError(t, auditor.Audit(AuditRecord{UID: "uid"}))
+		require.NoError(t, auditor.Close())
+		attempts, records := endpoint.state()
+		assert.Equal(t, data.attempts, attempts, data.status)
+		assert.Empty(t, records)
+		server.Close()
+	}
+}
+
+func TestHTTPAuditorQueue(t *testing.T) {
+	blocked := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blocked
+	}))
+	defer server.Close()
+	defer close(blocked)
+
+	auditor, err := NewHTTPAuditor(HTTPAuditorOptions{URL: server.URL, QueueSize: 1, FlushTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	// The first record is being delivered, the second one queued
+	require.NoError(t, auditor.Audit(AuditRecord{UID: "1"}))
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, auditor.Audit(AuditRecord{UID: "2"}))
+	assert.Equal(t, ErrAuditQueueFull, auditor.Audit(AuditRecord{UID: "3"}))
+
+	// Close doesn't wait for a blocked endpoint longer than the flush timeout
+	start := time.Now()
+	assert.Error(t, auditor.Close())
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestNewHTTPAuditor(t *testing.T) {
+	for _, url := range []string{"", "ftp://audit", "audit.example.com/records", "https://"} {
+		_, err := NewHTTPAuditor(HTTPAuditorOptions{URL: url})
+		assert.Error(t, err, url)
+	}
+}
+
+// failingAuditor fails every record and counts closing
+type failingAuditor struct {
+	closed int
+}
+
+func (f *failingAuditor) Audit(record AuditRecord) error {
+	return errors.New("failed")
+}
+
+func (f *failingAuditor) Close() error {
+	f.closed++
+	return nil
+}
+
+func TestMultiAuditor(t *testing.T) {
+	failing := &failingAuditor{}
+	endpoint := &auditEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	delivering, err := NewHTTPAuditor(HTTPAuditorOptions{URL: server.URL})
+	require.NoError(t, err)
+
+	auditor := NewMultiAuditor(failing, delivering)
+	assert.EqualError(t, auditor.Audit(AuditRecord{UID: "uid"}), "failed")
+	require.NoError(t, auditor.(interface{ Close() error }).Close())
+	assert.Equal(t, 1, failing.closed)
+	_, records := endpoint.state()
+	assert.Len(t, records, 1)
+}
@@ -0,0 +1,103 @@
+package sting
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// concurrencyLimiter limits the number of admission requests handled concurrently. Requests exceeding
+// the limit wait in a small queue for a free slot. Requests are rejected with 429 Too Many Requests if
+// the queue is full and with 503 Service Unavailable if no slot became free in time, so the API server
+// fails fast instead of piling up requests until it times out
+type concurrencyLimiter struct {
+	slots     chan struct{}
+	queued    int32
+	maxQueued int32
+	timeout   time.Duration
+	logger    Logger
+}
+
+func newConcurrencyLimiter(maxInFlight, maxQueued int, timeout time.Duration, logger Logger) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		slots:     make(chan struct{}, maxInFlight),
+		maxQueued: int32(maxQueued),
+		timeout:   timeout,
+		logger:    logger,
+	}
+}
+
+func (l *concurrencyLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if !l.wait(w, r) {
+				return
+			}
+		}
+		slot := &concurrencySlot{holders: 1, release: func() { <-l.slots }}
+		defer slot.done()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), slotKey, slot)))
+	})
+}
+
+// concurrencySlot is the slot of a request, which is freed once the request and all decisions holding
+// it are done. Decisions abandoned at their deadline keep running and hold the slot until they return
+type concurrencySlot struct {
+	holders int32
+	release func()
+}
+
+// slotFromContext returns the slot of the request the context belongs to, or nil if the concurrency
+// isn't limited
+func slotFromContext(ctx context.Context) *concurrencySlot {
+	slot, _ := ctx.Value(slotKey).(*concurrencySlot)
+	return slot
+}
+
+// hold keeps the slot from being freed until done is called
+func (s *concurrencySlot) hold() {
+	if s != nil {
+		atomic.AddInt32(&s.holders, 1)
+	}
+}
+
+// done frees the slot if no one else holds it
+func (s *concurrencySlot) done() {
+	if s != nil && atomic.AddInt32(&s.holders, -1) == 0 {
+		s.release()
+	}
+}
+
+// wait queues the request until a slot is free. It returns false if the request has been rejected
+func (l *concurrencyLimiter) wait(w http.ResponseWriter, r *http.Request) bool {
+	fields := Fields{
+		"remoteAddr":  r.RemoteAddr,
+		"requestUri":  r.RequestURI,
+		"maxInFlight": cap(l.slots),
+	}
+	if atomic.AddInt32(&l.queued, 1) > l.maxQueued {
+		atomic.AddInt32(&l.queued, -1)
+		l.logger.Warn("Too many concurrent admission requests, rejecting the request", fields)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many concurrent admission requests", http.StatusTooManyRequests)
+		return false
+	}
+	defer atomic.AddInt32(&l.queued, -1)
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		l.logger.Warn("Timed out waiting for a free slot, rejecting the admission request", fields)
+		w.Header().Set("Retry-After", strconv.Itoa(int(l.timeout.Seconds())+1))
+		http.Error(w, "Timed out waiting for concurrent admission requests", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package sting
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admission/v1beta1"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	limiter := newConcurrencyLimiter(1, 1, 50*time.Millisecond, newStdLogger())
+	handler := limiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1beta/mutate", nil))
+		return w
+	}
+
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() { inFlight <- serve() }()
+	<-started
+
+	// The second request is queued until it times out, the third one is rejected as the queue is full
+	queued := make(chan *httptest.ResponseRecorder)
+	go func() { queued <- serve() }()
+	for atomic.LoadInt32(&limiter.queued) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	rejected := serve()
+	assert.Equal(t, http.StatusTooManyRequests, rejected.Code)
+	assert.Equal(t, "1", rejected.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusServiceUnavailable, (<-queued).Code)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, (<-inFlight).Code)
+	assert.Equal(t, http.StatusOK, serve().Code)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&limiter.queued))
+}
+
+func TestConcurrencySlotHeldByAbandonedDecisions(t *testing.T) {
+	returned := make(chan struct{})
+	decided := make(chan struct{}, 2)
+	limiter := newConcurrencyLimiter(1, 0, 10*time.Millisecond, newStdLogger())
+	i := &InjectServer{logger: newStdLogger(), metrics: newServerMetrics(noCertificate)}
+	handler := limiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Millisecond)
+		defer cancel()
+		// The decision ignores its deadline, like a lookup without context
+		_, _ = i.withinDeadline(ctx, func() (*v1beta1.AdmissionResponse, error) {
+			<-returned
+			decided <- struct{}{}
+			return &v1beta1.AdmissionResponse{Allowed: true}, nil
+		})
+	}))
+	serve := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1beta/mutate", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve())
+	// The abandoned decision still holds the slot
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+	close(returned)
+	<-decided
+	for len(limiter.slots) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, http.StatusOK, serve())
+}
@@ -0,0 +1,68 @@
+package sting
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Fields are the structured context of a log entry
+type Fields map[string]interface{}
+
+// Logger is the structured logger the InjectServer logs to. Adapters for logrus and log/slog are
+// provided by the packages logrusadapter and slogadapter, so the logs of the InjectServer can be
+// routed into the logger of the application
+type Logger interface {
+	Debug(msg string, fields Fields)
+	Info(msg string, fields Fields)
+	Warn(msg string, fields Fields)
+	Error(msg string, err error, fields Fields)
+}
+
+// stdLogger is used if no Logger is configured. It writes info and higher levels as key value
+// pairs via the log package of the standard library
+type stdLogger struct {
+	logger *log.Logger
+}
+
+func newStdLogger() Logger {
+	return &stdLogger{logger: log.New(os.Stderr, "", log.LstdFlags)}
+}
+
+func (s *stdLogger) Debug(msg string, fields Fields) {}
+
+func (s *stdLogger) Info(msg string, fields Fields) {
+	s.log("info", msg, fields)
+}
+
+func (s *stdLogger) Warn(msg string, fields Fields) {
+	s.log("warning", msg, fields)
+}
+
+func (s *stdLogger) Error(msg string, err error, fields Fields) {
+	if err != nil {
+		withError := Fields{"error": err}
+		for key, value := range fields {
+			withError[key] = value
+		}
+		fields = withError
+	}
+	s.log("error", msg, fields)
+}
+
+func (s *stdLogger) log(level, msg string, fields Fields) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entry := &strings.Builder{}
+	fmt.Fprintf(entry, "level=%s msg=%q", level, msg)
+	for _, key := range keys {
+		fmt.Fprintf(entry, " %s=%q", key, fmt.Sprint(fields[key]))
+	}
+	s.logger.Print(entry.String())
+}
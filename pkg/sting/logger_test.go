@@ -0,0 +1,23 @@
+package sting
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := &stdLogger{logger: log.New(buf, "", 0)}
+
+	logger.Debug("Received request body", Fields{"bodyLength": 2})
+	logger.Info("Mutating resource", Fields{"namespace": "default", "name": "app"})
+	logger.Error("Failed to read request", errors.New("Request body is empty"), Fields{"protocol": "HTTP/1.1"})
+
+	assert.Equal(t, `level=info msg="Mutating resource" name="app" namespace="default"
+level=error msg="Failed to read request" error="Request body is empty" protocol="HTTP/1.1"
+`, buf.String())
+}
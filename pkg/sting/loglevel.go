@@ -0,0 +1,60 @@
+package sting
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// LevelVar is the log level of the application, which the admin endpoint /loglevel returns and
+// changes at runtime. Adapters for logrus and log/slog are provided by the packages logrusadapter
+// and slogadapter
+type LevelVar interface {
+	Level() string
+	SetLevel(level string) error
+}
+
+// logLevel is the request and response of the log level endpoint
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// isAuthorized checks whether the request carries the admin token as bearer token
+func isAuthorized(r *http.Request, token string) bool {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+// logLevelHandler returns the log level on GET and changes it on PUT, e.g. to debug a misbehaving
+// injection without restarting. Changes require the admin token and are disabled without one
+func (i *InjectServer) logLevelHandler(level LevelVar, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			if !isAuthorized(r, token) {
+				i.logger.Warn("Unauthorized request to change the log level", Fields{"remoteAddr": r.RemoteAddr})
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			requested := logLevel{}
+			if err := json.NewDecoder(r.Body).Decode(&requested); err != nil {
+				http.Error(w, "Invalid request body, want {\"level\":\"<level>\"}", http.StatusBadRequest)
+				return
+			}
+			previous := level.Level()
+			if err := level.SetLevel(requested.Level); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			i.logger.Info("Changed log level", Fields{
+				"previousLevel": previous,
+				"logLevel":      level.Level(),
+				"remoteAddr":    r.RemoteAddr,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(logLevel{Level: level.Level()}); err != nil {
+			i.logger.Error("Failed to serialize log level to JSON", err, nil)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package sting
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staticLevel supports the levels debug and info
+type staticLevel struct {
+	level string
+}
+
+func (s *staticLevel) Level() string {
+	return s.level
+}
+
+func (s *staticLevel) SetLevel(level string) error {
+	if level != "debug" && level != "info" {
+		return errors.New("not a valid level: " + level)
+	}
+	s.level = level
+	return nil
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	level := &staticLevel{level: "info"}
+	i := &InjectServer{logger: newStdLogger()}
+	handler := i.logLevelHandler(level, "secret")
+
+	request := func(method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/loglevel", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"level":"info"}`, rec.Body.String())
+
+	for _, token := range []string{"", "wrong"} {
+		rec = request(http.MethodPut, token, `{"level":"debug"}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, token)
+		assert.Equal(t, "info", level.level)
+	}
+
+	rec = request(http.MethodPut, "secret", `{"level":"verbose"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = request(http.MethodPut, "secret", `debug`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "info", level.level)
+
+	rec = request(http.MethodPut, "secret", `{"level":"debug"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"level":"debug"}`, rec.Body.String())
+	assert.Equal(t, "debug", level.level)
+
+	// Without admin token the level can't be changed
+	rec = httptest.NewRecorder()
+	i.logLevelHandler(level, "").ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader(`{"level":"info"}`)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "debug", level.level)
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	i, err := NewEmbedded(&Options{Mutate: denyWith("denied"), LogLevel: &staticLevel{level: "info"}, AdminToken: "secret"})
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	i.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	i, err = NewEmbedded(&Options{Mutate: denyWith("denied")})
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	i.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
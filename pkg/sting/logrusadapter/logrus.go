@@ -0,0 +1,60 @@
+// Package logrusadapter routes the logs of sting into a logrus logger
+package logrusadapter
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+type logger struct {
+	logger logrus.FieldLogger
+}
+
+// New creates a sting.Logger logging to the given logrus logger or entry, like logrus.StandardLogger()
+func New(l logrus.FieldLogger) sting.Logger {
+	return &logger{logger: l}
+}
+
+func (l *logger) Debug(msg string, fields sting.Fields) {
+	l.logger.WithFields(logrus.Fields(fields)).Debug(msg)
+}
+
+func (l *logger) Info(msg string, fields sting.Fields) {
+	l.logger.WithFields(logrus.Fields(fields)).Info(msg)
+}
+
+func (l *logger) Warn(msg string, fields sting.Fields) {
+	l.logger.WithFields(logrus.Fields(fields)).Warn(msg)
+}
+
+func (l *logger) Error(msg string, err error, fields sting.Fields) {
+	entry := l.logger.WithFields(logrus.Fields(fields))
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	entry.Error(msg)
+}
+
+type levelVar struct {
+	logger *logrus.Logger
+}
+
+// NewLevel creates a sting.LevelVar changing the level of the given logrus logger, like
+// logrus.StandardLogger()
+func NewLevel(l *logrus.Logger) sting.LevelVar {
+	return &levelVar{logger: l}
+}
+
+func (l *levelVar) Level() string {
+	return l.logger.GetLevel().String()
+}
+
+func (l *levelVar) SetLevel(level string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l.logger.SetLevel(parsed)
+	return nil
+}
@@ -0,0 +1,51 @@
+package logrusadapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+func TestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := logrus.New()
+	l.Out = buf
+	l.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+	logger := New(l)
+
+	logger.Debug("Received request body", sting.Fields{"bodyLength": 2})
+	logger.Warn("Failed to replicate secret", sting.Fields{"namespace": "default"})
+	logger.Error("Failed to read request", errors.New("Request body is empty"), nil)
+
+	entries := []map[string]interface{}{}
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		entry := map[string]interface{}{}
+		require.NoError(t, decoder.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, []map[string]interface{}{
+		{"level": "warning", "msg": "Failed to replicate secret", "namespace": "default"},
+		{"level": "error", "msg": "Failed to read request", "error": "Request body is empty"},
+	}, entries)
+}
+
+func TestLevel(t *testing.T) {
+	l := logrus.New()
+	level := NewLevel(l)
+	assert.Equal(t, "info", level.Level())
+
+	require.NoError(t, level.SetLevel("debug"))
+	assert.Equal(t, logrus.DebugLevel, l.GetLevel())
+	assert.Equal(t, "debug", level.Level())
+
+	assert.Error(t, level.SetLevel("verbose"))
+	assert.Equal(t, "debug", level.Level())
+}
@@ -0,0 +1,249 @@
+package sting
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// collector writes its samples in the Prometheus text format
+type collector interface {
+	writeTo(w io.Writer)
+}
+
+// metricsRegistry exposes the metrics of the InjectServer in the Prometheus text format, so they can
+// be scraped without a client library
+type metricsRegistry struct {
+	collectors []collector
+}
+
+func (m *metricsRegistry) register(c collector) {
+	m.collectors = append(m.collectors, c)
+}
+
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, c := range m.collectors {
+		c.writeTo(w)
+	}
+	applicationCounters.lock.Lock()
+	defer applicationCounters.lock.Unlock()
+	for _, c := range applicationCounters.counters {
+		c.writeTo(w)
+	}
+}
+
+// applicationCounters are the counters created via NewCounter
+var applicationCounters = struct {
+	lock     sync.Mutex
+	counters []*Counter
+}{}
+
+// Counter is a counter of the application partitioned by the value of a single label
+type Counter struct {
+	*counterVec
+}
+
+// NewCounter creates a counter of the application, which is served by all InjectServers together
+// with their own metrics
+func NewCounter(name, help, label string) *Counter {
+	c := &Counter{newCounterVec(name, help, label)}
+	applicationCounters.lock.Lock()
+	defer applicationCounters.lock.Unlock()
+	applicationCounters.counters = append(applicationCounters.counters, c)
+	return c
+}
+
+// counterVec is a counter partitioned by the value of a single label
+type counterVec struct {
+	name   string
+	help   string
+	label  string
+	lock   sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	return &counterVec{name: name, help: help, label: label, values: map[string]uint64{}}
+}
+
+// Inc increments the counter of the label value. A nil counter discards the increment
+func (c *counterVec) Inc(value string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.values[value]++
+}
+
+// Value returns the counter of the label value
+func (c *counterVec) Value(value string) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.values[value]
+}
+
+func (c *counterVec) writeTo(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, value := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s=%s} %d\n", c.name, c.label, strconv.Quote(value), c.values[value])
+	}
+}
+
+// Buckets of the latency histograms in seconds, from fast patches up to the default webhook timeout
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogramVec is a histogram partitioned by the value of a single label
+type histogramVec struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64
+	lock    sync.Mutex
+	values  map[string]*histogram
+}
+
+// histogram holds the cumulative counts of the observations per bucket
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help, label string, buckets []float64) *histogramVec {
+	return &histogramVec{name: name, help: help, label: label, buckets: buckets, values: map[string]*histogram{}}
+}
+
+// Observe adds the observation to the histogram of the label value
+func (h *histogramVec) Observe(value string, observed float64) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	hist, exists := h.values[value]
+	if !exists {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[value] = hist
+	}
+	for i, bound := range h.buckets {
+		if observed <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.sum += observed
+	hist.count++
+}
+
+// ObserveSince adds the seconds passed since start to the histogram of the label value
+func (h *histogramVec) ObserveSince(value string, start time.Time) {
+	h.Observe(value, time.Since(start).Seconds())
+}
+
+// Count returns the number of observations of the label value
+func (h *histogramVec) Count(value string) uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if hist, exists := h.values[value]; exists {
+		return hist.count
+	}
+	return 0
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	values := make([]string, 0, len(h.values))
+	for value := range h.values {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		hist := h.values[value]
+		label := fmt.Sprintf("%s=%s", h.label, strconv.Quote(value))
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", h.name, label, formatFloat(bound), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, label, hist.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, label, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, label, hist.count)
+	}
+}
+
+// gaugeFunc is a gauge whose value is determined when it is scraped. No sample is written if the
+// value is unknown
+type gaugeFunc struct {
+	name  string
+	help  string
+	value func() (float64, bool)
+}
+
+func (g *gaugeFunc) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	if value, known := g.value(); known {
+		fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(value))
+	}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]uint64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// patchDuration is shared by all InjectServers, as patches are created by the functions of the package
+var patchDuration = newHistogramVec("sting_patch_duration_seconds",
+	"Duration of creating the JSON patches of mutations in seconds", "method", latencyBuckets)
+
+// serverMetrics are the metrics of the InjectServer
+type serverMetrics struct {
+	registry         metricsRegistry
+	deadlineExceeded *counterVec
+	mutateDuration   *histogramVec
+	shadowDecisions  *counterVec
+	skippedRequests  *counterVec
+}
+
+// newServerMetrics creates the metrics of the InjectServer. certificateExpiry returns when the loaded
+// serving certificate expires, if one is loaded
+func newServerMetrics(certificateExpiry func() (time.Time, bool)) serverMetrics {
+	m := serverMetrics{
+		deadlineExceeded: newCounterVec("sting_admission_deadline_exceeded_total",
+			"Admission requests answered by the failure policy because the decision exceeded its deadline", "endpoint"),
+		mutateDuration: newHistogramVec("sting_mutate_duration_seconds",
+			"Duration of the mutate functions in seconds", "endpoint", latencyBuckets),
+		shadowDecisions: newCounterVec("sting_shadow_decisions_total",
+			"Admission decisions not enforced in shadow mode, by the decision which would have been made", "decision"),
+		skippedRequests: newCounterVec("sting_skipped_requests_total",
+			"Admission requests allowed without invoking the mutators, as their operation or subresource isn't processed", "reason"),
+	}
+	m.registry.register(m.deadlineExceeded)
+	m.registry.register(m.mutateDuration)
+	m.registry.register(m.shadowDecisions)
+	m.registry.register(m.skippedRequests)
+	m.registry.register(patchDuration)
+	m.registry.register(&gaugeFunc{
+		name: "sting_certificate_expiry_days",
+		help: "Days until the loaded serving certificate expires, negative once it is expired",
+		value: func() (float64, bool) {
+			notAfter, loaded := certificateExpiry()
+			return time.Until(notAfter).Hours() / 24, loaded
+		},
+	})
+	return m
+}
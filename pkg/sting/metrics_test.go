@@ -0,0 +1,82 @@
+package sting
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	m := newServerMetrics(noCertificate)
+	m.deadlineExceeded.Inc("cloudsql")
+	m.deadlineExceeded.Inc("cloudsql")
+	m.deadlineExceeded.Inc(admitEndpoint)
+
+	rec := httptest.NewRecorder()
+	m.registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"))
+	assert.Contains(t, rec.Body.String(), `# HELP sting_admission_deadline_exceeded_total Admission requests answered by the failure policy because the decision exceeded its deadline
+# TYPE sting_admission_deadline_exceeded_total counter
+sting_admission_deadline_exceeded_total{endpoint="admit"} 1
+sting_admission_deadline_exceeded_total{endpoint="cloudsql"} 2
+`)
+	// The certificate expiry is omitted as long as no certificate is loaded
+	assert.Contains(t, rec.Body.String(), "# TYPE sting_certificate_expiry_days gauge\n")
+	assert.NotContains(t, rec.Body.String(), "\nsting_certificate_expiry_days ")
+}
+
+func TestApplicationCounter(t *testing.T) {
+	c := NewCounter("sting_test_dropped_total", "Dropped things", "reason")
+	c.Inc("full")
+	assert.EqualValues(t, 1, c.Value("full"))
+
+	m := newServerMetrics(noCertificate)
+	rec := httptest.NewRecorder()
+	m.registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "# TYPE sting_test_dropped_total counter\nsting_test_dropped_total{reason=\"full\"} 1\n")
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogramVec("sting_mutate_duration_seconds", "Duration", "endpoint", []float64{0.1, 1})
+	h.Observe("cloudsql", 0.05)
+	h.Observe("cloudsql", 0.5)
+	h.Observe("cloudsql", 2)
+	assert.EqualValues(t, 3, h.Count("cloudsql"))
+	assert.EqualValues(t, 0, h.Count("admit"))
+
+	rec := httptest.NewRecorder()
+	h.writeTo(rec)
+	assert.Equal(t, `# HELP sting_mutate_duration_seconds Duration
+# TYPE sting_mutate_duration_seconds histogram
+sting_mutate_duration_seconds_bucket{endpoint="cloudsql",le="0.1"} 1
+sting_mutate_duration_seconds_bucket{endpoint="cloudsql",le="1"} 2
+sting_mutate_duration_seconds_bucket{endpoint="cloudsql",le="+Inf"} 3
+sting_mutate_duration_seconds_sum{endpoint="cloudsql"} 2.55
+sting_mutate_duration_seconds_count{endpoint="cloudsql"} 3
+`, rec.Body.String())
+}
+
+func TestCertificateExpiryGauge(t *testing.T) {
+	m := newServerMetrics(func() (time.Time, bool) {
+		return time.Now().Add(36 * time.Hour), true
+	})
+	rec := httptest.NewRecorder()
+	m.registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "sting_certificate_expiry_days 1.49")
+}
+
+func TestPatchDuration(t *testing.T) {
+	before := patchDuration.Count("targeted")
+	_, err := CreateTargetedPatch([]byte(`{"a":1}`), []byte(`{"a":1}`), []byte(`{"a":2}`))
+	assert.NoError(t, err)
+	assert.Equal(t, before+1, patchDuration.Count("targeted"))
+}
+
+func noCertificate() (time.Time, bool) {
+	return time.Time{}, false
+}
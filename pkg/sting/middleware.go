@@ -0,0 +1,37 @@
+package sting
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Middleware wraps the handling of admission requests, e.g. to authenticate the clients, collect
+// metrics, audit the reviews or shape the requests. A middleware may respond itself instead of
+// calling the next handler
+type Middleware func(next http.Handler) http.Handler
+
+// router returns the router of the admission endpoint. Requests pass the recovery from panics and
+// the concurrency limit first, then the configured middlewares in order and the validation of the
+// content type last
+func (i *InjectServer) router(opts *Options, mutators []Mutator) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(mux.MiddlewareFunc(recoverPanics(i.logger)))
+	if opts.MaxInFlight > 0 {
+		r.Use(newConcurrencyLimiter(opts.MaxInFlight, opts.MaxQueued, opts.QueueTimeout, i.logger).middleware)
+	}
+	for _, middleware := range opts.Middlewares {
+		r.Use(mux.MiddlewareFunc(middleware))
+	}
+	r.Use(validateContentType(i.logger, "application/json"))
+
+	i.addMutators(r, mutators)
+
+	if i.isAdmitted != nil {
+		for _, path := range opts.admitPaths() {
+			i.logger.Info("Adding non mutating admission endpoint", Fields{"urlPath": path})
+			r.Path(path).Methods(http.MethodPost).HandlerFunc(i.handleAdmission)
+		}
+	}
+	return r
+}
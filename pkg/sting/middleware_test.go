@@ -0,0 +1,66 @@
+package sting
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingMiddleware appends its name to the calls before calling the next handler
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*calls = append(*calls, name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	calls := []string{}
+	opts := &Options{
+		Mutate: denyWith("denied"),
+		Middlewares: []Middleware{
+			recordingMiddleware("first", &calls),
+			recordingMiddleware("second", &calls),
+			func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("Authorization") == "" {
+						http.Error(w, "unauthorized", http.StatusUnauthorized)
+						return
+					}
+					next.ServeHTTP(w, r)
+				})
+			},
+		},
+	}
+	mutators, err := opts.mutators()
+	require.NoError(t, err)
+	r := (&InjectServer{logger: newStdLogger()}).router(opts, mutators)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, defaultMutatePath, bytes.NewReader([]byte("{}"))))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, []string{"first", "second"}, calls)
+
+	// The content type is validated after the middlewares
+	calls = calls[:0]
+	req := httptest.NewRequest(http.MethodPost, defaultMutatePath, bytes.NewReader([]byte(`{"request":{"uid":"uid"}}`)))
+	req.Header.Set("Authorization", "Bearer token")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	assert.Equal(t, []string{"first", "second"}, calls)
+
+	req = httptest.NewRequest(http.MethodPost, defaultMutatePath, bytes.NewReader([]byte(`{"request":{"uid":"uid"}}`)))
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "denied")
+}
@@ -0,0 +1,128 @@
+package sting
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Default paths of the endpoints of Options.Mutate and Options.IsAdmitted
+	defaultMutatePath = "/api/v1beta/mutate"
+	defaultAdmitPath  = "/api/v1beta/admit"
+	// Name of the mutator of Options.Mutate in the logs
+	defaultMutatorName = "default"
+	// Name of the non mutating admission endpoint in the RequestInfo
+	admitEndpoint = "admit"
+)
+
+// Mutator is a named MutateFunc served on its own URL path, so a single InjectServer can serve
+// several webhook configurations with independent logic
+type Mutator struct {
+	// Name identifies the mutator in the logs
+	Name string
+	// Path of the endpoint, defaults to <first of Options.MutatePaths>/<name>
+	Path string
+	// The function implementation to be used when running mutations
+	Mutate MutateFunc
+	// MutateContext is used instead of Mutate if the mutation needs the context of the request
+	MutateContext MutateContextFunc
+	// Optional function to be used to decide whether a mutation is necessary or not
+	NeedsMutate NeedsMutationFunc
+}
+
+// mutatePaths returns the paths of the endpoint of Mutate, defaulting to /api/v1beta/mutate
+func (opts *Options) mutatePaths() []string {
+	if len(opts.MutatePaths) == 0 {
+		return []string{defaultMutatePath}
+	}
+	return opts.MutatePaths
+}
+
+// admitPaths returns the paths of the endpoint of IsAdmitted, defaulting to /api/v1beta/admit
+func (opts *Options) admitPaths() []string {
+	if len(opts.AdmitPaths) == 0 {
+		return []string{defaultAdmitPath}
+	}
+	return opts.AdmitPaths
+}
+
+// mutators returns the mutators to be served, including the one configured via Mutate and
+// NeedsMutate on each of the MutatePaths. Every mutator needs a name and a MutateFunc or
+// MutateContextFunc, names and paths must be unique and absolute. The returned mutators have their
+// MutateContext set, recovering from panics
+func (opts *Options) mutators() ([]Mutator, error) {
+	mutators := []Mutator{}
+	paths := map[string]bool{}
+	register := func(path, name string) error {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("Path %q of %s is not absolute", path, name)
+		}
+		if paths[path] {
+			return fmt.Errorf("Path %s of %s is already registered", path, name)
+		}
+		paths[path] = true
+		return nil
+	}
+
+	if opts.IsAdmitted != nil || opts.IsAdmittedContext != nil {
+		for _, path := range opts.admitPaths() {
+			if err := register(path, "the admission endpoint"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.Mutate != nil || opts.MutateContext != nil {
+		for _, path := range opts.mutatePaths() {
+			if err := register(path, "mutator "+defaultMutatorName); err != nil {
+				return nil, err
+			}
+			mutators = append(mutators, Mutator{
+				Name:          defaultMutatorName,
+				Path:          path,
+				Mutate:        opts.Mutate,
+				MutateContext: opts.MutateContext,
+				NeedsMutate:   opts.NeedsMutate,
+			})
+		}
+	}
+
+	names := map[string]bool{}
+	for _, mutator := range opts.Mutators {
+		if mutator.Name == "" {
+			return nil, fmt.Errorf("Mutator at path %q has no name", mutator.Path)
+		}
+		if mutator.Mutate == nil && mutator.MutateContext == nil {
+			return nil, fmt.Errorf("Mutator %s has no Mutate function", mutator.Name)
+		}
+		if mutator.Path == "" {
+			mutator.Path = opts.mutatePaths()[0] + "/" + mutator.Name
+		}
+		if names[mutator.Name] {
+			return nil, fmt.Errorf("Mutator %s is registered more than once", mutator.Name)
+		}
+		if err := register(mutator.Path, "mutator "+mutator.Name); err != nil {
+			return nil, err
+		}
+		names[mutator.Name] = true
+		mutators = append(mutators, mutator)
+	}
+
+	for i := range mutators {
+		if mutators[i].MutateContext == nil {
+			mutators[i].MutateContext = MutateWithContext(mutators[i].Mutate)
+		}
+		mutators[i].MutateContext = recoverMutate(mutators[i].MutateContext, opts.failurePolicy())
+	}
+	return mutators, nil
+}
+
+// addMutators registers the endpoints of the mutators
+func (i *InjectServer) addMutators(r *mux.Router, mutators []Mutator) {
+	for _, mutator := range mutators {
+		i.logger.Info("Adding mutating admission endpoint", Fields{"urlPath": mutator.Path, "mutator": mutator.Name})
+		r.Path(mutator.Path).Methods(http.MethodPost).HandlerFunc(i.mutateHandler(mutator))
+	}
+}
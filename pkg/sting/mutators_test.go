@@ -0,0 +1,122 @@
+package sting
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// denyWith returns a MutateFunc denying every request with the message
+func denyWith(message string) MutateFunc {
+	return func(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+		return &v1beta1.AdmissionResponse{Result: &metav1.Status{Message: message}}
+	}
+}
+
+func TestMutators(t *testing.T) {
+	opts := &Options{
+		Mutate: denyWith("default"),
+		Mutators: []Mutator{
+			{Name: "cloudsql", Mutate: denyWith("cloudsql")},
+			{Name: "alloydb", Path: "/mutate/alloydb", Mutate: denyWith("alloydb")},
+			{Name: "skipped", Mutate: denyWith("skipped"), NeedsMutate: func(*v1beta1.AdmissionReview) bool { return false }},
+		},
+	}
+	mutators, err := opts.mutators()
+	require.NoError(t, err)
+	r := mux.NewRouter()
+	(&InjectServer{logger: newStdLogger()}).addMutators(r, mutators)
+
+	for path, message := range map[string]string{
+		"/api/v1beta/mutate":          "default",
+		"/api/v1beta/mutate/cloudsql": "cloudsql",
+		"/mutate/alloydb":             "alloydb",
+		"/api/v1beta/mutate/skipped":  "This resource does not need mutation",
+	} {
+		body, err := json.Marshal(v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{UID: "uid"}})
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		review := v1beta1.AdmissionReview{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &review))
+		require.NotNil(t, review.Response)
+		assert.Equal(t, message, review.Response.Result.Message, path)
+		assert.EqualValues(t, "uid", review.Response.UID, path)
+	}
+}
+
+func TestMutatorsInvalid(t *testing.T) {
+	for _, opts := range []*Options{
+		{Mutators: []Mutator{{Mutate: denyWith("unnamed")}}},
+		{Mutators: []Mutator{{Name: "nil"}}},
+		{Mutators: []Mutator{{Name: "a", Mutate: denyWith("a")}, {Name: "a", Path: "/a", Mutate: denyWith("a")}}},
+		{Mutators: []Mutator{{Name: "a", Mutate: denyWith("a")}, {Name: "b", Path: "/api/v1beta/mutate/a", Mutate: denyWith("b")}}},
+		{Mutate: denyWith("default"), Mutators: []Mutator{{Name: "a", Path: "/api/v1beta/mutate", Mutate: denyWith("a")}}},
+	} {
+		_, err := opts.mutators()
+		assert.Error(t, err, "%+v", opts.Mutators)
+	}
+}
+
+func TestEndpointPaths(t *testing.T) {
+	opts := &Options{
+		Mutate:      denyWith("default"),
+		MutatePaths: []string{"/mutate", "/v2/mutate"},
+		Mutators:    []Mutator{{Name: "cloudsql", Mutate: denyWith("cloudsql")}},
+		IsAdmitted: func(ar *v1beta1.AdmissionReview) (*v1beta1.AdmissionResponse, error) {
+			return &v1beta1.AdmissionResponse{Allowed: true, Result: &metav1.Status{Message: "admitted"}}, nil
+		},
+		AdmitPaths: []string{"/validate"},
+	}
+	mutators, err := opts.mutators()
+	require.NoError(t, err)
+	i := &InjectServer{logger: newStdLogger(), isAdmitted: opts.isAdmitted()}
+	r := i.router(opts, mutators)
+
+	for path, message := range map[string]string{
+		"/mutate":          "default",
+		"/v2/mutate":       "default",
+		"/mutate/cloudsql": "cloudsql",
+		"/validate":        "admitted",
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(`{"request":{"uid":"uid"}}`)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		review := v1beta1.AdmissionReview{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &review))
+		require.NotNil(t, review.Response)
+		assert.Equal(t, message, review.Response.Result.Message, path)
+	}
+	for _, path := range []string{defaultMutatePath, defaultAdmitPath} {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(`{}`)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotFound, rec.Code, path)
+	}
+}
+
+func TestEndpointPathsInvalid(t *testing.T) {
+	isAdmitted := func(ar *v1beta1.AdmissionReview) (*v1beta1.AdmissionResponse, error) { return nil, nil }
+	for _, opts := range []*Options{
+		{Mutate: denyWith("default"), MutatePaths: []string{"mutate"}},
+		{Mutate: denyWith("default"), MutatePaths: []string{"/mutate", "/mutate"}},
+		{Mutate: denyWith("default"), IsAdmitted: isAdmitted, MutatePaths: []string{"/review"}, AdmitPaths: []string{"/review"}},
+		{IsAdmitted: isAdmitted, Mutators: []Mutator{{Name: "admit", Path: defaultAdmitPath, Mutate: denyWith("a")}}},
+	} {
+		_, err := opts.mutators()
+		assert.Error(t, err, "%+v", opts)
+	}
+}
@@ -0,0 +1,67 @@
+package sting
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/admission/v1beta1"
+)
+
+// The mutators only process the creation of objects by default
+var defaultOperations = []v1beta1.Operation{v1beta1.Create}
+
+// ParseOperations parses the comma separated admission operations, e.g. CREATE,UPDATE
+func ParseOperations(value string) ([]v1beta1.Operation, error) {
+	operations := []v1beta1.Operation{}
+	for _, operation := range strings.Split(value, ",") {
+		if operation = strings.ToUpper(strings.TrimSpace(operation)); operation != "" {
+			operations = append(operations, v1beta1.Operation(operation))
+		}
+	}
+	if err := validateOperations(operations); err != nil {
+		return nil, err
+	}
+	return operations, nil
+}
+
+// validateOperations checks that the operations are known admission operations
+func validateOperations(operations []v1beta1.Operation) error {
+	for _, operation := range operations {
+		switch operation {
+		case v1beta1.Create, v1beta1.Update, v1beta1.Delete, v1beta1.Connect:
+		default:
+			return fmt.Errorf("Unsupported operation %q, expected CREATE, UPDATE, DELETE or CONNECT", operation)
+		}
+	}
+	return nil
+}
+
+// operations returns the operations processed by the mutators, defaulting to CREATE
+func (opts *Options) operations() map[v1beta1.Operation]bool {
+	operations := opts.Operations
+	if len(operations) == 0 {
+		operations = defaultOperations
+	}
+	processed := map[v1beta1.Operation]bool{}
+	for _, operation := range operations {
+		processed[operation] = true
+	}
+	return processed
+}
+
+// skipReason returns why the mutators don't process the request, or an empty string if they do.
+// Requests of subresources and of operations which aren't configured are skipped, reviews without an
+// operation aren't filtered. Servers created via New default to CREATE, see operations, only servers
+// without any operations process every request
+func (i *InjectServer) skipReason(ar *v1beta1.AdmissionReview) string {
+	if ar.Request == nil || len(i.operations) == 0 {
+		return ""
+	}
+	if ar.Request.SubResource != "" {
+		return "subresource"
+	}
+	if ar.Request.Operation != "" && !i.operations[ar.Request.Operation] {
+		return "operation"
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package sting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+)
+
+func TestParseOperations(t *testing.T) {
+	operations, err := ParseOperations("create, Update,")
+	require.NoError(t, err)
+	assert.Equal(t, []v1beta1.Operation{v1beta1.Create, v1beta1.Update}, operations)
+
+	_, err = ParseOperations("CREATE,PATCH")
+	assert.EqualError(t, err, `Unsupported operation "PATCH", expected CREATE, UPDATE, DELETE or CONNECT`)
+}
+
+func TestOperations(t *testing.T) {
+	for _, data := range []struct {
+		operations  []v1beta1.Operation
+		operation   string
+		subResource string
+		mutated     bool
+	}{
+		{operation: "CREATE", mutated: true},
+		{operation: "UPDATE", mutated: false},
+		{operation: "DELETE", mutated: false},
+		{operation: "CREATE", subResource: "status", mutated: false},
+		// Reviews without an operation aren't filtered
+		{operation: "", mutated: true},
+		{operations: []v1beta1.Operation{v1beta1.Create, v1beta1.Update}, operation: "UPDATE", mutated: true},
+		{operations: []v1beta1.Operation{v1beta1.Update}, operation: "CREATE", mutated: false},
+	} {
+		calls := 0
+		i, err := NewEmbedded(WithMutate(func(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+			calls++
+			return &v1beta1.AdmissionResponse{Allowed: true, Patch: []byte(`[{"op":"add","path":"/metadata/labels","value":{}}]`)}
+		}), WithOperations(data.operations...))
+		require.NoError(t, err)
+
+		review := `{"request":{"uid":"uid","operation":"` + data.operation + `","subResource":"` + data.subResource + `"}}`
+		req := httptest.NewRequest(http.MethodPost, defaultMutatePath, strings.NewReader(review))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		i.Handler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		response := v1beta1.AdmissionReview{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.True(t, response.Response.Allowed)
+		assert.Equal(t, data.mutated, len(response.Response.Patch) > 0, "%s %s", data.operation, data.subResource)
+		assert.Equal(t, data.mutated, calls == 1, "%s %s", data.operation, data.subResource)
+	}
+
+	_, err := NewEmbedded(WithMutate(denyWith("denied")), WithOperations("PATCH"))
+	assert.Error(t, err)
+}
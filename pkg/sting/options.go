@@ -0,0 +1,361 @@
+package sting
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Option configures the InjectServer created by New or NewEmbedded. Options are applied in order on
+// top of NewOptions, options for alternatives like WithMutate and WithMutateContext or the sources of
+// the certificate replace each other, so they can't be combined by mistake. *Options is an Option
+// replacing all of the options, e.g. New(opts) with a struct configured up front
+type Option interface {
+	apply(opts *Options)
+}
+
+// optionFunc is an Option changing some of the options
+type optionFunc func(opts *Options)
+
+func (f optionFunc) apply(opts *Options) {
+	f(opts)
+}
+
+func (opts *Options) apply(target *Options) {
+	*target = *opts
+}
+
+// buildOptions applies the options on top of NewOptions
+func buildOptions(options []Option) *Options {
+	opts := NewOptions()
+	for _, option := range options {
+		if option != nil {
+			option.apply(opts)
+		}
+	}
+	return opts
+}
+
+// WithListenAddrs serves the admission endpoint on the addresses. Default is :443
+func WithListenAddrs(addrs ...string) Option {
+	return optionFunc(func(opts *Options) {
+		opts.ListenAddr = ""
+		opts.ListenAddrs = addrs
+	})
+}
+
+// WithMutate serves the MutateFunc on the mutate paths, replacing a MutateContextFunc
+func WithMutate(mutate MutateFunc) Option {
+	return optionFunc(func(opts *Options) {
+		opts.Mutate = mutate
+		opts.MutateContext = nil
+	})
+}
+
+// WithMutateContext serves the MutateContextFunc on the mutate paths, replacing a MutateFunc
+func WithMutateContext(mutate MutateContextFunc) Option {
+	return optionFunc(func(opts *Options) {
+		opts.MutateContext = mutate
+		opts.Mutate = nil
+	})
+}
+
+// WithNeedsMutate decides whether the mutation of WithMutate or WithMutateContext is necessary
+func WithNeedsMutate(needsMutate NeedsMutationFunc) Option {
+	return optionFunc(func(opts *Options) {
+		opts.NeedsMutate = needsMutate
+	})
+}
+
+// WithMutatePaths serves the mutation on the paths instead of /api/v1beta/mutate
+func WithMutatePaths(paths ...string) Option {
+	return optionFunc(func(opts *Options) {
+		opts.MutatePaths = paths
+	})
+}
+
+// WithMutators adds mutators served on their own paths
+func WithMutators(mutators ...Mutator) Option {
+	return optionFunc(func(opts *Options) {
+		opts.Mutators = append(opts.Mutators, mutators...)
+	})
+}
+
+// WithOperations processes the operations by the mutators instead of CREATE
+func WithOperations(operations ...v1beta1.Operation) Option {
+	return optionFunc(func(opts *Options) {
+		opts.Operations = operations
+	})
+}
+
+// WithIsAdmitted serves the admission check on the admit paths, replacing an IsAdmittedContextFunc
+func WithIsAdmitted(isAdmitted IsAdmittedFunc) Option {
+	return optionFunc(func(opts *Options) {
+		opts.IsAdmitted = isAdmitted
+		opts.IsAdmittedContext = nil
+	})
+}
+
+// WithIsAdmittedContext serves the admission check on the admit paths, replacing an IsAdmittedFunc
+func WithIsAdmittedContext(isAdmitted IsAdmittedContextFunc) Option {
+	return optionFunc(func(opts *Options) {
+		opts.IsAdmittedContext = isAdmitted
+		opts.IsAdmitted = nil
+	})
+}
+
+// WithAdmitPaths serves the admission check on the paths instead of /api/v1beta/admit
+func WithAdmitPaths(paths ...string) Option {
+	return optionFunc(func(opts *Options) {
+		opts.AdmitPaths = paths
+	})
+}
+
+// WithMiddlewares adds middlewares wrapping the handling of admission requests
+func WithMiddlewares(middlewares ...Middleware) Option {
+	return optionFunc(func(opts *Options) {
+		opts.Middlewares = append(opts.Middlewares, middlewares...)
+	})
+}
+
+// WithFailurePolicy determines whether objects are allowed or denied if their mutation fails
+func WithFailurePolicy(policy FailurePolicy) Option {
+	return optionFunc(func(opts *Options) {
+		opts.FailurePolicy = policy
+	})
+}
+
+// WithHandlerTimeout bounds the admission decision
+func WithHandlerTimeout(timeout time.Duration) Option {
+	return optionFunc(func(opts *Options) {
+		opts.HandlerTimeout = timeout
+	})
+}
+
+// WithAuditor records every admission decision with the Auditor
+func WithAuditor(auditor Auditor) Option {
+	return optionFunc(func(opts *Options) {
+		opts.Auditor = auditor
+	})
+}
+
+// WithShadow records the admission decisions, but admits every object unchanged
+func WithShadow() Option {
+	return optionFunc(func(opts *Options) {
+		opts.Shadow = true
+	})
+}
+
+// WithRecentDecisions keeps the last admission decisions for the endpoint /decisions
+func WithRecentDecisions(size int) Option {
+	return optionFunc(func(opts *Options) {
+		opts.RecentDecisions = size
+	})
+}
+
+// WithDefaults returns the configured defaults of the application by the health endpoint
+func WithDefaults(defaults interface{}) Option {
+	return optionFunc(func(opts *Options) {
+		opts.Defaults = defaults
+	})
+}
+
+// WithHealthChecks adds checks to the readiness endpoint
+func WithHealthChecks(checks ...HealthCheck) Option {
+	return optionFunc(func(opts *Options) {
+		opts.HealthChecks = append(opts.HealthChecks, checks...)
+	})
+}
+
+// WithLogger sends the logs of the InjectServer to the Logger
+func WithLogger(logger Logger) Option {
+	return optionFunc(func(opts *Options) {
+		opts.Logger = logger
+	})
+}
+
+// WithLogLevel returns and changes the log level via the endpoint /loglevel
+func WithLogLevel(level LevelVar) Option {
+	return optionFunc(func(opts *Options) {
+		opts.LogLevel = level
+	})
+}
+
+// WithAdminToken enables the admin endpoints changing the application, protected by the bearer token
+func WithAdminToken(token string) Option {
+	return optionFunc(func(opts *Options) {
+		opts.AdminToken = token
+	})
+}
+
+// WithServerTimeouts sets the timeouts of the HTTP(S) servers
+func WithServerTimeouts(read, readHeader, write, idle time.Duration) Option {
+	return optionFunc(func(opts *Options) {
+		opts.ReadTimeout = read
+		opts.ReadHeaderTimeout = readHeader
+		opts.WriteTimeout = write
+		opts.IdleTimeout = idle
+	})
+}
+
+// WithConcurrencyLimit limits the admission requests handled concurrently
+func WithConcurrencyLimit(maxInFlight, maxQueued int, queueTimeout time.Duration) Option {
+	return optionFunc(func(opts *Options) {
+		opts.MaxInFlight = maxInFlight
+		opts.MaxQueued = maxQueued
+		opts.QueueTimeout = queueTimeout
+	})
+}
+
+// WithShutdownTimeout is how long Close waits for the open connections to drain
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return optionFunc(func(opts *Options) {
+		opts.ShutdownTimeout = timeout
+	})
+}
+
+// WithTLSConfig is the base of the TLS configuration of the admission endpoint
+func WithTLSConfig(config *tls.Config, nextProtos ...string) Option {
+	return optionFunc(func(opts *Options) {
+		opts.TLSConfig = config
+		opts.NextProtos = nextProtos
+	})
+}
+
+// WithTLSFiles loads the serving certificate from the files, replacing a certificate secret or source
+func WithTLSFiles(certFile, keyFile string) Option {
+	return optionFunc(func(opts *Options) {
+		clearCertificate(opts)
+		opts.CertFile = certFile
+		opts.KeyFile = keyFile
+	})
+}
+
+// WithCertSecret loads the serving certificate from the secret watched via the SecretWatcher,
+// replacing the certificate files or source
+func WithCertSecret(secret *corev1.SecretReference, secrets SecretWatcher) Option {
+	return optionFunc(func(opts *Options) {
+		clearCertificate(opts)
+		opts.CertSecret = secret
+		opts.Secrets = secrets
+	})
+}
+
+// WithCertSource retrieves the serving certificate from the source, replacing the certificate files
+// or secret
+func WithCertSource(source CertificateSource) Option {
+	return optionFunc(func(opts *Options) {
+		clearCertificate(opts)
+		opts.CertSource = source
+	})
+}
+
+func clearCertificate(opts *Options) {
+	opts.CertFile = ""
+	opts.KeyFile = ""
+	opts.CertSecret = nil
+	opts.Secrets = nil
+	opts.CertSource = nil
+}
+
+// Validate checks the options of an InjectServer created by New, returning the first misconfiguration
+// found. NewEmbedded ignores the listeners and the certificate
+func (opts *Options) Validate() error {
+	return opts.validate(true)
+}
+
+// validate checks the options, including the listeners and the certificate if the InjectServer
+// serves the endpoints itself
+func (opts *Options) validate(serving bool) error {
+	if _, err := ParseFailurePolicy(string(opts.failurePolicy())); err != nil {
+		return err
+	}
+	if opts.Mutate != nil && opts.MutateContext != nil {
+		return errors.New("Only one of Mutate and MutateContext may be set")
+	}
+	if opts.IsAdmitted != nil && opts.IsAdmittedContext != nil {
+		return errors.New("Only one of IsAdmitted and IsAdmittedContext may be set")
+	}
+	mutates := opts.Mutate != nil || opts.MutateContext != nil
+	if !mutates && (opts.NeedsMutate != nil || len(opts.MutatePaths) > 0) {
+		return errors.New("NeedsMutate and MutatePaths require Mutate or MutateContext")
+	}
+	if opts.IsAdmitted == nil && opts.IsAdmittedContext == nil && len(opts.AdmitPaths) > 0 {
+		return errors.New("AdmitPaths require IsAdmitted or IsAdmittedContext")
+	}
+	mutators, err := opts.mutators()
+	if err != nil {
+		return err
+	}
+	if len(mutators) == 0 && opts.isAdmitted() == nil {
+		return errors.New("Nothing to serve, configure Mutate, MutateContext, Mutators or IsAdmitted")
+	}
+	if err := validateOperations(opts.Operations); err != nil {
+		return err
+	}
+	if err := validateHealthChecks(opts.HealthChecks); err != nil {
+		return err
+	}
+	for _, limit := range []struct {
+		name  string
+		value int64
+	}{
+		{"MaxInFlight", int64(opts.MaxInFlight)},
+		{"MaxQueued", int64(opts.MaxQueued)},
+		{"RecentDecisions", int64(opts.RecentDecisions)},
+		{"HandlerTimeout", int64(opts.HandlerTimeout)},
+		{"QueueTimeout", int64(opts.QueueTimeout)},
+		{"ShutdownTimeout", int64(opts.ShutdownTimeout)},
+		{"ReadTimeout", int64(opts.ReadTimeout)},
+		{"ReadHeaderTimeout", int64(opts.ReadHeaderTimeout)},
+		{"WriteTimeout", int64(opts.WriteTimeout)},
+		{"IdleTimeout", int64(opts.IdleTimeout)},
+	} {
+		if limit.value < 0 {
+			return fmt.Errorf("%s must not be negative", limit.name)
+		}
+	}
+	if opts.CaFile != "" {
+		return errors.New("CaFile isn't supported yet, TLS authenticated clients aren't implemented")
+	}
+	if !serving {
+		return nil
+	}
+	if _, err := opts.listenAddrs(); err != nil {
+		return err
+	}
+	return opts.validateCertificate()
+}
+
+// validateCertificate checks that exactly one source of the serving certificate is configured
+func (opts *Options) validateCertificate() error {
+	sources := 0
+	for _, configured := range []bool{
+		opts.CertFile != "" || opts.KeyFile != "",
+		opts.CertSecret != nil,
+		opts.CertSource != nil,
+	} {
+		if configured {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		return errors.New("No serving certificate configured, set the certificate files, a certificate secret or a certificate source")
+	case sources > 1:
+		return errors.New("Only one of the certificate files, the certificate secret and the certificate source may be set")
+	case opts.CertSecret != nil && opts.Secrets == nil:
+		return errors.New("A SecretWatcher is required to load the certificate from a secret")
+	case opts.CertSecret != nil && opts.CertSecret.Name == "":
+		return errors.New("The certificate secret requires a name")
+	case opts.CertSecret == nil && opts.Secrets != nil:
+		return errors.New("Secrets are only used to load the certificate from a secret")
+	case opts.CertSource == nil && opts.CertSecret == nil && (opts.CertFile == "" || opts.KeyFile == ""):
+		return fmt.Errorf("Both the certificate file and the key file are required, got %q and %q", opts.CertFile, opts.KeyFile)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package sting
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	source := CertificateSourceFunc(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return nil, nil
+	})
+	for _, data := range []struct {
+		name  string
+		opts  *Options
+		error string
+	}{
+		{name: "valid", opts: &Options{Mutate: denyWith("denied"), CertFile: "cert.pem", KeyFile: "key.pem"}},
+		{name: "nothing to serve", opts: &Options{CertFile: "cert.pem", KeyFile: "key.pem"}, error: "Nothing to serve"},
+		{name: "both mutations", opts: &Options{Mutate: denyWith("denied"), MutateContext: MutateWithContext(denyWith("denied")), CertSource: source}, error: "Only one of Mutate and MutateContext"},
+		{name: "unused mutate paths", opts: &Options{Mutators: []Mutator{{Name: "deny", Mutate: denyWith("denied")}}, MutatePaths: []string{"/mutate"}, CertSource: source}, error: "require Mutate or MutateContext"},
+		{name: "unused admit paths", opts: &Options{Mutate: denyWith("denied"), AdmitPaths: []string{"/admit"}, CertSource: source}, error: "AdmitPaths require"},
+		{name: "mutator without function", opts: &Options{Mutators: []Mutator{{Name: "deny"}}, CertSource: source}, error: "deny"},
+		{name: "negative limit", opts: &Options{Mutate: denyWith("denied"), MaxInFlight: -1, CertSource: source}, error: "MaxInFlight must not be negative"},
+		{name: "negative timeout", opts: &Options{Mutate: denyWith("denied"), HandlerTimeout: -time.Second, CertSource: source}, error: "HandlerTimeout must not be negative"},
+		{name: "failure policy", opts: &Options{Mutate: denyWith("denied"), FailurePolicy: "Retry", CertSource: source}, error: "Retry"},
+		{name: "ca file", opts: &Options{Mutate: denyWith("denied"), CaFile: "ca.pem", CertSource: source}, error: "CaFile isn't supported"},
+		{name: "listen addresses", opts: &Options{Mutate: denyWith("denied"), ListenAddrs: []string{":443", ":443"}, CertSource: source}, error: "more than once"},
+		{name: "no certificate", opts: &Options{Mutate: denyWith("denied")}, error: "No serving certificate configured"},
+		{name: "missing key", opts: &Options{Mutate: denyWith("denied"), CertFile: "cert.pem"}, error: "Both the certificate file and the key file are required"},
+		{name: "several certificates", opts: &Options{Mutate: denyWith("denied"), CertFile: "cert.pem", KeyFile: "key.pem", CertSource: source}, error: "Only one of the certificate files"},
+		{name: "secret without watcher", opts: &Options{Mutate: denyWith("denied"), CertSecret: &corev1.SecretReference{Name: "certs"}}, error: "A SecretWatcher is required"},
+	} {
+		err := data.opts.Validate()
+		if data.error == "" {
+			assert.NoError(t, err, data.name)
+			continue
+		}
+		require.Error(t, err, data.name)
+		assert.Contains(t, err.Error(), data.error, data.name)
+	}
+
+	// Embedded servers need neither listeners nor a certificate
+	assert.NoError(t, (&Options{Mutate: denyWith("denied")}).validate(false))
+}
+
+func TestOptions(t *testing.T) {
+	source := CertificateSourceFunc(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return nil, nil
+	})
+	opts := buildOptions([]Option{
+		WithMutateContext(MutateWithContext(denyWith("denied"))),
+		WithMutate(denyWith("denied")),
+		WithTLSFiles("cert.pem", "key.pem"),
+		WithCertSource(source),
+		WithListenAddrs(":8443"),
+		WithRecentDecisions(10),
+	})
+	// Alternatives replace each other
+	assert.NotNil(t, opts.Mutate)
+	assert.Nil(t, opts.MutateContext)
+	assert.NotNil(t, opts.CertSource)
+	assert.Empty(t, opts.CertFile)
+	assert.Empty(t, opts.KeyFile)
+	assert.Equal(t, []string{":8443"}, opts.ListenAddrs)
+	assert.Equal(t, 10, opts.RecentDecisions)
+	// The defaults of NewOptions are kept
+	assert.Equal(t, 2*time.Second, opts.QueueTimeout)
+	assert.NoError(t, opts.Validate())
+
+	// Options replace all of the options
+	opts = buildOptions([]Option{WithShadow(), &Options{Mutate: denyWith("denied")}})
+	assert.False(t, opts.Shadow)
+	assert.Zero(t, opts.QueueTimeout)
+}
+
+func TestNewValidatesOptions(t *testing.T) {
+	_, err := New(WithMutate(denyWith("denied")))
+	assert.EqualError(t, err, "No serving certificate configured, set the certificate files, a certificate secret or a certificate source")
+
+	_, err = New(&Options{Mutate: denyWith("denied"), CertFile: "cert.pem"})
+	assert.Error(t, err)
+
+	i, err := NewEmbedded(WithMutate(denyWith("denied")), WithMutatePaths("/webhooks/sqlbee"))
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/sqlbee", strings.NewReader(`{"request":{"uid":"uid"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	i.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "denied")
+}
@@ -0,0 +1,229 @@
+package sting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattbaird/jsonpatch"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Elements of arrays are identified by these fields, e.g. containers and volumes by their name and
+// volume mounts by their name and mount path
+var identityFields = []string{"name", "mountPath", "containerPort"}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// CreateTargetedPatch creates a JSON patch containing only the changes between the original and the
+// mutated object, which need to be serialized from the same API types. The patch is applied to the raw
+// object as received, so fields of the raw object unknown to the API types are never touched and fields
+// only introduced by the serialization, like empty resources, cause no changes. Elements of arrays are
+// compared by their identity, so an added container is inserted instead of rewriting the others
+func CreateTargetedPatch(objRaw, originalRaw, mutatedRaw []byte) ([]byte, error) {
+	defer patchDuration.ObserveSince("targeted", time.Now())
+	var obj, original, mutated interface{}
+	for _, doc := range []struct {
+		raw []byte
+		val *interface{}
+	}{{objRaw, &obj}, {originalRaw, &original}, {mutatedRaw, &mutated}} {
+		decoder := json.NewDecoder(bytes.NewReader(doc.raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(doc.val); err != nil {
+			return nil, err
+		}
+	}
+
+	patch := diffValues(obj, original, mutated, "")
+	if len(patch) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(patch)
+}
+
+// diffValues returns the operations changing the value a into b at the path of the raw object
+func diffValues(raw, a, b interface{}, path string) []jsonpatch.JsonPatchOperation {
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	rawObj, _ := raw.(map[string]interface{})
+	aObj, aIsObj := a.(map[string]interface{})
+	bObj, bIsObj := b.(map[string]interface{})
+	if aIsObj && bIsObj && rawObj != nil {
+		return diffObjects(rawObj, aObj, bObj, path)
+	}
+	rawList, _ := raw.([]interface{})
+	aList, aIsList := a.([]interface{})
+	bList, bIsList := b.([]interface{})
+	if aIsList && bIsList && len(rawList) == len(aList) {
+		return diffArrays(rawList, aList, bList, path)
+	}
+	return []jsonpatch.JsonPatchOperation{jsonpatch.NewPatch("replace", path, b)}
+}
+
+// diffObjects returns the operations changing the object a into b. Fields missing in the raw object are
+// added as a whole, fields the raw object doesn't know about aren't removed
+func diffObjects(raw, a, b map[string]interface{}, path string) []jsonpatch.JsonPatchOperation {
+	keys := []string{}
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, exists := a[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	patch := []jsonpatch.JsonPatchOperation{}
+	for _, key := range keys {
+		p := path + "/" + pointerEscaper.Replace(key)
+		rv, inRaw := raw[key]
+		av, inA := a[key]
+		bv, inB := b[key]
+		switch {
+		case !inB:
+			if inRaw {
+				patch = append(patch, jsonpatch.NewPatch("remove", p, nil))
+			}
+		case !inRaw:
+			if !inA || !reflect.DeepEqual(av, bv) {
+				patch = append(patch, jsonpatch.NewPatch("add", p, bv))
+			}
+		case !inA:
+			// Fields unknown to the API types are only replaced if they have been changed
+			if !reflect.DeepEqual(rv, bv) {
+				patch = append(patch, jsonpatch.NewPatch("replace", p, bv))
+			}
+		default:
+			patch = append(patch, diffValues(rv, av, bv, p)...)
+		}
+	}
+	return patch
+}
+
+// diffArrays returns the operations changing the array a into b. Elements with the same identity are
+// changed in place, afterwards the remaining elements of a are removed and the ones of b inserted
+func diffArrays(raw, a, b []interface{}, path string) []jsonpatch.JsonPatchOperation {
+	// The longest common subsequence of elements with the same identity is kept
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if sameElement(a[i], b[j]) {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	patch := []jsonpatch.JsonPatchOperation{}
+	keptA := make([]bool, len(a))
+	keptB := make([]bool, len(b))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case sameElement(a[i], b[j]) && lengths[i][j] == lengths[i+1][j+1]+1:
+			patch = append(patch, diffValues(raw[i], a[i], b[j], path+"/"+strconv.Itoa(i))...)
+			keptA[i], keptB[j] = true, true
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		if !keptA[i] {
+			patch = append(patch, jsonpatch.NewPatch("remove", path+"/"+strconv.Itoa(i), nil))
+		}
+	}
+	for j := range b {
+		if !keptB[j] {
+			patch = append(patch, jsonpatch.NewPatch("add", path+"/"+strconv.Itoa(j), b[j]))
+		}
+	}
+	return patch
+}
+
+// sameElement checks whether two elements of an array are equal or objects with the same identity
+func sameElement(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	aObj, aIsObj := a.(map[string]interface{})
+	bObj, bIsObj := b.(map[string]interface{})
+	if !aIsObj || !bIsObj {
+		return false
+	}
+	identified := false
+	for _, field := range identityFields {
+		av, inA := aObj[field]
+		bv, inB := bObj[field]
+		if inA != inB || !reflect.DeepEqual(av, bv) {
+			return false
+		}
+		identified = identified || inA
+	}
+	return identified
+}
+
+// PatchResponse creates the response allowing the object with the JSON patch from the original object,
+// as received with the admission request, to the mutated one. The original is decoded into the type
+// of the mutated object, so only the mutations are patched and not the artifacts of the serialization.
+// The warnings are added to the response, no patch is set if nothing has been mutated
+func PatchResponse(original []byte, mutated runtime.Object, warnings ...string) (*v1beta1.AdmissionResponse, error) {
+	if len(original) == 0 || mutated == nil {
+		return nil, errors.New("The original and the mutated object are required to create a patch")
+	}
+	mutatedType := reflect.TypeOf(mutated)
+	if mutatedType.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("Unsupported type %T of the mutated object, expected a pointer", mutated)
+	}
+	decoded := reflect.New(mutatedType.Elem()).Interface().(runtime.Object)
+	if err := json.Unmarshal(original, decoded); err != nil {
+		return nil, fmt.Errorf("Failed to decode the original object: %s", err)
+	}
+	originalRaw, mutatedRaw := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := Marshaler.Encode(decoded, originalRaw); err != nil {
+		return nil, fmt.Errorf("Failed to serialize the original object: %s", err)
+	}
+	if err := Marshaler.Encode(mutated, mutatedRaw); err != nil {
+		return nil, fmt.Errorf("Failed to serialize the mutated object: %s", err)
+	}
+	return RawPatchResponse(original, originalRaw.Bytes(), mutatedRaw.Bytes(), warnings...)
+}
+
+// RawPatchResponse creates the response like PatchResponse from the already serialized objects, for
+// mutators which edit the serialized mutated object further, e.g. to set fields missing in their API
+// types. serialized is the original object serialized like the mutated one
+func RawPatchResponse(original, serialized, mutated []byte, warnings ...string) (*v1beta1.AdmissionResponse, error) {
+	patch, err := CreateTargetedPatch(original, serialized, mutated)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the patch: %s", err)
+	}
+
+	response := &v1beta1.AdmissionResponse{Allowed: true}
+	if len(patch) > 0 {
+		patchType := v1beta1.PatchTypeJSONPatch
+		response.PatchType = &patchType
+		response.Patch = patch
+	}
+	for _, warning := range warnings {
+		AddWarning(response, warning)
+	}
+	return response, nil
+}
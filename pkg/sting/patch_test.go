@@ -0,0 +1,143 @@
+package sting
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCreateTargetedPatch(t *testing.T) {
+	for _, data := range []struct {
+		name     string
+		raw      string
+		original string
+		mutated  string
+		patch    string
+	}{
+		{
+			name:     "unchanged",
+			raw:      `{"spec":{"containers":[{"name":"app"}]}}`,
+			original: `{"spec":{"containers":[{"name":"app","resources":{}}]}}`,
+			mutated:  `{"spec":{"containers":[{"name":"app","resources":{}}]}}`,
+			patch:    ``,
+		},
+		{
+			name:     "unknown fields are kept",
+			raw:      `{"spec":{"os":{"name":"linux"},"containers":[{"name":"app"}]}}`,
+			original: `{"spec":{"containers":[{"name":"app"}]}}`,
+			mutated:  `{"spec":{"containers":[{"name":"app"},{"name":"proxy"}]}}`,
+			patch:    `[{"op":"add","path":"/spec/containers/1","value":{"name":"proxy"}}]`,
+		},
+		{
+			name:     "elements are inserted",
+			raw:      `{"spec":{"containers":[{"name":"app"},{"name":"other"}]}}`,
+			original: `{"spec":{"containers":[{"name":"app"},{"name":"other"}]}}`,
+			mutated:  `{"spec":{"containers":[{"name":"proxy"},{"name":"app"},{"name":"other"}]}}`,
+			patch:    `[{"op":"add","path":"/spec/containers/0","value":{"name":"proxy"}}]`,
+		},
+		{
+			name:     "elements are changed in place",
+			raw:      `{"spec":{"containers":[{"name":"app","env":[{"name":"A","value":"a"}]},{"name":"proxy","image":"proxy:1"}]}}`,
+			original: `{"spec":{"containers":[{"name":"app","env":[{"name":"A","value":"a"}]},{"name":"proxy","image":"proxy:1"}]}}`,
+			mutated:  `{"spec":{"containers":[{"name":"app","env":[{"name":"A","value":"a"},{"name":"B","value":"b"}]},{"name":"proxy","image":"proxy:2"}]}}`,
+			patch:    `[{"op":"add","path":"/spec/containers/0/env/1","value":{"name":"B","value":"b"}},{"op":"replace","path":"/spec/containers/1/image","value":"proxy:2"}]`,
+		},
+		{
+			name:     "elements are removed",
+			raw:      `{"spec":{"volumes":[{"name":"a"},{"name":"b"},{"name":"c"}]}}`,
+			original: `{"spec":{"volumes":[{"name":"a"},{"name":"b"},{"name":"c"}]}}`,
+			mutated:  `{"spec":{"volumes":[{"name":"b"},{"name":"d"}]}}`,
+			patch:    `[{"op":"remove","path":"/spec/volumes/2"},{"op":"remove","path":"/spec/volumes/0"},{"op":"add","path":"/spec/volumes/1","value":{"name":"d"}}]`,
+		},
+		{
+			name:     "fields missing in the raw object are added as a whole",
+			raw:      `{"spec":{"containers":[{"name":"proxy"}]}}`,
+			original: `{"spec":{"containers":[{"name":"proxy","resources":{}}]}}`,
+			mutated:  `{"spec":{"containers":[{"name":"proxy","resources":{"requests":{"cpu":"10m"}}}]}}`,
+			patch:    `[{"op":"add","path":"/spec/containers/0/resources","value":{"requests":{"cpu":"10m"}}}]`,
+		},
+		{
+			name:     "unknown fields are only replaced if changed",
+			raw:      `{"spec":{"initContainers":[{"name":"proxy","restartPolicy":"Always","image":"proxy:1"}]}}`,
+			original: `{"spec":{"initContainers":[{"name":"proxy","image":"proxy:1"}]}}`,
+			mutated:  `{"spec":{"initContainers":[{"name":"proxy","restartPolicy":"Always","image":"proxy:2"}]}}`,
+			patch:    `[{"op":"replace","path":"/spec/initContainers/0/image","value":"proxy:2"}]`,
+		},
+		{
+			name:     "keys are escaped",
+			raw:      `{"metadata":{"labels":{"app":"a"}}}`,
+			original: `{"metadata":{"labels":{"app":"a"}}}`,
+			mutated:  `{"metadata":{"labels":{"app":"a","sqlbee.connctd.io/status":"injected"}}}`,
+			patch:    `[{"op":"add","path":"/metadata/labels/sqlbee.connctd.io~1status","value":"injected"}]`,
+		},
+	} {
+		patch, err := CreateTargetedPatch([]byte(data.raw), []byte(data.original), []byte(data.mutated))
+		require.NoError(t, err, data.name)
+		if data.patch == "" {
+			assert.Empty(t, patch, data.name)
+			continue
+		}
+		assert.JSONEq(t, data.patch, string(patch), data.name)
+	}
+
+	_, err := CreateTargetedPatch([]byte(`{`), []byte(`{}`), []byte(`{}`))
+	assert.Error(t, err)
+}
+
+func TestCreateTargetedPatchNumbers(t *testing.T) {
+	patch, err := CreateTargetedPatch([]byte(`{"port":3306}`), []byte(`{"port":3306}`), []byte(`{"port":5432}`))
+	require.NoError(t, err)
+	ops := []map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	require.Len(t, ops, 1)
+	assert.Equal(t, float64(5432), ops[0]["value"])
+}
+
+func TestPatchResponse(t *testing.T) {
+	original := []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"app"},"spec":{"os":{"name":"linux"},"containers":[{"name":"app"}]}}`)
+	obj, _, err := DecodeObject(&v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{Object: runtime.RawExtension{Raw: original}}})
+	require.NoError(t, err)
+	pod := obj.(*corev1.Pod)
+
+	// Nothing mutated, nothing to patch
+	response, err := PatchResponse(original, pod)
+	require.NoError(t, err)
+	assert.Equal(t, &v1beta1.AdmissionResponse{Allowed: true}, response)
+
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "proxy"})
+	response, err = PatchResponse(original, pod, "defaulted")
+	require.NoError(t, err)
+	assert.True(t, response.Allowed)
+	require.NotNil(t, response.PatchType)
+	assert.Equal(t, v1beta1.PatchTypeJSONPatch, *response.PatchType)
+	assert.JSONEq(t, `[{"op":"add","path":"/spec/containers/1","value":{"name":"proxy","resources":{}}}]`, string(response.Patch))
+	assert.Equal(t, []string{"defaulted"}, Warnings(response))
+
+	_, err = PatchResponse(nil, pod)
+	assert.Error(t, err)
+	_, err = PatchResponse([]byte(`[]`), pod)
+	assert.Error(t, err)
+}
+
+func TestRawPatchResponse(t *testing.T) {
+	original := []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"app"},"spec":{"containers":[{"name":"app"}]}}`)
+	serialized := []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"app","creationTimestamp":null},"spec":{"containers":[{"name":"app","resources":{}}]},"status":{}}`)
+
+	// Artifacts of the serialization aren't patched
+	response, err := RawPatchResponse(original, serialized, serialized)
+	require.NoError(t, err)
+	assert.Equal(t, &v1beta1.AdmissionResponse{Allowed: true}, response)
+
+	// Fields which the API types lack are patched as well
+	mutated := []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"app","creationTimestamp":null},"spec":{"containers":[{"name":"app","resources":{},"restartPolicy":"Always"}]},"status":{}}`)
+	response, err = RawPatchResponse(original, serialized, mutated, "defaulted")
+	require.NoError(t, err)
+	require.NotNil(t, response.PatchType)
+	assert.JSONEq(t, `[{"op":"add","path":"/spec/containers/0/restartPolicy","value":"Always"}]`, string(response.Patch))
+	assert.Equal(t, []string{"defaulted"}, Warnings(response))
+}
@@ -0,0 +1,100 @@
+package sting
+
+import (
+	"errors"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ErrMissingPodTemplate is returned by PodSpecOf if the object has no pod template
+var ErrMissingPodTemplate = errors.New("Object does not contain a pod template")
+
+// PodSpecPaths are the paths at which the pod specs of workload kinds are commonly found, used to find
+// the pod spec of unstructured objects
+var PodSpecPaths = [][]string{
+	{"spec", "template", "spec"},
+	{"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// PodSpecOf returns the pod spec of a pod or the pod template of a workload, like a Deployment,
+// StatefulSet, DaemonSet, ReplicaSet, ReplicationController, Job or CronJob, so it can be mutated in
+// place. The pod spec of unstructured objects, e.g. of custom resources, is searched at PodSpecPaths
+// and returned as a copy, which needs to be written back into the object. ErrMissingPodTemplate is
+// returned for objects without pod template
+func PodSpecOf(obj runtime.Object) (*corev1.PodSpec, error) {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return &o.Spec, nil
+	case *corev1.PodTemplate:
+		return &o.Template.Spec, nil
+	case *corev1.ReplicationController:
+		if o.Spec.Template == nil {
+			return nil, ErrMissingPodTemplate
+		}
+		return &o.Spec.Template.Spec, nil
+	case *appsv1.Deployment:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1beta1.Deployment:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1beta2.Deployment:
+		return &o.Spec.Template.Spec, nil
+	case *extensionsv1beta1.Deployment:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1beta1.StatefulSet:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1beta2.StatefulSet:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1beta2.DaemonSet:
+		return &o.Spec.Template.Spec, nil
+	case *extensionsv1beta1.DaemonSet:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1.ReplicaSet:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1beta2.ReplicaSet:
+		return &o.Spec.Template.Spec, nil
+	case *extensionsv1beta1.ReplicaSet:
+		return &o.Spec.Template.Spec, nil
+	case *batchv1.Job:
+		return &o.Spec.Template.Spec, nil
+	case *batchv1beta1.CronJob:
+		return &o.Spec.JobTemplate.Spec.Template.Spec, nil
+	case *unstructured.Unstructured:
+		return unstructuredPodSpec(o.Object)
+	case runtime.Unstructured:
+		return unstructuredPodSpec(o.UnstructuredContent())
+	default:
+		return nil, ErrMissingPodTemplate
+	}
+}
+
+// unstructuredPodSpec converts the first pod spec found at PodSpecPaths
+func unstructuredPodSpec(content map[string]interface{}) (*corev1.PodSpec, error) {
+	for _, path := range PodSpecPaths {
+		spec, found, err := unstructured.NestedMap(content, path...)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		podSpec := &corev1.PodSpec{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, podSpec); err != nil {
+			return nil, fmt.Errorf("Failed to convert the pod spec at %v: %s", path, err)
+		}
+		return podSpec, nil
+	}
+	return nil, ErrMissingPodTemplate
+}
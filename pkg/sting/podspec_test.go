@@ -0,0 +1,73 @@
+package sting
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestPodSpecOf(t *testing.T) {
+	template := corev1.PodTemplateSpec{Spec: corev1.PodSpec{ServiceAccountName: "app"}}
+	deployment := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Template: template}}
+	for _, obj := range []runtime.Object{
+		&corev1.Pod{Spec: template.Spec},
+		deployment,
+		&extensionsv1beta1.Deployment{Spec: extensionsv1beta1.DeploymentSpec{Template: template}},
+		&appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Template: template}},
+		&appsv1.DaemonSet{Spec: appsv1.DaemonSetSpec{Template: template}},
+		&appsv1.ReplicaSet{Spec: appsv1.ReplicaSetSpec{Template: template}},
+		&corev1.ReplicationController{Spec: corev1.ReplicationControllerSpec{Template: &template}},
+		&batchv1.Job{Spec: batchv1.JobSpec{Template: template}},
+		&batchv1beta1.CronJob{Spec: batchv1beta1.CronJobSpec{JobTemplate: batchv1beta1.JobTemplateSpec{
+			Spec: batchv1.JobSpec{Template: template},
+		}}},
+	} {
+		podSpec, err := PodSpecOf(obj)
+		require.NoError(t, err, "%T", obj)
+		assert.Equal(t, "app", podSpec.ServiceAccountName, "%T", obj)
+	}
+
+	// The pod spec of typed objects is mutated in place
+	podSpec, err := PodSpecOf(deployment)
+	require.NoError(t, err)
+	podSpec.Containers = append(podSpec.Containers, corev1.Container{Name: "proxy"})
+	assert.Len(t, deployment.Spec.Template.Spec.Containers, 1)
+
+	for _, obj := range []runtime.Object{
+		&corev1.ConfigMap{},
+		&corev1.ReplicationController{},
+		&unstructured.Unstructured{Object: map[string]interface{}{"kind": "Widget", "spec": map[string]interface{}{}}},
+	} {
+		_, err := PodSpecOf(obj)
+		assert.Equal(t, ErrMissingPodTemplate, err, "%T", obj)
+	}
+}
+
+func TestPodSpecOfUnstructured(t *testing.T) {
+	for _, path := range PodSpecPaths {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{"kind": "Workflow"}}
+		require.NoError(t, unstructured.SetNestedField(obj.Object, map[string]interface{}{
+			"serviceAccountName": "app",
+			"containers":         []interface{}{map[string]interface{}{"name": "main", "image": "app:1.0"}},
+		}, path...))
+
+		podSpec, err := PodSpecOf(obj)
+		require.NoError(t, err, path)
+		assert.Equal(t, "app", podSpec.ServiceAccountName, path)
+		assert.Equal(t, []corev1.Container{{Name: "main", Image: "app:1.0"}}, podSpec.Containers, path)
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": "invalid"}},
+	}}
+	_, err := PodSpecOf(obj)
+	assert.Error(t, err)
+}
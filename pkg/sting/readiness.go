@@ -0,0 +1,93 @@
+package sting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// How long the readiness checks may take together, below the default timeout of probes
+const readinessTimeout = 900 * time.Millisecond
+
+// HealthCheck is a readiness check of the application, e.g. whether an informer has synced or an
+// API the mutation relies on is reachable
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// validateHealthChecks checks that every health check has a unique name and a check
+func validateHealthChecks(checks []HealthCheck) error {
+	names := map[string]bool{}
+	for _, check := range checks {
+		if check.Name == "" || check.Check == nil {
+			return fmt.Errorf("Health check %q requires a name and a check", check.Name)
+		}
+		if names[check.Name] {
+			return fmt.Errorf("Health check %q is registered more than once", check.Name)
+		}
+		names[check.Name] = true
+	}
+	return nil
+}
+
+// readiness is the response of the readiness endpoint
+type readiness struct {
+	Status string `json:"status"`
+	// Checks maps the names of the checks to ok or the error of the check
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// checkReadiness runs the health checks concurrently. The returned map contains ok or the error of
+// each check, checks not completing in time are failed
+func checkReadiness(ctx context.Context, checks []HealthCheck) (map[string]string, bool) {
+	type result struct {
+		name string
+		err  error
+	}
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	results := make(chan result, len(checks))
+	for _, check := range checks {
+		go func(check HealthCheck) {
+			results <- result{name: check.Name, err: check.Check(ctx)}
+		}(check)
+	}
+
+	statuses := map[string]string{}
+	ready := true
+	for _, check := range checks {
+		statuses[check.Name] = "timed out"
+	}
+	for range checks {
+		select {
+		case r := <-results:
+			statuses[r.name] = "ok"
+			if r.err != nil {
+				statuses[r.name] = r.err.Error()
+				ready = false
+			}
+		case <-ctx.Done():
+			return statuses, false
+		}
+	}
+	return statuses, ready
+}
+
+// readinessHandler aggregates the health checks, it responds with 503 Service Unavailable if any of
+// them fails
+func (i *InjectServer) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	statuses, ready := checkReadiness(r.Context(), i.healthChecks)
+	response := readiness{Status: "ok", Checks: statuses}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		i.logger.Warn("Readiness check failed", Fields{"checks": statuses})
+		response.Status = "failed"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		i.logger.Error("Failed to serialize readiness to JSON", err, nil)
+	}
+}
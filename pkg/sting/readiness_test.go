@@ -0,0 +1,69 @@
+package sting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateHealthChecks(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	assert.NoError(t, validateHealthChecks(nil))
+	assert.NoError(t, validateHealthChecks([]HealthCheck{{Name: "informer", Check: ok}, {Name: "gcp", Check: ok}}))
+	assert.Error(t, validateHealthChecks([]HealthCheck{{Check: ok}}))
+	assert.Error(t, validateHealthChecks([]HealthCheck{{Name: "informer"}}))
+	assert.Error(t, validateHealthChecks([]HealthCheck{{Name: "informer", Check: ok}, {Name: "informer", Check: ok}}))
+}
+
+func TestReadinessHandler(t *testing.T) {
+	synced := false
+	i := &InjectServer{logger: newStdLogger(), healthChecks: []HealthCheck{
+		{Name: "informer", Check: func(ctx context.Context) error {
+			if !synced {
+				return errors.New("Namespace informer not synced")
+			}
+			return nil
+		}},
+		{Name: "gcp", Check: func(ctx context.Context) error { return nil }},
+	}}
+
+	rec := httptest.NewRecorder()
+	i.readinessHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	response := readiness{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, readiness{Status: "failed", Checks: map[string]string{
+		"informer": "Namespace informer not synced",
+		"gcp":      "ok",
+	}}, response)
+
+	synced = true
+	rec = httptest.NewRecorder()
+	i.readinessHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	response = readiness{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, readiness{Status: "ok", Checks: map[string]string{"informer": "ok", "gcp": "ok"}}, response)
+
+	// Without checks the InjectServer is always ready
+	rec = httptest.NewRecorder()
+	(&InjectServer{logger: newStdLogger()}).readinessHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	statuses, ready := checkReadiness(context.Background(), []HealthCheck{
+		{Name: "hanging", Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	})
+	assert.False(t, ready)
+	assert.NotEqual(t, "ok", statuses["hanging"])
+}
@@ -0,0 +1,91 @@
+package sting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"k8s.io/api/admission/v1beta1"
+)
+
+// FailurePolicy determines the response to admission requests whose handling panicked, like the
+// failurePolicy of webhook configurations
+type FailurePolicy string
+
+const (
+	// FailurePolicyFail denies the object
+	FailurePolicyFail FailurePolicy = "Fail"
+	// FailurePolicyIgnore allows the object unchanged
+	FailurePolicyIgnore FailurePolicy = "Ignore"
+)
+
+// ParseFailurePolicy checks whether the failure policy is supported
+func ParseFailurePolicy(policy string) (FailurePolicy, error) {
+	switch FailurePolicy(policy) {
+	case FailurePolicyFail, FailurePolicyIgnore:
+		return FailurePolicy(policy), nil
+	}
+	return "", fmt.Errorf("Invalid failure policy %q, expected %s or %s", policy, FailurePolicyFail, FailurePolicyIgnore)
+}
+
+// recoverMutate recovers from panics of the mutation and responds according to the failure policy,
+// so a single bad object can't take the webhook down
+func recoverMutate(mutate MutateContextFunc, policy FailurePolicy) MutateContextFunc {
+	return func(ctx context.Context, ar *v1beta1.AdmissionReview) (response *v1beta1.AdmissionResponse) {
+		defer func() {
+			if r := recover(); r != nil {
+				response = recoveredResponse(ctx, r, policy)
+			}
+		}()
+		return mutate(ctx, ar)
+	}
+}
+
+// recoverIsAdmitted recovers from panics of the admission check and responds according to the
+// failure policy
+func recoverIsAdmitted(isAdmitted IsAdmittedContextFunc, policy FailurePolicy) IsAdmittedContextFunc {
+	return func(ctx context.Context, ar *v1beta1.AdmissionReview) (response *v1beta1.AdmissionResponse, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				response, err = recoveredResponse(ctx, r, policy), nil
+			}
+		}()
+		return isAdmitted(ctx, ar)
+	}
+}
+
+// recoveredResponse logs the panic including the stack and returns the response of the failure policy
+func recoveredResponse(ctx context.Context, recovered interface{}, policy FailurePolicy) *v1beta1.AdmissionResponse {
+	err := fmt.Errorf("%v", recovered)
+	LoggerFromContext(ctx).Error("Recovered from panic during admission", err, Fields{
+		"failurePolicy": policy,
+		"stack":         string(debug.Stack()),
+	})
+	if policy == FailurePolicyIgnore {
+		response := &v1beta1.AdmissionResponse{Allowed: true}
+		AddWarning(response, "The admission webhook failed internally, the object is admitted unchanged")
+		return response
+	}
+	return ToAdmissionResponse(fmt.Errorf("The admission webhook failed internally: %s", err))
+}
+
+// recoverPanics recovers from panics outside of the admission decision, e.g. within middlewares,
+// and responds with 500 Internal Server Error
+func recoverPanics(logger Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					logger.Error("Recovered from panic during request", fmt.Errorf("%v", recovered), Fields{
+						"remoteAddr": r.RemoteAddr,
+						"requestUri": r.RequestURI,
+						"stack":      string(debug.Stack()),
+					})
+					http.Error(w, "Internal error", http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package sting
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/api/admission/v1beta1"
+)
+
+func TestParseFailurePolicy(t *testing.T) {
+	for _, policy := range []string{"Fail", "Ignore"} {
+		parsed, err := ParseFailurePolicy(policy)
+		assert.NoError(t, err)
+		assert.EqualValues(t, policy, parsed)
+	}
+	_, err := ParseFailurePolicy("fail")
+	assert.Error(t, err)
+}
+
+func TestRecoverPanics(t *testing.T) {
+	panicking := func(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+		var pod *struct{ Name string }
+		return &v1beta1.AdmissionResponse{Allowed: pod.Name != ""}
+	}
+	for _, data := range []struct {
+		policy   FailurePolicy
+		allowed  bool
+		warnings int
+	}{
+		{policy: "", allowed: false},
+		{policy: FailurePolicyFail, allowed: false},
+		{policy: FailurePolicyIgnore, allowed: true, warnings: 1},
+	} {
+		opts := &Options{
+			Mutate:        panicking,
+			FailurePolicy: data.policy,
+			IsAdmitted: func(ar *v1beta1.AdmissionReview) (*v1beta1.AdmissionResponse, error) {
+				panic("admission check failed")
+			},
+		}
+		mutators, err := opts.mutators()
+		require.NoError(t, err)
+		i := &InjectServer{logger: newStdLogger(), isAdmitted: opts.isAdmitted()}
+		r := i.router(opts, mutators)
+
+		for _, path := range []string{"/api/v1beta/mutate", "/api/v1beta/admit"} {
+			req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(`{"request":{"uid":"uid"}}`)))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code, "%s %+v", path, data)
+			review := v1beta1.AdmissionReview{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &review))
+			require.NotNil(t, review.Response)
+			assert.EqualValues(t, "uid", review.Response.UID)
+			assert.Equal(t, data.allowed, review.Response.Allowed, "%s %+v", path, data)
+			assert.Len(t, responseWarnings(t, rec.Body.Bytes()), data.warnings, "%s %+v", path, data)
+			if !data.allowed {
+				assert.Contains(t, review.Response.Result.Message, "The admission webhook failed internally")
+			}
+		}
+	}
+}
+
+func TestRecoverPanicsOfMiddlewares(t *testing.T) {
+	opts := &Options{
+		Mutate: denyWith("denied"),
+		Middlewares: []Middleware{func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("middleware failed")
+			})
+		}},
+	}
+	mutators, err := opts.mutators()
+	require.NoError(t, err)
+	r := (&InjectServer{logger: newStdLogger()}).router(opts, mutators)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, defaultMutatePath, bytes.NewReader([]byte("{}"))))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
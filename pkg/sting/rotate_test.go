@@ -0,0 +1,42 @@
+package sting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sting")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte("0000\n"), 0640))
+
+	file, err := OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"1111\n", "2222\n", "3333\n", "4444\n"} {
+		_, err := file.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, file.Close())
+
+	for name, content := range map[string]string{
+		"audit.log":   "4444\n",
+		"audit.log.1": "2222\n3333\n",
+		"audit.log.2": "0000\n1111\n",
+	} {
+		actual, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(actual), name)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = file.Write([]byte("5555\n"))
+	assert.Error(t, err)
+}
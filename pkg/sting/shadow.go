@@ -0,0 +1,23 @@
+package sting
+
+import (
+	"context"
+
+	"k8s.io/api/admission/v1beta1"
+)
+
+// enforce returns the response sent for the admission decision. In shadow mode the decision is
+// only logged and counted, while the object is admitted unchanged
+func (i *InjectServer) enforce(ctx context.Context, ar *v1beta1.AdmissionReview, response *v1beta1.AdmissionResponse) *v1beta1.AdmissionResponse {
+	if !i.shadow {
+		return response
+	}
+	record := auditRecord(ctx, ar, response)
+	i.metrics.shadowDecisions.Inc(record.Decision)
+	LoggerFromContext(ctx).Info("Admitting the object unchanged in shadow mode", Fields{
+		"decision": record.Decision,
+		"patch":    record.Patch,
+		"reason":   record.Reason,
+	})
+	return &v1beta1.AdmissionResponse{UID: response.UID, Allowed: true}
+}
@@ -0,0 +1,73 @@
+package sting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+)
+
+func TestShadow(t *testing.T) {
+	buf := &bytes.Buffer{}
+	i, err := NewEmbedded(&Options{
+		Mutators: []Mutator{
+			{Name: "patch", Mutate: func(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+				response := &v1beta1.AdmissionResponse{Allowed: true, Patch: []byte(`[{"op":"add","path":"/spec/containers/-","value":{}}]`)}
+				AddWarning(response, "defaulted")
+				return response
+			}},
+			{Name: "deny", Mutate: denyWith("instance missing")},
+			{Name: "broken", Mutate: func(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+				return nil
+			}},
+		},
+		IsAdmitted: func(ar *v1beta1.AdmissionReview) (*v1beta1.AdmissionResponse, error) {
+			return nil, errors.New("lookup failed")
+		},
+		Auditor:         NewJSONAuditor(buf),
+		RecentDecisions: 10,
+		Shadow:          true,
+	})
+	require.NoError(t, err)
+
+	review := `{"request":{"uid":"uid","kind":{"version":"v1","kind":"Pod"},"namespace":"team-a","name":"app","operation":"CREATE"}}`
+	for _, path := range []string{"/api/v1beta/mutate/patch", "/api/v1beta/mutate/deny", "/api/v1beta/mutate/broken", "/api/v1beta/admit"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(review))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		i.Handler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, path)
+
+		// Every object is admitted unchanged
+		response := v1beta1.AdmissionReview{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response), path)
+		assert.Equal(t, &v1beta1.AdmissionResponse{UID: "uid", Allowed: true}, response.Response, path)
+	}
+
+	// The decisions which would have been made are recorded
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	decisions := []string{}
+	for _, line := range lines {
+		record := AuditRecord{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		assert.True(t, record.Shadow)
+		decisions = append(decisions, record.Decision)
+	}
+	assert.Equal(t, []string{DecisionMutated, DecisionDenied, DecisionDenied, DecisionDenied}, decisions)
+	recent := i.decisions.recent()
+	require.Len(t, recent, 4)
+	assert.True(t, recent[3].Shadow)
+	assert.Equal(t, DecisionMutated, recent[3].Decision)
+	assert.NotZero(t, recent[3].PatchSize)
+
+	assert.EqualValues(t, 1, i.metrics.shadowDecisions.Value(DecisionMutated))
+	assert.EqualValues(t, 3, i.metrics.shadowDecisions.Value(DecisionDenied))
+}
@@ -0,0 +1,173 @@
+package sting
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/howeyc/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve starts a HTTP server with the handler on a random port
+func serve(t *testing.T, handler http.Handler) (*http.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{Addr: listener.Addr().String(), Handler: handler}
+	go server.Serve(listener)
+	return server, "http://" + listener.Addr().String()
+}
+
+func TestClose(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	server, url := serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	adminServer, _ := serve(t, http.NotFoundHandler())
+	dir, err := ioutil.TempDir("", "sting")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tls.crt"), []byte("cert"), 0600))
+	watcher, err := fsnotify.NewWatcher()
+	require.NoError(t, err)
+	require.NoError(t, watcher.Watch(filepath.Join(dir, "tls.crt")))
+	i := &InjectServer{
+		servers:         []*http.Server{server},
+		adminServer:     adminServer,
+		certWatcher:     watcher,
+		logger:          newStdLogger(),
+		shutdownTimeout: time.Second,
+	}
+
+	// Close waits for the request in flight to complete
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp, err := http.Get(url)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	closed := make(chan error)
+	go func() { closed <- i.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the connection drained")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	assert.NoError(t, <-closed)
+	wg.Wait()
+	_, open := <-watcher.Event
+	assert.False(t, open)
+}
+
+func TestCloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	server, url := serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	adminServer, _ := serve(t, http.NotFoundHandler())
+	i := &InjectServer{
+		servers:         []*http.Server{server},
+		adminServer:     adminServer,
+		logger:          newStdLogger(),
+		shutdownTimeout: 50 * time.Millisecond,
+	}
+
+	go http.Get(url)
+	<-started
+	err := i.Close()
+	assert.EqualError(t, err, "Failed to drain connections of server "+server.Addr+": context deadline exceeded")
+}
+
+func TestCloseListenersIndependently(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	stuck, url := serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	idle, idleURL := serve(t, http.NotFoundHandler())
+	i := &InjectServer{
+		servers:         []*http.Server{stuck, idle},
+		logger:          newStdLogger(),
+		shutdownTimeout: 50 * time.Millisecond,
+	}
+
+	go http.Get(url)
+	<-started
+	// Only the listener whose connection didn't drain fails, the others are closed regardless
+	err := i.Close()
+	assert.EqualError(t, err, "Failed to drain connections of server "+stuck.Addr+": context deadline exceeded")
+	_, err = http.Get(idleURL)
+	assert.Error(t, err)
+}
+
+func TestListenAddrs(t *testing.T) {
+	for _, data := range []struct {
+		opts     Options
+		expected []string
+	}{
+		{Options{}, []string{":443"}},
+		{Options{ListenAddr: ":8443"}, []string{":8443"}},
+		{Options{ListenAddr: ":443", ListenAddrs: []string{":443", ":8443"}}, []string{":443", ":8443"}},
+	} {
+		addrs, err := data.opts.listenAddrs()
+		require.NoError(t, err)
+		assert.Equal(t, data.expected, addrs)
+	}
+	_, err := (&Options{ListenAddrs: []string{":8443", ":8443"}}).listenAddrs()
+	assert.Error(t, err)
+}
+
+func TestCloseListener(t *testing.T) {
+	closed, closedURL := serve(t, http.NotFoundHandler())
+	open, openURL := serve(t, http.NotFoundHandler())
+	i := &InjectServer{
+		servers:         []*http.Server{closed, open},
+		logger:          newStdLogger(),
+		shutdownTimeout: 50 * time.Millisecond,
+	}
+
+	require.NoError(t, i.CloseListener(closed.Addr))
+	_, err := http.Get(closedURL)
+	assert.Error(t, err)
+	// The other addresses are still served
+	resp, err := http.Get(openURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []*http.Server{open}, i.servers)
+
+	assert.EqualError(t, i.CloseListener(closed.Addr), "No server is listening on address "+closed.Addr)
+	assert.NoError(t, i.Close())
+	assert.Empty(t, i.servers)
+}
+
+func TestNewFailsToListen(t *testing.T) {
+	used, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer used.Close()
+	source := CertificateSourceFunc(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return nil, nil
+	})
+
+	// Addresses which can't be bound fail New, the ones already bound are released
+	_, err = New(WithMutate(denyWith("denied")), WithCertSource(source), WithListenAddrs("127.0.0.1:0", used.Addr().String()))
+	assert.EqualError(t, err, "Failed to listen on "+used.Addr().String()+": listen tcp "+used.Addr().String()+": bind: address already in use")
+}
@@ -0,0 +1,72 @@
+//go:build go1.21
+
+// Package slogadapter routes the logs of sting into a log/slog logger
+package slogadapter
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+type logger struct {
+	logger *slog.Logger
+}
+
+// New creates a sting.Logger logging to the given slog logger, like slog.Default()
+func New(l *slog.Logger) sting.Logger {
+	return &logger{logger: l}
+}
+
+func (l *logger) Debug(msg string, fields sting.Fields) {
+	l.log(slog.LevelDebug, msg, nil, fields)
+}
+
+func (l *logger) Info(msg string, fields sting.Fields) {
+	l.log(slog.LevelInfo, msg, nil, fields)
+}
+
+func (l *logger) Warn(msg string, fields sting.Fields) {
+	l.log(slog.LevelWarn, msg, nil, fields)
+}
+
+func (l *logger) Error(msg string, err error, fields sting.Fields) {
+	l.log(slog.LevelError, msg, err, fields)
+}
+
+// log converts the fields into attributes sorted by their key, the error is logged as attribute error
+func (l *logger) log(level slog.Level, msg string, err error, fields sting.Fields) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, len(fields)+1)
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	for key, value := range fields {
+		attrs = append(attrs, slog.Any(key, value))
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	l.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+type levelVar struct {
+	level *slog.LevelVar
+}
+
+// NewLevel creates a sting.LevelVar changing the given level, like the one of the slog.HandlerOptions
+// of the handler
+func NewLevel(level *slog.LevelVar) sting.LevelVar {
+	return &levelVar{level: level}
+}
+
+func (l *levelVar) Level() string {
+	return l.level.Level().String()
+}
+
+func (l *levelVar) SetLevel(level string) error {
+	return l.level.UnmarshalText([]byte(level))
+}
@@ -0,0 +1,46 @@
+//go:build go1.21
+
+package slogadapter
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/connctd/sqlbee/pkg/sting"
+)
+
+func TestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := New(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})))
+
+	logger.Debug("Received request body", sting.Fields{"bodyLength": 2})
+	logger.Info("Mutating resource", sting.Fields{"namespace": "default", "name": "app"})
+	logger.Error("Failed to read request", errors.New("Request body is empty"), sting.Fields{"protocol": "HTTP/1.1"})
+
+	assert.Equal(t, `level=INFO msg="Mutating resource" name=app namespace=default
+level=ERROR msg="Failed to read request" error="Request body is empty" protocol=HTTP/1.1
+`, buf.String())
+}
+
+func TestLevel(t *testing.T) {
+	levelVar := &slog.LevelVar{}
+	level := NewLevel(levelVar)
+	assert.Equal(t, "INFO", level.Level())
+
+	assert.NoError(t, level.SetLevel("debug"))
+	assert.Equal(t, slog.LevelDebug, levelVar.Level())
+
+	assert.Error(t, level.SetLevel("verbose"))
+	assert.Equal(t, "DEBUG", level.Level())
+}
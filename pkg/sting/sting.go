@@ -9,9 +9,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -19,14 +22,17 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/howeyc/fsnotify"
 	"github.com/mattbaird/jsonpatch"
-	"github.com/sirupsen/logrus"
 
 	"k8s.io/api/admission/v1beta1"
 	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
 	appsv1 "k8s.io/api/apps/v1"
 	appsv1beta1 "k8s.io/api/apps/v1beta1"
 	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
@@ -34,7 +40,18 @@ import (
 	"k8s.io/kubernetes/pkg/apis/core/v1"
 )
 
-var Version = "unset"
+// Version, Commit and BuildDate identify the build of the application, they are set via -ldflags
+var (
+	Version   = "unset"
+	Commit    = "unset"
+	BuildDate = "unset"
+)
+
+// The admission endpoint is served on the HTTPS port by default
+const defaultListenAddr = ":443"
+
+// How long Close waits for open connections to drain by default
+const defaultShutdownTimeout = 15 * time.Second
 
 var (
 	// WrongResourceError can be used to indicate that this webhook doesn't support this resource-
@@ -63,6 +80,9 @@ func init() {
 	_ = appsv1.AddToScheme(RuntimeScheme)
 	_ = appsv1beta1.AddToScheme(RuntimeScheme)
 	_ = appsv1beta2.AddToScheme(RuntimeScheme)
+	_ = batchv1.AddToScheme(RuntimeScheme)
+	_ = batchv1beta1.AddToScheme(RuntimeScheme)
+	_ = extensionsv1beta1.AddToScheme(RuntimeScheme)
 	_ = admissionregistrationv1beta1.AddToScheme(RuntimeScheme)
 	// defaulting with webhooks:
 	// https://github.com/kubernetes/kubernetes/issues/57982
@@ -82,26 +102,92 @@ type IsAdmittedFunc func(ar *v1beta1.AdmissionReview) (*v1beta1.AdmissionRespons
 // webhook. It provides a HTTPS secured endpoint for admission/mutation and a HTTP endpoint for
 // readiness and liveness checks
 type InjectServer struct {
-	server      *http.Server
-	cert        *tls.Certificate
-	certLock    *sync.Mutex
-	adminServer *http.Server
-
-	mutate      MutateFunc
-	needsMutate NeedsMutationFunc
-	isAdmitted  IsAdmittedFunc
+	servers      []*http.Server
+	serversLock  sync.Mutex
+	cert         *tls.Certificate
+	certLock     *sync.Mutex
+	certSource   CertificateSource
+	reloadCerts  func(ctx context.Context) error
+	adminServer  *http.Server
+	handler      http.Handler
+	adminHandler http.Handler
+
+	isAdmitted     IsAdmittedContextFunc
+	operations     map[v1beta1.Operation]bool
+	auditor        Auditor
+	decisions      *decisionLog
+	shadow         bool
+	defaults       interface{}
+	failurePolicy  FailurePolicy
+	handlerTimeout time.Duration
+	metrics        serverMetrics
+	healthChecks   []HealthCheck
+
+	logger          Logger
+	stopWatch       context.CancelFunc
+	certWatcher     *fsnotify.Watcher
+	shutdownTimeout time.Duration
 }
 
 // Options are used to configure the InjectServer
 type Options struct {
 	// ListenAddr is used for the admission endpoint. Default is :443
 	ListenAddr string
+	// ListenAddrs serve the admission endpoint on several addresses instead of ListenAddr, e.g. :443
+	// and :8443 for containers not running as root. Each address has its own server, which is shut
+	// down independently of the others via CloseListener. New fails if any address can't be bound
+	ListenAddrs []string
 	// The function implementation to be used when running mutations.
 	Mutate MutateFunc
+	// MutateContext is used instead of Mutate if the mutation needs the context of the request
+	MutateContext MutateContextFunc
 	// Optional function to be used to decide whether a mutation is necessary or not
 	NeedsMutate NeedsMutationFunc
+	// MutatePaths are the URL paths Mutate is served on, e.g. to serve existing webhook configurations
+	// or versioned paths alongside. Default is /api/v1beta/mutate
+	MutatePaths []string
+	// AdmitPaths are the URL paths IsAdmitted is served on. Default is /api/v1beta/admit
+	AdmitPaths []string
+	// Mutators are served in addition to Mutate, each on its own path
+	Mutators []Mutator
+	// Operations are processed by the mutators, requests of other operations and of subresources are
+	// allowed unchanged without invoking them, e.g. if a webhook rule is broader than intended. The
+	// admission check processes every request. Default is CREATE
+	Operations []v1beta1.Operation
+	// Middlewares wrap the handling of admission requests in order, the first one is the outermost
+	Middlewares []Middleware
+	// FailurePolicy determines whether objects are allowed or denied if their mutation or admission
+	// check panics. Default is Fail
+	FailurePolicy FailurePolicy
+	// HandlerTimeout bounds the admission decision. Once it is exceeded, the context of the decision is
+	// canceled and the response of the FailurePolicy is returned. If the API server passes a timeout
+	// leaving less time, 90% of that is used instead. Default is 9s, below the default timeoutSeconds
+	// of webhook configurations
+	HandlerTimeout time.Duration
+	// Auditor records every admission decision if set
+	Auditor Auditor
+	// Shadow computes and records the admission decisions, but admits every object unchanged, e.g. to
+	// validate the mutation against production traffic before enforcing it
+	Shadow bool
+	// RecentDecisions is the number of the last admission decisions kept in memory and returned by the
+	// endpoint /decisions of the admin server. None are kept if 0
+	RecentDecisions int
+	// Defaults are the configured defaults of the application, returned as JSON by the health endpoint
+	// so fleet tooling can verify what is running
+	Defaults interface{}
+	// HealthChecks are aggregated by the readiness endpoint, which fails if any of them fails
+	HealthChecks []HealthCheck
 	// IsAdmitted can be set to enable admission checks
 	IsAdmitted IsAdmittedFunc
+	// IsAdmittedContext is used instead of IsAdmitted if the check needs the context of the request
+	IsAdmittedContext IsAdmittedContextFunc
+	// Logger receives the logs of the InjectServer. Defaults to a logger writing to stderr
+	Logger Logger
+	// LogLevel is returned and changed at runtime by the endpoint /loglevel of the admin server, if set
+	LogLevel LevelVar
+	// AdminToken is required as bearer token by the admin endpoints changing the application, like
+	// PUT /loglevel or POST /reload-certs. These are disabled if it is empty
+	AdminToken string
 
 	// These are parameters for the HTTP(S) server, they are optional and default to sane values
 	ReadTimeout       time.Duration
@@ -109,36 +195,101 @@ type Options struct {
 	ReadHeaderTimeout time.Duration
 	WriteTimeout      time.Duration
 
+	// MaxInFlight limits the number of admission requests handled concurrently, unlimited if 0. Up
+	// to MaxQueued requests exceeding the limit wait at most QueueTimeout for a free slot, others are
+	// rejected with 429 if the queue is full or 503 once they timed out
+	MaxInFlight  int
+	MaxQueued    int
+	QueueTimeout time.Duration
+	// ShutdownTimeout is how long Close waits for the open connections to drain. Default is 15s
+	ShutdownTimeout time.Duration
+
+	// TLSConfig is the base of the TLS configuration of the admission endpoint, e.g. to restrict the
+	// versions or cipher suites. The certificates are set by the InjectServer
+	TLSConfig *tls.Config
+	// NextProtos are the ALPN protocols offered by the admission endpoint, overriding the ones of
+	// TLSConfig. Default is h2 and http/1.1, HTTP/2 is disabled if h2 is missing
+	NextProtos []string
+
 	// Path to the server X.509 certificate
 	CertFile string
 	// Path to the server private key
 	KeyFile string
+	// Secret containing the server certificate and private key as tls.crt and tls.key, like the
+	// secrets of cert-manager. If set, the secret is watched for updates via Secrets instead of
+	// loading the files
+	CertSecret *corev1.SecretReference
+	// Secrets retrieves and watches the CertSecret
+	Secrets SecretWatcher
+	// CertSource provides the serving certificate instead of the files or the CertSecret, e.g. to
+	// retrieve it from SPIRE, Vault or an in-memory rotation library
+	CertSource CertificateSource
 	// Unused so far. Will be required for support of TLS authenticated clients
 	CaFile string
+}
+
+// isAdmitted returns the admission check recovering from panics, preferring IsAdmittedContext
+func (opts *Options) isAdmitted() IsAdmittedContextFunc {
+	isAdmitted := opts.IsAdmittedContext
+	if isAdmitted == nil && opts.IsAdmitted != nil {
+		isAdmitted = IsAdmittedWithContext(opts.IsAdmitted)
+	}
+	if isAdmitted == nil {
+		return nil
+	}
+	return recoverIsAdmitted(isAdmitted, opts.failurePolicy())
+}
 
-	// The amount of CPU to be requested
-	cpuRequest string
-	// The amount of memory to be requested
-	memRequest string
+// listenAddrs returns the addresses the admission endpoint is served on, which need to be unique
+func (opts *Options) listenAddrs() ([]string, error) {
+	addrs := append([]string{}, opts.ListenAddrs...)
+	if len(addrs) == 0 {
+		addrs = []string{opts.ListenAddr}
+	}
+	unique := map[string]bool{}
+	for i, addr := range addrs {
+		if addr == "" {
+			addr = defaultListenAddr
+			addrs[i] = addr
+		}
+		if unique[addr] {
+			return nil, fmt.Errorf("Listen address %s is configured more than once", addr)
+		}
+		unique[addr] = true
+	}
+	return addrs, nil
+}
+
+// failurePolicy returns the configured FailurePolicy, defaulting to Fail
+func (opts *Options) failurePolicy() FailurePolicy {
+	if opts.FailurePolicy == "" {
+		return FailurePolicyFail
+	}
+	return opts.FailurePolicy
 }
 
 // Main is a simple helper method which takes an io.Closer and blocks until either
 // SIGTERM oder SIGINT are received and the calls Close() in the io.Closer() and exits with
-// (0)
+// (0), or (1) if closing failed
 func Main(closeable io.Closer) {
-	var gracefulStop = make(chan os.Signal)
+	var gracefulStop = make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGTERM)
 	signal.Notify(gracefulStop, syscall.SIGINT)
 
 	<-gracefulStop
+	logger := newStdLogger()
+	if server, ok := closeable.(*InjectServer); ok {
+		logger = server.logger
+	}
 	if err := closeable.Close(); err != nil {
-		logrus.WithError(err).Error("Failed to shutdown closeable")
+		logger.Error("Failed to shutdown closeable", err, nil)
+		os.Exit(1)
 	}
 	os.Exit(0)
 }
 
 // NewOptions creates a new instance of an Options struct with sane values set. Only
-// Mutate, NeedsMutate or IsAdmitted need to set now.
+// Mutate, NeedsMutate, Mutators or IsAdmitted need to set now.
 func NewOptions() *Options {
 	return &Options{
 		ListenAddr:        ":443",
@@ -146,238 +297,422 @@ func NewOptions() *Options {
 		IdleTimeout:       time.Second * 10,
 		ReadHeaderTimeout: time.Second * 2,
 		WriteTimeout:      time.Second * 10,
+		QueueTimeout:      time.Second * 2,
+		ShutdownTimeout:   time.Second * 15,
+		HandlerTimeout:    defaultHandlerTimeout,
 	}
 }
 
 // New creates and starts a new InjectServer. InjectServer implements io.Closer
-// so it can be used together with the helper function Main
-func New(opts *Options) (*InjectServer, error) {
-	i := &InjectServer{
-		mutate:      opts.Mutate,
-		needsMutate: opts.NeedsMutate,
-		isAdmitted:  opts.IsAdmitted,
-		certLock:    &sync.Mutex{},
+// so it can be used together with the helper function Main. The options are validated before
+// anything is started, e.g. New(WithMutate(mutate), WithTLSFiles(certFile, keyFile)) or New(opts)
+func New(options ...Option) (*InjectServer, error) {
+	opts := buildOptions(options)
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
-
-	pair, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	listenAddrs, err := opts.listenAddrs()
 	if err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"certPath": opts.CertFile,
-			"keyPath":  opts.KeyFile,
-		}).Error("Failed to load TLS X.509 keypair")
 		return nil, err
 	}
-	i.cert = &pair
-
-	r := mux.NewRouter()
-	r.Use(validateContentType("application/json"))
-
-	if opts.Mutate != nil {
-		logrus.WithField("urlPath", "/api/v1beta/mutate").Info("Adding mutating admission endpoint")
-		r.Path("/api/v1beta/mutate").Methods(http.MethodPost).HandlerFunc(i.handleMutate)
+	i, err := newInjectServer(opts)
+	if err != nil {
+		return nil, err
 	}
 
-	if opts.IsAdmitted != nil {
-		logrus.WithField("urlPath", "/api/v1beta/admit").Info("Adding non mutating admission endpoint")
-		r.Path("/api/v1beta/admit").Methods(http.MethodPost).HandlerFunc(i.handleAdmission)
+	if opts.CertSource != nil {
+		i.certSource = opts.CertSource
+	} else if opts.CertSecret != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		resourceVersion, err := i.loadCertSecret(ctx, opts.Secrets, opts.CertSecret)
+		if err != nil {
+			cancel()
+			i.logger.Error("Failed to load TLS X.509 keypair from secret", err, Fields{
+				"namespace": opts.CertSecret.Namespace,
+				"secret":    opts.CertSecret.Name,
+			})
+			return nil, err
+		}
+		i.stopWatch = cancel
+		go i.watchCertSecret(ctx, opts.Secrets, opts.CertSecret, resourceVersion)
+		i.reloadCerts = func(ctx context.Context) error {
+			_, err := i.loadCertSecret(ctx, opts.Secrets, opts.CertSecret)
+			return err
+		}
+	} else {
+		pair, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			i.logger.Error("Failed to load TLS X.509 keypair", err, Fields{
+				"certPath": opts.CertFile,
+				"keyPath":  opts.KeyFile,
+			})
+			return nil, err
+		}
+		i.cert = &pair
+		if err := i.watchCertFile(opts); err != nil {
+			return nil, err
+		}
+		i.reloadCerts = func(ctx context.Context) error {
+			return i.reloadCertFiles(opts)
+		}
 	}
 
-	ar := mux.NewRouter()
-	ar.Path("/health").Methods(http.MethodGet).HandlerFunc(i.healtHandler)
-
-	i.server = &http.Server{
-		Addr:              opts.ListenAddr,
-		Handler:           r,
-		ReadTimeout:       opts.ReadTimeout,
-		IdleTimeout:       opts.IdleTimeout,
-		ReadHeaderTimeout: opts.ReadHeaderTimeout,
-		WriteTimeout:      opts.WriteTimeout,
-		TLSConfig: &tls.Config{
-			GetCertificate: i.getCert,
-		},
-		// TODO k8s compatible TLS config
+	for _, addr := range listenAddrs {
+		server := &http.Server{
+			Addr:              addr,
+			Handler:           i.handler,
+			ReadTimeout:       opts.ReadTimeout,
+			IdleTimeout:       opts.IdleTimeout,
+			ReadHeaderTimeout: opts.ReadHeaderTimeout,
+			WriteTimeout:      opts.WriteTimeout,
+		}
+		i.configureTLS(server, opts)
+		i.servers = append(i.servers, server)
 	}
 
 	i.adminServer = &http.Server{
 		Addr:              ":8080",
-		Handler:           ar,
+		Handler:           i.adminHandler,
 		ReadTimeout:       opts.ReadTimeout,
 		IdleTimeout:       opts.IdleTimeout,
 		ReadHeaderTimeout: opts.ReadHeaderTimeout,
 		WriteTimeout:      opts.WriteTimeout,
 	}
 
-	certWatcher, err := fsnotify.NewWatcher()
-	if err := certWatcher.Watch(opts.CertFile); err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"certPath": opts.CertFile,
-		}).Error("Failed to creat file watcher for certificate")
-		return nil, err
-	}
-
-	go func(watcher *fsnotify.Watcher, opts *Options) {
-		for {
-			select {
-			case ev := <-watcher.Event:
-				if ev.IsModify() || ev.IsCreate() {
-					logrus.WithFields(logrus.Fields{
-						"certPath": opts.CertFile,
-						"keyPath":  opts.KeyFile,
-					}).Info("Certificate has been updated reloading keypair")
-					pair, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
-					if err == nil {
-						i.certLock.Lock()
-						i.cert = &pair
-						i.certLock.Unlock()
-					} else {
-						logrus.WithError(err).WithFields(logrus.Fields{
-							"certPath": opts.CertFile,
-							"keyPath":  opts.KeyFile,
-						}).Panic("Failed to reload keypair!")
-					}
-				}
+	// All addresses are bound before serving, so an address which can't be bound fails New instead of
+	// leaving the InjectServer partially reachable
+	listeners := make([]net.Listener, 0, len(i.servers))
+	for _, server := range i.servers {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
 			}
+			i.stopWatching()
+			return nil, fmt.Errorf("Failed to listen on %s: %s", server.Addr, err)
 		}
-	}(certWatcher, opts)
+		listeners = append(listeners, listener)
+	}
 
-	go func() {
-		logrus.WithFields(logrus.Fields{
-			"listenAddr": opts.ListenAddr,
-		}).Info("HTTPS server listening")
-		if err := i.server.ListenAndServeTLS("", ""); err != nil {
-			logrus.WithError(err).Error("Failed to listen as TLS server")
-		}
-	}()
+	// A server failing doesn't affect the others
+	for n, server := range i.servers {
+		go func(server *http.Server, listener net.Listener) {
+			i.logger.Info("HTTPS server listening", Fields{
+				"listenAddr": server.Addr,
+			})
+			if err := server.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+				i.logger.Error("Failed to serve as TLS server", err, Fields{"listenAddr": server.Addr})
+			}
+		}(server, listeners[n])
+	}
 
 	go func() {
-		logrus.WithFields(logrus.Fields{
+		i.logger.Info("Liveness and readiness HTTP server listening", Fields{
 			"listenAddr": i.adminServer.Addr,
-		}).Info("Liveness and readiness HTTP server listening")
-		if err := i.adminServer.ListenAndServe(); err != nil {
-			logrus.WithError(err).Error("Liveness and readiness HTTP server failed to listen")
+		})
+		if err := i.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			i.logger.Error("Liveness and readiness HTTP server failed to listen", err, nil)
 		}
 	}()
 
 	return i, nil
 }
 
-// Close is necessary to implement io.Closer interface
+// NewEmbedded creates an InjectServer to be embedded into an HTTPS server the application already
+// runs. Neither listeners are started nor certificates loaded, the application mounts Handler into
+// its own router and TLS stack and optionally AdminHandler for the health, readiness and metrics
+// endpoints
+func NewEmbedded(options ...Option) (*InjectServer, error) {
+	opts := buildOptions(options)
+	if err := opts.validate(false); err != nil {
+		return nil, err
+	}
+	return newInjectServer(opts)
+}
+
+// newInjectServer creates the InjectServer including the handlers of its endpoints from the
+// validated options
+func newInjectServer(opts *Options) (*InjectServer, error) {
+	mutators, err := opts.mutators()
+	if err != nil {
+		return nil, err
+	}
+	i := &InjectServer{
+		isAdmitted:      opts.isAdmitted(),
+		operations:      opts.operations(),
+		auditor:         opts.Auditor,
+		decisions:       newDecisionLog(opts.RecentDecisions),
+		shadow:          opts.Shadow,
+		defaults:        opts.Defaults,
+		failurePolicy:   opts.failurePolicy(),
+		handlerTimeout:  opts.HandlerTimeout,
+		healthChecks:    opts.HealthChecks,
+		certLock:        &sync.Mutex{},
+		logger:          opts.Logger,
+		shutdownTimeout: opts.ShutdownTimeout,
+	}
+	if i.logger == nil {
+		i.logger = newStdLogger()
+	}
+	if i.shutdownTimeout == 0 {
+		i.shutdownTimeout = defaultShutdownTimeout
+	}
+	if i.handlerTimeout == 0 {
+		i.handlerTimeout = defaultHandlerTimeout
+	}
+	i.metrics = newServerMetrics(i.certificateExpiry)
+
+	i.handler = i.router(opts, mutators)
+
+	ar := mux.NewRouter()
+	ar.Path("/health").Methods(http.MethodGet).HandlerFunc(i.healthHandler)
+	ar.Path("/ready").Methods(http.MethodGet).HandlerFunc(i.readinessHandler)
+	ar.Path("/metrics").Methods(http.MethodGet).Handler(&i.metrics.registry)
+	ar.Path("/reload-certs").Methods(http.MethodPost).HandlerFunc(i.reloadCertsHandler(opts.AdminToken))
+	if i.decisions != nil {
+		ar.Path("/decisions").Methods(http.MethodGet).HandlerFunc(i.decisionsHandler)
+	}
+	if opts.LogLevel != nil {
+		ar.Path("/loglevel").Methods(http.MethodGet, http.MethodPut).HandlerFunc(i.logLevelHandler(opts.LogLevel, opts.AdminToken))
+	}
+	i.adminHandler = ar
+	return i, nil
+}
+
+// Handler returns the handler of the admission endpoints, which expect to be served via HTTPS
+func (i *InjectServer) Handler() http.Handler {
+	return i.handler
+}
+
+// AdminHandler returns the handler of the health, readiness, metrics, recent decisions and log level
+// endpoints
+func (i *InjectServer) AdminHandler() http.Handler {
+	return i.adminHandler
+}
+
+// Close is necessary to implement io.Closer interface. It stops accepting new connections and waits
+// up to the shutdown timeout for the open ones to drain, before closing them. The errors occurred while
+// shutting down are returned together. Servers embedding the InjectServer are shut down by the
+// application
 func (i *InjectServer) Close() error {
-	logrus.WithFields(logrus.Fields{
-		"timeOut":    "15s",
-		"listenAddr": i.server.Addr,
-	}).Info("Shutting down HTTPS server")
-	shutdownCtx, _ := context.WithTimeout(context.Background(), 15*time.Second)
-	go i.server.Shutdown(shutdownCtx)
-	go i.adminServer.Shutdown(shutdownCtx)
-	<-shutdownCtx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), i.shutdownTimeout)
+	defer cancel()
+
+	// Embedded InjectServers have no servers of their own
+	servers := []*http.Server{}
+	i.serversLock.Lock()
+	listenServers := i.servers
+	i.servers = nil
+	i.serversLock.Unlock()
+	for _, server := range listenServers {
+		i.logger.Info("Shutting down HTTPS server", Fields{
+			"timeOut":    i.shutdownTimeout.String(),
+			"listenAddr": server.Addr,
+		})
+		servers = append(servers, server)
+	}
+	if i.adminServer != nil {
+		servers = append(servers, i.adminServer)
+	}
+	results := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			results <- shutdownServer(shutdownCtx, server)
+		}(server)
+	}
+
+	errs := shutdownErrors{}
+	for range servers {
+		if err := <-results; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := i.stopWatching(); err != nil {
+		errs = append(errs, err)
+	}
+	// Auditors delivering the records in the background, like the HTTPAuditor, flush them
+	if closer, ok := i.auditor.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("Failed to close auditor: %s", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
 
-func (i *InjectServer) getCert(*tls.ClientHelloInfo) (*tls.Certificate, error) {
-	i.certLock.Lock()
-	defer i.certLock.Unlock()
-	return i.cert, nil
+// CloseListener shuts down the server of the admission endpoint listening on the address as configured,
+// like Close but leaving the other addresses served, e.g. to stop serving a port which isn't used
+// anymore
+func (i *InjectServer) CloseListener(addr string) error {
+	i.serversLock.Lock()
+	var server *http.Server
+	for n, s := range i.servers {
+		if s.Addr == addr {
+			server = s
+			i.servers = append(i.servers[:n:n], i.servers[n+1:]...)
+			break
+		}
+	}
+	i.serversLock.Unlock()
+	if server == nil {
+		return fmt.Errorf("No server is listening on address %s", addr)
+	}
+
+	i.logger.Info("Shutting down HTTPS server", Fields{
+		"timeOut":    i.shutdownTimeout.String(),
+		"listenAddr": server.Addr,
+	})
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), i.shutdownTimeout)
+	defer cancel()
+	return shutdownServer(shutdownCtx, server)
+}
+
+// shutdownServer waits until the connections of the server drained or the context is done. Connections
+// which didn't drain in time are closed forcefully
+func shutdownServer(ctx context.Context, server *http.Server) error {
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("Failed to drain connections of server %s: %s", server.Addr, err)
+	}
+	return nil
 }
 
-func (i *InjectServer) healtHandler(w http.ResponseWriter, r *http.Request) {
+// stopWatching stops watching the certificate files or secret
+func (i *InjectServer) stopWatching() error {
+	if i.stopWatch != nil {
+		i.stopWatch()
+	}
+	if i.certWatcher != nil {
+		if err := i.certWatcher.Close(); err != nil {
+			return fmt.Errorf("Failed to stop certificate watcher: %s", err)
+		}
+	}
+	return nil
+}
+
+// shutdownErrors are the errors occurred while shutting down the InjectServer
+type shutdownErrors []error
 
+func (e shutdownErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
 }
 
-func readRequest(w http.ResponseWriter, r *http.Request) (*v1beta1.AdmissionReview, error) {
+func (i *InjectServer) getCert(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if i.certSource != nil {
+		return i.certSource.GetCertificate(hello)
+	}
+	i.certLock.Lock()
+	defer i.certLock.Unlock()
+	return i.cert, nil
+}
+
+func readRequest(logger Logger, w http.ResponseWriter, r *http.Request) (*v1beta1.AdmissionReview, error) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
+		logger.Error("Failed to read request body from client", err, Fields{
 			"remoteAddr": r.RemoteAddr,
 			"requestUri": r.RequestURI,
 			"protocol":   r.Proto,
-		}).Error("Failed to read request body from client")
+		})
 		err := fmt.Errorf("Failed to read request body")
-		errorResponse(err, http.StatusBadRequest, nil, w)
+		errorResponse(logger, err, http.StatusBadRequest, nil, w)
 		return nil, fmt.Errorf("Failed to read request body")
 	}
 
 	if len(body) == 0 {
 		err := fmt.Errorf("Request body is empty")
-		logrus.WithError(err).WithFields(logrus.Fields{
+		logger.Error("Failed to read request body from client", err, Fields{
 			"remoteAddr": r.RemoteAddr,
 			"requestUri": r.RequestURI,
 			"protocol":   r.Proto,
-		}).Error("Failed to read request body from client")
-		errorResponse(err, http.StatusBadRequest, nil, w)
+		})
+		errorResponse(logger, err, http.StatusBadRequest, nil, w)
 		return nil, err
 	}
 
-	logrus.WithFields(logrus.Fields{
+	logger.Debug("Received request body", Fields{
 		"remoteAddr": r.RemoteAddr,
 		"requestUri": r.RequestURI,
 		"protocol":   r.Proto,
 		"body":       string(body),
 		"bodyLength": len(body),
-	}).Debug("Received request body")
+	})
 
 	ar := v1beta1.AdmissionReview{}
 	if err := json.Unmarshal(body, &ar); err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
+		logger.Error("Failed to unmarshal request body", err, Fields{
 			"remoteAddr": r.RemoteAddr,
 			"requestUri": r.RequestURI,
 			"protocol":   r.Proto,
 			"body":       string(body),
-		}).Error("Failed to unmarshal request body")
-		errorResponse(err, http.StatusBadRequest, &ar, w)
+		})
+		errorResponse(logger, err, http.StatusBadRequest, &ar, w)
 		return nil, err
 	}
 	return &ar, nil
 }
 
-func (i *InjectServer) handleMutate(w http.ResponseWriter, r *http.Request) {
-	if i.mutate == nil {
-		http.Error(w, "Internal error", http.StatusInternalServerError)
-		logrus.Panic("Mutate function not set and handleMuate called, this shouldn't happen!")
-		return
+// mutateHandler returns the handler of the endpoint of the mutator
+func (i *InjectServer) mutateHandler(mutator Mutator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		i.handleMutate(mutator, w, r)
 	}
+}
 
-	ar, err := readRequest(w, r)
+func (i *InjectServer) handleMutate(mutator Mutator, w http.ResponseWriter, r *http.Request) {
+	ar, err := readRequest(i.logger, w, r)
 	if err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
+		i.logger.Error("Failed to read request", err, Fields{
 			"remoteAddr": r.RemoteAddr,
 			"requestUri": r.RequestURI,
 			"protocol":   r.Proto,
-		}).Error("Failed to read request")
+		})
 		return
 	}
+	ctx, cancel := requestContext(r, ar, mutator.Name, i.handlerTimeout, i.logger)
+	defer cancel()
+	logger := LoggerFromContext(ctx)
+	setRequestID(ctx, w)
 
 	var admissionResponse *v1beta1.AdmissionResponse
-	response := v1beta1.AdmissionReview{}
-
-	if i.needsMutate != nil && !i.needsMutate(ar) {
-		logrus.WithFields(logrus.Fields{
-			"name":         ar.Request.Name,
-			"namespace":    ar.Request.Namespace,
-			"groupVersion": ar.Request.Resource.String(),
-			"requestUID":   ar.Request.UID,
-		}).Info("This resource doesn't need mutation, allowing the request")
+	response := newResponseReview(ar)
+
+	if reason := i.skipReason(ar); reason != "" {
+		logger.Debug("Operation isn't processed, allowing the request", Fields{
+			"mutator":     mutator.Name,
+			"operation":   ar.Request.Operation,
+			"subResource": ar.Request.SubResource,
+		})
+		i.metrics.skippedRequests.Inc(reason)
+		admissionResponse = &v1beta1.AdmissionResponse{Allowed: true}
+		admissionResponse.Result = &metav1.Status{Message: "This operation is not processed"}
+	} else if mutator.NeedsMutate != nil && !mutator.NeedsMutate(ar) {
+		logger.Info("This resource doesn't need mutation, allowing the request", Fields{"mutator": mutator.Name})
 		admissionResponse = &v1beta1.AdmissionResponse{}
 		admissionResponse.Allowed = true
 		admissionResponse.Result = &metav1.Status{Message: "This resource does not need mutation"}
 		response.Response = admissionResponse
 	} else {
-		logrus.WithFields(logrus.Fields{
-			"name":         ar.Request.Name,
-			"namespace":    ar.Request.Namespace,
-			"groupVersion": ar.Request.Resource.String(),
-			"requestUID":   ar.Request.UID,
-		}).Info("Mutating resource")
-		admissionResponse = i.mutate(ar)
+		logger.Info("Mutating resource", Fields{"mutator": mutator.Name})
+		admissionResponse, _ = i.withinDeadline(ctx, func() (*v1beta1.AdmissionResponse, error) {
+			defer i.metrics.mutateDuration.ObserveSince(mutator.Name, time.Now())
+			return mutator.MutateContext(ctx, ar), nil
+		})
 		if admissionResponse == nil {
-			logrus.WithFields(logrus.Fields{
-				"name":         ar.Request.Name,
-				"namespace":    ar.Request.Namespace,
-				"groupVersion": ar.Request.Resource.String(),
-				"requestUID":   ar.Request.UID,
-			}).Error("Admission response was nil, some error occured")
-			errorResponse(fmt.Errorf("Failed to generate admission response"), http.StatusInternalServerError, ar, w)
-			return
+			logger.Error("Admission response was nil, some error occured", nil, Fields{"mutator": mutator.Name})
+			err := fmt.Errorf("Failed to generate admission response")
+			if !i.shadow {
+				i.audit(ctx, ar, ToAdmissionResponse(err))
+				errorResponse(logger, err, http.StatusInternalServerError, ar, w)
+				return
+			}
+			admissionResponse = ToAdmissionResponse(err)
 		}
 	}
 
@@ -385,65 +720,66 @@ func (i *InjectServer) handleMutate(w http.ResponseWriter, r *http.Request) {
 	if ar.Request != nil && response.Response != nil {
 		response.Response.UID = ar.Request.UID
 	}
+	i.audit(ctx, ar, admissionResponse)
+	response.Response = i.enforce(ctx, ar, admissionResponse)
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"name":         ar.Request.Name,
-			"namespace":    ar.Request.Namespace,
-			"groupVersion": ar.Request.Resource.String(),
-			"requestUID":   ar.Request.UID,
-		}).Error("Failed to serialize admission response to JSON")
+	if err := encodeReview(w, response); err != nil {
+		logger.Error("Failed to serialize admission response to JSON", err, Fields{"mutator": mutator.Name})
 	}
 }
 
 func (i *InjectServer) handleAdmission(w http.ResponseWriter, r *http.Request) {
 	if i.isAdmitted == nil {
-		logrus.Panic("isAdmitted function not set and handleAdmission called, this shouldn't happen!")
+		i.logger.Error("isAdmitted function not set and handleAdmission called, this shouldn't happen!", nil, nil)
 		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
 	}
 
-	ar, err := readRequest(w, r)
+	ar, err := readRequest(i.logger, w, r)
 	if err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
+		i.logger.Error("Failed to read request", err, Fields{
 			"remoteAddr": r.RemoteAddr,
 			"requestUri": r.RequestURI,
 			"protocol":   r.Proto,
-		}).Error("Failed to read request")
+		})
 		return
 	}
 
-	admissionResponse, err := i.isAdmitted(ar)
+	ctx, cancel := requestContext(r, ar, admitEndpoint, i.handlerTimeout, i.logger)
+	defer cancel()
+	logger := LoggerFromContext(ctx)
+	setRequestID(ctx, w)
+	admissionResponse, err := i.withinDeadline(ctx, func() (*v1beta1.AdmissionResponse, error) {
+		return i.isAdmitted(ctx, ar)
+	})
 	if err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"remoteAddr":   r.RemoteAddr,
-			"requestUri":   r.RequestURI,
-			"protocol":     r.Proto,
-			"name":         ar.Request.Name,
-			"namespace":    ar.Request.Namespace,
-			"groupVersion": ar.Request.Resource.String(),
-			"requestUID":   ar.Request.UID,
-		}).Error("An error occured during admission decision")
-		errorResponse(err, http.StatusNotAcceptable, ar, w)
-		return
+		logger.Error("An error occured during admission decision", err, Fields{
+			"remoteAddr": r.RemoteAddr,
+			"requestUri": r.RequestURI,
+			"protocol":   r.Proto,
+		})
+		if !i.shadow {
+			i.audit(ctx, ar, ToAdmissionResponse(err))
+			errorResponse(logger, err, http.StatusNotAcceptable, ar, w)
+			return
+		}
+		admissionResponse = ToAdmissionResponse(err)
 	}
-	response := v1beta1.AdmissionReview{}
+	response := newResponseReview(ar)
 	response.Response = admissionResponse
 	if ar.Request != nil {
 		response.Response.UID = ar.Request.UID
 	}
+	i.audit(ctx, ar, admissionResponse)
+	response.Response = i.enforce(ctx, ar, admissionResponse)
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"requestUID":   ar.Request.UID,
-			"name":         ar.Request.Name,
-			"namespace":    ar.Request.Namespace,
-			"groupVersion": ar.Request.Resource.String(),
-		}).Error("Failed to serialize admission response to JSON")
+	if err := encodeReview(w, response); err != nil {
+		logger.Error("Failed to serialize admission response to JSON", err, nil)
 	}
 
 }
 
-func validateContentType(allowedTypes ...string) mux.MiddlewareFunc {
+func validateContentType(logger Logger, allowedTypes ...string) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		allowed := make(map[string]bool)
 		for _, t := range allowedTypes {
@@ -452,12 +788,12 @@ func validateContentType(allowedTypes ...string) mux.MiddlewareFunc {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			contentType := r.Header.Get("Content-Type")
 			if !allowed[contentType] {
-				logrus.WithFields(logrus.Fields{
+				logger.Error("Invalid content type received from client", nil, Fields{
 					"contentType": contentType,
 					"remoteAddr":  r.RemoteAddr,
 					"requestUri":  r.RequestURI,
 					"protocol":    r.Proto,
-				}).Error("Invalid content type received from client")
+				})
 				http.Error(w, "invalid Content-Type, want `application/json`", http.StatusUnsupportedMediaType)
 			} else {
 				next.ServeHTTP(w, r)
@@ -466,8 +802,20 @@ func validateContentType(allowedTypes ...string) mux.MiddlewareFunc {
 	}
 }
 
-func errorResponse(err error, status int, ar *v1beta1.AdmissionReview, w http.ResponseWriter) {
-	response := v1beta1.AdmissionReview{}
+// newResponseReview returns the review carrying the response to the review of the request. It echoes
+// the API version of the request, as API servers sending admission.k8s.io/v1 reviews require the
+// response in the same version
+func newResponseReview(ar *v1beta1.AdmissionReview) v1beta1.AdmissionReview {
+	review := v1beta1.AdmissionReview{}
+	if ar != nil && ar.APIVersion != "" {
+		review.APIVersion = ar.APIVersion
+		review.Kind = "AdmissionReview"
+	}
+	return review
+}
+
+func errorResponse(logger Logger, err error, status int, ar *v1beta1.AdmissionReview, w http.ResponseWriter) {
+	response := newResponseReview(ar)
 	response.Response = ToAdmissionResponse(err)
 	if ar != nil && ar.Request != nil {
 		response.Response.UID = ar.Request.UID
@@ -476,26 +824,30 @@ func errorResponse(err error, status int, ar *v1beta1.AdmissionReview, w http.Re
 	w.WriteHeader(status)
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		logrus.WithError(err).Error("Failed to marshal error response")
+		logger.Error("Failed to marshal error response", err, nil)
 	} else {
 		w.Write([]byte(fmt.Sprintf("[ERROR]: %s", err)))
 	}
 }
 
-// TODO support more types
+// getAnnotations returns the annotations of every object providing object metadata, including
+// unstructured objects. Objects without metadata have no annotations
 func getAnnotations(obj runtime.Object) map[string]string {
-	var annotations map[string]string
-	switch v := obj.(type) {
-	case *corev1.Pod:
-		annotations = v.Annotations
-	case *appsv1.Deployment:
-		annotations = v.Annotations
-	case *appsv1.DaemonSet:
-		annotations = v.Annotations
-	default:
-		annotations = map[string]string{}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return map[string]string{}
 	}
-	return annotations
+	return accessor.GetAnnotations()
+}
+
+// Labels returns the labels of every object providing object metadata, including unstructured
+// objects. Objects without metadata have no labels
+func Labels(obj runtime.Object) map[string]string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return map[string]string{}
+	}
+	return accessor.GetLabels()
 }
 
 // AnnotationHasValue checks whether an API object has annotations and these annotations
@@ -520,9 +872,97 @@ func AnnotationValue(obj runtime.Object, key string, def ...string) string {
 	}
 }
 
+// LabelValue retrieves the value of a label specified by key. In case the label is not found a
+// default value can be specified as the last parameter
+func LabelValue(obj runtime.Object, key string, def ...string) string {
+	if val, exists := Labels(obj)[key]; exists || len(def) == 0 {
+		return val
+	}
+	return def[0]
+}
+
+// AnnotationsWithPrefix retrieves all annotations of an API object whose key starts with prefix. The
+// keys of the returned map are stripped of the prefix
+func AnnotationsWithPrefix(obj runtime.Object, prefix string) map[string]string {
+	annotations := map[string]string{}
+	for key, val := range getAnnotations(obj) {
+		if strings.HasPrefix(key, prefix) {
+			annotations[strings.TrimPrefix(key, prefix)] = val
+		}
+	}
+	return annotations
+}
+
+// Prefix of the audit annotations carrying the warnings of a response, see AddWarning
+const warningAuditPrefix = "warning-"
+
+// AddWarning adds a warning to the admission response, which the API server returns to the client,
+// e.g. shown by kubectl. Our API types don't know warnings, so they are carried as audit annotations
+// until the response is serialized, which moves them out of the audit annotations into the warnings
+func AddWarning(resp *v1beta1.AdmissionResponse, warning string) {
+	if resp.AuditAnnotations == nil {
+		resp.AuditAnnotations = map[string]string{}
+	}
+	resp.AuditAnnotations[warningAuditPrefix+strconv.Itoa(len(Warnings(resp))+1)] = warning
+}
+
+// Warnings returns the warnings added to the admission response in order
+func Warnings(resp *v1beta1.AdmissionResponse) []string {
+	warnings := []string{}
+	for i := 1; ; i++ {
+		warning, exists := resp.AuditAnnotations[warningAuditPrefix+strconv.Itoa(i)]
+		if !exists {
+			return warnings
+		}
+		warnings = append(warnings, warning)
+	}
+}
+
+// admissionResponse adds the warnings, supported by API servers since Kubernetes 1.19, to the
+// AdmissionResponse of our API types
+type admissionResponse struct {
+	*v1beta1.AdmissionResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// encodeReview writes the JSON serialized admission review including the warnings of its response.
+// The audit annotations carrying the warnings are stripped, so they don't end up in the audit log
+func encodeReview(w io.Writer, review v1beta1.AdmissionReview) error {
+	serialized := struct {
+		metav1.TypeMeta `json:",inline"`
+		Request         *v1beta1.AdmissionRequest `json:"request,omitempty"`
+		Response        *admissionResponse        `json:"response,omitempty"`
+	}{TypeMeta: review.TypeMeta, Request: review.Request}
+	if review.Response != nil {
+		response := *review.Response
+		response.AuditAnnotations = nil
+		for key, value := range review.Response.AuditAnnotations {
+			if strings.HasPrefix(key, warningAuditPrefix) {
+				continue
+			}
+			if response.AuditAnnotations == nil {
+				response.AuditAnnotations = map[string]string{}
+			}
+			response.AuditAnnotations[key] = value
+		}
+		serialized.Response = &admissionResponse{AdmissionResponse: &response}
+		if warnings := Warnings(review.Response); len(warnings) > 0 {
+			serialized.Response.Warnings = warnings
+		}
+	}
+	return json.NewEncoder(w).Encode(serialized)
+}
+
 // ToAdmissionResponse is a simple method to create a v1beta1.AdmissionResponse struct with an
-// error message set
+// error message set. The reason, code and causes of DenialErrors are set as well
 func ToAdmissionResponse(err error) *v1beta1.AdmissionResponse {
+	var denial *DenialError
+	if errors.As(err, &denial) {
+		status := denial.status()
+		// Errors wrapping the denial add context to its message
+		status.Message = err.Error()
+		return &v1beta1.AdmissionResponse{Result: status}
+	}
 	return &v1beta1.AdmissionResponse{Result: &metav1.Status{Message: err.Error()}}
 }
 
@@ -533,7 +973,15 @@ func CreatePatch(mutatedObj runtime.Object, objRaw []byte) ([]byte, error) {
 	if err := Marshaler.Encode(mutatedObj, mutatedRawBuf); err != nil {
 		return nil, err
 	}
-	patch, err := jsonpatch.CreatePatch(objRaw, mutatedRawBuf.Bytes())
+	return CreateJSONPatch(objRaw, mutatedRawBuf.Bytes())
+}
+
+// CreateJSONPatch creates a JSON patch from the JSON serialized original and mutated
+// structures. Allows to adjust the serialized mutated object before, e.g. to set fields
+// unknown to the API types
+func CreateJSONPatch(objRaw, mutatedRaw []byte) ([]byte, error) {
+	defer patchDuration.ObserveSince("json", time.Now())
+	patch, err := jsonpatch.CreatePatch(objRaw, mutatedRaw)
 	if err != nil {
 		return nil, err
 	}
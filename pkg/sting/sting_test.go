@@ -2,6 +2,7 @@ package sting
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -9,8 +10,15 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 func TestAnnotationHasValue(t *testing.T) {
@@ -87,6 +95,44 @@ func TestAnnotationValue(t *testing.T) {
 	}
 }
 
+func TestAnnotationsWithPrefix(t *testing.T) {
+	obj := &corev1.Pod{}
+	obj.Annotations = map[string]string{
+		"foo.bar": "1",
+		"foo.baz": "2",
+		"fop.bar": "3",
+	}
+	assert.Equal(t, map[string]string{"bar": "1", "baz": "2"}, AnnotationsWithPrefix(obj, "foo."))
+	assert.Empty(t, AnnotationsWithPrefix(&corev1.Pod{}, "foo."))
+}
+
+func TestObjectMetadata(t *testing.T) {
+	meta := metav1.ObjectMeta{
+		Annotations: map[string]string{"sqlbee.connctd.io/inject": "true"},
+		Labels:      map[string]string{"app": "web"},
+	}
+	cronJob := &unstructured.Unstructured{}
+	cronJob.SetAnnotations(meta.Annotations)
+	cronJob.SetLabels(meta.Labels)
+	for _, obj := range []runtime.Object{
+		&corev1.Pod{ObjectMeta: meta},
+		&appsv1.StatefulSet{ObjectMeta: meta},
+		&batchv1beta1.CronJob{ObjectMeta: meta},
+		&corev1.ConfigMap{ObjectMeta: meta},
+		cronJob,
+	} {
+		assert.True(t, AnnotationHasValue(obj, "sqlbee.connctd.io/inject", "true"), "%T", obj)
+		assert.Equal(t, map[string]string{"app": "web"}, Labels(obj), "%T", obj)
+		assert.Equal(t, "web", LabelValue(obj, "app", "none"), "%T", obj)
+	}
+
+	// Objects without metadata have neither annotations nor labels
+	status := &metav1.Status{}
+	assert.Empty(t, AnnotationValue(status, "sqlbee.connctd.io/inject"))
+	assert.Empty(t, Labels(status))
+	assert.Equal(t, "none", LabelValue(status, "app", "none"))
+}
+
 func TestReadRequest(t *testing.T) {
 
 	podReview := `
@@ -116,7 +162,7 @@ func TestReadRequest(t *testing.T) {
 		},
 	} {
 		w := httptest.NewRecorder()
-		ar, err := readRequest(w, data.request)
+		ar, err := readRequest(newStdLogger(), w, data.request)
 		assert.Equal(t, data.expectedError, err)
 		w.Flush()
 		assert.Equal(t, data.expectedStatus, w.Code)
@@ -127,3 +173,89 @@ func TestReadRequest(t *testing.T) {
 		}
 	}
 }
+
+// responseWarnings decodes the warnings of the serialized admission review
+func responseWarnings(t *testing.T, body []byte) []string {
+	review := struct {
+		Response struct {
+			Warnings []string `json:"warnings"`
+		} `json:"response"`
+	}{}
+	require.NoError(t, json.Unmarshal(body, &review))
+	return review.Response.Warnings
+}
+
+func TestResponseWarnings(t *testing.T) {
+	resp := &v1beta1.AdmissionResponse{Allowed: true}
+	assert.Empty(t, Warnings(resp))
+	AddWarning(resp, "first")
+	AddWarning(resp, "second")
+	assert.Equal(t, []string{"first", "second"}, Warnings(resp))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, encodeReview(buf, v1beta1.AdmissionReview{Response: resp}))
+	assert.JSONEq(t, `{"response":{"uid":"","allowed":true,"warnings":["first","second"]}}`, buf.String())
+
+	// Only the warnings are stripped from the audit annotations
+	resp.AuditAnnotations["reason"] = "injected"
+	buf.Reset()
+	require.NoError(t, encodeReview(buf, v1beta1.AdmissionReview{Response: resp}))
+	assert.JSONEq(t, `{"response":{"uid":"","allowed":true,"auditAnnotations":{"reason":"injected"},"warnings":["first","second"]}}`, buf.String())
+	assert.Equal(t, []string{"first", "second"}, Warnings(resp))
+}
+
+func TestNewEmbedded(t *testing.T) {
+	i, err := NewEmbedded(&Options{Mutate: denyWith("denied"), MutatePaths: []string{"/webhooks/sqlbee"}})
+	require.NoError(t, err)
+
+	// Mounted into the router and TLS stack of the application
+	mux := http.NewServeMux()
+	mux.Handle("/webhooks/", i.Handler())
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	resp, err := server.Client().Post(server.URL+"/webhooks/sqlbee", "application/json", bytes.NewReader([]byte(`{"request":{"uid":"uid"}}`)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	review := v1beta1.AdmissionReview{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&review))
+	require.NotNil(t, review.Response)
+	assert.EqualValues(t, "uid", review.Response.UID)
+	assert.Equal(t, "denied", review.Response.Result.Message)
+
+	rec := httptest.NewRecorder()
+	i.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"ok"`)
+
+	assert.NoError(t, i.Close())
+
+	_, err = NewEmbedded(&Options{Mutate: denyWith("denied"), MutatePaths: []string{"relative"}})
+	assert.Error(t, err)
+}
+
+func TestResponseReviewVersion(t *testing.T) {
+	i, err := NewEmbedded(&Options{Mutate: denyWith("denied")})
+	require.NoError(t, err)
+
+	for request, expected := range map[string]string{
+		`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"uid"}}`:      `"apiVersion":"admission.k8s.io/v1"`,
+		`{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview","request":{"uid":"uid"}}`: `"apiVersion":"admission.k8s.io/v1beta1"`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1beta/mutate", bytes.NewReader([]byte(request)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		i.Handler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), expected)
+		assert.Contains(t, rec.Body.String(), `"kind":"AdmissionReview"`)
+	}
+
+	// Reviews without version are answered without one, like before
+	req := httptest.NewRequest(http.MethodPost, "/api/v1beta/mutate", bytes.NewReader([]byte(`{"request":{"uid":"uid"}}`)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	i.Handler().ServeHTTP(rec, req)
+	assert.NotContains(t, rec.Body.String(), "apiVersion")
+}
@@ -0,0 +1,35 @@
+package sting
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// ALPN protocols offered by default. The API server prefers HTTP/2 when calling webhooks
+var defaultNextProtos = []string{"h2", "http/1.1"}
+
+// configureTLS sets the TLS configuration of the admission endpoint based on the one of the options,
+// serving the certificate of the InjectServer. The ALPN protocols of the options take precedence over
+// the ones of the TLS configuration. HTTP/2 is only enabled if h2 is one of the ALPN protocols, as
+// net/http otherwise adds it on its own
+func (i *InjectServer) configureTLS(server *http.Server, opts *Options) {
+	config := &tls.Config{}
+	if opts.TLSConfig != nil {
+		config = opts.TLSConfig.Clone()
+	}
+	config.GetCertificate = i.getCert
+	if len(opts.NextProtos) > 0 {
+		config.NextProtos = opts.NextProtos
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = defaultNextProtos
+	}
+	server.TLSConfig = config
+
+	for _, proto := range config.NextProtos {
+		if proto == "h2" {
+			return
+		}
+	}
+	server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+}
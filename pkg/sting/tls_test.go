@@ -0,0 +1,47 @@
+package sting
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureTLS(t *testing.T) {
+	pair, err := certFromSecret(certSecret(t, "sqlbee-svc.sqlbee.svc", "1"))
+	require.NoError(t, err)
+	i := &InjectServer{cert: pair, certLock: &sync.Mutex{}}
+
+	for _, data := range []struct {
+		opts     *Options
+		protocol string
+	}{
+		{&Options{}, "HTTP/2.0"},
+		{&Options{NextProtos: []string{"http/1.1"}}, "HTTP/1.1"},
+		{&Options{TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}, NextProtos: []string{"h2"}}, "HTTP/2.0"},
+		{&Options{TLSConfig: &tls.Config{NextProtos: []string{"http/1.1"}}}, "HTTP/1.1"},
+		{&Options{TLSConfig: &tls.Config{NextProtos: []string{"http/1.1"}}, NextProtos: []string{"h2"}}, "HTTP/2.0"},
+	} {
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		})}
+		i.configureTLS(server, data.opts)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go server.ServeTLS(listener, "", "")
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := client.Get("https://" + listener.Addr().String())
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, data.protocol, resp.Proto)
+		server.Close()
+	}
+}